@@ -1,9 +1,11 @@
 package api
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"pull2push/api/base"
+	cameraBroadcast "pull2push/core/broadcast/camera"
 	cameraBroker "pull2push/core/broker/camera"
 	"pull2push/service"
 )
@@ -18,7 +20,7 @@ type CameraController struct {
 func NewCameraController(base *base.BaseController, cameraBrokerPool *cameraBroker.CameraBroker) *CameraController {
 	return &CameraController{
 		BaseController: base,
-		cameraService:  &service.CameraService{CameraBrokerPool: cameraBrokerPool},
+		cameraService:  &service.CameraService{CameraBrokerPool: cameraBrokerPool, Publish: base.Resource.Config.Live.Publish},
 	}
 }
 
@@ -30,8 +32,33 @@ func (cc *CameraController) ExecutePush(c *gin.Context) {
 	//}
 	broadcasterKey := c.Param("broadcasterKey")
 
-	cc.cameraService.ExecutePush(c, broadcasterKey)
+	err := cc.cameraService.ExecutePush(c, broadcasterKey)
+	if err != nil {
+		pushError(c, err)
+		return
+	}
+}
+
+// ExecutePushWS 处理浏览器、移动端通过 WebSocket 推上来的 FLV 流
+func (cc *CameraController) ExecutePushWS(c *gin.Context) {
+	broadcasterKey := c.Param("broadcasterKey")
+
+	err := cc.cameraService.ExecutePushWS(c, broadcasterKey)
+	if err != nil {
+		pushError(c, err)
+		return
+	}
+}
 
+// ExecutePushTS 处理硬件编码器通过 HTTP chunked POST 推上来的 MPEG-TS
+func (cc *CameraController) ExecutePushTS(c *gin.Context) {
+	broadcasterKey := c.Param("broadcasterKey")
+
+	err := cc.cameraService.ExecutePushTS(c, broadcasterKey)
+	if err != nil {
+		pushError(c, err)
+		return
+	}
 }
 
 // ExecutePull 处理每一个链接上来的客户端的推流
@@ -47,3 +74,34 @@ func (cc *CameraController) ExecutePull(c *gin.Context) {
 
 	cc.cameraService.ExecutePull(c, broadcasterKey, clientId)
 }
+
+// ExecuteHLS 以 HLS 方式观看摄像头推流，推流结束后返回 VOD 回放
+func (cc *CameraController) ExecuteHLS(c *gin.Context) {
+
+	broadcasterKey := c.Param("broadcasterKey")
+	clientId := c.Param("clientId")
+
+	err := cc.cameraService.ExecuteHLS(c, broadcasterKey, clientId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code": 500,
+			"msg":  err.Error(),
+		})
+		return
+	}
+}
+
+// pushError 推流失败的响应：直播间正在推流时返回 409，推流端据此知道不是网络问题，其他错误沿用 code 500
+func pushError(c *gin.Context, err error) {
+	if errors.Is(err, cameraBroadcast.ErrPublishing) {
+		c.JSON(http.StatusConflict, gin.H{
+			"code": 409,
+			"msg":  err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 500,
+		"msg":  err.Error(),
+	})
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"pull2push/api/base"
+	flvBroker "pull2push/core/broker/flv"
+	hlsBroker "pull2push/core/broker/hls"
+	"pull2push/service"
+)
+
+// DASHController 处理 MPEG-DASH 相关的请求
+type DASHController struct {
+	*base.BaseController
+	dashService *service.DASHService
+}
+
+// NewDASHController 创建一个新的 DASHController
+func NewDASHController(base *base.BaseController, flvBrokerPool *flvBroker.FLVBroker, hlsBrokerPool *hlsBroker.HLSBroker) *DASHController {
+	return &DASHController{
+		BaseController: base,
+		dashService:    &service.DASHService{FLVBrokerPool: flvBrokerPool, HLSBrokerPool: hlsBrokerPool},
+	}
+}
+
+// LiveDASH 以 MPEG-DASH 方式输出拉流转推的直播
+func (dc DASHController) LiveDASH(c *gin.Context) {
+
+	broadcasterKey := c.Param("broadcasterKey")
+
+	err := dc.dashService.LiveDASH(c, broadcasterKey)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code": 500,
+			"msg":  err.Error(),
+		})
+		return
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"github.com/gin-gonic/gin"
+	"net/http"
 	"pull2push/api/base"
 	flvBroker "pull2push/core/broker/flv"
 	"pull2push/service"
@@ -41,3 +42,19 @@ func (fc *FLVController) LiveFlv(c *gin.Context) {
 	fc.flvService.LiveFlv(c, broadcasterKey, clientId)
 
 }
+
+// LiveFlvWS 以 WebSocket 方式观看 FLV 直播（flv.js / mpegts.js 的 ws:// 地址）
+func (fc *FLVController) LiveFlvWS(c *gin.Context) {
+
+	broadcasterKey := c.Param("broadcasterKey")
+	clientId := c.Param("clientId")
+
+	err := fc.flvService.LiveFlvWS(c, broadcasterKey, clientId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code": 500,
+			"msg":  err.Error(),
+		})
+		return
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"pull2push/api/base"
+	"pull2push/core/broker"
+	"pull2push/core/egress"
+	"pull2push/event"
+	"pull2push/metrics"
+	"pull2push/middleware"
+	"pull2push/service"
+)
+
+// MetricsController 处理 Prometheus 抓取 /metrics 的请求
+type MetricsController struct {
+	*base.BaseController
+	metricsService *service.MetricsService
+}
+
+// NewMetricsController 创建一个新的 MetricsController
+func NewMetricsController(base *base.BaseController, brokers []broker.Broker, viewerLimiter *middleware.ViewerLimiter, egressLimiter *egress.Limiter, eventBus *event.EventBus) *MetricsController {
+	return &MetricsController{
+		BaseController: base,
+		metricsService: &service.MetricsService{Brokers: brokers, ViewerLimiter: viewerLimiter, EgressLimiter: egressLimiter, EventBus: eventBus},
+	}
+}
+
+// Metrics 按 Prometheus 文本格式输出指标
+func (mc *MetricsController) Metrics(c *gin.Context) {
+	c.Data(http.StatusOK, metrics.ContentType, mc.metricsService.Metrics())
+}
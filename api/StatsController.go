@@ -0,0 +1,43 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"pull2push/api/base"
+	"pull2push/core/broker"
+	"pull2push/service"
+)
+
+// StatsController 处理直播间实时统计的请求
+type StatsController struct {
+	*base.BaseController
+	statsService *service.StatsService
+}
+
+// NewStatsController 创建一个新的 StatsController
+func NewStatsController(base *base.BaseController, brokers []broker.Broker) *StatsController {
+	return &StatsController{
+		BaseController: base,
+		statsService:   &service.StatsService{Brokers: brokers},
+	}
+}
+
+// Streams 返回所有直播间的实时统计，?clients=false 时不返回客户端列表
+func (sc *StatsController) Streams(c *gin.Context) {
+	c.JSON(http.StatusOK, base.JsonResultSuccess(sc.statsService.Streams(c.Query("clients") != "false")))
+}
+
+// Stream 返回一个直播间的实时统计，每个协议一条
+func (sc *StatsController) Stream(c *gin.Context) {
+	broadcasterKey := c.Param("broadcasterKey")
+
+	list, err := sc.statsService.Stream(broadcasterKey)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code": 404,
+			"msg":  err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, base.JsonResultSuccess(list))
+}
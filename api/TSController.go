@@ -0,0 +1,40 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"pull2push/api/base"
+	cameraBroker "pull2push/core/broker/camera"
+	flvBroker "pull2push/core/broker/flv"
+	"pull2push/service"
+)
+
+// TSController 处理连续 MPEG-TS 观看的请求
+type TSController struct {
+	*base.BaseController
+	tsService *service.TSService
+}
+
+// NewTSController 创建一个新的 TSController
+func NewTSController(base *base.BaseController, flvBrokerPool *flvBroker.FLVBroker, cameraBrokerPool *cameraBroker.CameraBroker) *TSController {
+	return &TSController{
+		BaseController: base,
+		tsService:      &service.TSService{FLVBrokerPool: flvBrokerPool, CameraBrokerPool: cameraBrokerPool},
+	}
+}
+
+// LiveTS 以连续 TS 观看直播（IPTV 机顶盒、VLC）
+func (tc *TSController) LiveTS(c *gin.Context) {
+
+	broadcasterKey := c.Param("broadcasterKey")
+	clientId := c.Param("clientId")
+
+	err := tc.tsService.LiveTS(c, broadcasterKey, clientId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code": 500,
+			"msg":  err.Error(),
+		})
+		return
+	}
+}
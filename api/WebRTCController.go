@@ -0,0 +1,82 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"pull2push/api/base"
+	cameraBroker "pull2push/core/broker/camera"
+	webrtcBroker "pull2push/core/broker/webrtc"
+	"pull2push/service"
+)
+
+// WebRTCController 处理 WHIP 推流、WHEP 观看相关的请求
+type WebRTCController struct {
+	*base.BaseController
+	webrtcService *service.WebRTCService
+}
+
+// NewWebRTCController 创建一个新的 WebRTCController
+func NewWebRTCController(base *base.BaseController, webrtcBrokerPool *webrtcBroker.WebRTCBroker, cameraBrokerPool *cameraBroker.CameraBroker) *WebRTCController {
+	return &WebRTCController{
+		BaseController: base,
+		webrtcService:  &service.WebRTCService{WebRTCBrokerPool: webrtcBrokerPool, CameraBrokerPool: cameraBrokerPool, Publish: base.Resource.Config.Live.Publish},
+	}
+}
+
+// WHIP 开始 WHIP 推流
+func (wc *WebRTCController) WHIP(c *gin.Context) {
+	broadcasterKey := c.Param("broadcasterKey")
+
+	err := wc.webrtcService.WHIP(c, broadcasterKey)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code": 500,
+			"msg":  err.Error(),
+		})
+		return
+	}
+}
+
+// WHIPDelete 结束 WHIP 推流
+func (wc *WebRTCController) WHIPDelete(c *gin.Context) {
+	broadcasterKey := c.Param("broadcasterKey")
+	sessionId := c.Param("sessionId")
+
+	err := wc.webrtcService.WHIPDelete(c, broadcasterKey, sessionId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code": 500,
+			"msg":  err.Error(),
+		})
+		return
+	}
+}
+
+// WHEP 开始 WHEP 观看
+func (wc *WebRTCController) WHEP(c *gin.Context) {
+	broadcasterKey := c.Param("broadcasterKey")
+
+	err := wc.webrtcService.WHEP(c, broadcasterKey)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code": 500,
+			"msg":  err.Error(),
+		})
+		return
+	}
+}
+
+// WHEPDelete 结束 WHEP 观看
+func (wc *WebRTCController) WHEPDelete(c *gin.Context) {
+	broadcasterKey := c.Param("broadcasterKey")
+	clientId := c.Param("clientId")
+
+	err := wc.webrtcService.WHEPDelete(c, broadcasterKey, clientId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code": 500,
+			"msg":  err.Error(),
+		})
+		return
+	}
+}
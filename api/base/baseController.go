@@ -42,9 +42,10 @@ func (c *BaseController) SetEventBus(bus *event.EventBus) {
 	go func() {
 		for {
 			select {
-			case <-shutdownCh:
+			case e := <-shutdownCh:
 				logger.Info("BaseController received system shutdown event")
 				c.Cleanup()
+				e.Ack()
 				return
 			case <-c.shutdownCh:
 				return
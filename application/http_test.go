@@ -0,0 +1,342 @@
+package application
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pull2push/config"
+	dashBroadcast "pull2push/core/broadcast/dash"
+	"pull2push/core/media/av"
+	"pull2push/core/media/testsrc"
+	"pull2push/core/media/ts"
+	"pull2push/event"
+	"pull2push/resource"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+各输出协议的离线集成测试：上游是内置测试流 testsrc://，不需要 ffmpeg 和外网，
+经过完整的路由和中间件，检查 HTTP-FLV、TS、DASH 和摄像头推流的 HLS 能输出可以解析的关键帧。
+*/
+
+// newTestHTTPService 启动带一个 testsrc 直播间的 HTTP 服务，configure 可以修改其余配置，测试结束时关闭所有直播间
+func newTestHTTPService(t *testing.T, configure ...func(cfg *config.Config)) *httptest.Server {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Live.Channels = []config.ChannelConfig{{BroadcasterKey: "testsrc", Source: "testsrc://"}}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	res, err := resource.NewResource(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewHTTPService(res)
+	s.SetEventBus(event.NewEventBus())
+	s.setupRoutes()
+
+	srv := httptest.NewServer(s.engine)
+	t.Cleanup(srv.Close)
+	// 先关闭直播间结束长连接，srv.Close 才不会等待
+	t.Cleanup(s.closeBroadcasters)
+	return srv
+}
+
+// get 发起 GET 请求，ctx 结束时取消，非 200 时测试失败
+func get(t *testing.T, ctx context.Context, url string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		t.Fatalf("GET %s: %s %s", url, resp.Status, body)
+	}
+	return resp
+}
+
+// getBytes GET 并读完响应体
+func getBytes(t *testing.T, ctx context.Context, url string) (string, []byte) {
+	t.Helper()
+	resp := get(t, ctx, url)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Header.Get("Content-Type"), body
+}
+
+// demuxTS 解出 TS 中的帧，返回是否有视频关键帧和音频帧
+func demuxTS(t *testing.T, r io.Reader) (keyFrame, audio bool) {
+	t.Helper()
+	demuxer := ts.NewDemuxer(func(pkt *av.Packet) {
+		if pkt.Type == av.PacketVideo && pkt.IsKeyFrame {
+			keyFrame = true
+		}
+		if pkt.Type == av.PacketAudio {
+			audio = true
+		}
+	})
+	buf := make([]byte, 188*64)
+	for !keyFrame || !audio {
+		n, err := r.Read(buf)
+		if n > 0 {
+			_, _ = demuxer.Write(buf[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+	return keyFrame, audio
+}
+
+func TestFLVOutput(t *testing.T) {
+	srv := newTestHTTPService(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp := get(t, ctx, srv.URL+"/api/live/flv/testsrc/viewer-1")
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "video/x-flv" {
+		t.Fatalf("Content-Type = %s", ct)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	var header [13]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	if string(header[:3]) != "FLV" {
+		t.Fatalf("FLV header = %x", header[:9])
+	}
+	var sawVideoConfig, sawAudioConfig bool
+	for {
+		var tagHeader [11]byte
+		if _, err := io.ReadFull(r, tagHeader[:]); err != nil {
+			t.Fatal("no key frame:", err)
+		}
+		data := make([]byte, int(tagHeader[1])<<16|int(tagHeader[2])<<8|int(tagHeader[3])+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			t.Fatal(err)
+		}
+		pkt, ok := av.DemuxFLVTag(tagHeader[0], 0, data[:len(data)-4])
+		if !ok {
+			continue
+		}
+		switch {
+		case pkt.IsSequenceHeader && pkt.Type == av.PacketVideo:
+			sawVideoConfig = true
+		case pkt.IsSequenceHeader:
+			sawAudioConfig = true
+		case pkt.Type == av.PacketVideo:
+			// 加入时从缓存的 GOP 开始，第一个视频帧就是关键帧
+			if !sawVideoConfig || !sawAudioConfig || !pkt.IsKeyFrame {
+				t.Fatalf("first video frame: key frame %v, video config %v, audio config %v", pkt.IsKeyFrame, sawVideoConfig, sawAudioConfig)
+			}
+			return
+		}
+	}
+}
+
+func TestTSOutput(t *testing.T) {
+	srv := newTestHTTPService(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp := get(t, ctx, srv.URL+"/api/live/ts/testsrc/viewer-1")
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "video/mp2t" {
+		t.Fatalf("Content-Type = %s", ct)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	if b, err := r.Peek(1); err != nil || b[0] != 0x47 {
+		t.Fatalf("first byte = %x, %v, want TS sync byte", b, err)
+	}
+	if keyFrame, audio := demuxTS(t, r); !keyFrame || !audio {
+		t.Fatalf("key frame %v, audio %v", keyFrame, audio)
+	}
+}
+
+func TestDASHOutput(t *testing.T) {
+	srv := newTestHTTPService(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	// 不带 clientId 的 MPD 请求重定向到新会话的地址
+	base := srv.URL + "/api/live/dash/testsrc/"
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(base + "manifest.mpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	redirect, err := resp.Location()
+	if err != nil || resp.StatusCode != http.StatusFound || redirect.Query().Get("clientId") == "" {
+		t.Fatalf("GET manifest.mpd: %s, Location %v, want redirect with clientId", resp.Status, redirect)
+	}
+	clientId := redirect.Query().Get("clientId")
+
+	// 新建的打包器等第一个分片切出后才返回 MPD
+	ct, body := getBytes(t, ctx, redirect.String())
+	if ct != "application/dash+xml" {
+		t.Fatalf("Content-Type = %s", ct)
+	}
+	var mpd dashBroadcast.MPD
+	if err := xml.Unmarshal(body, &mpd); err != nil {
+		t.Fatal(err)
+	}
+	if mpd.Type != "dynamic" || len(mpd.Period.AdaptationSets) != 2 {
+		t.Fatalf("MPD = %s", body)
+	}
+	// 播放器按 Location 刷新 MPD，沿用同一个会话
+	if location := "manifest.mpd?clientId=" + clientId; mpd.Location != location || mpd.BaseURL != clientId+"/" {
+		t.Fatalf("Location = %q, BaseURL = %q, want %q, %q", mpd.Location, mpd.BaseURL, location, clientId+"/")
+	}
+	for i := 0; i < 3; i++ {
+		getBytes(t, ctx, base+mpd.Location)
+	}
+	_, body = getBytes(t, ctx, srv.URL+"/api/stats/streams/testsrc")
+	var stats struct {
+		Data []struct {
+			Clients []struct {
+				ClientId string `json:"clientId"`
+			} `json:"clients"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &stats); err != nil || len(stats.Data) != 1 {
+		t.Fatalf("stats = %s, %v", body, err)
+	}
+	if clients := stats.Data[0].Clients; len(clients) != 1 || clients[0].ClientId != clientId {
+		t.Fatalf("clients after MPD refreshes = %+v, want only %s", clients, clientId)
+	}
+
+	for _, as := range mpd.Period.AdaptationSets {
+		rep := as.Representation
+		if as.ContentType == "video" && (rep.Width != 320 || rep.Height != 240 || !strings.HasPrefix(rep.Codecs, "avc1.")) {
+			t.Fatalf("video representation = %+v", rep)
+		}
+		tpl := rep.SegmentTemplate
+		if len(tpl.Timeline.S) == 0 {
+			t.Fatalf("%s: empty SegmentTimeline", as.ContentType)
+		}
+
+		ct, init := getBytes(t, ctx, base+mpd.BaseURL+tpl.Initialization)
+		if ct != as.MimeType || len(init) < 8 || string(init[4:8]) != "ftyp" || !bytes.Contains(init, []byte("moov")) {
+			t.Fatalf("%s init segment: %s, %d bytes", as.ContentType, ct, len(init))
+		}
+		media := strings.Replace(tpl.Media, "$Number$", strconv.FormatUint(tpl.StartNumber, 10), 1)
+		_, seg := getBytes(t, ctx, base+mpd.BaseURL+media)
+		if !bytes.Contains(seg, []byte("moof")) || !bytes.Contains(seg, []byte("mdat")) {
+			t.Fatalf("%s media segment %s: %d bytes without moof/mdat", as.ContentType, media, len(seg))
+		}
+	}
+}
+
+// TestDASHSessionLimit 同一个 DASH 会话反复刷新 MPD 只占一个名额，同一个 IP 再开一个会话时超限
+func TestDASHSessionLimit(t *testing.T) {
+	srv := newTestHTTPService(t, func(cfg *config.Config) {
+		cfg.Live.Limits.MaxConnsPerIP = 1
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	base := srv.URL + "/api/live/dash/testsrc/"
+	_, body := getBytes(t, ctx, base+"manifest.mpd")
+	var mpd dashBroadcast.MPD
+	if err := xml.Unmarshal(body, &mpd); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		getBytes(t, ctx, base+mpd.Location)
+	}
+
+	resp, err := http.Get(base + "manifest.mpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second DASH session from the same IP: %s, want 429", resp.Status)
+	}
+}
+
+// TestCameraHLSOutput 以 HTTP 推流的方式把测试流推到摄像头直播间，HLS 切出的分片中有关键帧
+func TestCameraHLSOutput(t *testing.T) {
+	srv := newTestHTTPService(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	src, err := testsrc.OpenFLV("testsrc://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	// 推流请求体：关闭 pw 时请求体正常结束，模拟推流端停止推流
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = io.Copy(pw, src)
+	}()
+	pushed := make(chan error, 1)
+	go func() {
+		resp, err := http.Post(srv.URL+"/api/live/camera/ingest/test-camera", "video/x-flv", pr)
+		if err == nil {
+			resp.Body.Close()
+		}
+		pushed <- err
+	}()
+
+	// 第一个分片在第二个关键帧到来时（约 2 秒）切出
+	playlist := srv.URL + "/api/live/camera/hls/test-camera/viewer-1/index.m3u8"
+	var segment string
+	for segment == "" {
+		if ctx.Err() != nil {
+			t.Fatal("no HLS segment")
+		}
+		ct, body := getBytes(t, ctx, playlist)
+		if ct != "application/vnd.apple.mpegurl" {
+			t.Fatalf("Content-Type = %s", ct)
+		}
+		for _, line := range strings.Split(string(body), "\n") {
+			if strings.HasSuffix(line, ".ts") {
+				segment = line
+				break
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	_, data := getBytes(t, ctx, srv.URL+segment)
+	if len(data)%188 != 0 || data[0] != 0x47 {
+		t.Fatalf("segment %s: %d bytes, first byte %x", segment, len(data), data[0])
+	}
+	if keyFrame, audio := demuxTS(t, bytes.NewReader(data)); !keyFrame || !audio {
+		t.Fatalf("segment %s: key frame %v, audio %v", segment, keyFrame, audio)
+	}
+
+	// 推流端停止后推流请求正常结束
+	_ = pw.Close()
+	select {
+	case err := <-pushed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-ctx.Done():
+		t.Fatal("ingest request not finished after publisher closed")
+	}
+}
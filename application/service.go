@@ -8,6 +8,7 @@ import (
 	"pull2push/logger"
 	"pull2push/resource"
 	"sync"
+	"time"
 )
 
 type Service interface {
@@ -104,10 +105,12 @@ func (m *ServiceManager) StartAll(ctx context.Context) error {
 
 // StopAll 停止所有服务
 func (m *ServiceManager) StopAll() {
-	// 发布系统关闭事件
-	m.eventBus.Publish(event.Event{
-		Type: event.SystemShutdown,
-	})
+	// 发布系统关闭事件，等各模块处理完再逐个停止服务，慢的模块最多等 5 秒
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := m.eventBus.PublishAndWait(ctx, event.Event{Type: event.SystemShutdown}); err != nil {
+		logger.Warn("System shutdown event not handled by all subscribers", "error", err)
+	}
+	cancel()
 
 	logger.Info("System shutdown event published")
 	for _, service := range m.services {
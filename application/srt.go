@@ -0,0 +1,99 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"github.com/datarhei/gosrt"
+	"pull2push/config"
+	cameraBroker "pull2push/core/broker/camera"
+	"pull2push/event"
+	"pull2push/logger"
+	"pull2push/resource"
+	"pull2push/service"
+	"pull2push/webhook"
+	"sync"
+)
+
+// SRTListenerService SRT 推流服务，listener 和 caller 两种模式收到的流都进入摄像头直播间
+type SRTListenerService struct {
+	config     *config.SRTConfig
+	resources  *resource.Resource
+	eventBus   *event.EventBus
+	srtService *service.SRTService
+
+	listener srt.Listener
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewSRTListenerService 创建 SRT 推流服务，cameraBrokerPool 使用 HTTPService.CameraBroker()，这样 SRT 推流可以通过 HTTP 观看；
+// hooks 使用 HTTPService.Hooks()，和 HTTP 推流使用同一套 on_publish 回调
+func NewSRTListenerService(res *resource.Resource, cameraBrokerPool *cameraBroker.CameraBroker, hooks *webhook.Client) *SRTListenerService {
+	srtConfig := &res.Config.Live.SRT
+	return &SRTListenerService{
+		config:    srtConfig,
+		resources: res,
+		srtService: &service.SRTService{
+			CameraBrokerPool: cameraBrokerPool,
+			Publish:          res.Config.Live.Publish,
+			Passphrase:       srtConfig.Passphrase,
+			Latency:          srtConfig.Latency,
+			Hooks:            hooks,
+		},
+	}
+}
+
+// Name 返回服务名称
+func (s *SRTListenerService) Name() string {
+	return "srt_service"
+}
+
+func (s *SRTListenerService) Start(ctx context.Context) error {
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	if s.config.Listen != "" {
+		ln, err := s.srtService.ListenSRT(s.config.Listen)
+		if err != nil {
+			return err
+		}
+		s.listener = ln
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := s.srtService.Serve(ln); err != nil && !errors.Is(err, srt.ErrListenerClosed) {
+				logger.Error("SRT listener error", "error", err)
+			}
+		}()
+		logger.Info("Starting SRT listener", "address", s.config.Listen)
+	}
+
+	for _, caller := range s.config.Callers {
+		s.wg.Add(1)
+		go func(caller config.SRTCallerConfig) {
+			defer s.wg.Done()
+			s.srtService.Call(ctx, caller.Address, caller.StreamId, caller.Passphrase, caller.BroadcasterKey)
+		}(caller)
+		logger.Info("Starting SRT caller", "address", caller.Address, "broadcasterKey", caller.BroadcasterKey)
+	}
+	return nil
+}
+
+func (s *SRTListenerService) Stop() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.wg.Wait()
+	logger.Info("SRT service stopped successfully")
+	return nil
+}
+
+func (s *SRTListenerService) SetEventBus(bus *event.EventBus) {
+	s.eventBus = bus
+}
+
+func (s *SRTListenerService) SetResources(res *resource.Resource) {
+	s.resources = res
+}
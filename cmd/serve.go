@@ -44,9 +44,19 @@ var serveCmd = &cobra.Command{
 			return err
 		}
 
+		// 2.1 SRT 推流服务，推上来的流进入 HTTP 服务的摄像头直播间
+		srtService := application.NewSRTListenerService(serviceManager.GetResource(), httpService.CameraBroker(), httpService.Hooks())
+		if err := serviceManager.AddService(srtService); err != nil {
+			logger.Error("Failed to add SRT service", "error", err)
+			return err
+		}
+
 		// 3. 任务管理器服务 - 没有特定依赖
 		cronTaskManager := cron.NewCronTaskManager(serviceManager.GetResource())
 
+		// 3.1 直播结束后的回放过期清理
+		cronTaskManager.AddTask(cron.NewVODExpireTask(cfg.Live.VODRetention, httpService.Brokers()...))
+
 		// 3.3 注册任务管理器服务
 		if err := serviceManager.AddService(cronTaskManager); err != nil {
 			logger.Error("Failed to add CronTaskManager service", "error", err)
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"os"
+	"time"
 )
 
 func LoadConfig(path string) (*Config, error) {
@@ -58,4 +59,102 @@ type LiveConfig struct {
 	flvPort    int `yaml:"flvPort"`  // 8080/80/443
 	rtmpPort   int `yaml:"rtmpPort"` // 1935
 	cameraPort int `yaml:"cameraPort"`
+
+	VODRetention time.Duration `yaml:"vodRetention"` // 直播结束后回放保留时长，如 10m
+
+	SRT SRTConfig `yaml:"srt"` // SRT 推流
+
+	Webhook WebhookConfig `yaml:"webhook"` // 推流、观看的 HTTP 回调，业务后台据此鉴权
+
+	PlayAuth PlayAuthConfig `yaml:"playAuth"` // 观看鉴权：带签名、会过期的播放地址
+
+	Publish PublishConfig `yaml:"publish"` // 摄像头推流（HTTP、WebSocket、TS）的推流密钥和重复推流处理
+
+	Limits LimitsConfig `yaml:"limits"` // 观众数、单 IP 连接数上限
+
+	Egress EgressConfig `yaml:"egress"` // 出口带宽限制
+
+	Channels []ChannelConfig `yaml:"channels"` // 启动时创建的拉流转推直播间，如 24 小时循环播放的垫片频道
+}
+
+// ChannelConfig 一个拉流转推直播间
+type ChannelConfig struct {
+	BroadcasterKey string        `yaml:"broadcasterKey"` // 直播间
+	Source         string        `yaml:"source"`         // 上游地址：http(s)://、rtsp://、file:// 本地 FLV 文件或播放列表、testsrc:// 内置测试流
+	Backups        []string      `yaml:"backups"`        // 备用上游，主上游连续失败或没有数据时按顺序切换
+	FailBack       bool          `yaml:"failBack"`       // 主上游恢复后是否切回
+	Slate          string        `yaml:"slate"`          // 垫片：上游断流时播放的 file:// 本地 FLV 或 testsrc:// 测试流，为空不播放
+	SlateAfter     time.Duration `yaml:"slateAfter"`     // 上游断流多久后切到垫片，如 1500ms，为 0 使用默认值 2s
+	OnDemand       bool          `yaml:"onDemand"`       // 按需拉流：第一个客户端到来时才连接上游
+	IdleTimeout    time.Duration `yaml:"idleTimeout"`    // 按需拉流时没有客户端多久后断开上游，为 0 使用默认值 1m
+}
+
+// SRTConfig SRT 推流配置，推上来的 MPEG-TS 会进入同名的摄像头直播间
+type SRTConfig struct {
+	Listen     string            `yaml:"listen"`     // listener 模式的监听地址，如 :6000，为空不监听
+	Passphrase string            `yaml:"passphrase"` // 加密口令（10~79 个字符），为空不加密
+	Latency    time.Duration     `yaml:"latency"`    // 接收延迟，公网一般 200ms~1s，为 0 使用默认值 120ms
+	Callers    []SRTCallerConfig `yaml:"callers"`    // caller 模式：主动连接处于 listener 模式的编码器
+}
+
+// SRTCallerConfig caller 模式的一路拉流
+type SRTCallerConfig struct {
+	BroadcasterKey string `yaml:"broadcasterKey"` // 进入的直播间
+	Address        string `yaml:"address"`        // 编码器地址 host:port
+	StreamId       string `yaml:"streamId"`       // 连接时携带的 stream id，可以为空
+	Passphrase     string `yaml:"passphrase"`     // 为空时使用 SRTConfig.Passphrase
+}
+
+// EgressConfig 出口带宽限制（FLV、摄像头拉流、HLS 分片），速率单位为 kbps，0 表示不限制
+type EgressConfig struct {
+	NodeKbps    int64              `yaml:"nodeKbps"`    // 本节点出口带宽
+	ViewerKbps  int64              `yaml:"viewerKbps"`  // 每个观众
+	StreamKbps  int64              `yaml:"streamKbps"`  // 每个直播间的默认值
+	Streams     map[string]int64   `yaml:"streams"`     // 直播间 → 带宽，覆盖 streamKbps
+	Priorities  map[string]string  `yaml:"priorities"`  // 直播间（支持通配符）→ 优先级 high/normal/low，默认 normal
+	Thresholds  map[string]float64 `yaml:"thresholds"`  // 优先级 → 最多使用本节点带宽的比例，默认 high 1.0、normal 0.9、low 0.7
+	RedirectURL string             `yaml:"redirectURL"` // 带宽不足时把新观众重定向到这个地址（拼上原请求路径），为空时返回 503
+}
+
+// LimitsConfig 观看限制，FLV、TS、摄像头拉流、WHEP 按连接计数，HLS、DASH 按会话计数，所有上限为 0 表示不限制
+type LimitsConfig struct {
+	MaxViewers          int            `yaml:"maxViewers"`          // 本节点的观众总数上限，超过时返回 503
+	MaxViewersPerStream int            `yaml:"maxViewersPerStream"` // 每个直播间的观众上限，超过时返回 503
+	Streams             map[string]int `yaml:"streams"`             // 直播间 → 观众上限，覆盖 maxViewersPerStream
+	MaxConnsPerIP       int            `yaml:"maxConnsPerIP"`       // 单个 IP 的连接/会话上限，超过时返回 429
+	SessionTTL          time.Duration  `yaml:"sessionTTL"`          // HLS、DASH 会话多久没有请求后不再计数，为 0 使用默认值 30s
+}
+
+// PublishConfig 推流配置，HTTP、WebSocket、TS、WHIP、SRT 推流都适用
+type PublishConfig struct {
+	StreamKeys  map[string]string `yaml:"streamKeys"`  // 直播间 → 推流密钥，推流地址带 ?key= 或请求头 X-Stream-Key，SRT 在 streamid 中带 key=
+	RequireKey  bool              `yaml:"requireKey"`  // 为 true 时没有配置推流密钥的直播间不允许推流
+	OnDuplicate string            `yaml:"onDuplicate"` // 直播间正在推流时新的推流端：reject 拒绝（默认），kick 顶掉旧的推流端
+	AutoCreate  bool              `yaml:"autoCreate"`  // 推流到不存在的直播间时自动创建，不需要提前注册
+}
+
+// 重复推流的处理方式
+const (
+	DuplicateReject = "reject"
+	DuplicateKick   = "kick"
+)
+
+// PlayAuthConfig 观看鉴权，Streams 匹配的直播间观看时必须在 query 中带 token
+type PlayAuthConfig struct {
+	Streams   []string `yaml:"streams"`   // 需要鉴权的直播间，支持 path.Match 通配符，如 "vip-*"，"*" 表示所有直播间；为空不鉴权
+	Secret    string   `yaml:"secret"`    // HMAC 签名密钥，token 为 <过期时间>-<签名>，为空不接受签名 token
+	JWTSecret string   `yaml:"jwtSecret"` // JWT（HS256）密钥，claims 中 stream 为直播间（"*" 表示所有），为空不接受 JWT
+}
+
+// WebhookConfig HTTP 回调（参考 SRS 的 http_hooks），地址为空的回调不调用
+type WebhookConfig struct {
+	OnPublish    string        `yaml:"onPublish"`    // 推流前同步调用，拒绝时不允许推流
+	OnUnpublish  string        `yaml:"onUnpublish"`  // 推流结束后异步调用
+	OnPlay       string        `yaml:"onPlay"`       // 观看前同步调用，拒绝时不允许观看
+	OnStop       string        `yaml:"onStop"`       // 观众离开后异步调用
+	OnRecordDone string        `yaml:"onRecordDone"` // 回放定稿后异步调用
+	Secret       string        `yaml:"secret"`       // HMAC-SHA256 签名密钥，为空时不签名
+	Timeout      time.Duration `yaml:"timeout"`      // 单次请求超时，为 0 使用默认值 3s
+	Retries      int           `yaml:"retries"`      // 异步回调失败后的重试次数，为 0 使用默认值 3，小于 0 不重试
+	PlayCacheTTL time.Duration `yaml:"playCacheTTL"` // on_play 允许后同一个客户端多久内不再回调（HLS、DASH 每个分片都是一次请求），为 0 使用默认值 1m
 }
@@ -2,7 +2,11 @@ package broadcast
 
 import (
 	"github.com/gin-gonic/gin"
+	"io"
 	"pull2push/core/client"
+	"pull2push/core/media/av"
+	"pull2push/event"
+	"time"
 )
 
 // ====================== Broadcaster ======================
@@ -30,11 +34,50 @@ type Broadcaster interface {
 
 	// UpdateSourceURL 支持切换直播原地址
 	UpdateSourceURL(newSourceURL string)
+
+	// Close 关闭直播间：停止拉流/推流，通知所有客户端结束，并从所在的 Broker 中移除；重复调用无效果
+	Close(reason BROADCAST_CLOSE_TYPE)
+
+	// OnClose 注册关闭回调，Broker 加入广播器时通过它在关闭后自动移除
+	OnClose(fn func())
+
+	// SetEventBus 注入事件总线，广播器通过它发布上游、观众等生命周期事件，由 Broker 加入广播器时调用
+	SetEventBus(bus *event.EventBus)
+}
+
+// Replayable 直播结束后仍保留最后若干分片供回放（VOD）的广播器
+// 回放保留期过后由定时任务处理：预先注册的直播间清空回放分片，推流时自动创建的直播间从 Broker 中移除
+type Replayable interface {
+
+	// EndedAt 返回直播结束时间，ended 为 false 表示直播尚未结束
+	EndedAt() (endedAt time.Time, ended bool)
+
+	// Configured 是否为预先注册的直播间（启动时创建、配置文件中的），false 表示推流时自动创建
+	Configured() bool
+
+	// ResetReplay 清空回放分片，回到未开播的状态：推流的直播间可以重新推流，拉流转推的直播间重新拉流
+	ResetReplay()
+}
+
+// PacketHandler 接收音视频帧的回调，在广播器的拉流协程中同步调用，不能阻塞
+type PacketHandler func(pkt *av.Packet)
+
+// PacketSource 能按音视频帧输出直播数据的广播器，DASH 等需要重新封装的输出通过它订阅
+type PacketSource interface {
+
+	// SubscribePackets 订阅音视频帧，订阅时先回放缓存的序列头和最近一个 GOP，返回取消订阅函数
+	SubscribePackets(subscriberId string, handler PacketHandler) (unsubscribe func())
+}
+
+// Touchable 按需拉流的广播器。HLS、DASH 这类短请求没有断开事件，每次请求都调用 Touch，超过空闲时间没有请求才停止拉流
+type Touchable interface {
+	Touch()
 }
 
 // BroadcasterOptional broker配置选项
 type BroadcasterOptional struct {
 	GinContext *gin.Context
+	Reader     io.Reader // 推流数据来源，为空时读取 GinContext 的请求体（如 WebSocket 推流）
 }
 
 type BROADCAST_CLOSE_TYPE int
@@ -3,16 +3,26 @@ package camera
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"pull2push/core/broadcast"
+	flvBroadcast "pull2push/core/broadcast/flv"
+	hlsBroadcast "pull2push/core/broadcast/hls"
 	"pull2push/core/client"
+	"pull2push/core/media/av"
+	"pull2push/core/media/ts"
+	"pull2push/event"
+	"pull2push/event/payload"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 /*
 1️⃣ 核心思路
 目标是实现：
-	摄像头或其他来源推流 → HTTP POST 到 Go 服务
+	摄像头或其他来源推流 → HTTP POST 或 WebSocket 到 Go 服务
 	Go 服务缓存最近若干视频数据 → 用于新客户端秒开
 	多客户端拉流 → 每个客户端可以同时观看实时流
 	保证推流不卡死 → 慢客户端不阻塞推流
@@ -22,18 +32,48 @@ import (
 	多客户端同时读取，要避免互相阻塞
 	新观众加入时希望立即看到最近几秒画面
 
+HLS 切片与回放：
+	推流的同时把 FLV 解析成音视频帧并切成 TS 分片放入 StreamState，
+	推流结束后最后若干分片保留在内存里，/index.m3u8 返回 VOD 播放列表，直到回放保留期过期。
+
 
 */
 
+// ErrPublishing 直播间已经有推流端在推流
+var ErrPublishing = errors.New("直播间正在推流")
+
+const (
+	cameraHLSSegments       = 6               // HLS 切片缓存的分片数，也是回放保留的分片数
+	cameraHLSTargetDuration = 2 * time.Second // HLS 目标分片时长
+)
+
 // CameraBroadcaster 每个 直播地址 用一个 CameraBroadcaster 管理，里面管理了多个当前直播链接的客户端
 type CameraBroadcaster struct {
+	broadcast.Lifecycle
+	broadcast.Emitter
+	broadcast.StreamMeter // 推流计数，统计接口使用
+
 	// 直播数据相关
-	BroadcasterKey string   // 直播房间的唯一编号
-	gop            [][]byte // 内存缓存最近的若干个 FLV 包，方便新客户端秒开缓存最近一个 GOP（关键帧 + 后续帧）
+	BroadcasterKey string // 直播房间的唯一编号
+
+	// 缓存相关：FLV 头 + 序列头 + 最近一个 GOP，方便新客户端秒开
+	cacheMutex sync.Mutex
+	gopCache   *flvBroadcast.GOPCache
+	publishing atomic.Bool // 是否正在推流
+
+	// 当前推流的数据来源，关闭直播间时关闭它打断阻塞的读取
+	publisherMutex sync.Mutex
+	publisher      io.Reader
+	interrupt      func()        // 打断阻塞中的请求体读取，HTTP 推流时才有
+	kickSig        chan struct{} // 当前推流被新的推流端顶掉时被关闭
+
+	// HLS 切片相关
+	StreamState *hlsBroadcast.StreamState // 推流切出的 TS 分片缓存，推流结束后用于回放
+	autoCreated bool                      // 推流时自动创建的直播间，回放过期后关闭；预先注册的只清空回放分片
 
 	// 状态控制相关
-	BroadcasterCloseSig chan broadcast.BROADCAST_CLOSE_TYPE // 控制当前这个直播是否被关闭
-	once                sync.Once
+	BroadcasterCloseSig chan broadcast.BROADCAST_CLOSE_TYPE // 直播间关闭时被关闭，客户端据此结束
+	stopSig             chan struct{}                       // 直播间关闭时被关闭，推流、状态监听协程据此退出
 
 	// 客户端相关
 	clientMutex    sync.Mutex                   // 客户端的异步操作控制器
@@ -46,12 +86,16 @@ func NewCameraBroadcaster(broadcasterKey string, maxCache int) *CameraBroadcaste
 	if maxCache == 0 {
 		maxCache = 150
 	}
+	streamState := hlsBroadcast.NewStreamState(cameraHLSSegments)
+	streamState.TargetDur = cameraHLSTargetDuration.Seconds()
 	cb := CameraBroadcaster{
 		BroadcasterKey:      broadcasterKey,
-		gop:                 make([][]byte, 0),
+		gopCache:            flvBroadcast.NewGOPCache(),
+		StreamState:         streamState,
 		clientMap:           make(map[string]client.LiveClient),
 		BroadcasterCloseSig: make(chan broadcast.BROADCAST_CLOSE_TYPE),
 		ClientCloseSig:      make(chan string),
+		stopSig:             make(chan struct{}),
 	}
 
 	// 开启必要的状态监听
@@ -62,15 +106,39 @@ func NewCameraBroadcaster(broadcasterKey string, maxCache int) *CameraBroadcaste
 	return &cb
 }
 
-// AddLiveClient 添加客户端
+// NewAutoCameraBroadcaster 推流到不存在的直播间时自动创建的直播间，回放保留期过后会被关闭并从 Broker 中移除
+func NewAutoCameraBroadcaster(broadcasterKey string) *CameraBroadcaster {
+	cb := NewCameraBroadcaster(broadcasterKey, 0)
+	cb.autoCreated = true
+	return cb
+}
+
+// AddLiveClient 添加客户端，先把缓存的 FLV 头和 GOP 发送给新客户端
 func (cb *CameraBroadcaster) AddLiveClient(clientId string, client client.LiveClient) {
+	// 已经关闭的直播间不再加入，客户端收到 BroadcasterCloseSig 后自己结束
+	if _, closed := cb.Closed(); closed {
+		return
+	}
+
+	cb.cacheMutex.Lock()
+	defer cb.cacheMutex.Unlock()
+
+	if init := cb.gopCache.InitBytes(); len(init) > 0 {
+		select {
+		case client.GetDataChan() <- init:
+		default:
+			cb.CountDropped()
+		}
+	}
+
 	cb.clientMutex.Lock()
+	_, exists := cb.clientMap[clientId]
 	cb.clientMap[clientId] = client
+	viewers := len(cb.clientMap)
 	cb.clientMutex.Unlock()
 
-	// 先把缓存的 GOP 发送给新客户端
-	for _, pkt := range cb.gop {
-		client.GetDataChan() <- pkt
+	if !exists {
+		cb.Emit(event.ViewerJoined, payload.ViewerPayload{BroadcasterKey: cb.BroadcasterKey, Protocol: payload.ProtocolCamera, ClientId: clientId, Viewers: viewers})
 	}
 }
 
@@ -83,6 +151,8 @@ func (cb *CameraBroadcaster) RemoveLiveClient(clientId string) {
 		return
 	}
 	delete(cb.clientMap, clientId)
+	cb.ClientRemoved(liveClient)
+	cb.EmitViewerRemoved(liveClient, payload.ViewerPayload{BroadcasterKey: cb.BroadcasterKey, Protocol: payload.ProtocolCamera, ClientId: clientId, Viewers: len(cb.clientMap)})
 
 	//// 如果没有客户端并且想释放 CameraBroadcaster，可关闭 stopCh 让 PullLoop 停止（本示例保留 CameraBroadcaster，防止频繁断开上游）
 	//if remaining == 0 {
@@ -92,12 +162,25 @@ func (cb *CameraBroadcaster) RemoveLiveClient(clientId string) {
 
 // FindLiveClient 查询 LiveClient
 func (cb *CameraBroadcaster) FindLiveClient(clientId string) (client.LiveClient, error) {
+	cb.clientMutex.Lock()
+	defer cb.clientMutex.Unlock()
 	if val, ok := cb.clientMap[clientId]; ok {
 		return val, nil
 	}
 	return nil, errors.New(fmt.Sprintf("未找到 %s 对应的 LiveClient", clientId))
 }
 
+// Stats 实现 broadcast.StatsProvider
+func (cb *CameraBroadcaster) Stats() broadcast.StreamStats {
+	cb.clientMutex.Lock()
+	clients := make([]client.LiveClient, 0, len(cb.clientMap))
+	for _, c := range cb.clientMap {
+		clients = append(clients, c)
+	}
+	cb.clientMutex.Unlock()
+	return cb.Snapshot(cb.BroadcasterKey, payload.ProtocolCamera, clients)
+}
+
 // UpdateSourceURL 支持切换直播原地址
 func (cb *CameraBroadcaster) UpdateSourceURL(newSourceURL string) {}
 
@@ -109,50 +192,255 @@ func (cb *CameraBroadcaster) ListenStatus() {
 			// 监听客户端离开消息
 			cb.RemoveLiveClient(clientId)
 			fmt.Printf("CameraBroadcaster.ListenStatus.RemoveLiveClient.clientId %s successful.", clientId)
-		case <-cb.BroadcasterCloseSig:
+		case <-cb.stopSig:
 			// 直播被关闭
-			close(cb.BroadcasterCloseSig)
+			return
+		}
+
+	}
+}
+
+// Close 关闭直播间：断开正在进行的推流，通知所有客户端结束，并从所在的 Broker 中移除
+func (cb *CameraBroadcaster) Close(reason broadcast.BROADCAST_CLOSE_TYPE) {
+	cb.Shutdown(reason, func() {
+		log.Println("关闭直播间:", cb.BroadcasterKey, "reason =", reason)
+		close(cb.stopSig)
+
+		// 打断阻塞中的读取
+		cb.publisherMutex.Lock()
+		cb.interruptPublisher()
+		cb.publisherMutex.Unlock()
+
+		close(cb.BroadcasterCloseSig)
+
+		cb.clientMutex.Lock()
+		for clientId, liveClient := range cb.clientMap {
+			cb.ClientRemoved(liveClient)
+			cb.Emit(event.ViewerKicked, payload.ViewerPayload{BroadcasterKey: cb.BroadcasterKey, Protocol: payload.ProtocolCamera, ClientId: clientId, Reason: "broadcaster closed"})
+		}
+		clear(cb.clientMap)
+		cb.clientMutex.Unlock()
+	})
+}
+
+// stopReader 直播间关闭或推流被顶掉后读取直接返回 io.ErrClosedPipe，数据来源不支持 Close 时也能在下一次读取时退出推流
+type stopReader struct {
+	r    io.Reader
+	stop <-chan struct{}
+	kick <-chan struct{}
+}
+
+func (sr *stopReader) Read(p []byte) (int, error) {
+	select {
+	case <-sr.stop:
+		return 0, io.ErrClosedPipe
+	case <-sr.kick:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	return sr.r.Read(p)
+}
+
+// Publishing 是否正在推流
+func (cb *CameraBroadcaster) Publishing() bool {
+	return cb.publishing.Load()
+}
+
+// KickPublisher 断开当前的推流端，等待它退出，timeout 内仍未退出时返回 false
+func (cb *CameraBroadcaster) KickPublisher(timeout time.Duration) bool {
+	cb.publisherMutex.Lock()
+	if cb.kickSig != nil {
+		log.Println("断开当前推流端:", cb.BroadcasterKey)
+		close(cb.kickSig)
+		cb.kickSig = nil
+		cb.interruptPublisher()
+	}
+	cb.publisherMutex.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for cb.publishing.Load() {
+		if time.Now().After(deadline) {
+			return false
 		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return true
+}
 
+// interruptPublisher 打断推流端阻塞中的读取，调用时需持有 publisherMutex
+//
+//	服务端的请求体在读取时 Close 会等读取返回，推流端不发数据时只能通过读超时打断；WebSocket 管道等支持 Close 的来源直接关闭。
+func (cb *CameraBroadcaster) interruptPublisher() {
+	if cb.interrupt != nil {
+		cb.interrupt()
+	}
+	if closer, ok := cb.publisher.(io.Closer); ok {
+		go closer.Close()
 	}
 }
 
-// PullLoop 持续去直播原地址拉流/数据
+// PullLoop 持续接收推流数据，推流来源为 bo.Reader（WebSocket 推流），为空时读取 HTTP POST 的请求体
 func (cb *CameraBroadcaster) PullLoop(bo broadcast.BroadcasterOptional) {
+	if err := cb.Publish(bo); err != nil {
+		log.Println("推流失败:", cb.BroadcasterKey, err)
+	}
+}
+
+// Publish 和 PullLoop 一样，阻塞到推流结束；直播间已经有推流时返回 ErrPublishing
+func (cb *CameraBroadcaster) Publish(bo broadcast.BroadcasterOptional) error {
+
+	reader := bo.Reader
+	if reader == nil && bo.GinContext != nil {
+		reader = bo.GinContext.Request.Body
+	}
+	if reader == nil {
+		return errors.New("没有推流数据来源")
+	}
+	if _, closed := cb.Closed(); closed {
+		return errors.New("直播间已关闭")
+	}
+
+	// 同一时间只允许一路推流，移动端重连时旧连接可能还没断开
+	if !cb.publishing.CompareAndSwap(false, true) {
+		return ErrPublishing
+	}
+	defer cb.publishing.Store(false)
+
+	// 同一个直播间重新推流，清空上一场的回放分片
+	if _, ended := cb.StreamState.EndedInfo(); ended {
+		cb.StreamState.Restart()
+	}
+
+	kickSig := make(chan struct{})
+	cb.publisherMutex.Lock()
+	cb.publisher = reader
+	cb.kickSig = kickSig
+	if bo.GinContext != nil {
+		rc := http.NewResponseController(bo.GinContext.Writer)
+		cb.interrupt = func() { _ = rc.SetReadDeadline(time.Now()) }
+	}
+	cb.publisherMutex.Unlock()
+	defer func() {
+		cb.publisherMutex.Lock()
+		cb.publisher = nil
+		cb.interrupt = nil
+		cb.kickSig = nil
+		cb.publisherMutex.Unlock()
+	}()
+
+	// 推流端就是这个直播间的上游
+	var publisherAddr string
+	if bo.GinContext != nil {
+		publisherAddr = bo.GinContext.Request.RemoteAddr
+	}
+	cb.EmitPublished(payload.StreamPayload{BroadcasterKey: cb.BroadcasterKey, Protocol: payload.ProtocolCamera, URL: publisherAddr})
+	cb.Emit(event.UpstreamConnected, payload.UpstreamPayload{BroadcasterKey: cb.BroadcasterKey, Protocol: payload.ProtocolCamera, URL: publisherAddr})
+	cb.SetUpstream(publisherAddr, broadcast.UpstreamStreaming)
+	defer cb.SetUpstream("", broadcast.UpstreamIdle)
+
+	reason := "publisher closed"
+	if err := cb.ingest(&stopReader{r: reader, stop: cb.stopSig, kick: kickSig}); err != nil && !errors.Is(err, io.EOF) {
+		fmt.Println("推流断开:", err)
+		reason = err.Error()
+	}
+	select {
+	case <-kickSig:
+		reason = "kicked by new publisher"
+	default:
+	}
+	cb.Emit(event.UpstreamLost, payload.UpstreamPayload{BroadcasterKey: cb.BroadcasterKey, Protocol: payload.ProtocolCamera, URL: publisherAddr, Reason: reason})
+	cb.EmitUnpublished(payload.StreamPayload{BroadcasterKey: cb.BroadcasterKey, Protocol: payload.ProtocolCamera, URL: publisherAddr, Reason: reason})
+
+	// 推流结束：清空 GOP 缓存，冻结分片供回放
+	cb.cacheMutex.Lock()
+	cb.gopCache = flvBroadcast.NewGOPCache()
+	cb.cacheMutex.Unlock()
+	cb.StreamState.MarkEnded()
+	if segments, duration := cb.StreamState.Totals(); segments > 0 {
+		cb.Emit(event.RecordDone, payload.RecordPayload{BroadcasterKey: cb.BroadcasterKey, Protocol: payload.ProtocolCamera, Segments: segments, Duration: duration})
+	}
+	log.Println("推流结束，进入回放:", cb.BroadcasterKey)
+	return nil
+}
+
+// ingest 逐个解析推上来的 FLV tag：写入 GOP 缓存并分发给客户端，同时切成 HLS 分片
+func (cb *CameraBroadcaster) ingest(reader io.Reader) error {
+	parser := flvBroadcast.NewFLVParser(false)
+	header, err := parser.ParseHeader(reader)
+	if err != nil {
+		return err
+	}
+
+	cb.cacheMutex.Lock()
+	cb.gopCache.Reset(header.HasVideo, header.HasAudio)
+	cb.cacheMutex.Unlock()
+
+	cb.StreamState.Mu.RLock()
+	seq := cb.StreamState.LastSeq
+	discont := cb.StreamState.Discont
+	cb.StreamState.Mu.RUnlock()
+
+	segmenter := ts.NewSegmenter(cameraHLSTargetDuration, func(data []byte, dur time.Duration) {
+		seq++
+		cb.StreamState.PushSegment(&hlsBroadcast.Segment{
+			Seq:       seq,
+			LocalName: fmt.Sprintf("%d.ts", seq),
+			Data:      data,
+			Dur:       dur.Seconds(),
+			Discont:   discont,
+			AddedAt:   time.Now(),
+		})
+		discont = false
+	})
+	// 推流结束时输出最后一个分片
+	defer segmenter.Flush()
 
-	data := make([]byte, 4096)
 	for {
-		n, err := bo.GinContext.Request.Body.Read(data)
+		tag, err := parser.ParseNextTag(reader)
 		if err != nil {
-			fmt.Println("推流断开:", err)
-			break
+			return err
+		}
+		flvBroadcast.MeterTag(&cb.StreamMeter, tag)
+
+		// 缓存和分发在同一把锁内完成，新客户端不会漏掉或重复收到 tag
+		cb.cacheMutex.Lock()
+		raw := cb.gopCache.WriteTag(tag.TagType, tag.Timestamp, tag.RawData)
+		cb.Broadcast2LiveClient(raw)
+		cb.cacheMutex.Unlock()
+
+		pkt, ok := av.DemuxFLVTag(tag.TagType, tag.Timestamp, tag.RawData)
+		if !ok {
+			continue
+		}
+		if err := segmenter.WritePacket(pkt); err != nil {
+			log.Println("HLS 切片失败:", err)
 		}
-		packet := make([]byte, n)
-		copy(packet, data[:n])
-		cb.Broadcast2LiveClient(packet)
 	}
+}
+
+// EndedAt 实现 broadcast.Replayable，返回推流结束时间
+func (cb *CameraBroadcaster) EndedAt() (time.Time, bool) {
+	return cb.StreamState.EndedInfo()
+}
+
+// Configured 实现 broadcast.Replayable，自动创建的直播间返回 false
+func (cb *CameraBroadcaster) Configured() bool {
+	return !cb.autoCreated
+}
 
+// ResetReplay 实现 broadcast.Replayable，清空回放分片，下次推流序列号接着往后排
+func (cb *CameraBroadcaster) ResetReplay() {
+	cb.StreamState.Restart()
 }
 
 // Broadcast2LiveClient 原地址拉取到数据之后广播给客户端
+// 发送不阻塞推流：客户端的通道塞满说明已经跟不上，由客户端通知 CameraService 断开，断开后从列表中移除
+// 丢弃的数据包记在客户端的 Meter 上，Stats 汇总在线客户端的，移除时由 ClientRemoved 计入直播间的累计值
 func (cb *CameraBroadcaster) Broadcast2LiveClient(data []byte) {
 	cb.clientMutex.Lock()
-	clientMap := cb.clientMap
-	cb.clientMutex.Unlock()
-
-	// 简化：这里假设每个 packet 都是关键帧或帧数据
-	// 实际项目中需要解析 FLV tag 判断关键帧
-	isKeyFrame := data[0]&0x10 != 0
-	// 如果是关键帧，重置 GOP 缓存
-	if isKeyFrame {
-		cb.gop = cb.gop[:0]
-	}
-	// 缓存
-	cb.gop = append(cb.gop, data)
+	defer cb.clientMutex.Unlock()
 
-	// 广播给所有客户端
-	for _, c := range clientMap {
-		c.GetDataChan() <- data
+	for _, c := range cb.clientMap {
+		c.Broadcast(data)
 	}
-
 }
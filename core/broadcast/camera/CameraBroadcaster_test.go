@@ -0,0 +1,148 @@
+package camera
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pull2push/core/broadcast"
+	cameraBroker "pull2push/core/broker/camera"
+	cameraClient "pull2push/core/client/camera"
+	"pull2push/core/media/testsrc"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkGoroutines 等待 goroutine 数回到 before 以内，超时后打印所有 goroutine 的堆栈
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+			t.Fatalf("goroutines = %d, want <= %d\n%s", runtime.NumGoroutine(), before, buf[:n])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// waitFor 等待 cond 成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestCloseStopsGoroutines 推流中关闭直播间：推流返回、直播间从 Broker 中移除，推流、客户端和状态监听的 goroutine 全部退出
+func TestCloseStopsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	broker := cameraBroker.NewCameraBroker()
+	cb := NewCameraBroadcaster("leak-camera", 3)
+	broker.AddBroadcaster("leak-camera", cb)
+
+	src, err := testsrc.OpenFLV("testsrc://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	// 推流数据来源：管道支持 Close，直播间关闭时直接打断阻塞中的读取
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := io.Copy(pw, src)
+		_ = pw.CloseWithError(err)
+	}()
+	published := make(chan error, 1)
+	go func() {
+		published <- cb.Publish(broadcast.BroadcasterOptional{Reader: pr})
+	}()
+	waitFor(t, "publisher data", func() bool { return cb.Stats().BytesIn > 0 })
+
+	var clients []*cameraClient.CameraLiveClient
+	for i := 0; i < 2; i++ {
+		clientId := "viewer-" + strconv.Itoa(i)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/live/camera/flv/leak-camera/"+clientId, nil)
+		liveClient, err := cameraClient.NewCameraLiveClient(c, "leak-camera", clientId, cb.ClientCloseSig, cb.BroadcasterCloseSig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cb.AddLiveClient(clientId, liveClient)
+		clients = append(clients, liveClient)
+	}
+	for _, liveClient := range clients {
+		select {
+		case <-liveClient.GetDataChan():
+		case <-time.After(5 * time.Second):
+			t.Fatal("client received no data")
+		}
+	}
+
+	cb.Close(broadcast.BrokerClosed)
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish not returned after Close")
+	}
+	if cb.Publishing() {
+		t.Fatal("still publishing after Close")
+	}
+	if _, err := broker.FindBroadcaster("leak-camera"); err == nil {
+		t.Fatal("broadcaster still in broker after Close")
+	}
+	// 关闭后不再接受推流，重复关闭不会 panic
+	if err := cb.Publish(broadcast.BroadcasterOptional{Reader: pr}); err == nil {
+		t.Fatal("Publish accepted after Close")
+	}
+	cb.Close(broadcast.BrokerClosed)
+	_ = src.Close()
+
+	checkGoroutines(t, before)
+}
+
+// TestSlowClientDropped 客户端跟不上时被断开，丢弃的数据包计入直播间的统计，客户端移除后仍然保留
+func TestSlowClientDropped(t *testing.T) {
+	cb := NewCameraBroadcaster("slow-camera", 3)
+	defer cb.Close(broadcast.BrokerClosed)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/live/camera/flv/slow-camera/slow", nil)
+	slow, err := cameraClient.NewCameraLiveClient(c, "slow-camera", "slow", cb.ClientCloseSig, cb.BroadcasterCloseSig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb.AddLiveClient("slow", slow)
+
+	// 不读取，发送队列塞满后再发一次
+	for i := 0; i <= cap(slow.GetDataChan()); i++ {
+		cb.Broadcast2LiveClient([]byte{byte(i)})
+	}
+	select {
+	case <-slow.Kicked():
+	default:
+		t.Fatal("slow client not kicked when send queue full")
+	}
+	if slow.KickReason() != "send queue full" {
+		t.Fatalf("KickReason = %q", slow.KickReason())
+	}
+	if stats := cb.Stats(); stats.Dropped != 1 || stats.Viewers != 1 {
+		t.Fatalf("dropped = %d, viewers = %d before remove, want 1, 1", stats.Dropped, stats.Viewers)
+	}
+
+	cb.RemoveLiveClient("slow")
+	if stats := cb.Stats(); stats.Dropped != 1 || stats.Viewers != 0 {
+		t.Fatalf("dropped = %d, viewers = %d after remove, want 1, 0", stats.Dropped, stats.Viewers)
+	}
+}
@@ -0,0 +1,231 @@
+package dash
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"pull2push/core/broadcast"
+	hlsBroadcast "pull2push/core/broadcast/hls"
+	"pull2push/core/media/av"
+	"pull2push/core/media/fmp4"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+DASH 打包器
+	订阅广播器的音视频帧（broadcast.PacketSource），重新封装成 CMAF fMP4 分片，
+	视频、音频各自一个 StreamState 环形缓冲（与 HLS 共用同一套分片缓存），
+	由 Manifest 生成 type="dynamic" 的 MPD（SegmentTemplate + SegmentTimeline）。
+
+分片命名
+	v-init.mp4 / a-init.mp4   初始化分片
+	v-{n}.m4s / a-{n}.m4s     媒体分片，n 为 $Number$
+*/
+
+const (
+	defaultSegmentDuration = 2 * time.Second
+	defaultWindowSize      = 10
+
+	// 时间戳回退超过该值视为上游重新开始推流，时间轴重新计算
+	timelineResetThreshold = time.Second
+)
+
+// DASHPackager 一个直播对应一个 DASH 打包器
+type DASHPackager struct {
+	BroadcasterKey  string
+	SegmentDuration time.Duration
+
+	VideoState *hlsBroadcast.StreamState // 视频分片缓存
+	AudioState *hlsBroadcast.StreamState // 音频分片缓存
+
+	mu          sync.RWMutex
+	segmenter   *fmp4.Segmenter
+	videoTrack  *fmp4.Track
+	audioTrack  *fmp4.Track
+	videoInit   []byte
+	audioInit   []byte
+	availStart  time.Time // availabilityStartTime：时间轴 0 点对应的墙上时间
+	videoEnd    uint64    // 视频时间轴当前结束位置，用于检测时间戳回退
+	audioEnd    uint64
+	source      broadcast.PacketSource
+	unsubscribe func()
+	lastAccess  time.Time
+}
+
+// NewDASHPackager 创建 DASH 打包器并订阅广播器的音视频帧
+func NewDASHPackager(broadcasterKey string, source broadcast.PacketSource) *DASHPackager {
+	p := &DASHPackager{
+		BroadcasterKey:  broadcasterKey,
+		SegmentDuration: defaultSegmentDuration,
+		VideoState:      hlsBroadcast.NewStreamState(defaultWindowSize),
+		AudioState:      hlsBroadcast.NewStreamState(defaultWindowSize),
+		source:          source,
+		lastAccess:      time.Now(),
+	}
+	p.segmenter = fmp4.NewSegmenter(p.SegmentDuration, p.onTrack, p.onFragment)
+	p.unsubscribe = source.SubscribePackets("dash-"+broadcasterKey, p.writePacket)
+	return p
+}
+
+// Close 取消订阅，停止打包
+func (p *DASHPackager) Close() {
+	if p.unsubscribe != nil {
+		p.unsubscribe()
+	}
+}
+
+// Source 打包器订阅的广播器
+func (p *DASHPackager) Source() broadcast.PacketSource {
+	return p.source
+}
+
+// Touch 记录最近一次被访问的时间
+func (p *DASHPackager) Touch() {
+	p.mu.Lock()
+	p.lastAccess = time.Now()
+	p.mu.Unlock()
+}
+
+// IdleSince 最近一次被访问的时间
+func (p *DASHPackager) IdleSince() time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.lastAccess
+}
+
+// writePacket 在广播器的拉流协程中被调用
+func (p *DASHPackager) writePacket(pkt *av.Packet) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.segmenter.WritePacket(pkt); err != nil {
+		log.Printf("[dash:%s] 封装失败: %v", p.BroadcasterKey, err)
+	}
+}
+
+func (p *DASHPackager) onTrack(track *fmp4.Track) {
+	init, err := track.InitSegment()
+	if err != nil {
+		log.Printf("[dash:%s] 生成初始化分片失败: %v", p.BroadcasterKey, err)
+		return
+	}
+	if track.IsVideo() {
+		p.videoTrack, p.videoInit = track, init
+	} else {
+		p.audioTrack, p.audioInit = track, init
+	}
+}
+
+func (p *DASHPackager) onFragment(frag *fmp4.TrackFragment) {
+	state, prefix, end := p.AudioState, "a", &p.audioEnd
+	if frag.Track.IsVideo() {
+		state, prefix, end = p.VideoState, "v", &p.videoEnd
+	}
+
+	timescale := uint64(frag.Track.Timescale)
+	if *end > 0 && frag.BaseTime+uint64(timelineResetThreshold.Seconds()*float64(timescale)) < *end {
+		// 时间戳回退，之前的时间轴作废
+		log.Printf("[dash:%s] 时间戳回退，重置时间轴", p.BroadcasterKey)
+		p.VideoState.Restart()
+		p.AudioState.Restart()
+		p.videoEnd, p.audioEnd = 0, 0
+		p.availStart = time.Time{}
+	}
+	*end = frag.BaseTime + frag.Duration
+
+	// 以第一个分片结束的时刻作为“当前”，反推时间轴 0 点
+	if p.availStart.IsZero() {
+		p.availStart = time.Now().Add(-ticksToDuration(*end, timescale))
+	}
+
+	state.PushSegment(&hlsBroadcast.Segment{
+		Seq:       uint64(frag.Number),
+		LocalName: fmt.Sprintf("%s-%d.m4s", prefix, frag.Number),
+		Data:      frag.Data,
+		Dur:       float64(frag.Duration) / float64(timescale),
+		AddedAt:   time.Now(),
+		BaseTime:  frag.BaseTime,
+		Ticks:     frag.Duration,
+	})
+}
+
+// InitSegment 返回初始化分片，name 为 v-init.mp4 或 a-init.mp4
+func (p *DASHPackager) InitSegment(name string) []byte {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	switch name {
+	case "v-init.mp4":
+		return p.videoInit
+	case "a-init.mp4":
+		return p.audioInit
+	}
+	return nil
+}
+
+// MediaSegment 按文件名查找缓存的媒体分片
+func (p *DASHPackager) MediaSegment(name string) *hlsBroadcast.Segment {
+	if len(name) < 3 {
+		return nil
+	}
+	state := p.AudioState
+	if strings.HasPrefix(name, "v-") {
+		state = p.VideoState
+	}
+	if _, err := strconv.ParseUint(strings.TrimSuffix(name[2:], ".m4s"), 10, 64); err != nil {
+		return nil
+	}
+	segs, _, _, _ := state.Snapshot()
+	for _, seg := range segs {
+		if seg.LocalName == name {
+			return seg
+		}
+	}
+	return nil
+}
+
+// Ready 是否已经切出了第一个分片，可以生成 MPD
+func (p *DASHPackager) Ready() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return !p.availStart.IsZero()
+}
+
+// Manifest 生成 MPD，baseURL 为分片相对地址前缀（携带会话编号），query 不为空时追加到分片地址后（如 "?token=xxx"），
+// location 为播放器刷新 MPD 的地址，刷新时带上会话编号才不会每次都开一个新会话
+func (p *DASHPackager) Manifest(baseURL, query, location string) ([]byte, error) {
+	p.mu.RLock()
+	videoTrack, audioTrack := p.videoTrack, p.audioTrack
+	availStart := p.availStart
+	p.mu.RUnlock()
+
+	if availStart.IsZero() || (videoTrack == nil && audioTrack == nil) {
+		return nil, errors.New(fmt.Sprintf("直播 %s 的 DASH 分片尚未就绪", p.BroadcasterKey))
+	}
+
+	m := newMPD(availStart, p.SegmentDuration, baseURL, query, location)
+	if videoTrack != nil {
+		segs, _, _, _ := p.VideoState.Snapshot()
+		if len(segs) > 0 {
+			m.addAdaptationSet(videoTrack, segs, "v")
+		}
+	}
+	if audioTrack != nil {
+		segs, _, _, _ := p.AudioState.Snapshot()
+		if len(segs) > 0 {
+			m.addAdaptationSet(audioTrack, segs, "a")
+		}
+	}
+	if len(m.Period.AdaptationSets) == 0 {
+		return nil, errors.New(fmt.Sprintf("直播 %s 的 DASH 分片尚未就绪", p.BroadcasterKey))
+	}
+	return m.marshal()
+}
+
+func ticksToDuration(ticks, timescale uint64) time.Duration {
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(ticks/timescale)*time.Second + time.Duration(ticks%timescale)*time.Second/time.Duration(timescale)
+}
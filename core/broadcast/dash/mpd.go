@@ -0,0 +1,169 @@
+package dash
+
+import (
+	"encoding/xml"
+	"fmt"
+	hlsBroadcast "pull2push/core/broadcast/hls"
+	"pull2push/core/media/fmp4"
+	"time"
+)
+
+// MPD 只包含直播场景用到的字段
+type MPD struct {
+	XMLName                    xml.Name `xml:"MPD"`
+	Xmlns                      string   `xml:"xmlns,attr"`
+	Profiles                   string   `xml:"profiles,attr"`
+	Type                       string   `xml:"type,attr"`
+	AvailabilityStartTime      string   `xml:"availabilityStartTime,attr"`
+	PublishTime                string   `xml:"publishTime,attr"`
+	MinimumUpdatePeriod        string   `xml:"minimumUpdatePeriod,attr"`
+	MinBufferTime              string   `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth       string   `xml:"timeShiftBufferDepth,attr"`
+	SuggestedPresentationDelay string   `xml:"suggestedPresentationDelay,attr"`
+	BaseURL                    string   `xml:"BaseURL,omitempty"`
+	Location                   string   `xml:"Location,omitempty"` // 播放器刷新 MPD 时请求的地址，带着会话编号
+	Period                     Period   `xml:"Period"`
+
+	windowDepth time.Duration
+	query       string // 分片地址后追加的 query，BaseURL 中的 query 不会被分片地址继承
+}
+
+type Period struct {
+	ID             string          `xml:"id,attr"`
+	Start          string          `xml:"start,attr"`
+	AdaptationSets []AdaptationSet `xml:"AdaptationSet"`
+}
+
+type AdaptationSet struct {
+	ID               string         `xml:"id,attr"`
+	ContentType      string         `xml:"contentType,attr"`
+	MimeType         string         `xml:"mimeType,attr"`
+	SegmentAlignment bool           `xml:"segmentAlignment,attr"`
+	StartWithSAP     int            `xml:"startWithSAP,attr"`
+	Representation   Representation `xml:"Representation"`
+}
+
+type Representation struct {
+	ID                        string                     `xml:"id,attr"`
+	Codecs                    string                     `xml:"codecs,attr"`
+	Bandwidth                 int                        `xml:"bandwidth,attr"`
+	Width                     int                        `xml:"width,attr,omitempty"`
+	Height                    int                        `xml:"height,attr,omitempty"`
+	AudioSamplingRate         int                        `xml:"audioSamplingRate,attr,omitempty"`
+	AudioChannelConfiguration *AudioChannelConfiguration `xml:"AudioChannelConfiguration,omitempty"`
+	SegmentTemplate           SegmentTemplate            `xml:"SegmentTemplate"`
+}
+
+type AudioChannelConfiguration struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       int    `xml:"value,attr"`
+}
+
+type SegmentTemplate struct {
+	Timescale      uint32          `xml:"timescale,attr"`
+	Initialization string          `xml:"initialization,attr"`
+	Media          string          `xml:"media,attr"`
+	StartNumber    uint64          `xml:"startNumber,attr"`
+	Timeline       SegmentTimeline `xml:"SegmentTimeline"`
+}
+
+type SegmentTimeline struct {
+	S []S `xml:"S"`
+}
+
+// S 一段时间轴，T 只在与上一段不连续时输出
+type S struct {
+	T *uint64 `xml:"t,attr,omitempty"`
+	D uint64  `xml:"d,attr"`
+}
+
+func newMPD(availStart time.Time, segmentDuration time.Duration, baseURL, query, location string) *MPD {
+	return &MPD{
+		Xmlns:                 "urn:mpeg:dash:schema:mpd:2011",
+		Profiles:              "urn:mpeg:dash:profile:isoff-live:2011,urn:mpeg:dash:profile:cmaf:2019",
+		Type:                  "dynamic",
+		AvailabilityStartTime: availStart.UTC().Format("2006-01-02T15:04:05.000Z"),
+		PublishTime:           time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		MinimumUpdatePeriod:   isoDuration(segmentDuration),
+		MinBufferTime:         isoDuration(2 * segmentDuration),
+		// 播放器离直播点保持 3 个分片，留出上游抖动的余量
+		SuggestedPresentationDelay: isoDuration(3 * segmentDuration),
+		BaseURL:                    baseURL,
+		Location:                   location,
+		Period:                     Period{ID: "0", Start: "PT0S"},
+		query:                      query,
+	}
+}
+
+// addAdaptationSet 为一个轨道生成 AdaptationSet，prefix 为分片文件名前缀
+func (m *MPD) addAdaptationSet(track *fmp4.Track, segs []*hlsBroadcast.Segment, prefix string) {
+	timeline := SegmentTimeline{}
+	var next uint64
+	var bytes int
+	var total float64
+	for i, seg := range segs {
+		s := S{D: seg.Ticks}
+		if i == 0 || seg.BaseTime != next {
+			t := seg.BaseTime
+			s.T = &t
+		}
+		timeline.S = append(timeline.S, s)
+		next = seg.BaseTime + seg.Ticks
+		bytes += len(seg.Data)
+		total += seg.Dur
+	}
+	if depth := time.Duration(total * float64(time.Second)); depth > m.windowDepth {
+		m.windowDepth = depth
+		m.TimeShiftBufferDepth = isoDuration(depth)
+	}
+
+	// 按窗口内的平均码率估算 bandwidth
+	bandwidth := 0
+	if total > 0 {
+		bandwidth = int(float64(bytes*8) / total)
+	}
+
+	rep := Representation{
+		ID:        prefix,
+		Codecs:    track.CodecString(),
+		Bandwidth: bandwidth,
+		SegmentTemplate: SegmentTemplate{
+			Timescale:      track.Timescale,
+			Initialization: prefix + "-init.mp4" + m.query,
+			Media:          prefix + "-$Number$.m4s" + m.query,
+			StartNumber:    segs[0].Seq,
+			Timeline:       timeline,
+		},
+	}
+	as := AdaptationSet{
+		ID:               fmt.Sprintf("%d", len(m.Period.AdaptationSets)),
+		SegmentAlignment: true,
+		StartWithSAP:     1,
+	}
+	if track.IsVideo() {
+		as.ContentType, as.MimeType = "video", "video/mp4"
+		rep.Width, rep.Height = track.Width, track.Height
+	} else {
+		as.ContentType, as.MimeType = "audio", "audio/mp4"
+		rep.AudioSamplingRate = track.AAC.SampleRate
+		rep.AudioChannelConfiguration = &AudioChannelConfiguration{
+			SchemeIDURI: "urn:mpeg:dash:23003:3:audio_channel_configuration:2011",
+			Value:       track.AAC.Channels,
+		}
+	}
+	as.Representation = rep
+	m.Period.AdaptationSets = append(m.Period.AdaptationSets, as)
+}
+
+func (m *MPD) marshal() ([]byte, error) {
+	out, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// isoDuration 转换为 ISO 8601 时长，如 PT2.000S
+func isoDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%.3fS", d.Seconds())
+}
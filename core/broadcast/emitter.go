@@ -0,0 +1,73 @@
+package broadcast
+
+import (
+	"pull2push/core/client"
+	"pull2push/event"
+	"pull2push/event/payload"
+	"sync/atomic"
+)
+
+// Emitter 发布直播间事件，嵌入到 Broker 和各个广播器中
+//
+//	Broker 的事件总线由 application 注入，Broker 加入广播器时再注入给广播器；没有注入时不发布。
+type Emitter struct {
+	bus       atomic.Pointer[event.EventBus]
+	published atomic.Bool // 广播器发布过 StreamPublished 还没有发布 StreamUnpublished
+}
+
+// SetEventBus 注入事件总线
+func (e *Emitter) SetEventBus(bus *event.EventBus) {
+	e.bus.Store(bus)
+}
+
+// EventBus 返回注入的事件总线，没有注入时为 nil
+func (e *Emitter) EventBus() *event.EventBus {
+	return e.bus.Load()
+}
+
+// Emit 发布事件，订阅方默认不阻塞发布方，调用方可能持有 Broker、广播器的锁
+func (e *Emitter) Emit(eventType event.EventType, p any) {
+	if bus := e.bus.Load(); bus != nil {
+		bus.Publish(event.Event{Type: eventType, Payload: p})
+	}
+}
+
+// EmitPublished 开始推流、拉流转推连上上游时发布 StreamPublished，重连、切换上游时不重复发布；没有注入事件总线时不记录
+func (e *Emitter) EmitPublished(p payload.StreamPayload) {
+	if e.bus.Load() != nil && e.published.CompareAndSwap(false, true) {
+		e.Emit(event.StreamPublished, p)
+	}
+}
+
+// EmitUnpublished 推流结束、拉流转推停止或连不上上游时发布 StreamUnpublished，和 EmitPublished 成对
+func (e *Emitter) EmitUnpublished(p payload.StreamPayload) {
+	if e.published.CompareAndSwap(true, false) {
+		e.Emit(event.StreamUnpublished, p)
+	}
+}
+
+// FailoverHandler 返回 Upstreams.OnSwitch 回调，把上游切换发布为 UpstreamFailover 事件
+func (e *Emitter) FailoverHandler(protocol string) func(UpstreamSwitchEvent) {
+	return func(se UpstreamSwitchEvent) {
+		e.Emit(event.UpstreamFailover, payload.FailoverPayload{
+			BroadcasterKey: se.BroadcasterKey,
+			Protocol:       protocol,
+			From:           se.From,
+			To:             se.To,
+			Reason:         se.Reason,
+			FailBack:       se.FailBack,
+		})
+	}
+}
+
+// EmitViewerRemoved 客户端从广播器移除后调用：被服务端断开（client.Kickable）的发布 ViewerKicked，其余发布 ViewerLeft
+func (e *Emitter) EmitViewerRemoved(c client.LiveClient, p payload.ViewerPayload) {
+	if k, ok := c.(client.Kickable); ok {
+		if reason := k.KickReason(); reason != "" {
+			p.Reason = reason
+			e.Emit(event.ViewerKicked, p)
+			return
+		}
+	}
+	e.Emit(event.ViewerLeft, p)
+}
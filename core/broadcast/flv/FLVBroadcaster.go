@@ -6,31 +6,65 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"log/slog"
 	"net"
 	"net/http"
 	"pull2push/core/broadcast"
 	"pull2push/core/client"
+	"pull2push/core/media/av"
+	"pull2push/core/media/rtsp"
+	"pull2push/core/media/testsrc"
+	"pull2push/event"
+	"pull2push/event/payload"
+	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	reconnectTimestampGap = 40               // 重连后第一个 tag 与上一段最后一个 tag 的时间戳间隔（毫秒）
+	onDemandReadyTimeout  = 10 * time.Second // 按需拉流时新客户端最多等多久第一个 GOP
+)
+
 // ====================== FLVBroadcaster ======================
 
 // FLVBroadcaster 每个 直播地址 用一个 Broker 管理，里面管理了多个当前直播链接的客户端
 type FLVBroadcaster struct {
-	BroadcasterKey string      // 直播房间的唯一编号
-	UpstreamURL    string      // 直播房间的上游拉流地址
-	DataCh         chan []byte // 上游拉流缓存的数据
-	flvParser      *FLVParser  // 创建FLV解析器 (启用调试模式)
-	HeaderMutex    sync.RWMutex
-	HeaderBytes    []byte
-	HeaderParsed   bool
+	broadcast.Lifecycle
+	broadcast.Emitter
+	broadcast.StreamMeter // 上游计数，统计接口使用
+
+	BroadcasterKey string               // 直播房间的唯一编号
+	Upstreams      *broadcast.Upstreams // 直播房间的上游拉流地址，第一个为主上游，其余为备用
+	DataCh         chan []byte          // 上游拉流缓存的数据
+
+	// 输出时间轴：重连、切换上游、切换垫片后时间戳接着上一段往后排，由 cacheMutex 保护
+	timelineStarted bool
+	lastTimestamp   uint32
+
+	// 按需拉流，nil 表示创建后一直拉流
+	onDemand *broadcast.OnDemand
+
+	// 垫片：上游超过 slateAfter 没有数据时播放，见 slate.go
+	slateMutex       sync.Mutex
+	pulling          bool // 是否在拉流，没有拉流（按需拉流空闲）时不播放垫片
+	slateSource      string
+	slateAfter       time.Duration
+	slateWatching    bool
+	slate            *slatePlayer // 正在播放的垫片，nil 表示没有
+	lastUpstreamTag  time.Time    // 最后一次收到上游 tag 的时间
+	upstreamVideoSeq []byte       // 上游最近的视频序列头，切回上游时重新发送
+	upstreamAudioSeq []byte       // 上游最近的音频序列头
+
+	// 缓存相关：FLV 头 + 序列头 + 最近一个 GOP，新客户端加入时先发送，保证秒开
+	cacheMutex sync.Mutex
+	gopCache   *GOPCache
+	packetSubs map[string]broadcast.PacketHandler // 帧订阅者，DASH 等重新封装的输出使用
 
 	// 状态控制相关
-	BroadcasterCloseSig chan broadcast.BROADCAST_CLOSE_TYPE // 控制当前这个直播是否被关闭
-	stopSig             chan struct{}                       // 控制当前这个直播是否被关闭
-	once                sync.Once
+	BroadcasterCloseSig chan broadcast.BROADCAST_CLOSE_TYPE // 直播间关闭时被关闭，客户端据此结束
+	stopSig             chan struct{}                       // 直播间关闭时被关闭，拉流、垫片、状态监听协程据此退出
+	ctx                 context.Context                     // 直播间关闭时取消，打断正在进行的上游 HTTP 请求
+	cancel              context.CancelFunc
 
 	// 客户端相关
 	clientMutex    sync.Mutex                   // 客户端的异步操作控制器
@@ -39,31 +73,107 @@ type FLVBroadcaster struct {
 
 }
 
-func NewFLVBroadcaster(broadcasterKey, upstreamURL string) *FLVBroadcaster {
-	b := FLVBroadcaster{
-		BroadcasterKey: broadcasterKey,
-		UpstreamURL:    upstreamURL,
-		DataCh:         make(chan []byte, 4096), // 带缓冲，缓存 4096 个数据包
-		clientMap:      make(map[string]client.LiveClient),
-		stopSig:        make(chan struct{}),
-	}
+// NewFLVBroadcaster 创建拉流转推的广播器，创建后立即开始拉流；upstreams 为空地址列表时 panic
+func NewFLVBroadcaster(broadcasterKey string, upstreams *broadcast.Upstreams) *FLVBroadcaster {
+	b := newFLVBroadcaster(broadcasterKey, upstreams)
 
 	// start pulling loop
 	go b.PullLoop(broadcast.BroadcasterOptional{})
-	fmt.Printf("\n FLVBroadcaster = %#v \n", &b)
 
-	return &b
+	return b
 }
 
-func (fb *FLVBroadcaster) AddLiveClient(clientId string, client client.LiveClient) {
+// NewOnDemandFLVBroadcaster 创建按需拉流的广播器：第一个客户端加入时才连接上游，没有客户端超过 idleTimeout 后断开
+func NewOnDemandFLVBroadcaster(broadcasterKey string, upstreams *broadcast.Upstreams, idleTimeout time.Duration) *FLVBroadcaster {
+	b := newFLVBroadcaster(broadcasterKey, upstreams)
+	b.onDemand = broadcast.NewOnDemand(idleTimeout, b.pullOnDemand, b.clientCount)
+	return b
+}
+
+func newFLVBroadcaster(broadcasterKey string, upstreams *broadcast.Upstreams) *FLVBroadcaster {
+	if len(upstreams.URLs) == 0 {
+		panic("FLVBroadcaster 至少需要一个上游地址：" + broadcasterKey)
+	}
+	upstreams.BroadcasterKey = broadcasterKey
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &FLVBroadcaster{
+		BroadcasterKey:      broadcasterKey,
+		Upstreams:           upstreams,
+		DataCh:              make(chan []byte, 4096), // 带缓冲，缓存 4096 个数据包
+		clientMap:           make(map[string]client.LiveClient),
+		BroadcasterCloseSig: make(chan broadcast.BROADCAST_CLOSE_TYPE),
+		ClientCloseSig:      make(chan string),
+		stopSig:             make(chan struct{}),
+		ctx:                 ctx,
+		cancel:              cancel,
+		gopCache:            NewGOPCache(),
+		packetSubs:          make(map[string]broadcast.PacketHandler),
+	}
+	upstreams.OnSwitch = b.FailoverHandler(payload.ProtocolFLV)
+	go b.ListenStatus()
+	return b
+}
+
+// pullOnDemand 按需拉流的一次拉流，有客户端时开始，空闲超时后 stop 被关闭
+func (fb *FLVBroadcaster) pullOnDemand(stop <-chan struct{}) {
+	log.Printf("[pull:%s] first viewer arrived, start pulling", fb.BroadcasterKey)
+	fb.pullLoop(stop)
+	log.Printf("[pull:%s] idle for %s, stop pulling", fb.BroadcasterKey, fb.onDemand.IdleTimeout)
+}
+
+// Touch 实现 broadcast.Touchable，按需拉流时没有在拉流则开始拉流
+func (fb *FLVBroadcaster) Touch() {
+	if fb.onDemand != nil {
+		fb.onDemand.Touch()
+	}
+}
+
+// clientCount 当前客户端数量
+func (fb *FLVBroadcaster) clientCount() int {
 	fb.clientMutex.Lock()
 	defer fb.clientMutex.Unlock()
+	return len(fb.clientMap)
+}
 
+// AddLiveClient 新增客户端，先发送缓存的 FLV 头和 GOP，再加入分发列表
+//
+//	按需拉流时会先触发拉流，并等到收到第一个 GOP（最多 onDemandReadyTimeout）再加入，客户端拿到的第一帧就是关键帧。
+func (fb *FLVBroadcaster) AddLiveClient(clientId string, client client.LiveClient) {
+	// 已经关闭的直播间不再加入，客户端收到 BroadcasterCloseSig 后自己结束
+	if _, closed := fb.Closed(); closed {
+		return
+	}
+	if fb.onDemand != nil {
+		fb.onDemand.Touch()
+		if !fb.onDemand.WaitReady(onDemandReadyTimeout) {
+			log.Printf("[pull:%s] upstream not ready after %s, add client %s anyway", fb.BroadcasterKey, onDemandReadyTimeout, clientId)
+		}
+	}
+
+	fb.cacheMutex.Lock()
+	defer fb.cacheMutex.Unlock()
+
+	if init := fb.gopCache.InitBytes(); len(init) > 0 {
+		client.Broadcast(init)
+	}
+
+	fb.clientMutex.Lock()
+	_, exists := fb.clientMap[clientId]
 	fb.clientMap[clientId] = client
+	viewers := len(fb.clientMap)
+	fb.clientMutex.Unlock()
+
+	if !exists {
+		fb.Emit(event.ViewerJoined, payload.ViewerPayload{BroadcasterKey: fb.BroadcasterKey, Protocol: payload.ProtocolFLV, ClientId: clientId, Viewers: viewers})
+	}
 }
 
 // RemoveLiveClient 移除客户端
+//
+//	持有 cacheMutex 等正在进行的分发结束，返回后不会再往这个客户端的发送队列放数据。
 func (fb *FLVBroadcaster) RemoveLiveClient(clientId string) {
+	fb.cacheMutex.Lock()
+	defer fb.cacheMutex.Unlock()
 	fb.clientMutex.Lock()
 	defer fb.clientMutex.Unlock()
 	liveClient := fb.clientMap[clientId]
@@ -71,6 +181,8 @@ func (fb *FLVBroadcaster) RemoveLiveClient(clientId string) {
 		return
 	}
 	delete(fb.clientMap, clientId)
+	fb.ClientRemoved(liveClient)
+	fb.EmitViewerRemoved(liveClient, payload.ViewerPayload{BroadcasterKey: fb.BroadcasterKey, Protocol: payload.ProtocolFLV, ClientId: clientId, Viewers: len(fb.clientMap)})
 
 	//// 如果没有客户端并且想释放 broker，可关闭 stopCh 让 PullLoop 停止（本示例保留 broker，防止频繁断开上游）
 	//if remaining == 0 {
@@ -80,135 +192,385 @@ func (fb *FLVBroadcaster) RemoveLiveClient(clientId string) {
 
 // FindLiveClient 查询 LiveClient
 func (fb *FLVBroadcaster) FindLiveClient(clientId string) (client.LiveClient, error) {
+	fb.clientMutex.Lock()
+	defer fb.clientMutex.Unlock()
 	if val, ok := fb.clientMap[clientId]; ok {
 		return val, nil
 	}
 	return nil, errors.New(fmt.Sprintf("未找到 %s 对应的 LiveClient", clientId))
 }
 
+// Stats 实现 broadcast.StatsProvider，正在播放垫片时上游状态为 UpstreamSlate
+func (fb *FLVBroadcaster) Stats() broadcast.StreamStats {
+	fb.clientMutex.Lock()
+	clients := make([]client.LiveClient, 0, len(fb.clientMap))
+	for _, c := range fb.clientMap {
+		clients = append(clients, c)
+	}
+	fb.clientMutex.Unlock()
+
+	stats := fb.Snapshot(fb.BroadcasterKey, payload.ProtocolFLV, clients)
+	fb.slateMutex.Lock()
+	if fb.slate != nil {
+		stats.UpstreamState = broadcast.UpstreamSlate
+	}
+	fb.slateMutex.Unlock()
+	return stats
+}
+
 // UpdateSourceURL 支持切换直播原地址
 func (fb *FLVBroadcaster) UpdateSourceURL(newSourceURL string) {}
 
-// ListenStatus 监听当前直播的必要状态
-func (fb *FLVBroadcaster) ListenStatus() {}
+// ListenStatus 监听当前直播的必要状态：客户端主动断开时移除客户端，直播间关闭后退出
+func (fb *FLVBroadcaster) ListenStatus() {
+	ticker := time.NewTicker(broadcast.SessionReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case clientId := <-fb.ClientCloseSig:
+			fb.RemoveLiveClient(clientId)
+		case <-ticker.C:
+			// DASH 会话没有断开事件，很久没有请求后移除
+			fb.removeExpiredClients()
+		case <-fb.stopSig:
+			return
+		}
+	}
+}
 
-// PullLoop 持续去服务端拉流
+// removeExpiredClients 移除很久没有请求的 DASH 会话
+func (fb *FLVBroadcaster) removeExpiredClients() {
+	fb.clientMutex.Lock()
+	expired := broadcast.ExpiredClients(fb.clientMap)
+	fb.clientMutex.Unlock()
+	for _, clientId := range expired {
+		fb.RemoveLiveClient(clientId)
+	}
+}
+
+// Close 关闭直播间：停止拉流和垫片，通知所有客户端结束，并从所在的 Broker 中移除
+func (fb *FLVBroadcaster) Close(reason broadcast.BROADCAST_CLOSE_TYPE) {
+	fb.Shutdown(reason, func() {
+		log.Printf("[pull:%s] broadcaster closed, reason=%d", fb.BroadcasterKey, reason)
+		if fb.onDemand != nil {
+			fb.onDemand.Close()
+		}
+		close(fb.stopSig)
+		fb.cancel()
+
+		// 先移除所有客户端再通知它们结束，客户端的 HTTP 响应结束后不会再被写入
+		fb.cacheMutex.Lock()
+		fb.clientMutex.Lock()
+		for clientId, liveClient := range fb.clientMap {
+			fb.ClientRemoved(liveClient)
+			fb.Emit(event.ViewerKicked, payload.ViewerPayload{BroadcasterKey: fb.BroadcasterKey, Protocol: payload.ProtocolFLV, ClientId: clientId, Reason: "broadcaster closed"})
+		}
+		clear(fb.clientMap)
+		fb.clientMutex.Unlock()
+		fb.cacheMutex.Unlock()
+		close(fb.BroadcasterCloseSig)
+	})
+}
+
+// PullLoop 持续去服务端拉流，上游可以是 HTTP-FLV 地址、rtsp:// 摄像头地址，file:// 本地文件，也可以是 testsrc:// 内置测试流
+//
+//	配置了多个上游时，当前上游连续连接失败、HTTP 状态码错误，或者超过 StallTimeout 没有数据，切换到下一个上游；
+//	切换只是换了数据来源，客户端连接保持不变，时间戳由 relay 接着往后排。
 func (fb *FLVBroadcaster) PullLoop(bo broadcast.BroadcasterOptional) {
+	fb.pullLoop(fb.stopSig)
+}
+
+// pullLoop 拉流直到 stop 被关闭；按需拉流时每次有观众都会重新调用
+func (fb *FLVBroadcaster) pullLoop(stop <-chan struct{}) {
+	fb.setPulling(true)
+	defer fb.setPulling(false)
+	defer fb.EmitUnpublished(payload.StreamPayload{BroadcasterKey: fb.BroadcasterKey, Protocol: payload.ProtocolFLV, Reason: "stopped"})
+	defer fb.SetUpstream("", broadcast.UpstreamIdle)
+
 	backoff := time.Second
 	for {
-		log.Println("dial upstream", fb.UpstreamURL)
-		req, _ := http.NewRequest("GET", fb.UpstreamURL, nil)
-		// add headers typical for FLV
-		req.Header.Set("User-Agent", "Go-Relay-Flv/1.0")
-		req.Header.Set("Accept", "*/*")
-		client := &http.Client{
-			Timeout: 0, // streaming
-			Transport: &http.Transport{
-				// keep-alive
-				DialContext: (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
-			},
+		select {
+		case <-stop:
+			return
+		default:
 		}
+
+		index, upstreamURL := fb.Upstreams.Current()
+		log.Println("dial upstream", upstreamURL)
+		fb.SetUpstream(upstreamURL, broadcast.UpstreamConnecting)
 		// 拉流
-		resp, err := client.Do(req)
+		body, err := fb.openUpstream(upstreamURL)
 		// 失败重试
 		if err != nil {
-			time.Sleep(backoff)
+			log.Println("upstream open error:", err)
+			// 断开后重连不上，直播间没有数据了
+			fb.EmitUnpublished(payload.StreamPayload{BroadcasterKey: fb.BroadcasterKey, Protocol: payload.ProtocolFLV, URL: upstreamURL, Reason: "open failed: " + err.Error()})
+			if fb.Upstreams.ReportFailure("open failed: "+err.Error(), false) {
+				// 已经切换到下一个上游，立即连接
+				backoff = time.Second
+				continue
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(backoff):
+			}
 			backoff *= 2
 			if backoff > 30*time.Second {
 				backoff = 30 * time.Second
 			}
 			continue
 		}
-		if resp.StatusCode != http.StatusOK {
-			log.Println("upstream bad status:", resp.Status)
-			resp.Body.Close()
-			time.Sleep(backoff)
-			continue
-		}
-
-		//fb.doFlvParse(resp.Body)
 
 		// 成功连接，重置 backoff
 		backoff = time.Second
 
-		// 读取本次拉到的流数据，并且进行数据分发
-		buf := make([]byte, 4096)
-		for {
-			n, err := resp.Body.Read(buf)
-			if n > 0 {
-				// copy bytes to avoid race
-				cp := make([]byte, n)
-				copy(cp, buf[:n])
-				fb.Broadcast2LiveClient(cp)
+		// 超过 StallTimeout 没有数据时断开；在备用上游上时定时探测主上游，恢复后断开当前连接切回去
+		stallReader := broadcast.NewStallReader(body, fb.Upstreams.StallTimeout)
+		failBackStop := make(chan struct{})
+		go fb.Upstreams.WatchFailBack(failBackStop, fb.probeUpstream, func() { _ = stallReader.Close() })
+		// 停止拉流时断开上游，让阻塞的读取返回
+		go func() {
+			select {
+			case <-stop:
+				_ = stallReader.Close()
+			case <-failBackStop:
 			}
-			if err != nil {
-				if err == io.EOF {
-					log.Println("upstream EOF, reconnecting", "退出拉流过程")
-					break
-				} else if "unexpected EOF" == err.Error() {
-					log.Println("upstream read error:", "上游直播关闭", "退出拉流过程")
-					break
-				} else {
-					log.Println("upstream read error:", err)
-				}
-				resp.Body.Close()
-				break
-			}
-		}
+		}()
+
+		// 按 tag 读取本次拉到的流数据，并且进行数据分发
+		connected, err := fb.relay(upstreamURL, stallReader)
+		close(failBackStop)
+		stallReader.Close()
 
-		// 如果 stop 信号被触发，可以退出（此实现未触发 stop）
 		select {
-		case <-fb.stopSig:
+		case <-stop:
+			log.Println("upstream pull stopped", upstreamURL)
+			fb.emitUpstreamLost(connected, upstreamURL, "stopped")
 			return
 		default:
 		}
 
+		if current, _ := fb.Upstreams.Current(); current != index {
+			log.Println("upstream switched back to primary")
+			fb.emitUpstreamLost(connected, upstreamURL, "switched back to primary")
+		} else if stallReader.Stalled() {
+			log.Println("upstream stalled:", upstreamURL)
+			reason := fmt.Sprintf("no data for %s", fb.Upstreams.StallTimeout)
+			fb.emitUpstreamLost(connected, upstreamURL, reason)
+			fb.Upstreams.ReportFailure(reason, true)
+		} else if err == nil || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Println("upstream EOF, reconnecting", "上游直播关闭", "退出拉流过程")
+			fb.emitUpstreamLost(connected, upstreamURL, "upstream closed")
+			fb.Upstreams.ReportFailure("upstream closed", false)
+		} else {
+			log.Println("upstream read error:", err)
+			fb.emitUpstreamLost(connected, upstreamURL, "read error: "+err.Error())
+			fb.Upstreams.ReportFailure("read error: "+err.Error(), false)
+		}
+
 		// small backoff before reconnect
-		time.Sleep(500 * time.Millisecond)
+		select {
+		case <-stop:
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
 	}
 }
 
-func (fb *FLVBroadcaster) doFlvParse(body io.ReadCloser) {
+// emitUpstreamLost 收到过数据的上游断开时发布 UpstreamLost，和 relay 发布的 UpstreamConnected 成对
+func (fb *FLVBroadcaster) emitUpstreamLost(connected bool, upstreamURL, reason string) {
+	if connected {
+		fb.Emit(event.UpstreamLost, payload.UpstreamPayload{BroadcasterKey: fb.BroadcasterKey, Protocol: payload.ProtocolFLV, URL: upstreamURL, Reason: reason})
+	}
+}
 
-	ctx := context.Background()
-	_ = ctx
-	// 创建FLV解析器 (启用调试模式)
-	fb.flvParser = NewFLVParser(true)
+// probeUpstream 探测上游是否可用：能连上并在 5 秒内收到 FLV 头
+func (fb *FLVBroadcaster) probeUpstream(upstreamURL string) error {
+	body, err := fb.openUpstream(upstreamURL)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	timer := time.AfterFunc(5*time.Second, func() { _ = body.Close() })
+	defer timer.Stop()
 
-	fmt.Println("开始解析FLV流...")
-	fmt.Println("等待解析视频和音频标签...")
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(body, head); err != nil {
+		return err
+	}
+	if string(head) != "FLV" {
+		return errors.New("upstream is not FLV")
+	}
+	return nil
+}
 
-	// 使用带超时的方法解析FLV头部和初始标签
-	err := fb.flvParser.ParseInitialTags(ctx, body)
+// openUpstream 连接上游，返回 FLV 字节流；rtsp:// 地址由 RTSP 客户端拉取 RTP 后转成 FLV，file:// 地址循环播放本地文件，testsrc:// 为内置测试流
+func (fb *FLVBroadcaster) openUpstream(upstreamURL string) (io.ReadCloser, error) {
+	if strings.HasPrefix(upstreamURL, "rtsp://") {
+		return rtsp.OpenFLV(upstreamURL)
+	}
+	if strings.HasPrefix(upstreamURL, "file://") {
+		return OpenFileSource(upstreamURL)
+	}
+	if strings.HasPrefix(upstreamURL, "testsrc://") {
+		return testsrc.OpenFLV(upstreamURL)
+	}
+
+	req, _ := http.NewRequestWithContext(fb.ctx, "GET", upstreamURL, nil)
+	// add headers typical for FLV
+	req.Header.Set("User-Agent", "Go-Relay-Flv/1.0")
+	req.Header.Set("Accept", "*/*")
+	client := &http.Client{
+		Timeout: 0, // streaming
+		Transport: &http.Transport{
+			// keep-alive
+			DialContext: (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
+		},
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Printf("解析FLV失败: %v\n", err)
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New("upstream bad status: " + resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// timelineOffset 一段输入（一次上游连接或一次垫片）的时间戳偏移
+type timelineOffset struct {
+	offset int64
+	set    bool
+}
+
+// rebaseTimestamp 把输入的时间戳改写到输出时间轴上，保证整路输出单调递增，调用方持有 cacheMutex
+//
+//	偏移量按第一个时间戳不为 0 的音视频 tag 计算（中途接入的直播，metadata 和序列头的时间戳往往是 0），在此之前的 tag 沿用上一段的最后时间戳。
+func (fb *FLVBroadcaster) rebaseTimestamp(tl *timelineOffset, tagType uint8, ts uint32) uint32 {
+	if !fb.timelineStarted {
+		// 第一段输入保留原始时间戳
+		fb.timelineStarted, tl.set = true, true
+	}
+	if !tl.set && tagType != TagTypeScript && ts > 0 {
+		tl.offset = int64(fb.lastTimestamp) + reconnectTimestampGap - int64(ts)
+		tl.set = true
+	}
+	timestamp := fb.lastTimestamp
+	if tl.set {
+		timestamp = uint32(max(int64(ts)+tl.offset, 0))
+	}
+	fb.lastTimestamp = max(fb.lastTimestamp, timestamp)
+	return timestamp
+}
 
-		panic(err)
+// writeTag 改写时间戳后写入 GOP 缓存并分发给客户端和帧订阅者，tl 为 nil 时使用输出时间轴的当前时间戳
+func (fb *FLVBroadcaster) writeTag(tl *timelineOffset, tagType uint8, ts uint32, data []byte) {
+	// 改写时间戳、缓存、分发在同一把锁内完成，保证新加入的客户端不会漏掉或重复收到 tag，上游和垫片的写入也不会交错
+	fb.cacheMutex.Lock()
+	defer fb.cacheMutex.Unlock()
+	timestamp := fb.lastTimestamp
+	if tl != nil {
+		timestamp = fb.rebaseTimestamp(tl, tagType, ts)
+	}
+	raw := fb.gopCache.WriteTag(tagType, timestamp, data)
+	if fb.onDemand != nil && fb.gopCache.Ready() {
+		fb.onDemand.MarkReady()
+	}
+	if len(fb.packetSubs) > 0 {
+		if pkt, ok := av.DemuxFLVTag(tagType, timestamp, data); ok {
+			for _, handler := range fb.packetSubs {
+				handler(pkt)
+			}
+		}
 	}
-	fb.flvParser.PrintRequiredTags()
+	fb.Broadcast2LiveClient(raw)
+}
 
-	// 获取必要的FLV头和标签字节
-	headerBytes, err := fb.flvParser.GetRequiredTagsBytes()
+// relay 逐个解析上游的 FLV tag，写入 GOP 缓存后分发给客户端和帧订阅者
+//
+//	重连或切换上游后上游的时间戳从头开始，改写成接着上一段往后排，已经连着的客户端不用重新连接。
+//	正在播放垫片时，收到第一个上游 tag 就停止垫片，重新发送上游序列头，并等到关键帧再发送视频。
+//	connected 表示是否收到过上游的 tag，收到第一个 tag 时发布 UpstreamConnected。
+func (fb *FLVBroadcaster) relay(upstreamURL string, body io.Reader) (connected bool, err error) {
+	parser := NewFLVParser(false)
+	header, err := parser.ParseHeader(body)
 	if err != nil {
-		body.Close()
-		fmt.Println("获取FLV头部字节失败: %v", err)
+		return false, err
 	}
 
-	// 保存头部字节供后续使用
-	fb.HeaderMutex.Lock()
-	fb.HeaderBytes = headerBytes
-	fb.HeaderParsed = true
-	fb.HeaderMutex.Unlock()
+	tl := &timelineOffset{}
+	first, waitKeyFrame := true, false
+	for {
+		tag, err := parser.ParseNextTag(body)
+		if err != nil {
+			return !first, err
+		}
+		fromSlate := fb.markUpstreamTag()
+		MeterTag(&fb.StreamMeter, tag)
+		if first {
+			fb.Upstreams.ReportSuccess()
+			fb.SetUpstream(upstreamURL, broadcast.UpstreamStreaming)
+			fb.EmitPublished(payload.StreamPayload{BroadcasterKey: fb.BroadcasterKey, Protocol: payload.ProtocolFLV, URL: upstreamURL})
+			fb.Emit(event.UpstreamConnected, payload.UpstreamPayload{BroadcasterKey: fb.BroadcasterKey, Protocol: payload.ProtocolFLV, URL: upstreamURL})
+			fb.cacheMutex.Lock()
+			fb.gopCache.Reset(header.HasVideo, header.HasAudio)
+			fb.cacheMutex.Unlock()
+			first = false
+		}
+		if fromSlate {
+			tl.set, waitKeyFrame = false, true
+			if fb.upstreamVideoSeq != nil {
+				fb.writeTag(nil, TagTypeVideo, 0, fb.upstreamVideoSeq)
+			}
+			if fb.upstreamAudioSeq != nil {
+				fb.writeTag(nil, TagTypeAudio, 0, fb.upstreamAudioSeq)
+			}
+		}
 
-	slog.Info("FLV头部解析完成，等待客户端连接开始转发", "bytes", len(headerBytes))
+		// 记下上游的序列头，从垫片切回时重新发送
+		if isSequenceHeader(tag.TagType, tag.RawData) {
+			if tag.TagType == TagTypeVideo {
+				fb.upstreamVideoSeq = tag.RawData
+			} else {
+				fb.upstreamAudioSeq = tag.RawData
+			}
+		} else if waitKeyFrame && tag.TagType == TagTypeVideo {
+			if len(tag.RawData) == 0 || tag.RawData[0]>>4 != 1 {
+				continue
+			}
+			waitKeyFrame = false
+		}
 
+		fb.writeTag(tl, tag.TagType, tag.Timestamp, tag.RawData)
+	}
 }
+
+// SubscribePackets 实现 broadcast.PacketSource，订阅时先回放序列头和最近一个 GOP
+func (fb *FLVBroadcaster) SubscribePackets(subscriberId string, handler broadcast.PacketHandler) func() {
+	fb.cacheMutex.Lock()
+	defer fb.cacheMutex.Unlock()
+
+	for _, pkt := range fb.gopCache.Packets() {
+		handler(pkt)
+	}
+	fb.packetSubs[subscriberId] = handler
+
+	return func() {
+		fb.cacheMutex.Lock()
+		defer fb.cacheMutex.Unlock()
+		delete(fb.packetSubs, subscriberId)
+	}
+}
+
 func (fb *FLVBroadcaster) Broadcast2LiveClient(data []byte) {
 	//log.Println("FLVBroadcaster.Broadcast.BroadcasterKey:", fb.BroadcasterKey)
+	fb.clientMutex.Lock()
 	if len(fb.clientMap) == 0 {
+		fb.clientMutex.Unlock()
 		return
 	}
-	fb.clientMutex.Lock()
 	// 复制 client list to avoid holding lock during send
 	clients := make([]client.LiveClient, 0, len(fb.clientMap))
 	for _, c := range fb.clientMap {
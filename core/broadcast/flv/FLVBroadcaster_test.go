@@ -0,0 +1,189 @@
+package flv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"pull2push/core/broadcast"
+	flvBroker "pull2push/core/broker/flv"
+	"pull2push/core/client"
+	flvClient "pull2push/core/client/flv"
+	"pull2push/core/media/av"
+	"pull2push/core/media/testsrc"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkGoroutines 等待 goroutine 数回到 before 以内，超时后打印所有 goroutine 的堆栈
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+			t.Fatalf("goroutines = %d, want <= %d\n%s", runtime.NumGoroutine(), before, buf[:n])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// waitFor 等待 cond 成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// newFLVUpstream 按实时速度输出 testsrc 的 HTTP-FLV 上游
+func newFLVUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		src, err := testsrc.OpenFLV("testsrc://")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer src.Close()
+		w.Header().Set("Content-Type", "video/x-flv")
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				if _, err := w.Write(buf[:n]); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// TestCloseStopsGoroutines 关闭直播间后客户端结束、直播间从 Broker 中移除，拉流、客户端和上游连接的 goroutine 全部退出
+func TestCloseStopsGoroutines(t *testing.T) {
+	tests := []struct {
+		name     string
+		onDemand bool
+	}{
+		{"always on", false},
+		{"on demand", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFLVUpstream(t)
+			before := runtime.NumGoroutine()
+
+			broker := flvBroker.NewFLVBroker()
+			var fb *FLVBroadcaster
+			if tt.onDemand {
+				fb = NewOnDemandFLVBroadcaster("leak-flv", broadcast.NewUpstreams(upstream.URL), time.Minute)
+				fb.Touch()
+			} else {
+				fb = NewFLVBroadcaster("leak-flv", broadcast.NewUpstreams(upstream.URL))
+			}
+			broker.AddBroadcaster("leak-flv", fb)
+			waitFor(t, "upstream data", func() bool { return fb.Stats().BytesIn > 0 })
+
+			var clients []*flvClient.FLVLiveClient
+			for i := 0; i < 2; i++ {
+				clientId := "viewer-" + strconv.Itoa(i)
+				c, _ := gin.CreateTestContext(httptest.NewRecorder())
+				c.Request = httptest.NewRequest(http.MethodGet, "/api/live/flv/leak-flv/"+clientId, nil)
+				liveClient, err := flvClient.NewFLVLiveClient(c, "leak-flv", clientId, fb.ClientCloseSig, fb.BroadcasterCloseSig)
+				if err != nil {
+					t.Fatal(err)
+				}
+				fb.AddLiveClient(clientId, liveClient)
+				clients = append(clients, liveClient)
+			}
+			unsubscribe := fb.SubscribePackets("packets", func(pkt *av.Packet) {})
+			waitFor(t, "clients receiving", func() bool { return fb.Stats().BytesOut > 0 })
+
+			fb.Close(broadcast.BrokerClosed)
+			for _, liveClient := range clients {
+				select {
+				case <-liveClient.Done():
+				case <-time.After(5 * time.Second):
+					t.Fatal("client not closed after broadcaster Close")
+				}
+			}
+			if _, err := broker.FindBroadcaster("leak-flv"); err == nil {
+				t.Fatal("broadcaster still in broker after Close")
+			}
+			// 关闭后不再接收新的客户端，重复关闭不会 panic
+			fb.Close(broadcast.BrokerClosed)
+			unsubscribe()
+
+			checkGoroutines(t, before)
+		})
+	}
+}
+
+// sessionClient 短请求会话的客户端，expired 表示很久没有请求
+type sessionClient struct {
+	expired atomic.Bool
+}
+
+func (sc *sessionClient) Broadcast(data []byte)    {}
+func (sc *sessionClient) Listen()                  {}
+func (sc *sessionClient) GetDataChan() chan []byte { return nil }
+func (sc *sessionClient) Stats() client.Stats      { return client.Stats{} }
+func (sc *sessionClient) Expired() bool            { return sc.expired.Load() }
+
+// TestRemoveExpiredClients 很久没有请求的 DASH 会话从直播间移除，其余客户端保留
+func TestRemoveExpiredClients(t *testing.T) {
+	upstream := newFLVUpstream(t)
+	fb := NewFLVBroadcaster("expire-flv", broadcast.NewUpstreams(upstream.URL))
+	defer fb.Close(broadcast.BrokerClosed)
+
+	idle := &sessionClient{}
+	idle.expired.Store(true)
+	fb.AddLiveClient("dash-idle", idle)
+	fb.AddLiveClient("dash-active", &sessionClient{})
+	fb.removeExpiredClients()
+
+	if _, err := fb.FindLiveClient("dash-idle"); err == nil {
+		t.Fatal("expired session not removed")
+	}
+	if _, err := fb.FindLiveClient("dash-active"); err != nil {
+		t.Fatal("active session removed:", err)
+	}
+}
+
+// TestOnDemandStopsAfterSessionsExpire 按需拉流的直播间只剩过期的 DASH 会话时，移除后观众数回到 0，空闲超时后停止拉流
+func TestOnDemandStopsAfterSessionsExpire(t *testing.T) {
+	upstream := newFLVUpstream(t)
+	fb := NewOnDemandFLVBroadcaster("expire-on-demand", broadcast.NewUpstreams(upstream.URL), 200*time.Millisecond)
+	defer fb.Close(broadcast.BrokerClosed)
+
+	session := &sessionClient{}
+	fb.AddLiveClient("dash-viewer", session)
+	waitFor(t, "pulling", func() bool { return fb.Stats().UpstreamState == broadcast.UpstreamStreaming })
+
+	// 会话还在时一直拉流
+	time.Sleep(500 * time.Millisecond)
+	if state := fb.Stats().UpstreamState; state != broadcast.UpstreamStreaming {
+		t.Fatalf("upstream state with a viewer = %s, want streaming", state)
+	}
+
+	session.expired.Store(true)
+	fb.removeExpiredClients()
+	if n := fb.clientCount(); n != 0 {
+		t.Fatalf("viewers after expired session removed = %d, want 0", n)
+	}
+	waitFor(t, "idle stop", func() bool { return fb.Stats().UpstreamState == broadcast.UpstreamIdle })
+}
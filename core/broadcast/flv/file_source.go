@@ -0,0 +1,175 @@
+package flv
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"pull2push/core/media/av"
+	"strings"
+	"time"
+)
+
+/*
+本地文件源
+	没有直播源的垫片频道、测试直播间，用本地 FLV 文件 24 小时循环播放。
+	上游地址为 file:// 开头：
+		file:///data/slate.flv          单个文件循环播放
+		file:///data/channel1.m3u       播放列表，每行一个文件（相对路径相对于播放列表所在目录），# 开头为注释
+	播放列表每播完一轮重新读取一次，修改节目单不用重启服务。
+	按 tag 时间戳实时发送；文件之间、每一轮之间时间戳接着上一个文件往后排，播放端看到的是一路不间断的直播。
+*/
+
+// fileSourceFrameGap 文件衔接处时间戳的默认间隔（毫秒），文件中无法推算帧间隔时使用
+const fileSourceFrameGap = 40
+
+// OpenFileSource 打开 file:// 上游，返回按实时速度输出的 FLV 字节流，关闭返回的 ReadCloser 后停止读取文件
+func OpenFileSource(upstreamURL string) (io.ReadCloser, error) {
+	schedulePath := strings.TrimPrefix(upstreamURL, "file://")
+	files, err := loadSchedule(schedulePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// FLV 头按第一个文件的音视频情况输出
+	f, err := os.Open(files[0])
+	if err != nil {
+		return nil, err
+	}
+	header, err := NewFLVParser(false).ParseHeader(bufio.NewReader(f))
+	f.Close()
+	if err != nil {
+		return nil, errors.New("文件不是 FLV！！！" + files[0] + " " + err.Error())
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(loopSchedule(pw, schedulePath, header))
+	}()
+	return pr, nil
+}
+
+// loadSchedule 读取节目单：.m3u/.m3u8/.txt 为播放列表，其他当作单个 FLV 文件
+func loadSchedule(schedulePath string) ([]string, error) {
+	switch strings.ToLower(filepath.Ext(schedulePath)) {
+	case ".m3u", ".m3u8", ".txt":
+	default:
+		return []string{schedulePath}, nil
+	}
+
+	data, err := os.ReadFile(schedulePath)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(schedulePath), line)
+		}
+		files = append(files, line)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("播放列表为空！！！" + schedulePath)
+	}
+	return files, nil
+}
+
+// loopSchedule 按节目单循环播放，只有写入失败（读端关闭）或整轮都没有可播放的文件时返回
+func loopSchedule(w io.Writer, schedulePath string, header *FLVHeader) error {
+	if _, err := w.Write(av.FLVHeader(header.HasVideo, header.HasAudio)); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var next uint32 // 下一个文件在输出时间轴上的起点（毫秒）
+	first := true
+	for {
+		files, err := loadSchedule(schedulePath)
+		if err != nil {
+			return err
+		}
+		played := 0
+		for _, file := range files {
+			end, ok, err := playFile(w, file, next, first, start)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			next, first = end, false
+			played++
+		}
+		if played == 0 {
+			return errors.New("播放列表中没有可以播放的文件！！！" + schedulePath)
+		}
+	}
+}
+
+// playFile 播放一个文件，时间戳改写为从 base 开始，返回下一个文件的起点
+//
+//	metadata 只保留第一个文件的，文件打开失败时跳过（ok 为 false），err 只在写入失败时返回。
+func playFile(w io.Writer, file string, base uint32, withMetadata bool, start time.Time) (next uint32, ok bool, err error) {
+	f, openErr := os.Open(file)
+	if openErr != nil {
+		log.Println("跳过无法打开的文件:", file, openErr)
+		return base, false, nil
+	}
+	defer f.Close()
+
+	reader := bufio.NewReaderSize(f, 64*1024)
+	parser := NewFLVParser(false)
+	if _, parseErr := parser.ParseHeader(reader); parseErr != nil {
+		log.Println("跳过不是 FLV 的文件:", file, parseErr)
+		return base, false, nil
+	}
+
+	var firstTs uint32
+	firstTag := true
+	last, gap := base, uint32(fileSourceFrameGap)
+	for {
+		// 读到文件结尾或文件损坏都结束这个文件
+		tag, readErr := parser.ParseNextTag(reader)
+		if readErr != nil {
+			break
+		}
+		if tag.TagType == TagTypeScript && !withMetadata {
+			continue
+		}
+		if firstTag {
+			firstTs, firstTag = tag.Timestamp, false
+		}
+
+		// 改写时间戳，保证整路输出单调递增
+		timestamp := base
+		if tag.Timestamp > firstTs {
+			timestamp += tag.Timestamp - firstTs
+		}
+		if timestamp < last {
+			timestamp = last
+		}
+		if d := timestamp - last; d > 0 && d < 1000 {
+			gap = d
+		}
+		last = timestamp
+
+		// 按时间戳实时发送
+		if wait := time.Until(start.Add(time.Duration(timestamp) * time.Millisecond)); wait > 0 {
+			time.Sleep(wait)
+		}
+		if _, err := w.Write(av.FLVTagBytes(tag.TagType, timestamp, tag.RawData)); err != nil {
+			return last, true, err
+		}
+	}
+	if firstTag {
+		log.Println("跳过没有 tag 的文件:", file)
+		return base, false, nil
+	}
+	return last + gap, true, nil
+}
@@ -10,6 +10,7 @@ import (
 	"io"
 	"log"
 	"math"
+	"pull2push/core/media/av"
 	"sync"
 )
 
@@ -92,6 +93,22 @@ func NewFLVParser(debug bool) *FLVParser {
 	}
 }
 
+// ParseHeader 解析FLV头和第一个PreviousTagSize，之后可以循环调用 ParseNextTag 逐个读取标签
+func (p *FLVParser) ParseHeader(reader io.Reader) (*FLVHeader, error) {
+	header, err := p.parseHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	p.header = header
+
+	prevTagSizeBuf := make([]byte, PrevTagSizeLength)
+	if _, err := io.ReadFull(reader, prevTagSizeBuf); err != nil {
+		return nil, fmt.Errorf("读取第一个PreviousTagSize失败: %v", err)
+	}
+	p.initialPreviousTagSize = binary.BigEndian.Uint32(prevTagSizeBuf)
+	return header, nil
+}
+
 // ParseInitialTags 解析FLV流的初始标签并保存到解析器中
 func (p *FLVParser) ParseInitialTags(ctx context.Context, reader io.Reader) error {
 	// 读取FLV Header
@@ -376,7 +393,7 @@ func (p *FLVParser) PrintRequiredTags() {
 					fmt.Print("\n    ")
 				}
 			}
-			fmt.Println("\n")
+			fmt.Print("\n\n")
 
 			// 打印解析后的元数据
 			if tag.Metadata != nil {
@@ -725,8 +742,15 @@ func (p *FLVParser) parseVideoConfig(data []byte, tag *FLVTag, codecID uint8) {
 	switch codecID {
 	case CodecH264:
 		p.parseAVCConfig(data[5:], tag) // 跳过前5个字节的FLV视频tag头部
+		// 能从 SPS 解析出宽高时以 SPS 为准，不用按 Profile 估算的值
+		if cfg, err := av.ParseAVCConfig(data[5:]); err == nil && cfg.Width > 0 {
+			tag.Width, tag.Height = cfg.Width, cfg.Height
+		}
 	case CodecH265:
 		p.parseHEVCConfig(data[5:], tag) // 跳过前5个字节的FLV视频tag头部
+		if cfg, err := av.ParseHEVCConfig(data[5:]); err == nil && cfg.Width > 0 {
+			tag.Width, tag.Height = cfg.Width, cfg.Height
+		}
 	default:
 		if p.debug {
 			log.Printf("[DEBUG] 不支持的视频编解码器: %d", codecID)
@@ -1115,8 +1139,7 @@ func (p *FLVParser) parseScriptData(data []byte, tag *FLVTag) {
 	// 解析元数据属性
 	for currentPos+2 < len(data) {
 		// 检查是否到达对象结束标记
-		if currentPos+2 < len(data) && data[currentPos] == 0x00 && data[currentPos+1] == 0x00 &&
-			currentPos+2 < len(data) && data[currentPos+2] == AMF0_OBJECT_END {
+		if data[currentPos] == 0x00 && data[currentPos+1] == 0x00 && data[currentPos+2] == AMF0_OBJECT_END {
 			break
 		}
 
@@ -0,0 +1,150 @@
+package flv
+
+import (
+	"pull2push/core/media/av"
+)
+
+// maxGOPTags 单个 GOP 最多缓存的 tag 数，超过说明关键帧间隔异常，放弃缓存直到下一个关键帧
+const maxGOPTags = 4096
+
+// cachedTag 缓存的一个 tag
+type cachedTag struct {
+	tagType   uint8
+	timestamp uint32
+	data      []byte // tag data 部分
+	raw       []byte // 完整的 tag 字节（含 tag 头和 PreviousTagSize）
+}
+
+// GOPCache 缓存 FLV 头、metadata、音视频序列头以及最近一个 GOP
+//
+//	新客户端加入时先发送这些数据，播放器不用等下一个关键帧就能开始解码。
+//	非并发安全，由广播器加锁保护。
+type GOPCache struct {
+	header   []byte
+	hasVideo bool
+	metadata *cachedTag
+	videoSeq *cachedTag
+	audioSeq *cachedTag
+	gop      []*cachedTag
+}
+
+// NewGOPCache 创建 GOP 缓存
+func NewGOPCache() *GOPCache {
+	return &GOPCache{}
+}
+
+// Reset 上游重新连接时清空缓存，header 为新的 FLV 头
+func (g *GOPCache) Reset(hasVideo, hasAudio bool) {
+	g.header = av.FLVHeader(hasVideo, hasAudio)
+	g.hasVideo = hasVideo
+	g.metadata = nil
+	g.videoSeq = nil
+	g.audioSeq = nil
+	g.gop = nil
+}
+
+// WriteTag 写入一个 tag，返回完整的 tag 字节
+func (g *GOPCache) WriteTag(tagType uint8, timestamp uint32, data []byte) []byte {
+	tag := &cachedTag{
+		tagType:   tagType,
+		timestamp: timestamp,
+		data:      data,
+		raw:       av.FLVTagBytes(tagType, timestamp, data),
+	}
+
+	switch tagType {
+	case TagTypeScript:
+		g.metadata = tag
+	case TagTypeVideo:
+		if len(data) < 2 {
+			break
+		}
+		if isSequenceHeader(tagType, data) {
+			g.videoSeq = tag
+			break
+		}
+		if data[0]>>4 == 1 {
+			g.gop = []*cachedTag{tag}
+		} else if len(g.gop) > 0 {
+			g.appendGOP(tag)
+		}
+	case TagTypeAudio:
+		if isSequenceHeader(tagType, data) {
+			g.audioSeq = tag
+			break
+		}
+		if len(g.gop) > 0 {
+			g.appendGOP(tag)
+		}
+	}
+	return tag.raw
+}
+
+// isSequenceHeader 是否为 AVC/HEVC 或 AAC 的序列头
+func isSequenceHeader(tagType uint8, data []byte) bool {
+	if len(data) < 2 || data[1] != 0 {
+		return false
+	}
+	switch tagType {
+	case TagTypeVideo:
+		codecID := data[0] & 0x0F
+		return codecID == CodecH264 || codecID == CodecH265
+	case TagTypeAudio:
+		return data[0]>>4 == FormatAAC
+	}
+	return false
+}
+
+func (g *GOPCache) appendGOP(tag *cachedTag) {
+	if len(g.gop) >= maxGOPTags {
+		g.gop = nil
+		return
+	}
+	g.gop = append(g.gop, tag)
+}
+
+// HasHeader 是否已经收到 FLV 头
+func (g *GOPCache) HasHeader() bool {
+	return g.header != nil
+}
+
+// Ready 新客户端加入后能马上开始播放：有视频时已经缓存了关键帧，纯音频时收到了音频序列头
+func (g *GOPCache) Ready() bool {
+	if g.hasVideo {
+		return len(g.gop) > 0
+	}
+	return g.audioSeq != nil
+}
+
+// InitBytes 新客户端需要先发送的数据：FLV 头 + metadata + 序列头 + 最近一个 GOP
+func (g *GOPCache) InitBytes() []byte {
+	if g.header == nil {
+		return nil
+	}
+	out := append([]byte(nil), g.header...)
+	for _, t := range g.initTags() {
+		out = append(out, t.raw...)
+	}
+	return out
+}
+
+// Packets 把缓存的序列头和 GOP 转换成音视频帧，供新的帧订阅者回放
+func (g *GOPCache) Packets() []*av.Packet {
+	var pkts []*av.Packet
+	for _, t := range g.initTags() {
+		if pkt, ok := av.DemuxFLVTag(t.tagType, t.timestamp, t.data); ok {
+			pkts = append(pkts, pkt)
+		}
+	}
+	return pkts
+}
+
+func (g *GOPCache) initTags() []*cachedTag {
+	tags := make([]*cachedTag, 0, len(g.gop)+3)
+	for _, t := range []*cachedTag{g.metadata, g.videoSeq, g.audioSeq} {
+		if t != nil {
+			tags = append(tags, t)
+		}
+	}
+	return append(tags, g.gop...)
+}
@@ -0,0 +1,164 @@
+package flv
+
+import (
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+垫片（slate）
+	上游断开后 PullLoop 在退避重连，客户端收不到数据画面会卡住，很多播放器几秒后就直接断开。
+	配置垫片后，上游超过 slateAfter 没有数据就改为播放垫片（file:// 本地 FLV 循环，或 testsrc:// 内置测试流），
+	上游重新出数据后立即切回：
+		1、时间戳接着上一段往后排，客户端连接一直不断；
+		2、切到垫片时先发送垫片的序列头，切回上游时重新发送上游的序列头，并丢弃关键帧之前的视频帧，播放器不会花屏。
+	垫片和上游不会同时写入：relay 每收到一个上游 tag 都会先停止正在播放的垫片。
+*/
+
+const (
+	defaultSlateAfter  = 2 * time.Second        // 默认多久没有上游数据切到垫片
+	slateCheckInterval = 200 * time.Millisecond // 检查上游是否断流的间隔
+)
+
+// slatePlayer 一次垫片播放
+type slatePlayer struct {
+	stop chan struct{} // 关闭后停止播放
+	done chan struct{} // 播放协程退出后关闭
+
+	mu sync.Mutex
+	rc io.ReadCloser // 当前打开的垫片流，停止时关闭以打断阻塞的读取
+}
+
+// SetSlate 设置垫片地址，上游超过 after 没有数据时播放；after 不大于 0 时使用默认的 2 秒
+func (fb *FLVBroadcaster) SetSlate(source string, after time.Duration) {
+	if after <= 0 {
+		after = defaultSlateAfter
+	}
+	fb.slateMutex.Lock()
+	defer fb.slateMutex.Unlock()
+	fb.slateSource, fb.slateAfter = source, after
+	if fb.slateWatching {
+		return
+	}
+	fb.slateWatching = true
+	fb.lastUpstreamTag = time.Now()
+	go fb.watchSlate()
+}
+
+// watchSlate 定时检查上游是否断流，断流超过 slateAfter 开始播放垫片
+func (fb *FLVBroadcaster) watchSlate() {
+	ticker := time.NewTicker(slateCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fb.stopSig:
+			fb.stopSlate()
+			return
+		case <-ticker.C:
+		}
+
+		fb.slateMutex.Lock()
+		if fb.slate == nil && fb.slateSource != "" && fb.pulling && time.Since(fb.lastUpstreamTag) > fb.slateAfter {
+			log.Printf("[slate:%s] no upstream data for %s, play slate %s", fb.BroadcasterKey, fb.slateAfter, fb.slateSource)
+			fb.slate = &slatePlayer{stop: make(chan struct{}), done: make(chan struct{})}
+			go fb.playSlate(fb.slate, fb.slateSource)
+		}
+		fb.slateMutex.Unlock()
+	}
+}
+
+// setPulling 开始/停止拉流时调用，停止拉流时同时停止垫片
+func (fb *FLVBroadcaster) setPulling(pulling bool) {
+	fb.slateMutex.Lock()
+	fb.pulling = pulling
+	// 从开始拉流时算起断流时间，按需拉流重新开始时不会马上播放垫片
+	fb.lastUpstreamTag = time.Now()
+	fb.slateMutex.Unlock()
+	if !pulling {
+		fb.stopSlate()
+	}
+}
+
+// markUpstreamTag relay 收到上游 tag 时调用，正在播放垫片时停止垫片并返回 true
+func (fb *FLVBroadcaster) markUpstreamTag() bool {
+	fb.slateMutex.Lock()
+	fb.lastUpstreamTag = time.Now()
+	playing := fb.slate != nil
+	fb.slateMutex.Unlock()
+
+	if !playing {
+		return false
+	}
+	fb.stopSlate()
+	log.Printf("[slate:%s] upstream recovered, stop slate", fb.BroadcasterKey)
+	return true
+}
+
+// stopSlate 停止垫片并等待播放协程退出，之后垫片不会再写入
+func (fb *FLVBroadcaster) stopSlate() {
+	fb.slateMutex.Lock()
+	p := fb.slate
+	fb.slate = nil
+	fb.slateMutex.Unlock()
+	if p == nil {
+		return
+	}
+
+	close(p.stop)
+	p.mu.Lock()
+	if p.rc != nil {
+		_ = p.rc.Close()
+	}
+	p.mu.Unlock()
+	<-p.done
+}
+
+// playSlate 循环播放垫片，垫片流结束或出错时隔一秒重新打开，直到被停止
+func (fb *FLVBroadcaster) playSlate(p *slatePlayer, source string) {
+	defer close(p.done)
+	for {
+		rc, err := fb.openUpstream(source)
+		if err == nil {
+			p.mu.Lock()
+			p.rc = rc
+			p.mu.Unlock()
+
+			err = fb.relaySlate(p, rc)
+			_ = rc.Close()
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(time.Second):
+			log.Printf("[slate:%s] slate %s interrupted: %v", fb.BroadcasterKey, source, err)
+		}
+	}
+}
+
+// relaySlate 把垫片的 tag 接到输出时间轴上分发，垫片的 metadata 不发送，保留上游的
+func (fb *FLVBroadcaster) relaySlate(p *slatePlayer, body io.Reader) error {
+	parser := NewFLVParser(false)
+	if _, err := parser.ParseHeader(body); err != nil {
+		return err
+	}
+
+	tl := &timelineOffset{}
+	for {
+		tag, err := parser.ParseNextTag(body)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-p.stop:
+			return nil
+		default:
+		}
+		if tag.TagType == TagTypeScript {
+			continue
+		}
+		fb.writeTag(tl, tag.TagType, tag.Timestamp, tag.RawData)
+	}
+}
@@ -0,0 +1,35 @@
+package flv
+
+import (
+	"pull2push/core/broadcast"
+)
+
+// MeterTag 按 FLVParser 解析出的 tag 更新直播间统计：上游字节数、帧率、GOP 和编码信息，拉流和推流的广播器共用
+func MeterTag(m *broadcast.StreamMeter, tag *FLVTag) {
+	m.CountIn(FLVTagHeaderSize + len(tag.RawData) + PrevTagSizeLength)
+
+	switch tag.TagType {
+	case TagTypeVideo:
+		if tag.IsConfig {
+			m.SetVideo(broadcast.VideoStats{Codec: tag.Codec, Width: tag.Width, Height: tag.Height})
+			return
+		}
+		// frame type 5 为视频信息/命令帧，不是画面
+		if len(tag.RawData) > 0 && tag.RawData[0]>>4 != 5 {
+			m.SetVideo(broadcast.VideoStats{Codec: tag.Codec})
+			m.CountVideoFrame(tag.IsKeyFrame)
+		}
+	case TagTypeAudio:
+		audio := broadcast.AudioStats{Codec: tag.AudioFormat}
+		// AAC 只有序列头中的采样率、声道数是准确的
+		if tag.AudioFormat != "AAC" || tag.IsConfig {
+			audio.SampleRate, audio.Channels = tag.SampleRate, tag.Channels
+		}
+		m.SetAudio(audio)
+	case TagTypeScript:
+		// onMetaData 中的宽高，之后的序列头能解析出宽高时会覆盖
+		width, _ := tag.Metadata["width"].(float64)
+		height, _ := tag.Metadata["height"].(float64)
+		m.SetVideo(broadcast.VideoStats{Width: int(width), Height: int(height)})
+	}
+}
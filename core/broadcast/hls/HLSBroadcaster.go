@@ -13,6 +13,9 @@ import (
 	"path"
 	"pull2push/core/broadcast"
 	"pull2push/core/client"
+	"pull2push/event"
+	"pull2push/event/payload"
+	"pull2push/metrics"
 	"strconv"
 	"strings"
 	"sync"
@@ -39,16 +42,35 @@ import (
 
 // HLSBroadcaster 每个 直播地址 用一个 Broker 管理，里面管理了多个当前直播链接的客户端
 type HLSBroadcaster struct {
+	broadcast.Lifecycle
+	broadcast.Emitter
+	broadcast.StreamMeter
+
 	// 直播数据相关
-	BroadcasterKey string       // 直播房间的唯一编号
-	upstreamURL    string       // 直播房间的上游拉流地址
-	Variant        string       // 可选：固定选择带宽 id/分辨率（留空自动选最优）
-	StreamState0   *StreamState // m3u8数据分片处理器
+	BroadcasterKey string               // 直播房间的唯一编号
+	Upstreams      *broadcast.Upstreams // 直播房间的上游拉流地址，支持主备切换
+	Variant        string               // 可选：固定选择带宽 id/分辨率（留空自动选最优）
+	StreamState0   *StreamState         // m3u8数据分片处理器
+
+	// 分片下载相关
+	DownloadConcurrency int // 分片并行下载数
+	DownloadRetries     int // 单个分片失败后的重试次数
+
+	// 按需拉流，nil 表示创建后一直拉流
+	onDemand *broadcast.OnDemand
+	timeline hlsTimeline // 本地分片序列号，多次按需拉流之间保持连续，只在拉流协程中访问
+
+	// 帧订阅者，DASH 等重新封装的输出使用
+	packetMutex sync.Mutex
+	packetSubs  map[string]broadcast.PacketHandler
 
 	// 状态控制相关
-	BroadcasterCloseSig chan broadcast.BROADCAST_CLOSE_TYPE // 控制当前这个直播是否被关闭
-	once                sync.Once
-	ctx                 context.Context
+	closeSig       chan broadcast.BROADCAST_CLOSE_TYPE // 直播结束或被关闭时被关闭，客户端据此结束；回放过期后重新创建，用 CloseSignal 读取
+	closeSigClosed bool
+	ctx            context.Context // 直播间关闭时取消，拉流、状态监听协程据此退出
+	cancel         context.CancelFunc
+	status         broadcast.BROADCAST_CLOSE_TYPE // 当前直播状态：BrokerStarted/BrokerEnd/BrokerClosed
+	statusMutex    sync.RWMutex                   // 保护 status、closeSig
 
 	// 客户端相关
 	clientMutex    sync.Mutex                   // 客户端的异步操作控制器
@@ -57,23 +79,48 @@ type HLSBroadcaster struct {
 
 }
 
-func NewHLSBroadcaster(ctx context.Context, broadcasterKey, upstreamURL, variant string, buffer int) *HLSBroadcaster {
+// NewHLSBroadcaster 创建 HLS 拉流转推的广播器，创建后立即开始拉流
+func NewHLSBroadcaster(ctx context.Context, broadcasterKey string, upstreams *broadcast.Upstreams, variant string, buffer int) *HLSBroadcaster {
+	hmb := newHLSBroadcaster(ctx, broadcasterKey, upstreams, variant, buffer)
+
+	// 开始持续拉流
+	go hmb.PullLoop(broadcast.BroadcasterOptional{})
+
+	return hmb
+}
+
+// NewOnDemandHLSBroadcaster 创建按需拉流的广播器：第一次有客户端请求时才连接上游，超过 idleTimeout 没有请求后断开
+func NewOnDemandHLSBroadcaster(ctx context.Context, broadcasterKey string, upstreams *broadcast.Upstreams, variant string, buffer int, idleTimeout time.Duration) *HLSBroadcaster {
+	hmb := newHLSBroadcaster(ctx, broadcasterKey, upstreams, variant, buffer)
+	// HLS 客户端没有断开事件，只按最后一次请求时间判断是否空闲
+	hmb.onDemand = broadcast.NewOnDemand(idleTimeout, hmb.pullOnDemand, func() int { return 0 })
+	return hmb
+}
+
+func newHLSBroadcaster(ctx context.Context, broadcasterKey string, upstreams *broadcast.Upstreams, variant string, buffer int) *HLSBroadcaster {
 	if buffer == 0 {
 		buffer = 3
 	}
+	if len(upstreams.URLs) == 0 {
+		panic("HLSBroadcaster 至少需要一个上游地址：" + broadcasterKey)
+	}
+	upstreams.BroadcasterKey = broadcasterKey
+	ctx, cancel := context.WithCancel(ctx)
 	hmb := HLSBroadcaster{
 		BroadcasterKey:      broadcasterKey,
-		upstreamURL:         upstreamURL,
+		Upstreams:           upstreams,
 		Variant:             variant,
 		StreamState0:        NewStreamState(buffer),
+		DownloadConcurrency: defaultDownloadConcurrency,
+		DownloadRetries:     defaultDownloadRetries,
 		clientMap:           make(map[string]client.LiveClient),
 		ctx:                 ctx,
-		BroadcasterCloseSig: make(chan broadcast.BROADCAST_CLOSE_TYPE),
+		cancel:              cancel,
+		closeSig:            make(chan broadcast.BROADCAST_CLOSE_TYPE),
 		ClientCloseSig:      make(chan string),
 	}
 
-	// 开始持续拉流
-	go hmb.PullLoop(broadcast.BroadcasterOptional{})
+	upstreams.OnSwitch = hmb.FailoverHandler(payload.ProtocolHLS)
 
 	// 开启必要的状态监听
 	go hmb.ListenStatus()
@@ -81,6 +128,39 @@ func NewHLSBroadcaster(ctx context.Context, broadcasterKey, upstreamURL, variant
 	return &hmb
 }
 
+// pullOnDemand 按需拉流的一次拉流，有客户端请求时开始，空闲超时后 stop 被关闭
+func (hb *HLSBroadcaster) pullOnDemand(stop <-chan struct{}) {
+	// 直播已经结束，回放过期（ResetReplay）前不再重新拉流，观众直接看回放，不用等分片
+	if hb.Status() == broadcast.BrokerEnd {
+		hb.onDemand.MarkReady()
+		return
+	}
+	ctx, cancel := context.WithCancel(hb.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	log.Printf("[pull:%s] first viewer arrived, start pulling", hb.BroadcasterKey)
+	hb.pull(ctx)
+	if hb.Status() != broadcast.BrokerEnd {
+		// 丢弃空闲前的旧分片，下次拉流时序列号接着往后排并标记断点
+		hb.StreamState0.Restart()
+		log.Printf("[pull:%s] idle for %s, stop pulling", hb.BroadcasterKey, hb.onDemand.IdleTimeout)
+	}
+}
+
+// Touch 实现 broadcast.Touchable，按需拉流时每次请求都调用，没有在拉流时开始拉流
+func (hb *HLSBroadcaster) Touch() {
+	if hb.onDemand != nil {
+		hb.onDemand.Touch()
+	}
+}
+
 // ---------- HLS 拉流逻辑 ----------
 
 // resolveURL 处理相对 URI -> 绝对 URL
@@ -165,126 +245,453 @@ func (hb *HLSBroadcaster) fetchOnce(ctx context.Context, client *http.Client, u
 
 // PullWorker 持续从上游拉取分片并写入 stream state
 
+// hlsTimeline 跨上游保持连续的本地分片序列号
+type hlsTimeline struct {
+	lastLocal uint64 // 最后一个写入的本地序列号
+	sessions  int    // 已经连接过几次上游
+}
+
 // PullLoop 持续去直播原地址拉流/数据
 func (hb *HLSBroadcaster) PullLoop(bo broadcast.BroadcasterOptional) {
 	/*
 		这是一个后台 goroutine，用来持续从某个 HLS 上游地址拉取数据。
 		它先请求 Master Playlist，如果是多码率流，选择合适变体变成 Media Playlist。
-		定时轮询 Media Playlist（默认 800ms），发现新分片后下载。
-		下载到分片后，调用 stream.PushSegment() 把它放入对应的 StreamState 环形缓存。
+		按 HLS 规范自适应轮询 Media Playlist：有变化时隔一个 target duration，没变化时隔一半，出错时指数退避。
+		发现新分片后交给下载器并行下载（见 hlsDownloader.go）。
+		下载到分片后，由 insertLoop 按顺序调用 stream.PushSegment() 把它放入对应的 StreamState 环形缓存。
+		主地址失败时会回退到冗余变体的备用地址。
+		配置了多个上游时，当前上游连续失败或长时间没有新分片就切到下一个上游（见 broadcast.Upstreams），
+		切换后本地序列号接着往后排，并在第一个分片上打 EXT-X-DISCONTINUITY，播放端不用重新打开。
 
 		核心点：
 			通过 seen 维护已下载分片，避免重复下载。
 			计算本地序列号 Seq，保证分片顺序。
 			下载的分片保持原样字节，不做解码重封装，性能好且稳定。
+			遇到 EXT-X-ENDLIST 后等剩余分片写完，进入 BrokerEnd 状态并停止拉取。
 	*/
+	hb.pull(hb.ctx)
+}
 
-	log.Printf("[pull:%s] start from %s", hb.BroadcasterKey, hb.upstreamURL)
-	client := &http.Client{Timeout: 10 * time.Second}
-
-	stream := hb.StreamState0
-	seen := map[string]bool{}
-	var mediaURL string
-
-	// 初次处理 master/ media
-	p, _, err := hb.fetchOnce(hb.ctx, client, hb.upstreamURL)
-	if err != nil {
-		log.Printf("[pull:%s] fetch master/media failed: %v", hb.BroadcasterKey, err)
-		return
+// pull 拉流直到 ctx 结束或直播结束
+func (hb *HLSBroadcaster) pull(parent context.Context) {
+	// 每次拉流用自己的连接池，结束时关闭空闲的 keep-alive 连接，不然连接和它的读写 goroutine 会一直留着
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Timeout: 10 * time.Second, Transport: transport}
+
+	// 下载任务按发现顺序进入 jobs，由 insertLoop 顺序写入 StreamState，切换上游时不中断
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	concurrency := hb.DownloadConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
 	}
-	if mp, ok := p.(*m3u8.MasterPlaylist); ok {
-		v, err := pickVariant(mp, hb.Variant)
-		if err != nil {
-			log.Printf("[pull:%s] no variant: %v", hb.BroadcasterKey, err)
+	sem := make(chan struct{}, concurrency)
+	jobs := make(chan *segmentJob, 64)
+	insertDone := make(chan struct{})
+	go func() {
+		hb.insertLoop(ctx, jobs)
+		close(insertDone)
+	}()
+
+	timeline := &hb.timeline
+	errCount := 0
+	defer hb.EmitUnpublished(payload.StreamPayload{BroadcasterKey: hb.BroadcasterKey, Protocol: payload.ProtocolHLS, Reason: "stopped"})
+	defer func() {
+		if hb.Status() != broadcast.BrokerEnd {
+			hb.SetUpstream("", broadcast.UpstreamIdle)
+		}
+	}()
+	for {
+		index, upstreamURL := hb.Upstreams.Current()
+		log.Printf("[pull:%s] start from %s", hb.BroadcasterKey, upstreamURL)
+		hb.SetUpstream(upstreamURL, broadcast.UpstreamConnecting)
+
+		ended, connected, err := hb.pullSession(ctx, client, upstreamURL, sem, jobs, timeline)
+		if ctx.Err() != nil {
+			log.Printf("[pull:%s] stop", hb.BroadcasterKey)
+			hb.emitUpstreamLost(connected, upstreamURL, "stopped")
 			return
 		}
-		mediaURL, err = resolveURL(hb.upstreamURL, v.URI)
-		if err != nil {
-			log.Printf("[pull:%s] resolve media url: %v", hb.BroadcasterKey, err)
+		if ended {
+			hb.emitUpstreamLost(connected, upstreamURL, "ended")
+			hb.EmitUnpublished(payload.StreamPayload{BroadcasterKey: hb.BroadcasterKey, Protocol: payload.ProtocolHLS, URL: upstreamURL, Reason: "ended"})
+			close(jobs)
+			select {
+			case <-insertDone:
+			case <-ctx.Done():
+				return
+			}
+			hb.end(upstreamURL)
 			return
 		}
-		log.Printf("[pull:%s] choose variant bw=%d res=%s uri=%s", hb.BroadcasterKey, v.Bandwidth, v.Resolution, mediaURL)
-	} else if _, ok := p.(*m3u8.MediaPlaylist); ok {
-		mediaURL = hb.upstreamURL
-	} else {
-		log.Printf("[pull:%s] unknown playlist type", hb.BroadcasterKey)
-		return
+		if now, _ := hb.Upstreams.Current(); now != index {
+			// 切回了主上游
+			hb.emitUpstreamLost(connected, upstreamURL, "switched back to primary")
+			errCount = 0
+			continue
+		}
+
+		log.Printf("[pull:%s] upstream %s failed: %v", hb.BroadcasterKey, upstreamURL, err)
+		hb.emitUpstreamLost(connected, upstreamURL, err.Error())
+		if !connected {
+			// 断开后重连不上，直播间没有数据了
+			hb.EmitUnpublished(payload.StreamPayload{BroadcasterKey: hb.BroadcasterKey, Protocol: payload.ProtocolHLS, URL: upstreamURL, Reason: err.Error()})
+		}
+		// 连上之后失败（连续拉取失败/没有新分片）立即切换；连不上按连续失败次数判断
+		if hb.Upstreams.ReportFailure(err.Error(), connected) {
+			errCount = 0
+			continue
+		}
+		errCount++
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollErrorBackoff(hb.targetDuration(), errCount)):
+		}
 	}
+}
 
-	ticker := time.NewTicker(800 * time.Millisecond)
-	defer ticker.Stop()
+// emitUpstreamLost 连上过的上游断开时发布 UpstreamLost，和 pullSession 发布的 UpstreamConnected 成对
+func (hb *HLSBroadcaster) emitUpstreamLost(connected bool, upstreamURL, reason string) {
+	if connected {
+		hb.Emit(event.UpstreamLost, payload.UpstreamPayload{BroadcasterKey: hb.BroadcasterKey, Protocol: payload.ProtocolHLS, URL: upstreamURL, Reason: reason})
+	}
+}
 
+// pullSession 从一个上游拉流，直到直播结束（ended）、上游不健康或切回主上游（返回 nil 错误）
+//
+//	connected 表示是否成功解析过该上游的播放列表。
+func (hb *HLSBroadcaster) pullSession(ctx context.Context, client *http.Client, upstreamURL string, sem chan struct{}, jobs chan<- *segmentJob, timeline *hlsTimeline) (ended, connected bool, err error) {
+	// mediaURLs[0] 为主媒体列表，其余为 EXT-X-STREAM-INF 冗余变体提供的备用媒体列表
+	mediaURLs, err := hb.resolveMediaURLs(ctx, client, upstreamURL)
+	if err != nil {
+		return false, false, err
+	}
+	connected = true
+	hb.SetUpstream(upstreamURL, broadcast.UpstreamStreaming)
+	hb.EmitPublished(payload.StreamPayload{BroadcasterKey: hb.BroadcasterKey, Protocol: payload.ProtocolHLS, URL: upstreamURL})
+	hb.Emit(event.UpstreamConnected, payload.UpstreamPayload{BroadcasterKey: hb.BroadcasterKey, Protocol: payload.ProtocolHLS, URL: upstreamURL})
+	current := 0
+
+	// 切换上游后，本地序列号接着上一个上游往后排
+	timeline.sessions++
+	switched := timeline.sessions > 1
+	var offset int64
+	offsetSet := !switched
+
+	// 开启 FailBack 时探测主上游，恢复后结束本次拉流
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go hb.Upstreams.WatchFailBack(sessionCtx.Done(), func(primary string) error {
+		_, err := hb.resolveMediaURLs(sessionCtx, client, primary)
+		return err
+	}, cancel)
+
+	// 首次立即拉取媒体列表，之后按 target duration 自适应轮询
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	seen := map[string]bool{}
 	var lastSeq uint64
+	haveSeq := false // 是否已经收到过分片，序列号 0 也是有效的分片
+	var lastBody []byte
+	errCount := 0
+	lastNew := time.Now()
 	for {
 		select {
-		case <-hb.ctx.Done():
-			log.Printf("[pull:%s] stop", hb.BroadcasterKey)
-			return
-		case <-ticker.C:
-			p, _, err := hb.fetchOnce(hb.ctx, client, mediaURL)
+		case <-sessionCtx.Done():
+			return false, true, nil
+		case <-timer.C:
+		}
+
+		// 太久没有新分片，视为上游卡死
+		if stall := max(hb.Upstreams.StallTimeout, time.Duration(3*hb.targetDuration()*float64(time.Second))); time.Since(lastNew) > stall {
+			return false, true, errors.New("no new segment")
+		}
+
+		p, body, err := hb.fetchOnce(sessionCtx, client, mediaURLs[current])
+		if err != nil {
+			if sessionCtx.Err() != nil {
+				return false, true, nil
+			}
+			log.Printf("[pull:%s] fetch media: %v", hb.BroadcasterKey, err)
+			// 主媒体列表失败时轮换到下一个备用媒体列表
+			if len(mediaURLs) > 1 {
+				current = (current + 1) % len(mediaURLs)
+				log.Printf("[pull:%s] switch media playlist to %s", hb.BroadcasterKey, mediaURLs[current])
+			}
+			errCount++
+			if errCount >= hb.Upstreams.MaxFailures {
+				return false, true, err
+			}
+			timer.Reset(pollErrorBackoff(hb.targetDuration(), errCount))
+			continue
+		}
+		mp, ok := p.(*m3u8.MediaPlaylist)
+		if !ok {
+			log.Printf("[pull:%s] not media playlist", hb.BroadcasterKey)
+			errCount++
+			if errCount >= hb.Upstreams.MaxFailures {
+				return false, true, errors.New("not media playlist")
+			}
+			timer.Reset(pollErrorBackoff(hb.targetDuration(), errCount))
+			continue
+		}
+		errCount = 0
+		hb.Upstreams.ReportSuccess()
+		hb.setStatus(broadcast.BrokerStarted)
+
+		// 更新 target duration
+		if mp.TargetDuration > 0 {
+			hb.StreamState0.Mu.Lock()
+			hb.StreamState0.TargetDur = float64(mp.TargetDuration)
+			hb.StreamState0.Mu.Unlock()
+		}
+
+		// 刚切换过来时只取最新的一个分片，避免重复播放切换前已经播过的内容
+		segments := make([]*m3u8.MediaSegment, 0, len(mp.Segments))
+		for _, seg := range mp.Segments {
+			if seg != nil {
+				segments = append(segments, seg)
+			}
+		}
+		// 直播结束时列表里是完整的回放，缓冲放得下整个列表，不然只剩最后几个分片
+		if mp.Closed {
+			hb.StreamState0.Grow(min(len(segments), maxReplaySegments))
+		}
+
+		if !offsetSet && len(segments) > 1 {
+			segments = segments[len(segments)-1:]
+		}
+
+		// 遍历新片段，为每个新片段创建下载任务，并行下载、顺序写入
+		for _, seg := range segments {
+			absURI, err := resolveURL(mediaURLs[current], seg.URI)
 			if err != nil {
-				log.Printf("[pull:%s] fetch media: %v", hb.BroadcasterKey, err)
 				continue
 			}
-			mp, ok := p.(*m3u8.MediaPlaylist)
-			if !ok {
-				log.Printf("[pull:%s] not media playlist", hb.BroadcasterKey)
+			if seen[absURI] {
 				continue
 			}
 
-			// 更新 target duration
-			if mp.TargetDuration > 0 {
-				stream.Mu.Lock()
-				stream.TargetDur = float64(mp.TargetDuration)
-				stream.Mu.Unlock()
+			// seq：解码时 SeqId 已经是 EXT-X-MEDIA-SEQUENCE + 相对偏移，EXT-X-MEDIA-SEQUENCE:0 时第一个分片就是 0
+			seq := seg.SeqId
+			// 切换到备用媒体列表后分片地址会变化，按序列号去重
+			if haveSeq && seq <= lastSeq {
+				continue
 			}
 
-			// 遍历新片段
-			for _, seg := range mp.Segments {
-				if seg == nil {
-					continue
-				}
-				absURI, err := resolveURL(mediaURL, seg.URI)
-				if err != nil {
-					continue
-				}
-				if seen[absURI] {
-					continue
-				}
-
-				// 估算 seq：用节目序列号 + 相对偏移（若提供）
-				var seq uint64
-				if mp.SeqNo != 0 {
-					seq = uint64(mp.SeqNo) + uint64(seg.SeqId)
-				} else {
-					// 回退：自增
-					lastSeq++
-					seq = lastSeq
-				}
-
-				data, err := hb.download(hb.ctx, client, absURI)
-				if err != nil {
-					log.Printf("[pull:%s] seg dl: %v", hb.BroadcasterKey, err)
-					continue
-				}
-
-				localName := localSegName(absURI, seq)
-				fmt.Println("分片创建完成：.filename = ", localName)
-				stream.PushSegment(&Segment{
-					Seq:       seq,
-					URI:       absURI,
-					LocalName: localName,
-					Data:      data,
-					Dur:       seg.Duration,
-					Discont:   seg.Discontinuity,
-					AddedAt:   time.Now(),
-				})
-
-				seen[absURI] = true
-				lastSeq = seq
+			discont := false
+			if !offsetSet {
+				offset = int64(timeline.lastLocal+1) - int64(seq)
+				offsetSet, discont = true, true
+			}
+			local := uint64(int64(seq) + offset)
+
+			job := &segmentJob{
+				seq:     local,
+				seg:     seg,
+				urls:    segmentCandidates(mediaURLs, current, seg.URI),
+				discont: discont,
+				done:    make(chan struct{}),
 			}
+			select {
+			case jobs <- job:
+			case <-sessionCtx.Done():
+				return false, true, nil
+			}
+			hb.scheduleDownload(ctx, client, sem, job)
+
+			seen[absURI] = true
+			lastSeq, haveSeq = seq, true
+			timeline.lastLocal = local
+			lastNew = time.Now()
+		}
+
+		// 出现 EXT-X-ENDLIST：直播结束，等剩余分片写入后进入结束状态
+		if mp.Closed {
+			log.Printf("[pull:%s] EXT-X-ENDLIST received, live ended", hb.BroadcasterKey)
+			return true, true, nil
+		}
+
+		// HLS 规范：播放列表有变化时间隔一个 target duration 再拉，没变化时间隔一半
+		changed := !bytes.Equal(body, lastBody)
+		lastBody = body
+		timer.Reset(pollInterval(hb.targetDuration(), changed))
+	}
+}
+
+// resolveMediaURLs 拉取上游地址，是主清单时选出变体，返回媒体列表地址（第一个为主地址，其余为冗余变体）
+func (hb *HLSBroadcaster) resolveMediaURLs(ctx context.Context, client *http.Client, upstreamURL string) ([]string, error) {
+	p, _, err := hb.fetchOnce(ctx, client, upstreamURL)
+	if err != nil {
+		return nil, err
+	}
+	switch pl := p.(type) {
+	case *m3u8.MasterPlaylist:
+		v, err := pickVariant(pl, hb.Variant)
+		if err != nil {
+			return nil, err
+		}
+		mediaURL, err := resolveURL(upstreamURL, v.URI)
+		if err != nil {
+			return nil, err
 		}
+		mediaURLs := []string{mediaURL}
+		for _, backup := range redundantVariants(pl, v) {
+			if backupURL, err := resolveURL(upstreamURL, backup.URI); err == nil {
+				mediaURLs = append(mediaURLs, backupURL)
+			}
+		}
+		log.Printf("[pull:%s] choose variant bw=%d res=%s uri=%s backups=%d", hb.BroadcasterKey, v.Bandwidth, v.Resolution, mediaURL, len(mediaURLs)-1)
+		return mediaURLs, nil
+	case *m3u8.MediaPlaylist:
+		return []string{upstreamURL}, nil
+	default:
+		return nil, errors.New("unknown playlist type")
+	}
+}
+
+const (
+	onDemandReadyTimeout = 15 * time.Second       // 按需拉流时新客户端最多等多久第一个分片
+	minPollInterval      = 500 * time.Millisecond // 播放列表最小轮询间隔
+	maxPollErrorBackoff  = 30 * time.Second       // 播放列表拉取失败时的最大退避间隔
+)
+
+// pollInterval 根据 target duration 和本次播放列表是否变化计算下一次轮询间隔
+func pollInterval(targetDur float64, changed bool) time.Duration {
+	if targetDur <= 0 {
+		targetDur = 6
+	}
+	d := time.Duration(targetDur * float64(time.Second))
+	if !changed {
+		d /= 2
+	}
+	if d < minPollInterval {
+		d = minPollInterval
+	}
+	return d
+}
+
+// pollErrorBackoff 拉取播放列表失败后的指数退避间隔，从半个 target duration 开始翻倍
+func pollErrorBackoff(targetDur float64, errCount int) time.Duration {
+	d := pollInterval(targetDur, false)
+	for i := 1; i < errCount && d < maxPollErrorBackoff; i++ {
+		d *= 2
+	}
+	if d > maxPollErrorBackoff {
+		d = maxPollErrorBackoff
+	}
+	return d
+}
+
+// targetDuration 当前上游的 target duration（秒）
+func (hb *HLSBroadcaster) targetDuration() float64 {
+	hb.StreamState0.Mu.RLock()
+	defer hb.StreamState0.Mu.RUnlock()
+	return hb.StreamState0.TargetDur
+}
+
+// Status 返回当前直播状态
+func (hb *HLSBroadcaster) Status() broadcast.BROADCAST_CLOSE_TYPE {
+	hb.statusMutex.RLock()
+	defer hb.statusMutex.RUnlock()
+	return hb.status
+}
+
+func (hb *HLSBroadcaster) setStatus(status broadcast.BROADCAST_CLOSE_TYPE) {
+	hb.statusMutex.Lock()
+	hb.status = status
+	hb.statusMutex.Unlock()
+}
+
+// EndedAt 实现 broadcast.Replayable，返回直播结束时间
+func (hb *HLSBroadcaster) EndedAt() (time.Time, bool) {
+	return hb.StreamState0.EndedInfo()
+}
+
+// Configured 实现 broadcast.Replayable，拉流转推的直播间都是预先注册的
+func (hb *HLSBroadcaster) Configured() bool {
+	return true
+}
+
+// ResetReplay 实现 broadcast.Replayable，清空回放分片并恢复拉流
+//
+//	直播结束后状态停在 BrokerEnd、关闭信号已经关闭，这里重置状态并重新创建关闭信号：
+//	一直拉流的直播间马上重新拉流，按需拉流的等下一个观众到来时开始。
+func (hb *HLSBroadcaster) ResetReplay() {
+	hb.StreamState0.Restart()
+
+	hb.statusMutex.Lock()
+	if _, closed := hb.Closed(); closed || hb.status != broadcast.BrokerEnd {
+		hb.statusMutex.Unlock()
+		return
+	}
+	hb.status = broadcast.BrokerStarted
+	hb.closeSig, hb.closeSigClosed = make(chan broadcast.BROADCAST_CLOSE_TYPE), false
+	hb.statusMutex.Unlock()
+
+	hb.SetUpstream("", broadcast.UpstreamIdle)
+	log.Printf("[pull:%s] replay expired, broadcaster reset", hb.BroadcasterKey)
+	if hb.onDemand != nil {
+		// 结束前的那次拉流已经返回，停掉它，下一次 Touch 重新开始
+		hb.onDemand.Stop()
+		return
+	}
+	go hb.PullLoop(broadcast.BroadcasterOptional{})
+}
+
+// CloseSignal 当前的关闭信号，直播结束或直播间被关闭时被关闭，客户端据此结束
+func (hb *HLSBroadcaster) CloseSignal() chan broadcast.BROADCAST_CLOSE_TYPE {
+	hb.statusMutex.RLock()
+	defer hb.statusMutex.RUnlock()
+	return hb.closeSig
+}
+
+// end 上游直播结束：冻结分片供回放，切换到结束状态，发布 StreamEnded 并关闭关闭信号通知客户端
+func (hb *HLSBroadcaster) end(upstreamURL string) {
+	hb.StreamState0.MarkEnded()
+	hb.setStatus(broadcast.BrokerEnd)
+	hb.SetUpstream("", broadcast.UpstreamEnded)
+	log.Printf("[pull:%s] broadcaster status changed: %d", hb.BroadcasterKey, broadcast.BrokerEnd)
+	hb.Emit(event.StreamEnded, payload.StreamPayload{BroadcasterKey: hb.BroadcasterKey, Protocol: payload.ProtocolHLS, URL: upstreamURL, Reason: "ended"})
+	if segments, duration := hb.StreamState0.Totals(); segments > 0 {
+		hb.Emit(event.RecordDone, payload.RecordPayload{BroadcasterKey: hb.BroadcasterKey, Protocol: payload.ProtocolHLS, Segments: segments, Duration: duration})
 	}
+	hb.notifyClientsClosed()
+}
+
+// notifyClientsClosed 关闭关闭信号通知所有客户端，直播结束和关闭直播间都会调用，只关闭一次
+func (hb *HLSBroadcaster) notifyClientsClosed() {
+	hb.statusMutex.Lock()
+	defer hb.statusMutex.Unlock()
+	if !hb.closeSigClosed {
+		close(hb.closeSig)
+		hb.closeSigClosed = true
+	}
+}
+
+// Close 关闭直播间：停止拉流，通知所有客户端结束，并从所在的 Broker 中移除
+func (hb *HLSBroadcaster) Close(reason broadcast.BROADCAST_CLOSE_TYPE) {
+	hb.Shutdown(reason, func() {
+		log.Printf("[pull:%s] broadcaster closed, reason=%d", hb.BroadcasterKey, reason)
+		if hb.onDemand != nil {
+			hb.onDemand.Close()
+		}
+		hb.cancel()
+		hb.setStatus(reason)
+		metrics.SegmentDownloadSeconds.Delete(payload.ProtocolHLS, hb.BroadcasterKey)
+		hb.notifyClientsClosed()
+
+		hb.clientMutex.Lock()
+		for clientId, liveClient := range hb.clientMap {
+			hb.ClientRemoved(liveClient)
+			hb.Emit(event.ViewerKicked, payload.ViewerPayload{BroadcasterKey: hb.BroadcasterKey, Protocol: payload.ProtocolHLS, ClientId: clientId, Reason: "broadcaster closed"})
+		}
+		clear(hb.clientMap)
+		hb.clientMutex.Unlock()
+	})
 }
 
 func (hb *HLSBroadcaster) download(ctx context.Context, client *http.Client, u string) ([]byte, error) {
@@ -301,12 +708,29 @@ func (hb *HLSBroadcaster) download(ctx context.Context, client *http.Client, u s
 	return io.ReadAll(resp.Body)
 }
 
-// AddLiveClient 添加客户端
+// AddLiveClient 添加客户端，按需拉流时先触发拉流，并等到第一个分片（最多 onDemandReadyTimeout）
 func (hb *HLSBroadcaster) AddLiveClient(clientId string, client client.LiveClient) {
-	hb.clientMutex.Lock()
-	defer hb.clientMutex.Unlock()
+	// 已经关闭的直播间不再加入，客户端收到关闭信号后自己结束
+	if _, closed := hb.Closed(); closed {
+		return
+	}
+	if hb.onDemand != nil {
+		hb.onDemand.Touch()
+		if !hb.onDemand.WaitReady(onDemandReadyTimeout) {
+			log.Printf("[pull:%s] upstream not ready after %s, add client %s anyway", hb.BroadcasterKey, onDemandReadyTimeout, clientId)
+		}
+	}
 
+	hb.clientMutex.Lock()
+	_, exists := hb.clientMap[clientId]
 	hb.clientMap[clientId] = client
+	viewers := len(hb.clientMap)
+	hb.clientMutex.Unlock()
+
+	// 同一个客户端每次请求播放列表都会调用，只在第一次发布
+	if !exists {
+		hb.Emit(event.ViewerJoined, payload.ViewerPayload{BroadcasterKey: hb.BroadcasterKey, Protocol: payload.ProtocolHLS, ClientId: clientId, Viewers: viewers})
+	}
 }
 
 // RemoveLiveClient 移除客户端
@@ -318,6 +742,8 @@ func (hb *HLSBroadcaster) RemoveLiveClient(clientId string) {
 		return
 	}
 	delete(hb.clientMap, clientId)
+	hb.ClientRemoved(liveClient)
+	hb.EmitViewerRemoved(liveClient, payload.ViewerPayload{BroadcasterKey: hb.BroadcasterKey, Protocol: payload.ProtocolHLS, ClientId: clientId, Viewers: len(hb.clientMap)})
 
 	//// 如果没有客户端并且想释放 broker，可关闭 stopCh 让 PullLoop 停止（本示例保留 broker，防止频繁断开上游）
 	//if remaining == 0 {
@@ -327,6 +753,8 @@ func (hb *HLSBroadcaster) RemoveLiveClient(clientId string) {
 
 // FindLiveClient 查询 LiveClient
 func (hb *HLSBroadcaster) FindLiveClient(clientId string) (client.LiveClient, error) {
+	hb.clientMutex.Lock()
+	defer hb.clientMutex.Unlock()
 	if val, ok := hb.clientMap[clientId]; ok {
 		return val, nil
 	}
@@ -334,27 +762,54 @@ func (hb *HLSBroadcaster) FindLiveClient(clientId string) (client.LiveClient, er
 
 }
 
+// Stats 实现 broadcast.StatsProvider
+func (hb *HLSBroadcaster) Stats() broadcast.StreamStats {
+	hb.clientMutex.Lock()
+	clients := make([]client.LiveClient, 0, len(hb.clientMap))
+	for _, c := range hb.clientMap {
+		clients = append(clients, c)
+	}
+	hb.clientMutex.Unlock()
+	return hb.Snapshot(hb.BroadcasterKey, payload.ProtocolHLS, clients)
+}
+
 // UpdateSourceURL 支持切换直播原地址
 func (hb *HLSBroadcaster) UpdateSourceURL(newSourceURL string) {
 
 }
 
-// ListenStatus 监听当前直播的必要状态
+// ListenStatus 监听当前直播的必要状态：客户端主动断开时移除客户端，直播间关闭后退出
+//
+//	直播结束（BrokerEnd）时分片还要保留供回放，客户端由 end 关闭关闭信号通知，这里继续监听。
 func (hb *HLSBroadcaster) ListenStatus() {
+	ticker := time.NewTicker(broadcast.SessionReapInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case clientId := <-hb.ClientCloseSig:
 			// 监听客户端离开消息
 			hb.RemoveLiveClient(clientId)
 			fmt.Printf("HLSBroadcaster.ListenStatus.RemoveLiveClient.clientId %s successful.", clientId)
-		case <-hb.BroadcasterCloseSig:
-			// 直播被关闭
-			close(hb.BroadcasterCloseSig)
+		case <-ticker.C:
+			// HLS、DASH 会话没有断开事件，很久没有请求后移除
+			hb.removeExpiredClients()
+		case <-hb.ctx.Done():
+			return
 		}
 
 	}
 }
 
+// removeExpiredClients 移除很久没有请求的 HLS、DASH 会话
+func (hb *HLSBroadcaster) removeExpiredClients() {
+	hb.clientMutex.Lock()
+	expired := broadcast.ExpiredClients(hb.clientMap)
+	hb.clientMutex.Unlock()
+	for _, clientId := range expired {
+		hb.RemoveLiveClient(clientId)
+	}
+}
+
 // Broadcast2LiveClient 原地址拉取到数据之后广播给客户端
 func (hb *HLSBroadcaster) Broadcast2LiveClient(data []byte) {
 
@@ -0,0 +1,348 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pull2push/core/broadcast"
+	hlsBroker "pull2push/core/broker/hls"
+	"pull2push/core/media/av"
+	"pull2push/core/media/testsrc"
+	"pull2push/core/media/ts"
+	"pull2push/cron"
+	"pull2push/event"
+	"pull2push/event/payload"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// checkGoroutines 等待 goroutine 数回到 before 以内，超时后打印所有 goroutine 的堆栈
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+			t.Fatalf("goroutines = %d, want <= %d\n%s", runtime.NumGoroutine(), before, buf[:n])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// limitWriter 写满 n 字节后返回错误，让 testsrc.Generate 退出
+type limitWriter struct {
+	buf bytes.Buffer
+	n   int
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.buf.Len() >= w.n {
+		return 0, io.ErrShortWrite
+	}
+	return w.buf.Write(p)
+}
+
+// hlsUpstream 模拟的 HLS 上游，ended 为 true 时播放列表带 EXT-X-ENDLIST
+type hlsUpstream struct {
+	*httptest.Server
+	ended     atomic.Bool
+	playlists atomic.Int32 // 播放列表的请求次数
+}
+
+// newHLSUpstream 直播中的 HLS 上游：testsrc 转成 TS 后按 188 字节对齐切成 n 个分片，EXT-X-MEDIA-SEQUENCE:0
+func newHLSUpstream(t *testing.T, n int) *hlsUpstream {
+	t.Helper()
+	flv := &limitWriter{n: 600 * 1024}
+	_ = testsrc.Generate(flv, testsrc.Options{FPS: 25, GOP: 25, Audio: true}, false)
+	var tsData bytes.Buffer
+	_ = ts.CopyFLVAsTS(&tsData, &flv.buf)
+
+	packets := tsData.Len() / 188
+	var segments [][]byte
+	for i := 0; i < n; i++ {
+		segments = append(segments, tsData.Bytes()[i*packets/n*188:(i+1)*packets/n*188])
+	}
+
+	upstream := &hlsUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/live.m3u8" {
+			upstream.playlists.Add(1)
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			var b strings.Builder
+			b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:0\n")
+			for i := range segments {
+				fmt.Fprintf(&b, "#EXTINF:2.000,\n%d.ts\n", i)
+			}
+			if upstream.ended.Load() {
+				b.WriteString("#EXT-X-ENDLIST\n")
+			}
+			_, _ = io.WriteString(w, b.String())
+			return
+		}
+		var i int
+		if _, err := fmt.Sscanf(r.URL.Path, "/%d.ts", &i); err != nil || i >= len(segments) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp2t")
+		_, _ = w.Write(segments[i])
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// TestCloseStopsGoroutines 关闭直播间后直播间从 Broker 中移除，拉流、下载、解封装和上游连接的 goroutine 全部退出
+func TestCloseStopsGoroutines(t *testing.T) {
+	tests := []struct {
+		name     string
+		onDemand bool
+	}{
+		{"always on", false},
+		{"on demand", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newHLSUpstream(t, 3)
+			before := runtime.NumGoroutine()
+
+			broker := hlsBroker.NewHLSBroker()
+			upstreams := broadcast.NewUpstreams(upstream.URL + "/live.m3u8")
+			var hb *HLSBroadcaster
+			if tt.onDemand {
+				hb = NewOnDemandHLSBroadcaster(context.Background(), "leak-hls", upstreams, "", 3, time.Minute)
+				hb.Touch()
+			} else {
+				hb = NewHLSBroadcaster(context.Background(), "leak-hls", upstreams, "", 3)
+			}
+			broker.AddBroadcaster("leak-hls", hb)
+
+			gotPacket := make(chan struct{}, 1)
+			unsubscribe := hb.SubscribePackets("packets", func(pkt *av.Packet) {
+				select {
+				case gotPacket <- struct{}{}:
+				default:
+				}
+			})
+			select {
+			case <-gotPacket:
+			case <-time.After(10 * time.Second):
+				t.Fatal("no packets from HLS upstream")
+			}
+
+			hb.Close(broadcast.BrokerClosed)
+			select {
+			case <-hb.CloseSignal():
+			default:
+				t.Fatal("CloseSignal not closed after Close")
+			}
+			if _, err := broker.FindBroadcaster("leak-hls"); err == nil {
+				t.Fatal("broadcaster still in broker after Close")
+			}
+			hb.Close(broadcast.BrokerClosed)
+			unsubscribe()
+
+			checkGoroutines(t, before)
+		})
+	}
+}
+
+// TestPullMediaSequenceZero EXT-X-MEDIA-SEQUENCE:0 时序列号为 0 的分片也是有效分片，三个分片都要写入且序列号连续
+func TestPullMediaSequenceZero(t *testing.T) {
+	upstream := newHLSUpstream(t, 3)
+	hb := NewHLSBroadcaster(context.Background(), "seq-zero", broadcast.NewUpstreams(upstream.URL+"/live.m3u8"), "", 3)
+	defer hb.Close(broadcast.BrokerClosed)
+
+	deadline := time.Now().Add(10 * time.Second)
+	var segs []*Segment
+	for len(segs) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d segments pulled, want 3", len(segs))
+		}
+		time.Sleep(20 * time.Millisecond)
+		segs, _, _, _ = hb.StreamState0.Snapshot()
+	}
+	for i, seg := range segs {
+		if want := fmt.Sprintf("%d.ts", i); !strings.HasSuffix(seg.URI, "/"+want) || seg.Seq != segs[0].Seq+uint64(i) {
+			t.Fatalf("segment %d = %s seq %d, want %s seq %d", i, seg.URI, seg.Seq, want, segs[0].Seq+uint64(i))
+		}
+	}
+}
+
+// waitFor 等待 cond 成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// closed 关闭信号是否已经关闭
+func closed(sig <-chan broadcast.BROADCAST_CLOSE_TYPE) bool {
+	select {
+	case <-sig:
+		return true
+	default:
+		return false
+	}
+}
+
+// TestEndListReplayExpire 上游 EXT-X-ENDLIST 后冻结成完整的回放；回放保留期过期后直播间恢复，上游重新开播时继续拉流
+func TestEndListReplayExpire(t *testing.T) {
+	tests := []struct {
+		name     string
+		onDemand bool
+	}{
+		{"always on", false},
+		{"on demand", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newHLSUpstream(t, 5)
+			upstream.ended.Store(true)
+
+			broker := hlsBroker.NewHLSBroker()
+			upstreams := broadcast.NewUpstreams(upstream.URL + "/live.m3u8")
+			var hb *HLSBroadcaster
+			if tt.onDemand {
+				hb = NewOnDemandHLSBroadcaster(context.Background(), "replay", upstreams, "", 3, time.Minute)
+				hb.Touch()
+			} else {
+				hb = NewHLSBroadcaster(context.Background(), "replay", upstreams, "", 3)
+			}
+			defer hb.Close(broadcast.BrokerClosed)
+			broker.AddBroadcaster("replay", hb)
+
+			// 回放是完整的 5 个分片，不只是直播时缓冲的 3 个
+			waitFor(t, "live ended", func() bool { return hb.Status() == broadcast.BrokerEnd })
+			if _, ended := hb.EndedAt(); !ended || !closed(hb.CloseSignal()) {
+				t.Fatalf("ended = %v, close signal closed = %v after EXT-X-ENDLIST", ended, closed(hb.CloseSignal()))
+			}
+			segs, _, _, _ := hb.StreamState0.Snapshot()
+			if len(segs) != 5 {
+				t.Fatalf("replay has %d segments, want 5", len(segs))
+			}
+			lastSeq := segs[len(segs)-1].Seq
+			if tt.onDemand {
+				// 直播结束后不用等分片，直接看回放
+				hb.Touch()
+				if !hb.onDemand.WaitReady(time.Second) {
+					t.Fatal("replay viewer waits for upstream after live ended")
+				}
+			}
+
+			// 保留期还没到，回放不动
+			task := cron.NewVODExpireTask(time.Hour, broker)
+			if err := task.Execute(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if hb.Status() != broadcast.BrokerEnd {
+				t.Fatalf("status = %d before retention expired", hb.Status())
+			}
+
+			// 上游重新开播，保留期过期后清空回放，状态和关闭信号恢复，重新拉流
+			upstream.ended.Store(false)
+			task = cron.NewVODExpireTask(time.Nanosecond, broker)
+			if err := task.Execute(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := broker.FindBroadcaster("replay"); err != nil {
+				t.Fatal("configured broadcaster removed after retention expired")
+			}
+			if _, ended := hb.EndedAt(); ended || hb.Status() == broadcast.BrokerEnd || closed(hb.CloseSignal()) {
+				t.Fatalf("ended = %v, status = %d, close signal closed = %v after ResetReplay", ended, hb.Status(), closed(hb.CloseSignal()))
+			}
+			if tt.onDemand {
+				hb.Touch()
+			}
+			// 重新连接上游时只取最新的一个分片，序列号接着回放往后排并标记断点
+			waitFor(t, "pulling again", func() bool {
+				segs, _, _, _ = hb.StreamState0.Snapshot()
+				return len(segs) > 0
+			})
+			if segs[0].Seq <= lastSeq || !segs[0].Discont || hb.StreamState0.Cap != 3 {
+				t.Fatalf("first segment seq %d discont %v (last replay seq %d), buffer %d", segs[0].Seq, segs[0].Discont, lastSeq, hb.StreamState0.Cap)
+			}
+			if closed(hb.CloseSignal()) {
+				t.Fatal("close signal closed while pulling again")
+			}
+
+			// 关闭后关闭信号关闭，再过期也不会恢复
+			hb.Close(broadcast.BrokerClosed)
+			if !closed(hb.CloseSignal()) {
+				t.Fatal("close signal not closed after Close")
+			}
+			hb.ResetReplay()
+			if !closed(hb.CloseSignal()) {
+				t.Fatal("close signal reopened after Close")
+			}
+		})
+	}
+}
+
+// TestPollThroughEndList 轮询直播中的上游直到出现 EXT-X-ENDLIST：没变化时按半个 target duration 轮询，
+// 结束后进入 BrokerEnd，在事件总线上发布 StreamEnded 和 RecordDone，停止轮询
+func TestPollThroughEndList(t *testing.T) {
+	upstream := newHLSUpstream(t, 3)
+	bus := event.NewEventBus()
+	events := bus.SubscribeMultiple([]event.EventType{event.StreamUnpublished, event.StreamEnded, event.RecordDone})
+
+	hb := NewOnDemandHLSBroadcaster(context.Background(), "endlist", broadcast.NewUpstreams(upstream.URL+"/live.m3u8"), "", 3, time.Minute)
+	hb.SetEventBus(bus)
+	defer hb.Close(broadcast.BrokerClosed)
+	hb.Touch()
+	waitFor(t, "segments", func() bool {
+		segs, _, _, _ := hb.StreamState0.Snapshot()
+		return len(segs) == 3
+	})
+
+	// TARGETDURATION:2，播放列表没有变化时每秒轮询一次
+	polls := upstream.playlists.Load()
+	time.Sleep(2500 * time.Millisecond)
+	if n := upstream.playlists.Load() - polls; n < 1 || n > 4 {
+		t.Fatalf("%d playlist polls in 2.5s, want about 2", n)
+	}
+	if hb.Status() != broadcast.BrokerStarted {
+		t.Fatalf("status = %d while live", hb.Status())
+	}
+
+	upstream.ended.Store(true)
+	var got []event.Event
+	for len(got) < 3 {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("events = %+v, want StreamUnpublished, StreamEnded, RecordDone", got)
+		}
+	}
+	if p, ok := got[0].Payload.(payload.StreamPayload); got[0].Type != event.StreamUnpublished || !ok || p.Reason != "ended" {
+		t.Fatalf("first event = %+v", got[0])
+	}
+	if p, ok := got[1].Payload.(payload.StreamPayload); got[1].Type != event.StreamEnded || !ok || p.BroadcasterKey != "endlist" || p.URL != upstream.URL+"/live.m3u8" {
+		t.Fatalf("second event = %+v", got[1])
+	}
+	if p, ok := got[2].Payload.(payload.RecordPayload); got[2].Type != event.RecordDone || !ok || p.Segments != 3 || p.Duration != 6 {
+		t.Fatalf("third event = %+v", got[2])
+	}
+	if hb.Status() != broadcast.BrokerEnd || !closed(hb.CloseSignal()) {
+		t.Fatalf("status = %d, close signal closed = %v after EXT-X-ENDLIST", hb.Status(), closed(hb.CloseSignal()))
+	}
+
+	// 结束后不再轮询上游
+	polls = upstream.playlists.Load()
+	time.Sleep(1500 * time.Millisecond)
+	if n := upstream.playlists.Load() - polls; n != 0 {
+		t.Fatalf("%d playlist polls after EXT-X-ENDLIST", n)
+	}
+}
@@ -0,0 +1,58 @@
+package hls
+
+import (
+	"pull2push/core/broadcast"
+	"pull2push/core/media/av"
+	"pull2push/core/media/ts"
+)
+
+// SubscribePackets 实现 broadcast.PacketSource
+//
+//	上游 TS 分片下载完成后解封装成音视频帧分发给订阅者；订阅时先回放最新的一个分片，
+//	HLS 分片都以关键帧开头，订阅者可以立即开始重新封装。fMP4 分片暂不支持解封装。
+func (hb *HLSBroadcaster) SubscribePackets(subscriberId string, handler broadcast.PacketHandler) func() {
+	hb.packetMutex.Lock()
+	defer hb.packetMutex.Unlock()
+
+	if segs, _, _, _ := hb.StreamState0.Snapshot(); len(segs) > 0 {
+		demuxSegment(segs[len(segs)-1], func(pkt *av.Packet) { handler(pkt) })
+	}
+	if hb.packetSubs == nil {
+		hb.packetSubs = make(map[string]broadcast.PacketHandler)
+	}
+	hb.packetSubs[subscriberId] = handler
+
+	return func() {
+		hb.packetMutex.Lock()
+		defer hb.packetMutex.Unlock()
+		delete(hb.packetSubs, subscriberId)
+	}
+}
+
+// publishSegment 把新写入的分片解封装后分发给帧订阅者，同时统计分片的码率、帧率和编码信息
+func (hb *HLSBroadcaster) publishSegment(seg *Segment) {
+	hb.packetMutex.Lock()
+	defer hb.packetMutex.Unlock()
+
+	videoFrames := 0
+	demuxSegment(seg, func(pkt *av.Packet) {
+		hb.CountPacket(pkt)
+		if pkt.Type == av.PacketVideo && !pkt.IsSequenceHeader {
+			videoFrames++
+		}
+		for _, handler := range hb.packetSubs {
+			handler(pkt)
+		}
+	})
+	hb.CountSegment(len(seg.Data), videoFrames, seg.Dur)
+}
+
+// demuxSegment 解封装一个 TS 分片，每个分片独立解析（分片内自带 PAT/PMT 和参数集）
+func demuxSegment(seg *Segment, onPacket ts.PacketHandler) {
+	if len(seg.Data) < ts.PacketSize || seg.Data[0] != 0x47 {
+		return
+	}
+	demuxer := ts.NewDemuxer(onPacket)
+	_, _ = demuxer.Write(seg.Data)
+	demuxer.Flush()
+}
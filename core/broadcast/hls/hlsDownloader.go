@@ -0,0 +1,183 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/grafov/m3u8"
+	"log"
+	"math/rand"
+	"net/http"
+	"pull2push/event/payload"
+	"pull2push/metrics"
+	"time"
+)
+
+/*
+分片下载器
+	原来的实现是在轮询协程里逐个串行下载新分片，一个慢分片会拖慢后面所有分片。
+	现在的做法：
+		1、轮询发现新分片后立即为其创建一个下载任务（segmentJob），并按发现顺序放入有序队列；
+		2、下载任务在有限并发（信号量）下并行执行，后面的分片可以提前预取；
+		3、一个独立的写入协程按队列顺序等待每个任务完成，再写入 StreamState，保证分片顺序；
+		4、每个分片失败后带抖动指数退避重试，主地址失败时依次尝试 EXT-X-STREAM-INF 冗余变体里的备用地址。
+*/
+
+const (
+	defaultDownloadConcurrency = 4                      // 默认分片并行下载数
+	defaultDownloadRetries     = 3                      // 默认单个分片的重试次数
+	defaultRetryBackoff        = 300 * time.Millisecond // 重试基础退避时间
+	maxRetryBackoff            = 3 * time.Second        // 重试最大退避时间
+	maxReplaySegments          = 1800                   // 回放最多保留的分片数，按 2 秒一个分片约 1 小时
+)
+
+// segmentJob 一个分片的下载任务
+type segmentJob struct {
+	seq     uint64             // 分片序列号
+	seg     *m3u8.MediaSegment // 上游播放列表中的分片信息
+	urls    []string           // 候选下载地址，第一个为主地址，其余为备用地址
+	discont bool               // 切换上游后的第一个分片，需要标记断点
+	done    chan struct{}      // 下载结束（成功或失败）时关闭
+	data    []byte             // 下载到的分片数据
+	err     error              // 最终下载错误
+	usedURL string             // 实际下载成功的地址
+}
+
+// redundantVariants 找出与选中变体互为冗余（带宽、分辨率、编码一致，地址不同）的备用变体
+func redundantVariants(master *m3u8.MasterPlaylist, chosen *m3u8.Variant) []*m3u8.Variant {
+	var backups []*m3u8.Variant
+	for _, v := range master.Variants {
+		if v == nil || v == chosen || v.Iframe || v.URI == chosen.URI {
+			continue
+		}
+		if v.Bandwidth == chosen.Bandwidth && v.Resolution == chosen.Resolution && v.Codecs == chosen.Codecs {
+			backups = append(backups, v)
+		}
+	}
+	return backups
+}
+
+// segmentCandidates 构造一个分片的候选下载地址：主媒体列表解析出的地址 + 各备用媒体列表解析出的地址
+func segmentCandidates(mediaURLs []string, current int, segURI string) []string {
+	urls := make([]string, 0, len(mediaURLs))
+	seen := make(map[string]bool, len(mediaURLs))
+	add := func(base string) {
+		abs, err := resolveURL(base, segURI)
+		if err != nil || seen[abs] {
+			return
+		}
+		seen[abs] = true
+		urls = append(urls, abs)
+	}
+	// 当前正在使用的媒体列表优先
+	add(mediaURLs[current])
+	for i, u := range mediaURLs {
+		if i != current {
+			add(u)
+		}
+	}
+	return urls
+}
+
+// retryBackoff 计算第 attempt 次重试前的等待时间（指数退避 + 随机抖动）
+func retryBackoff(attempt int) time.Duration {
+	d := defaultRetryBackoff << attempt
+	if d > maxRetryBackoff || d <= 0 {
+		d = maxRetryBackoff
+	}
+	// 抖动范围 [d/2, d)，避免多个分片同时重试打到源站
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// downloadWithRetry 按候选地址顺序下载分片，全部失败后退避重试
+func (hb *HLSBroadcaster) downloadWithRetry(ctx context.Context, client *http.Client, urls []string) ([]byte, string, error) {
+	var lastErr error
+	for attempt := 0; attempt <= hb.DownloadRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, "", ctx.Err()
+			case <-time.After(retryBackoff(attempt - 1)):
+			}
+		}
+		for i, u := range urls {
+			data, err := hb.download(ctx, client, u)
+			if err == nil {
+				if i > 0 {
+					log.Printf("[pull:%s] seg fallback to backup %s", hb.BroadcasterKey, u)
+				}
+				return data, u, nil
+			}
+			lastErr = err
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil, "", ctx.Err()
+			}
+		}
+		log.Printf("[pull:%s] seg dl attempt %d/%d failed: %v", hb.BroadcasterKey, attempt+1, hb.DownloadRetries+1, lastErr)
+	}
+	return nil, "", fmt.Errorf("download segment failed after %d attempts: %w", hb.DownloadRetries+1, lastErr)
+}
+
+// scheduleDownload 异步执行下载任务，受 sem 限制并发数
+func (hb *HLSBroadcaster) scheduleDownload(ctx context.Context, client *http.Client, sem chan struct{}, job *segmentJob) {
+	go func() {
+		defer close(job.done)
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			job.err = ctx.Err()
+			return
+		}
+		defer func() { <-sem }()
+
+		start := time.Now()
+		job.data, job.usedURL, job.err = hb.downloadWithRetry(ctx, client, job.urls)
+		if job.err == nil {
+			metrics.SegmentDownloadSeconds.Observe(time.Since(start).Seconds(), payload.ProtocolHLS, hb.BroadcasterKey)
+		}
+	}()
+}
+
+// insertLoop 按下载任务的创建顺序等待其完成，并依次写入 StreamState
+func (hb *HLSBroadcaster) insertLoop(ctx context.Context, jobs <-chan *segmentJob) {
+	stream := hb.StreamState0
+	for {
+		var job *segmentJob
+		select {
+		case <-ctx.Done():
+			return
+		case j, ok := <-jobs:
+			if !ok {
+				return
+			}
+			job = j
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-job.done:
+		}
+
+		if job.err != nil {
+			log.Printf("[pull:%s] seg %d dropped: %v", hb.BroadcasterKey, job.seq, job.err)
+			continue
+		}
+
+		localName := localSegName(job.urls[0], job.seq)
+		seg := &Segment{
+			Seq:       job.seq,
+			URI:       job.usedURL,
+			LocalName: localName,
+			Data:      job.data,
+			Dur:       job.seg.Duration,
+			Discont:   job.seg.Discontinuity || job.discont,
+			AddedAt:   time.Now(),
+		}
+		stream.PushSegment(seg)
+		hb.publishSegment(seg)
+		if hb.onDemand != nil {
+			hb.onDemand.MarkReady()
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"pull2push/core/broadcast"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// newIdleHLSBroadcaster 不会自己拉流的广播器（按需拉流且没有客户端），只用来调用下载和写入
+func newIdleHLSBroadcaster(t *testing.T) *HLSBroadcaster {
+	t.Helper()
+	hb := NewOnDemandHLSBroadcaster(context.Background(), "downloader", broadcast.NewUpstreams("http://127.0.0.1:1/index.m3u8"), "", 3, time.Minute)
+	t.Cleanup(func() { hb.Close(broadcast.BrokerClosed) })
+	return hb
+}
+
+// TestInsertLoopOrder 分片并行下载，后面的分片先下载完成，写入 StreamState 的顺序仍然和发现顺序一致
+func TestInsertLoopOrder(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var finished []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 第一个分片等后面两个都下载完才返回
+		if r.URL.Path == "/0.ts" {
+			<-release
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+		mu.Lock()
+		finished = append(finished, r.URL.Path)
+		if len(finished) == 2 {
+			close(release)
+		}
+		mu.Unlock()
+	}))
+	defer upstream.Close()
+
+	hb := newIdleHLSBroadcaster(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sem := make(chan struct{}, 3)
+	jobs := make(chan *segmentJob, 3)
+	insertDone := make(chan struct{})
+	go func() {
+		hb.insertLoop(ctx, jobs)
+		close(insertDone)
+	}()
+
+	for i, name := range []string{"0.ts", "1.ts", "2.ts"} {
+		job := &segmentJob{
+			seq:  uint64(10 + i),
+			seg:  &m3u8.MediaSegment{URI: name, Duration: 2},
+			urls: []string{upstream.URL + "/" + name},
+			done: make(chan struct{}),
+		}
+		jobs <- job
+		hb.scheduleDownload(ctx, http.DefaultClient, sem, job)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var segs []*Segment
+	for len(segs) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d segments inserted, want 3", len(segs))
+		}
+		time.Sleep(10 * time.Millisecond)
+		segs, _, _, _ = hb.StreamState0.Snapshot()
+	}
+	cancel()
+	<-insertDone
+
+	mu.Lock()
+	if finished[2] != "/0.ts" {
+		t.Fatalf("download finish order = %v, want 0.ts last", finished)
+	}
+	mu.Unlock()
+	for i, seg := range segs {
+		if want := "/" + string(rune('0'+i)) + ".ts"; seg.Seq != uint64(10+i) || string(seg.Data) != want {
+			t.Fatalf("segment %d = seq %d %q, want seq %d %q", i, seg.Seq, seg.Data, 10+i, want)
+		}
+	}
+}
+
+// TestDownloadWithRetry 下载失败后退避重试，重试次数用完后返回最后一次的错误
+func TestDownloadWithRetry(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		n := requests[r.URL.Path]
+		mu.Unlock()
+		// flaky.ts 前两次失败，broken.ts 一直失败
+		if r.URL.Path == "/broken.ts" || n <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("segment"))
+	}))
+	defer upstream.Close()
+
+	count := func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[path]
+	}
+
+	hb := newIdleHLSBroadcaster(t)
+	hb.DownloadRetries = 3
+
+	start := time.Now()
+	data, usedURL, err := hb.downloadWithRetry(context.Background(), http.DefaultClient, []string{upstream.URL + "/flaky.ts"})
+	if err != nil || string(data) != "segment" || usedURL != upstream.URL+"/flaky.ts" {
+		t.Fatalf("downloadWithRetry = %q, %s, %v", data, usedURL, err)
+	}
+	// 两次重试前分别至少等待 defaultRetryBackoff/2 和 defaultRetryBackoff
+	if elapsed := time.Since(start); elapsed < defaultRetryBackoff*3/2 {
+		t.Fatalf("retried after %v, want backoff >= %v", elapsed, defaultRetryBackoff*3/2)
+	}
+	if n := count("/flaky.ts"); n != 3 {
+		t.Fatalf("flaky.ts requested %d times, want 3", n)
+	}
+
+	hb.DownloadRetries = 1
+	if _, _, err := hb.downloadWithRetry(context.Background(), http.DefaultClient, []string{upstream.URL + "/broken.ts"}); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("err = %v, want bad status 503", err)
+	}
+	if n := count("/broken.ts"); n != 2 {
+		t.Fatalf("broken.ts requested %d times, want 2", n)
+	}
+
+	// 直播间关闭时不再等待重试
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := hb.downloadWithRetry(ctx, http.DefaultClient, []string{upstream.URL + "/broken.ts"}); err == nil {
+		t.Fatal("downloadWithRetry succeeded with canceled context")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	for attempt := 0; attempt < 8; attempt++ {
+		d := min(defaultRetryBackoff<<attempt, maxRetryBackoff)
+		for i := 0; i < 20; i++ {
+			if got := retryBackoff(attempt); got < d/2 || got > d {
+				t.Fatalf("retryBackoff(%d) = %v, want [%v, %v]", attempt, got, d/2, d)
+			}
+		}
+	}
+}
+
+// TestBackupVariantFallback 主媒体列表的分片下载失败时，改用冗余变体里同名的分片
+func TestBackupVariantFallback(t *testing.T) {
+	master := "#EXTM3U\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS=\"avc1.64001e,mp4a.40.2\"\nprimary/index.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS=\"avc1.64001e,mp4a.40.2\"\nbackup/index.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=400000,RESOLUTION=320x180,CODECS=\"avc1.64001e,mp4a.40.2\"\nlow/index.m3u8\n"
+	p, _, err := m3u8.DecodeFrom(bytes.NewBufferString(master), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp := p.(*m3u8.MasterPlaylist)
+	backups := redundantVariants(mp, mp.Variants[0])
+	if len(backups) != 1 || backups[0].URI != "backup/index.m3u8" {
+		t.Fatalf("redundantVariants = %v, want only backup/index.m3u8", backups)
+	}
+
+	var mu sync.Mutex
+	var requested []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/primary/") {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	mediaURLs := []string{upstream.URL + "/primary/index.m3u8", upstream.URL + "/backup/index.m3u8"}
+	urls := segmentCandidates(mediaURLs, 0, "seg-7.ts")
+	if len(urls) != 2 || urls[0] != upstream.URL+"/primary/seg-7.ts" || urls[1] != upstream.URL+"/backup/seg-7.ts" {
+		t.Fatalf("segmentCandidates = %v", urls)
+	}
+	// 正在使用备用媒体列表时它排在前面
+	if urls := segmentCandidates(mediaURLs, 1, "seg-7.ts"); urls[0] != upstream.URL+"/backup/seg-7.ts" {
+		t.Fatalf("segmentCandidates(current = 1) = %v", urls)
+	}
+
+	hb := newIdleHLSBroadcaster(t)
+	data, usedURL, err := hb.downloadWithRetry(context.Background(), http.DefaultClient, urls)
+	if err != nil || usedURL != urls[1] || string(data) != "/backup/seg-7.ts" {
+		t.Fatalf("downloadWithRetry = %q, %s, %v, want backup", data, usedURL, err)
+	}
+	// 备用地址成功，不需要退避重试
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requested) != 2 {
+		t.Fatalf("requests = %v, want primary then backup once", requested)
+	}
+}
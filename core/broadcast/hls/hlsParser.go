@@ -15,19 +15,27 @@ type Segment struct {
 	Dur       float64   // 分片时长，秒
 	Discont   bool      // 是否断点分片
 	AddedAt   time.Time // 拉取时间
+
+	// DASH SegmentTimeline 使用，单位为轨道时间基
+	BaseTime uint64 // 分片起始时间
+	Ticks    uint64 // 分片时长
 }
 
 // StreamState 每一路拉流任务维护一个 StreamState，存放它的分片缓存和元数据。
 // 用 ring.Ring 实现固定容量的循环队列，保持缓存窗口。
 type StreamState struct {
-	Mu        sync.RWMutex
-	Segments  *ring.Ring // 环形缓冲，存放最近 N 个分片，元素为 *Segment 或 nil
-	Cap       int        // 缓冲分片数
-	TargetDur float64    // HLS 目标分片时长
-	SeqStart  uint64     // 本地播放列表起始序列号
-	LastSeq   uint64     // 最新分片序列号（递增）
-	LastMod   time.Time  // 最后更新时间
-	Discont   bool       // 是否有断点续播
+	Mu         sync.RWMutex
+	Segments   *ring.Ring // 环形缓冲，存放最近 N 个分片，元素为 *Segment 或 nil
+	Cap        int        // 缓冲分片数
+	liveCap    int        // 直播时的缓冲分片数，Grow 扩大的缓冲在 Restart 时恢复
+	TargetDur  float64    // HLS 目标分片时长
+	SeqStart   uint64     // 本地播放列表起始序列号
+	LastSeq    uint64     // 最新分片序列号（递增）
+	LastMod    time.Time  // 最后更新时间
+	Discont    bool       // 是否有断点续播
+	DiscontSeq uint64     // 已经移出缓冲的断点分片数，即 EXT-X-DISCONTINUITY-SEQUENCE
+	Ended      bool       // 直播是否已结束，结束后播放列表以 VOD 形式返回
+	EndedAt    time.Time  // 直播结束时间，用于计算回放保留期
 }
 
 // NewStreamState 创建每一个直播的拉流缓冲区对象
@@ -35,6 +43,7 @@ func NewStreamState(cap int) *StreamState {
 	return &StreamState{
 		Segments:  ring.New(cap),
 		Cap:       cap,
+		liveCap:   cap,
 		TargetDur: 6,
 		SeqStart:  0,
 		LastSeq:   0,
@@ -52,18 +61,19 @@ func (s *StreamState) PushSegment(seg *Segment) {
 	s.Mu.Lock()
 	defer s.Mu.Unlock()
 
-	// 移动指针到下一格并覆盖
+	// 移动指针到下一格并覆盖，被覆盖的断点分片计入 DiscontSeq
 	s.Segments = s.Segments.Next()
+	if old, ok := s.Segments.Value.(*Segment); ok && old != nil && old.Discont {
+		s.DiscontSeq++
+	}
 	s.Segments.Value = seg
 
-	if s.SeqStart == 0 {
-		// 第一次写入
+	// 当前窗口的起始序列号 = 最旧的分片（下一格）的序列号，缓冲还没写满时为第一个分片
+	if oldest, ok := s.Segments.Next().Value.(*Segment); ok && oldest != nil {
+		s.SeqStart = oldest.Seq
+	} else if s.SeqStart == 0 {
 		s.SeqStart = seg.Seq
 	}
-	// 计算当前窗口的“起始序列号” = 最新序列 - (cap-1)
-	if seg.Seq+1 >= uint64(s.Cap) {
-		s.SeqStart = seg.Seq + 1 - uint64(s.Cap)
-	}
 	s.LastSeq = seg.Seq
 	s.LastMod = time.Now()
 	if seg.Discont {
@@ -71,13 +81,71 @@ func (s *StreamState) PushSegment(seg *Segment) {
 	}
 }
 
-// Snapshot 返回按序的窗口分片拷贝（只读）
-func (s *StreamState) Snapshot() (segs []*Segment, seqStart uint64, targetDur float64, discont bool) {
+// Grow 把缓冲扩大到至少 n 个分片，已有的分片保留。上游给出带 EXT-X-ENDLIST 的完整列表时用，回放才是完整的
+func (s *StreamState) Grow(n int) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	if n <= s.Cap {
+		return
+	}
+	// 当前指针是最新的分片，在它后面插入空格，之后写入的分片先填空格，不会覆盖旧分片
+	s.Segments.Link(ring.New(n - s.Cap))
+	s.Cap = n
+}
+
+// MarkEnded 直播结束，冻结当前缓存的最后若干分片供回放
+func (s *StreamState) MarkEnded() {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	if s.Ended {
+		return
+	}
+	s.Ended = true
+	s.EndedAt = time.Now()
+}
+
+// Totals 缓存中的分片数和总时长（秒），回放定稿时用
+func (s *StreamState) Totals() (segments int, duration float64) {
+	segs, _, _, _ := s.Snapshot()
+	for _, seg := range segs {
+		duration += seg.Dur
+	}
+	return len(segs), duration
+}
+
+// EndedInfo 返回直播结束时间以及是否已结束
+func (s *StreamState) EndedInfo() (time.Time, bool) {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+	return s.EndedAt, s.Ended
+}
+
+// Restart 同一个直播间重新开播：清空回放分片，序列号继续递增并标记断点
+func (s *StreamState) Restart() {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	s.Segments.Do(func(v any) {
+		if seg, ok := v.(*Segment); ok && seg != nil && seg.Discont {
+			s.DiscontSeq++
+		}
+	})
+	if s.liveCap > 0 {
+		s.Cap = s.liveCap
+	}
+	s.Segments = ring.New(s.Cap)
+	s.SeqStart = 0
+	s.Ended = false
+	s.EndedAt = time.Time{}
+	s.Discont = s.LastSeq > 0
+}
+
+// Snapshot 返回按序的窗口分片拷贝（只读），discontSeq 为已经移出窗口的断点分片数
+func (s *StreamState) Snapshot() (segs []*Segment, seqStart uint64, targetDur float64, discontSeq uint64) {
 	s.Mu.RLock()
 	defer s.Mu.RUnlock()
 	segs = make([]*Segment, 0, s.Cap)
-	// 从环形缓冲按时间顺序读出
-	tmp := s.Segments
+	// 从环形缓冲按时间顺序读出：当前指针是最新的分片，下一格才是最旧的
+	tmp := s.Segments.Next()
 	tmp.Do(func(v any) {
 		if v == nil {
 			return
@@ -88,5 +156,5 @@ func (s *StreamState) Snapshot() (segs []*Segment, seqStart uint64, targetDur fl
 			segs = append(segs, seg)
 		}
 	})
-	return segs, s.SeqStart, s.TargetDur, s.Discont
+	return segs, s.SeqStart, s.TargetDur, s.DiscontSeq
 }
@@ -0,0 +1,53 @@
+package broadcast
+
+import (
+	"sync"
+)
+
+// Lifecycle 广播器关闭的公共逻辑，嵌入到各个广播器中
+//
+//	Close 只执行一次；OnClose 注册的回调在关闭后依次调用，Broker 用它在广播器关闭后把它移除。
+type Lifecycle struct {
+	mu      sync.Mutex
+	closed  bool
+	reason  BROADCAST_CLOSE_TYPE
+	onClose []func()
+}
+
+// OnClose 注册关闭回调，已经关闭时立即调用
+func (l *Lifecycle) OnClose(fn func()) {
+	l.mu.Lock()
+	if !l.closed {
+		l.onClose = append(l.onClose, fn)
+		l.mu.Unlock()
+		return
+	}
+	l.mu.Unlock()
+	fn()
+}
+
+// Closed 是否已经关闭，以及关闭原因
+func (l *Lifecycle) Closed() (BROADCAST_CLOSE_TYPE, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reason, l.closed
+}
+
+// Shutdown 第一次调用时执行 stop，然后调用关闭回调，返回是否是第一次调用
+func (l *Lifecycle) Shutdown(reason BROADCAST_CLOSE_TYPE, stop func()) bool {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return false
+	}
+	l.closed, l.reason = true, reason
+	hooks := l.onClose
+	l.onClose = nil
+	l.mu.Unlock()
+
+	stop()
+	for _, fn := range hooks {
+		fn()
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package broadcast
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+按需拉流
+	配置了几百个直播间时，没人看的直播间一直拉流很浪费带宽。按需拉流的广播器创建时不连接上游：
+		1、第一个观众到来（Touch）时才开始拉流，观众先等第一个 GOP/分片到了（MarkReady）再开始播放；
+		2、没有观众并且超过 IdleTimeout 没有访问时停止拉流，下一个观众到来时重新开始。
+	观众数由广播器提供：FLV 等长连接按客户端数量算；HLS 这种短请求没有断开事件，只按最后一次访问时间算。
+*/
+
+const (
+	defaultIdleTimeout = time.Minute
+	maxIdleCheck       = time.Second // 检查是否空闲的最大间隔
+)
+
+// OnDemand 按需拉流的控制器，并发安全
+type OnDemand struct {
+	IdleTimeout time.Duration // 没有观众多久后停止拉流
+
+	run     func(stop <-chan struct{}) // 拉流，stop 被关闭后尽快返回
+	viewers func() int                 // 当前观众数
+
+	mu         sync.Mutex
+	stop       chan struct{} // 当前这次拉流的停止信号，nil 表示没有在拉流
+	done       chan struct{} // 上一次拉流协程退出后关闭
+	ready      chan struct{} // 当前这次拉流收到第一个 GOP 后关闭
+	readyOnce  *sync.Once
+	lastActive time.Time
+	closed     bool // 广播器关闭后不再开始拉流
+}
+
+// NewOnDemand 创建按需拉流控制器，idleTimeout 不大于 0 时使用默认的 1 分钟
+func NewOnDemand(idleTimeout time.Duration, run func(stop <-chan struct{}), viewers func() int) *OnDemand {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	return &OnDemand{IdleTimeout: idleTimeout, run: run, viewers: viewers}
+}
+
+// Touch 记录一次观众访问，没有在拉流时开始拉流
+func (o *OnDemand) Touch() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lastActive = time.Now()
+	if o.stop != nil || o.closed {
+		return
+	}
+
+	stop, done, prevDone := make(chan struct{}), make(chan struct{}), o.done
+	o.stop, o.done = stop, done
+	o.ready, o.readyOnce = make(chan struct{}), &sync.Once{}
+	go func() {
+		defer close(done)
+		// 上一次拉流还没退出时等它退出，同一时间只有一个拉流协程
+		if prevDone != nil {
+			<-prevDone
+		}
+		o.run(stop)
+	}()
+	go o.watchIdle(stop)
+}
+
+// Running 当前是否在拉流
+func (o *OnDemand) Running() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.stop != nil
+}
+
+// MarkReady 拉流协程收到第一个 GOP（或分片）时调用，放行等待中的观众
+func (o *OnDemand) MarkReady() {
+	o.mu.Lock()
+	ready, once := o.ready, o.readyOnce
+	o.mu.Unlock()
+	if once != nil {
+		once.Do(func() { close(ready) })
+	}
+}
+
+// WaitReady 等待当前这次拉流就绪，超时返回 false
+func (o *OnDemand) WaitReady(timeout time.Duration) bool {
+	o.mu.Lock()
+	ready := o.ready
+	o.mu.Unlock()
+	if ready == nil {
+		return false
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Stop 立即停止拉流，不等待拉流协程退出
+func (o *OnDemand) Stop() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stopLocked()
+}
+
+// Close 停止拉流，之后 Touch 不会再开始拉流，广播器关闭时调用
+func (o *OnDemand) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	o.stopLocked()
+}
+
+func (o *OnDemand) stopLocked() {
+	if o.stop == nil {
+		return
+	}
+	close(o.stop)
+	o.stop = nil
+}
+
+// watchIdle 没有观众并且超过 IdleTimeout 没有访问时停止这次拉流
+func (o *OnDemand) watchIdle(stop chan struct{}) {
+	ticker := time.NewTicker(min(o.IdleTimeout/4, maxIdleCheck))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		viewers := o.viewers()
+		o.mu.Lock()
+		if viewers > 0 {
+			// 有观众时一直算作活跃，空闲时间从最后一个观众离开开始算
+			o.lastActive = time.Now()
+		} else if o.stop == stop && time.Since(o.lastActive) > o.IdleTimeout {
+			o.stopLocked()
+		}
+		o.mu.Unlock()
+	}
+}
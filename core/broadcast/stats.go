@@ -0,0 +1,320 @@
+package broadcast
+
+import (
+	"pull2push/core/client"
+	"pull2push/core/media/av"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+直播间实时统计
+	拉流/推流路径调用 StreamMeter 的 CountIn、CountVideoFrame 等方法维护上游计数，客户端在写路径上用 client.Meter 维护自己的发送字节数，
+	统计接口调用广播器的 Stats 时把两者汇总，不需要额外读取或解析直播数据。
+	码率、帧率按最近一个完整的一秒窗口计算；HLS 上游按分片到达，改为按最新一个分片的大小、帧数和时长计算。
+*/
+
+// 上游状态
+const (
+	UpstreamIdle       = "idle"       // 没有拉流（按需拉流空闲）或者在等待推流
+	UpstreamConnecting = "connecting" // 正在连接上游
+	UpstreamStreaming  = "streaming"  // 正在接收上游数据
+	UpstreamSlate      = "slate"      // 上游没有数据，正在播放垫片
+	UpstreamEnded      = "ended"      // 直播已结束
+)
+
+// VideoStats 视频编码信息
+type VideoStats struct {
+	Codec  string `json:"codec,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// AudioStats 音频编码信息
+type AudioStats struct {
+	Codec      string `json:"codec,omitempty"`
+	SampleRate int    `json:"sampleRate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+}
+
+// StreamStats 一个直播间的实时状态
+type StreamStats struct {
+	BroadcasterKey string         `json:"broadcasterKey"`
+	Protocol       string         `json:"protocol"`
+	UpstreamURL    string         `json:"upstreamURL"` // 拉流为上游地址，推流为推流端地址
+	UpstreamState  string         `json:"upstreamState"`
+	Reconnects     uint64         `json:"reconnects"`          // 上游断开后重新开始收到数据的次数，包括按需拉流再次拉流、推流端重新推流
+	StartedAt      *time.Time     `json:"startedAt,omitempty"` // 上游本次开始收到数据的时间
+	Uptime         int64          `json:"uptime"`              // 上游本次持续收到数据的秒数
+	BytesIn        uint64         `json:"bytesIn"`
+	BytesOut       uint64         `json:"bytesOut"` // 所有客户端（包括已经离开的）累计发送的字节数
+	Dropped        uint64         `json:"dropped"`  // 客户端跟不上时丢弃的数据包数，包括已经离开的客户端
+	Bitrate        float64        `json:"bitrate"`  // 上游码率，kbps
+	FPS            float64        `json:"fps"`
+	Video          VideoStats     `json:"video"`
+	Audio          AudioStats     `json:"audio"`
+	GOPSize        int            `json:"gopSize"` // 最近一个完整 GOP 的视频帧数
+	Viewers        int            `json:"viewers"`
+	Clients        []client.Stats `json:"clients"`
+}
+
+// StatsProvider 能输出实时统计的广播器
+type StatsProvider interface {
+
+	// Stats 返回直播间当前的状态和客户端列表
+	Stats() StreamStats
+}
+
+// StreamMeter 直播间的上游计数，嵌入到各个广播器中，并发安全
+type StreamMeter struct {
+	mu            sync.Mutex
+	upstreamURL   string
+	upstreamState string
+	startedAt     time.Time
+	streamed      bool // 是否收到过上游数据
+	reconnects    uint64
+
+	bytesIn  uint64
+	bytesOut uint64 // 已经离开的客户端发送的字节数
+	dropped  uint64 // 广播器丢弃的数据包数 + 已经离开的客户端丢弃的数据包数
+
+	in     rateWindow // 上游字节数
+	frames rateWindow // 视频帧数
+
+	// HLS 上游按分片计算的码率和帧率
+	segmentRate bool
+	segBitrate  float64
+	segFPS      float64
+
+	video     VideoStats
+	audio     AudioStats
+	gopFrames int // 当前 GOP 已经收到的视频帧数
+	gopSize   int
+}
+
+// SetUpstream 更新上游地址和状态，进入 UpstreamStreaming 时记录开始时间，不是第一次进入时记为一次重连
+func (m *StreamMeter) SetUpstream(url, state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if state == UpstreamStreaming && m.upstreamState != UpstreamStreaming {
+		m.startedAt = time.Now()
+		if m.streamed {
+			m.reconnects++
+		}
+		m.streamed = true
+	}
+	if url != "" {
+		m.upstreamURL = url
+	}
+	m.upstreamState = state
+}
+
+// CountIn 收到 n 字节上游数据
+func (m *StreamMeter) CountIn(n int) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytesIn += uint64(n)
+	m.in.add(n, now)
+}
+
+// CountVideoFrame 收到一个视频帧（不含序列头），按关键帧统计 GOP 大小
+func (m *StreamMeter) CountVideoFrame(keyFrame bool) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.frames.add(1, now)
+	if keyFrame {
+		if m.gopFrames > 0 {
+			m.gopSize = m.gopFrames
+		}
+		m.gopFrames = 0
+	}
+	m.gopFrames++
+}
+
+// CountSegment 收到一个 HLS 分片，码率和帧率改为按分片计算；帧数、编码信息由调用方解封装分片后通过 CountPacket 统计
+func (m *StreamMeter) CountSegment(n, videoFrames int, dur float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytesIn += uint64(n)
+	if dur <= 0 {
+		return
+	}
+	m.segmentRate = true
+	m.segBitrate = float64(n) * 8 / 1000 / dur
+	m.segFPS = float64(videoFrames) / dur
+}
+
+// SetVideo 更新视频编码信息，为空的字段保留原来的值
+func (m *StreamMeter) SetVideo(v VideoStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v.Codec != "" {
+		m.video.Codec = v.Codec
+	}
+	if v.Width > 0 && v.Height > 0 {
+		m.video.Width, m.video.Height = v.Width, v.Height
+	}
+}
+
+// SetAudio 更新音频编码信息，为空的字段保留原来的值
+func (m *StreamMeter) SetAudio(a AudioStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a.Codec != "" {
+		m.audio.Codec = a.Codec
+	}
+	if a.SampleRate > 0 {
+		m.audio.SampleRate = a.SampleRate
+	}
+	if a.Channels > 0 {
+		m.audio.Channels = a.Channels
+	}
+}
+
+// CountPacket 按解封装后的音视频帧统计帧数、GOP 和编码信息，HLS、WebRTC 等不经过 FLVParser 的上游使用
+func (m *StreamMeter) CountPacket(pkt *av.Packet) {
+	switch {
+	case pkt.Type == av.PacketVideo && pkt.IsSequenceHeader:
+		v := VideoStats{Codec: pkt.Codec.String()}
+		switch pkt.Codec {
+		case av.CodecH264:
+			if cfg, err := av.ParseAVCConfig(pkt.Data); err == nil {
+				v.Width, v.Height = cfg.Width, cfg.Height
+			}
+		case av.CodecH265:
+			if cfg, err := av.ParseHEVCConfig(pkt.Data); err == nil {
+				v.Width, v.Height = cfg.Width, cfg.Height
+			}
+		}
+		m.SetVideo(v)
+	case pkt.Type == av.PacketVideo:
+		m.CountVideoFrame(pkt.IsKeyFrame)
+	case pkt.Type == av.PacketAudio && pkt.IsSequenceHeader:
+		a := AudioStats{Codec: pkt.Codec.String()}
+		if cfg, err := av.ParseAACConfig(pkt.Data); err == nil {
+			a.SampleRate, a.Channels = cfg.SampleRate, cfg.Channels
+		}
+		m.SetAudio(a)
+	case pkt.Type == av.PacketAudio:
+		m.SetAudio(AudioStats{Codec: pkt.Codec.String()})
+	}
+}
+
+// CountDropped 广播器给跟不上的客户端丢弃了一个数据包
+func (m *StreamMeter) CountDropped() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropped++
+}
+
+// ClientRemoved 客户端离开时调用，把它发送的字节数、丢弃的数据包数计入直播间的累计值
+func (m *StreamMeter) ClientRemoved(c client.LiveClient) {
+	sp, ok := c.(client.StatsProvider)
+	if !ok {
+		return
+	}
+	cs := sp.Stats()
+	m.mu.Lock()
+	m.bytesOut += cs.BytesSent
+	m.dropped += cs.Dropped
+	m.mu.Unlock()
+}
+
+// SessionReapInterval 广播器多久检查一次过期的 HLS、DASH 会话
+const SessionReapInterval = 10 * time.Second
+
+// ExpiredClients 找出很久没有请求的 HLS、DASH 会话，调用方持有客户端锁，解锁后再逐个 RemoveLiveClient
+//
+//	这类客户端没有断开事件，不移除的话会一直留在广播器中，按需拉流的观众数永远不会回到 0。
+func ExpiredClients(clientMap map[string]client.LiveClient) []string {
+	var expired []string
+	for clientId, c := range clientMap {
+		if sp, ok := c.(client.StatsProvider); ok && sp.Expired() {
+			expired = append(expired, clientId)
+		}
+	}
+	return expired
+}
+
+// Snapshot 汇总上游计数和客户端的统计，clients 为调用方在锁内复制出的客户端列表
+func (m *StreamMeter) Snapshot(broadcasterKey, protocol string, clients []client.LiveClient) StreamStats {
+	clientStats := make([]client.Stats, 0, len(clients))
+	var bytesOut, dropped uint64
+	for _, c := range clients {
+		sp, ok := c.(client.StatsProvider)
+		if !ok {
+			continue
+		}
+		cs := sp.Stats()
+		bytesOut += cs.BytesSent
+		dropped += cs.Dropped
+		// 很久没有请求的 HLS、DASH 会话仍在广播器中，发送的字节数照常累计，但不算作当前观众
+		if !sp.Expired() {
+			clientStats = append(clientStats, cs)
+		}
+	}
+	sort.Slice(clientStats, func(i, j int) bool { return clientStats[i].ConnectedAt.Before(clientStats[j].ConnectedAt) })
+
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := StreamStats{
+		BroadcasterKey: broadcasterKey,
+		Protocol:       protocol,
+		UpstreamURL:    m.upstreamURL,
+		UpstreamState:  m.upstreamState,
+		Reconnects:     m.reconnects,
+		BytesIn:        m.bytesIn,
+		BytesOut:       m.bytesOut + bytesOut,
+		Dropped:        m.dropped + dropped,
+		Bitrate:        m.in.rate(now) * 8 / 1000,
+		FPS:            m.frames.rate(now),
+		Video:          m.video,
+		Audio:          m.audio,
+		GOPSize:        m.gopSize,
+		Viewers:        len(clientStats),
+		Clients:        clientStats,
+	}
+	if stats.UpstreamState == "" {
+		stats.UpstreamState = UpstreamIdle
+	}
+	if m.segmentRate {
+		stats.Bitrate, stats.FPS = m.segBitrate, m.segFPS
+	}
+	if m.upstreamState == UpstreamStreaming {
+		startedAt := m.startedAt
+		stats.StartedAt = &startedAt
+		stats.Uptime = int64(now.Sub(startedAt).Seconds())
+	}
+	return stats
+}
+
+// rateWindow 统计最近一个完整的一秒窗口内的速率，由 StreamMeter 加锁保护
+type rateWindow struct {
+	windowStart time.Time
+	count       int64
+	last        float64
+}
+
+func (w *rateWindow) add(n int, now time.Time) {
+	if elapsed := now.Sub(w.windowStart); elapsed >= time.Second {
+		w.last = float64(w.count) / elapsed.Seconds()
+		if elapsed >= 2*time.Second {
+			// 中间断了一段时间，上一个窗口的速率已经没有意义
+			w.last = 0
+		}
+		w.windowStart = now
+		w.count = 0
+	}
+	w.count += int64(n)
+}
+
+func (w *rateWindow) rate(now time.Time) float64 {
+	if now.Sub(w.windowStart) >= 2*time.Second {
+		return 0
+	}
+	return w.last
+}
@@ -0,0 +1,181 @@
+package broadcast
+
+import (
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+上游主备切换
+	一路直播可以配置多个上游地址，第一个为主上游，其余按顺序作为备用。
+	判定上游不健康的条件：
+		1、连接失败、HTTP 状态码错误，连续 MaxFailures 次；
+		2、连上之后超过 StallTimeout 没有收到数据（由 StallReader 或广播器自己判断），直接算一次失败并立即切换。
+	切换后接着按顺序往后轮换；开启 FailBack 时，在备用上游上每隔 FailBackInterval 探测一次主上游，恢复后切回。
+	每次切换调用 OnSwitch，由 application 层发布 event.UpstreamFailover 事件。
+*/
+
+const (
+	defaultMaxFailures      = 3
+	defaultStallTimeout     = 15 * time.Second
+	defaultFailBackInterval = 30 * time.Second
+)
+
+// UpstreamSwitchEvent 一次上游切换
+type UpstreamSwitchEvent struct {
+	BroadcasterKey string    `json:"broadcasterKey"`
+	From           string    `json:"from"`
+	To             string    `json:"to"`
+	Reason         string    `json:"reason"`
+	FailBack       bool      `json:"failBack"` // 是否为主上游恢复后的切回
+	At             time.Time `json:"at"`
+}
+
+// Upstreams 一路直播的上游列表，并发安全
+type Upstreams struct {
+	BroadcasterKey   string                    // 由广播器在创建时设置，用于事件
+	URLs             []string                  // 第一个为主上游
+	MaxFailures      int                       // 连续失败多少次切换，默认 3
+	StallTimeout     time.Duration             // 多久没有数据视为失败，默认 15s
+	FailBack         bool                      // 主上游恢复后是否切回
+	FailBackInterval time.Duration             // 探测主上游的间隔，默认 30s
+	OnSwitch         func(UpstreamSwitchEvent) // 切换时回调，不能阻塞
+
+	mu       sync.Mutex
+	current  int
+	failures int
+}
+
+// NewUpstreams 创建上游列表，urls 按优先级排列
+func NewUpstreams(urls ...string) *Upstreams {
+	return &Upstreams{
+		URLs:             urls,
+		MaxFailures:      defaultMaxFailures,
+		StallTimeout:     defaultStallTimeout,
+		FailBackInterval: defaultFailBackInterval,
+	}
+}
+
+// Current 返回当前使用的上游
+func (u *Upstreams) Current() (index int, url string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.current, u.URLs[u.current]
+}
+
+// Primary 返回主上游地址
+func (u *Upstreams) Primary() string {
+	return u.URLs[0]
+}
+
+// ReportSuccess 当前上游收到了数据，清空失败计数
+func (u *Upstreams) ReportSuccess() {
+	u.mu.Lock()
+	u.failures = 0
+	u.mu.Unlock()
+}
+
+// ReportFailure 当前上游失败一次，连续失败达到 MaxFailures 或 immediate 为 true 时切换到下一个，返回是否发生了切换
+func (u *Upstreams) ReportFailure(reason string, immediate bool) bool {
+	u.mu.Lock()
+	u.failures++
+	if len(u.URLs) < 2 || (!immediate && u.failures < u.MaxFailures) {
+		u.mu.Unlock()
+		return false
+	}
+	u.mu.Unlock()
+	u.switchTo(-1, reason, false)
+	return true
+}
+
+// SwitchToPrimary 主上游恢复后切回
+func (u *Upstreams) SwitchToPrimary() {
+	u.switchTo(0, "primary recovered", true)
+}
+
+// switchTo 切换到 index，index 为 -1 时切换到下一个
+func (u *Upstreams) switchTo(index int, reason string, failBack bool) {
+	u.mu.Lock()
+	from := u.URLs[u.current]
+	if index < 0 {
+		index = (u.current + 1) % len(u.URLs)
+	}
+	u.current = index
+	u.failures = 0
+	to := u.URLs[index]
+	onSwitch := u.OnSwitch
+	u.mu.Unlock()
+
+	log.Printf("[upstream:%s] switch %s -> %s: %s", u.BroadcasterKey, from, to, reason)
+	if onSwitch != nil {
+		onSwitch(UpstreamSwitchEvent{BroadcasterKey: u.BroadcasterKey, From: from, To: to, Reason: reason, FailBack: failBack, At: time.Now()})
+	}
+}
+
+// WatchFailBack 当前不在主上游且开启了 FailBack 时，每隔 FailBackInterval 用 probe 探测主上游；
+// 探测成功后切回主上游并调用 recovered。stop 被关闭时退出，应在每次连接上游时调用一次
+func (u *Upstreams) WatchFailBack(stop <-chan struct{}, probe func(url string) error, recovered func()) {
+	if index, _ := u.Current(); !u.FailBack || index == 0 {
+		return
+	}
+	ticker := time.NewTicker(u.FailBackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := probe(u.Primary()); err != nil {
+				continue
+			}
+			u.SwitchToPrimary()
+			recovered()
+			return
+		}
+	}
+}
+
+// ====================== StallReader ======================
+
+// StallReader 超过 timeout 没有读到数据时关闭底层连接，让阻塞的 Read 返回
+type StallReader struct {
+	rc      io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+// NewStallReader 包装上游连接，timeout 不大于 0 时不检测
+func NewStallReader(rc io.ReadCloser, timeout time.Duration) *StallReader {
+	sr := &StallReader{rc: rc, timeout: timeout}
+	if timeout > 0 {
+		sr.timer = time.AfterFunc(timeout, func() {
+			sr.stalled.Store(true)
+			_ = rc.Close()
+		})
+	}
+	return sr
+}
+
+func (sr *StallReader) Read(p []byte) (int, error) {
+	n, err := sr.rc.Read(p)
+	if n > 0 && sr.timer != nil && !sr.stalled.Load() {
+		sr.timer.Reset(sr.timeout)
+	}
+	return n, err
+}
+
+func (sr *StallReader) Close() error {
+	if sr.timer != nil {
+		sr.timer.Stop()
+	}
+	return sr.rc.Close()
+}
+
+// Stalled 连接是否因为超时没有数据被关闭
+func (sr *StallReader) Stalled() bool {
+	return sr.stalled.Load()
+}
@@ -0,0 +1,299 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/samplebuilder"
+	"io"
+	"log"
+	"pull2push/core/broadcast"
+	"pull2push/core/client"
+	"pull2push/core/media/av"
+	"pull2push/event"
+	"pull2push/event/payload"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+WHIP 推流 → WebRTCBroadcaster → WHEP 观众 / FLV 桥接
+
+	推流端的视频、音频 RTP 原样写入两个本地轨道，所有 WHEP 观众共用这两个轨道，延迟在一秒以内。
+	同时把 H.264 RTP 组帧后转成 FLV tag 写入 FLV 管道，交给同一个直播间的 CameraBroadcaster，
+	这样 WebRTC 推流也可以用 HTTP-FLV、WebSocket-FLV、HLS 观看。
+	FLV 不支持 Opus，桥接出去的 FLV 只有视频。
+*/
+
+const (
+	keyFrameInterval = 2 * time.Second // 定时向推流端请求关键帧，FLV GOP 缓存和 HLS 切片都依赖关键帧
+	rtpMaxLate       = 256             // 组帧时最多等待的乱序包数
+)
+
+// WebRTCBroadcaster 每一路 WHIP 推流对应一个 WebRTCBroadcaster，里面管理了多个 WHEP 观众
+type WebRTCBroadcaster struct {
+	broadcast.Lifecycle
+	broadcast.Emitter
+	broadcast.StreamMeter
+
+	// 直播数据相关
+	BroadcasterKey string // 直播房间的唯一编号
+	SessionId      string // WHIP 会话编号，结束推流（DELETE）时校验
+
+	pc         *webrtc.PeerConnection
+	videoTrack *webrtc.TrackLocalStaticRTP // 转发给 WHEP 观众的视频轨道
+	audioTrack *webrtc.TrackLocalStaticRTP // 转发给 WHEP 观众的音频轨道
+	videoSSRC  atomic.Uint32               // 推流端视频 SSRC，请求关键帧时使用
+	connected  atomic.Bool                 // 推流连接是否建立过，结束推流时据此发布 UpstreamLost
+
+	// FLV 桥接
+	flvReader *io.PipeReader
+	flvWriter *io.PipeWriter
+
+	// 状态控制相关
+	BroadcasterCloseSig chan broadcast.BROADCAST_CLOSE_TYPE // 推流结束时被关闭
+
+	// 客户端相关
+	clientMutex    sync.Mutex                   // 客户端的异步操作控制器
+	clientMap      map[string]client.LiveClient // map[clientId]LiveClient 存储所有的 WHEP 观众
+	ClientCloseSig chan string                  // 客户端关闭信号，输出的字符串为关闭的客户端编号clientId
+}
+
+// NewWebRTCBroadcaster 根据 WHIP 推流端的 offer 创建广播器，返回 answer
+func NewWebRTCBroadcaster(broadcasterKey, sessionId, offer string) (*WebRTCBroadcaster, string, error) {
+	pc, err := NewPeerConnection()
+	if err != nil {
+		return nil, "", err
+	}
+
+	videoTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", broadcasterKey)
+	if err != nil {
+		_ = pc.Close()
+		return nil, "", err
+	}
+	audioTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", broadcasterKey)
+	if err != nil {
+		_ = pc.Close()
+		return nil, "", err
+	}
+
+	pr, pw := io.Pipe()
+	wb := &WebRTCBroadcaster{
+		BroadcasterKey:      broadcasterKey,
+		SessionId:           sessionId,
+		pc:                  pc,
+		videoTrack:          videoTrack,
+		audioTrack:          audioTrack,
+		flvReader:           pr,
+		flvWriter:           pw,
+		BroadcasterCloseSig: make(chan broadcast.BROADCAST_CLOSE_TYPE),
+		clientMap:           make(map[string]client.LiveClient),
+		ClientCloseSig:      make(chan string),
+	}
+
+	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		switch track.Kind() {
+		case webrtc.RTPCodecTypeVideo:
+			go wb.readVideo(track)
+		case webrtc.RTPCodecTypeAudio:
+			go wb.readAudio(track)
+		}
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Println("WHIP 推流连接状态:", broadcasterKey, state)
+		if state == webrtc.PeerConnectionStateConnected && wb.connected.CompareAndSwap(false, true) {
+			wb.SetUpstream("", broadcast.UpstreamStreaming)
+			wb.EmitPublished(payload.StreamPayload{BroadcasterKey: broadcasterKey, Protocol: payload.ProtocolWebRTC})
+			wb.Emit(event.UpstreamConnected, payload.UpstreamPayload{BroadcasterKey: broadcasterKey, Protocol: payload.ProtocolWebRTC})
+		}
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			go wb.Close(broadcast.BrokerEnd)
+		}
+	})
+
+	answer, err := Negotiate(pc, offer)
+	if err != nil {
+		wb.Close(broadcast.BrokerClosed)
+		return nil, "", errors.New(fmt.Sprintf("WHIP 协商失败: %s", err.Error()))
+	}
+
+	// 开启必要的状态监听
+	go wb.ListenStatus()
+
+	log.Println("WHIP 开始推流:", broadcasterKey)
+
+	return wb, answer, nil
+}
+
+// readVideo 转发视频 RTP，同时组帧写入 FLV 桥接
+func (wb *WebRTCBroadcaster) readVideo(track *webrtc.TrackRemote) {
+	wb.videoSSRC.Store(uint32(track.SSRC()))
+	wb.RequestKeyFrame()
+
+	bridge := newFLVBridge(wb.flvWriter, track.Codec().ClockRate, &wb.StreamMeter)
+	builder := samplebuilder.New(rtpMaxLate, &codecs.H264Packet{}, track.Codec().ClockRate)
+	bridgeOK := true
+	for {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		wb.CountIn(pkt.MarshalSize())
+		if err := wb.videoTrack.WriteRTP(pkt); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			log.Println("WHEP 视频转发失败:", err)
+		}
+		if !bridgeOK {
+			continue
+		}
+
+		builder.Push(pkt)
+		for sample := builder.Pop(); sample != nil; sample = builder.Pop() {
+			if err := bridge.writeH264(sample.Data, sample.PacketTimestamp); err != nil {
+				// FLV 那一侧已经不读了（如直播间正在被其他方式推流），只保留 WebRTC 转发
+				log.Println("FLV 桥接停止:", wb.BroadcasterKey, err)
+				bridgeOK = false
+				break
+			}
+		}
+	}
+}
+
+// readAudio 转发音频 RTP，Opus 无法放入 FLV，不参与桥接
+func (wb *WebRTCBroadcaster) readAudio(track *webrtc.TrackRemote) {
+	codec := track.Codec()
+	wb.SetAudio(broadcast.AudioStats{Codec: av.CodecOpus.String(), SampleRate: int(codec.ClockRate), Channels: int(codec.Channels)})
+	for {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		wb.CountIn(pkt.MarshalSize())
+		if err := wb.audioTrack.WriteRTP(pkt); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			log.Println("WHEP 音频转发失败:", err)
+		}
+	}
+}
+
+// RequestKeyFrame 向推流端发送 PLI 请求关键帧，新观众加入时调用可以尽快出画面
+func (wb *WebRTCBroadcaster) RequestKeyFrame() {
+	if ssrc := wb.videoSSRC.Load(); ssrc != 0 {
+		_ = wb.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}})
+	}
+}
+
+// Tracks 返回转发给 WHEP 观众的本地轨道
+func (wb *WebRTCBroadcaster) Tracks() []webrtc.TrackLocal {
+	return []webrtc.TrackLocal{wb.videoTrack, wb.audioTrack}
+}
+
+// FLVReader 返回 FLV 桥接的读端，推流结束后读到 EOF；读的一方不再需要时关闭它，桥接随之停止
+func (wb *WebRTCBroadcaster) FLVReader() io.ReadCloser {
+	return wb.flvReader
+}
+
+// Close 结束推流：关闭推流连接和 FLV 桥接，通知所有 WHEP 观众，并从所在的 Broker 中移除
+func (wb *WebRTCBroadcaster) Close(reason broadcast.BROADCAST_CLOSE_TYPE) {
+	wb.Shutdown(reason, func() {
+		_ = wb.pc.Close()
+		_ = wb.flvWriter.Close()
+		close(wb.BroadcasterCloseSig)
+		wb.SetUpstream("", broadcast.UpstreamEnded)
+		log.Println("WHIP 推流结束:", wb.BroadcasterKey, "reason =", reason)
+
+		if wb.connected.Load() {
+			wb.Emit(event.UpstreamLost, payload.UpstreamPayload{BroadcasterKey: wb.BroadcasterKey, Protocol: payload.ProtocolWebRTC, Reason: "publisher closed"})
+		}
+		wb.EmitUnpublished(payload.StreamPayload{BroadcasterKey: wb.BroadcasterKey, Protocol: payload.ProtocolWebRTC, Reason: "publisher closed"})
+		wb.clientMutex.Lock()
+		for clientId, liveClient := range wb.clientMap {
+			wb.ClientRemoved(liveClient)
+			wb.Emit(event.ViewerKicked, payload.ViewerPayload{BroadcasterKey: wb.BroadcasterKey, Protocol: payload.ProtocolWebRTC, ClientId: clientId, Reason: "broadcaster closed"})
+		}
+		clear(wb.clientMap)
+		wb.clientMutex.Unlock()
+	})
+}
+
+// AddLiveClient 添加 WHEP 观众，并请求一个关键帧
+func (wb *WebRTCBroadcaster) AddLiveClient(clientId string, client client.LiveClient) {
+	wb.clientMutex.Lock()
+	_, exists := wb.clientMap[clientId]
+	wb.clientMap[clientId] = client
+	viewers := len(wb.clientMap)
+	wb.clientMutex.Unlock()
+
+	if !exists {
+		wb.Emit(event.ViewerJoined, payload.ViewerPayload{BroadcasterKey: wb.BroadcasterKey, Protocol: payload.ProtocolWebRTC, ClientId: clientId, Viewers: viewers})
+	}
+
+	wb.RequestKeyFrame()
+}
+
+// RemoveLiveClient 移除客户端
+func (wb *WebRTCBroadcaster) RemoveLiveClient(clientId string) {
+	wb.clientMutex.Lock()
+	defer wb.clientMutex.Unlock()
+	liveClient, ok := wb.clientMap[clientId]
+	if !ok {
+		return
+	}
+	delete(wb.clientMap, clientId)
+	wb.ClientRemoved(liveClient)
+	wb.EmitViewerRemoved(liveClient, payload.ViewerPayload{BroadcasterKey: wb.BroadcasterKey, Protocol: payload.ProtocolWebRTC, ClientId: clientId, Viewers: len(wb.clientMap)})
+}
+
+// FindLiveClient 查询 LiveClient
+func (wb *WebRTCBroadcaster) FindLiveClient(clientId string) (client.LiveClient, error) {
+	wb.clientMutex.Lock()
+	defer wb.clientMutex.Unlock()
+	if val, ok := wb.clientMap[clientId]; ok {
+		return val, nil
+	}
+	return nil, errors.New(fmt.Sprintf("未找到 %s 对应的 LiveClient", clientId))
+}
+
+// Stats 实现 broadcast.StatsProvider，上游地址为推流端地址
+func (wb *WebRTCBroadcaster) Stats() broadcast.StreamStats {
+	wb.clientMutex.Lock()
+	clients := make([]client.LiveClient, 0, len(wb.clientMap))
+	for _, c := range wb.clientMap {
+		clients = append(clients, c)
+	}
+	wb.clientMutex.Unlock()
+	return wb.Snapshot(wb.BroadcasterKey, payload.ProtocolWebRTC, clients)
+}
+
+// UpdateSourceURL 支持切换直播原地址
+func (wb *WebRTCBroadcaster) UpdateSourceURL(newSourceURL string) {}
+
+// ListenStatus 监听当前直播的必要状态
+func (wb *WebRTCBroadcaster) ListenStatus() {
+	for {
+		select {
+		case clientId := <-wb.ClientCloseSig:
+			wb.RemoveLiveClient(clientId)
+		case <-wb.BroadcasterCloseSig:
+			return
+		}
+	}
+}
+
+// PullLoop 保持推流会话：RTP 由 OnTrack 回调接收，这里定时请求关键帧，推流结束后返回
+func (wb *WebRTCBroadcaster) PullLoop(bo broadcast.BroadcasterOptional) {
+	ticker := time.NewTicker(keyFrameInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			wb.RequestKeyFrame()
+		case <-wb.BroadcasterCloseSig:
+			return
+		}
+	}
+}
+
+// Broadcast2LiveClient WHEP 观众直接绑定本地轨道，不需要逐个分发
+func (wb *WebRTCBroadcaster) Broadcast2LiveClient(data []byte) {}
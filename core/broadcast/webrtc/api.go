@@ -0,0 +1,75 @@
+package webrtc
+
+import (
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+	"sync"
+)
+
+var (
+	apiOnce sync.Once
+	api     *webrtc.API
+	apiErr  error
+)
+
+// NewPeerConnection 创建 WHIP/WHEP 使用的 PeerConnection
+//
+//	只注册 H.264 和 Opus，推流端协商时只能选这两种编码，FLV/HLS 桥接才能直接使用视频数据。
+//	没有配置 STUN/TURN，只收集本机地址，适合内网或服务器有公网 IP 的场景。
+func NewPeerConnection() (*webrtc.PeerConnection, error) {
+	apiOnce.Do(func() {
+		m := &webrtc.MediaEngine{}
+		if apiErr = m.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:    webrtc.MimeTypeH264,
+				ClockRate:   90000,
+				SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+				RTCPFeedback: []webrtc.RTCPFeedback{
+					{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"},
+				},
+			},
+			PayloadType: 102,
+		}, webrtc.RTPCodecTypeVideo); apiErr != nil {
+			return
+		}
+		if apiErr = m.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:    webrtc.MimeTypeOpus,
+				ClockRate:   48000,
+				Channels:    2,
+				SDPFmtpLine: "minptime=10;useinbandfec=1",
+			},
+			PayloadType: 111,
+		}, webrtc.RTPCodecTypeAudio); apiErr != nil {
+			return
+		}
+
+		// NACK 重传、RTCP 报告等
+		registry := &interceptor.Registry{}
+		if apiErr = webrtc.RegisterDefaultInterceptors(m, registry); apiErr != nil {
+			return
+		}
+		api = webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(registry))
+	})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return api.NewPeerConnection(webrtc.Configuration{})
+}
+
+// Negotiate 设置对端 offer 并生成 answer，等待 ICE 候选收集完成后返回（WHIP/WHEP 不使用 trickle ICE）
+func Negotiate(pc *webrtc.PeerConnection, offer string) (string, error) {
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	<-gatherComplete
+	return pc.LocalDescription().SDP, nil
+}
@@ -0,0 +1,114 @@
+package webrtc
+
+import (
+	"bytes"
+	"io"
+	"pull2push/core/broadcast"
+	"pull2push/core/media/av"
+	"time"
+)
+
+// flvBridge 把 RTP 组好的 H.264 帧（Annex B）转成 FLV tag 写出
+//
+//	SPS/PPS 变化时重新输出 AVC 序列头；收到第一个关键帧之前的帧直接丢弃，保证 FLV 从关键帧开始。
+//	WebRTC 的 H.264 不带 B 帧，CompositionTime 恒为 0。
+type flvBridge struct {
+	w          io.Writer
+	clockRate  uint32 // RTP 时钟频率，H.264 为 90000
+	headerSent bool
+	sps, pps   []byte
+	configSent bool
+	started    bool                   // 是否已经输出过关键帧
+	baseTs     uint32                 // 第一个输出帧的 RTP 时间戳
+	meter      *broadcast.StreamMeter // 按输出的帧统计帧率、GOP 和分辨率
+}
+
+func newFLVBridge(w io.Writer, clockRate uint32, meter *broadcast.StreamMeter) *flvBridge {
+	if clockRate == 0 {
+		clockRate = 90000
+	}
+	return &flvBridge{w: w, clockRate: clockRate, meter: meter}
+}
+
+// writeH264 写入一帧，rtpTs 为该帧的 RTP 时间戳
+func (fb *flvBridge) writeH264(annexB []byte, rtpTs uint32) error {
+	var frame [][]byte
+	keyFrame := false
+	configChanged := false
+	for _, nalu := range av.SplitAnnexB(annexB) {
+		switch av.H264NALType(nalu) {
+		case 7:
+			if !bytes.Equal(fb.sps, nalu) {
+				fb.sps = append([]byte(nil), nalu...)
+				configChanged = true
+			}
+		case 8:
+			if !bytes.Equal(fb.pps, nalu) {
+				fb.pps = append([]byte(nil), nalu...)
+				configChanged = true
+			}
+		case 9:
+			// AUD 在 FLV 中没有意义
+		case 5:
+			keyFrame = true
+			frame = append(frame, nalu)
+		default:
+			frame = append(frame, nalu)
+		}
+	}
+
+	if !fb.headerSent {
+		if _, err := fb.w.Write(av.FLVHeader(true, false)); err != nil {
+			return err
+		}
+		fb.headerSent = true
+	}
+
+	if configChanged && fb.sps != nil && fb.pps != nil {
+		record, err := av.BuildAVCConfig(fb.sps, fb.pps)
+		if err != nil {
+			return err
+		}
+		if !fb.started {
+			fb.baseTs = rtpTs
+		}
+		if err := fb.writePacket(&av.Packet{
+			Type:             av.PacketVideo,
+			Codec:            av.CodecH264,
+			DTS:              fb.dts(rtpTs),
+			IsKeyFrame:       true,
+			IsSequenceHeader: true,
+			Data:             record,
+		}); err != nil {
+			return err
+		}
+		fb.configSent = true
+	}
+
+	if !fb.configSent || len(frame) == 0 || (!fb.started && !keyFrame) {
+		return nil
+	}
+	if !fb.started {
+		fb.started = true
+		fb.baseTs = rtpTs
+	}
+	return fb.writePacket(&av.Packet{
+		Type:       av.PacketVideo,
+		Codec:      av.CodecH264,
+		DTS:        fb.dts(rtpTs),
+		IsKeyFrame: keyFrame,
+		Data:       av.JoinAVCC(frame),
+	})
+}
+
+// dts RTP 时间戳换算成相对第一帧的时间，uint32 相减可以处理时间戳回绕
+func (fb *flvBridge) dts(rtpTs uint32) time.Duration {
+	return time.Duration(rtpTs-fb.baseTs) * time.Second / time.Duration(fb.clockRate)
+}
+
+func (fb *flvBridge) writePacket(pkt *av.Packet) error {
+	fb.meter.CountPacket(pkt)
+	tagType, timestamp, data, _ := av.MuxFLVTag(pkt)
+	_, err := fb.w.Write(av.FLVTagBytes(tagType, timestamp, data))
+	return err
+}
@@ -1,6 +1,9 @@
 package broker
 
-import "pull2push/core/broadcast"
+import (
+	"pull2push/core/broadcast"
+	"pull2push/event"
+)
 
 // ====================== Broker ======================
 // Broker ===>> Broadcaster ===>> LiveClient
@@ -18,4 +21,10 @@ type Broker interface {
 
 	// FindBroadcaster 查询 Broker
 	FindBroadcaster(brokerKey string) (broadcast.Broadcaster, error)
+
+	// ListBroadcaster 返回当前所有 Broadcaster 的快照，key 为直播房间号
+	ListBroadcaster() map[string]broadcast.Broadcaster
+
+	// SetEventBus 注入事件总线，之后加入的 Broadcaster 也会用它发布事件
+	SetEventBus(bus *event.EventBus)
 }
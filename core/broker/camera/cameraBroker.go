@@ -11,6 +11,8 @@ import (
 
 // CameraBroker 广播器
 type CameraBroker struct {
+	broadcast.Emitter
+
 	mutex sync.Mutex
 
 	//  负责协调所有的拉流链接 FLVStreamBroker 直接的工作
@@ -27,9 +29,44 @@ func NewCameraBroker() *CameraBroker {
 
 func (cb *CameraBroker) AddBroadcaster(broadcastKey string, b broadcast.Broadcaster) {
 	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
+	cb.broadcastMap[broadcastKey] = b
+	cb.mutex.Unlock()
 
+	cb.attach(broadcastKey, b)
+}
+
+// FindOrAddBroadcaster 直播间存在时返回已有的，不存在时用 create 创建并加入，created 表示是否新建；
+// 查找和加入在同一把锁内完成，同时推流到同一个新直播间时只会创建一个
+func (cb *CameraBroker) FindOrAddBroadcaster(broadcastKey string, create func() broadcast.Broadcaster) (b broadcast.Broadcaster, created bool) {
+	cb.mutex.Lock()
+	if cur, ok := cb.broadcastMap[broadcastKey]; ok {
+		cb.mutex.Unlock()
+		return cur, false
+	}
+	b = create()
 	cb.broadcastMap[broadcastKey] = b
+	cb.mutex.Unlock()
+
+	cb.attach(broadcastKey, b)
+	return b, true
+}
+
+// attach 新加入的广播器注入事件总线，并在关闭后自动移除
+func (cb *CameraBroker) attach(broadcastKey string, b broadcast.Broadcaster) {
+	b.SetEventBus(cb.EventBus())
+
+	// 广播器关闭后自动移除，已经关闭的广播器会立即回调，不能持有锁
+	b.OnClose(func() { cb.removeClosed(broadcastKey, b) })
+}
+
+// removeClosed 移除已经关闭的广播器，同一个房间号已经换成新的广播器时不移除
+func (cb *CameraBroker) removeClosed(broadcastKey string, b broadcast.Broadcaster) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cur, ok := cb.broadcastMap[broadcastKey]; ok && cur == b {
+		delete(cb.broadcastMap, broadcastKey)
+	}
 }
 
 func (cb *CameraBroker) RemoveBroadcaster(broadcastKey string) {
@@ -45,8 +82,23 @@ func (cb *CameraBroker) RemoveBroadcaster(broadcastKey string) {
 
 // FindBroker 查询 Broker
 func (cb *CameraBroker) FindBroadcaster(broadcastKey string) (broadcast.Broadcaster, error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
 	if val, ok := cb.broadcastMap[broadcastKey]; ok {
 		return val, nil
 	}
 	return nil, errors.New(fmt.Sprintf("未找到 %s 对应的 Broadcaster", broadcastKey))
 }
+
+// ListBroadcaster 返回当前所有 Broadcaster 的快照
+func (cb *CameraBroker) ListBroadcaster() map[string]broadcast.Broadcaster {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	list := make(map[string]broadcast.Broadcaster, len(cb.broadcastMap))
+	for key, b := range cb.broadcastMap {
+		list[key] = b
+	}
+	return list
+}
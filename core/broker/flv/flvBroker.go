@@ -11,6 +11,8 @@ import (
 
 // FLVBroker 广播器
 type FLVBroker struct {
+	broadcast.Emitter
+
 	mutex sync.Mutex
 
 	//  负责协调所有的拉流链接 broadcast.Broadcaster 直接的工作
@@ -25,10 +27,24 @@ func NewFLVBroker() *FLVBroker {
 }
 
 func (fb *FLVBroker) AddBroadcaster(broadcastKey string, b broadcast.Broadcaster) {
+	fb.mutex.Lock()
+	fb.broadcastMap[broadcastKey] = b
+	fb.mutex.Unlock()
+
+	b.SetEventBus(fb.EventBus())
+
+	// 广播器关闭后自动移除，已经关闭的广播器会立即回调，不能持有锁
+	b.OnClose(func() { fb.removeClosed(broadcastKey, b) })
+}
+
+// removeClosed 移除已经关闭的广播器，同一个房间号已经换成新的广播器时不移除
+func (fb *FLVBroker) removeClosed(broadcastKey string, b broadcast.Broadcaster) {
 	fb.mutex.Lock()
 	defer fb.mutex.Unlock()
 
-	fb.broadcastMap[broadcastKey] = b
+	if cur, ok := fb.broadcastMap[broadcastKey]; ok && cur == b {
+		delete(fb.broadcastMap, broadcastKey)
+	}
 }
 
 func (fb *FLVBroker) RemoveBroadcaster(broadcastKey string) {
@@ -44,8 +60,23 @@ func (fb *FLVBroker) RemoveBroadcaster(broadcastKey string) {
 
 // FindBroker 查询 Broker
 func (fb *FLVBroker) FindBroadcaster(broadcastKey string) (broadcast.Broadcaster, error) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+
 	if val, ok := fb.broadcastMap[broadcastKey]; ok {
 		return val, nil
 	}
 	return nil, errors.New(fmt.Sprintf("未找到 %s 对应的 Broadcaster", broadcastKey))
 }
+
+// ListBroadcaster 返回当前所有 Broadcaster 的快照
+func (fb *FLVBroker) ListBroadcaster() map[string]broadcast.Broadcaster {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+
+	list := make(map[string]broadcast.Broadcaster, len(fb.broadcastMap))
+	for key, b := range fb.broadcastMap {
+		list[key] = b
+	}
+	return list
+}
@@ -11,6 +11,8 @@ import (
 
 // HLSBroker 广播器
 type HLSBroker struct {
+	broadcast.Emitter
+
 	mutex sync.Mutex
 
 	//  负责协调所有的 broadcast.Broadcaster 直接的工作
@@ -25,10 +27,24 @@ func NewHLSBroker() *HLSBroker {
 }
 
 func (hb *HLSBroker) AddBroadcaster(broadcastKey string, b broadcast.Broadcaster) {
+	hb.mutex.Lock()
+	hb.broadcastMap[broadcastKey] = b
+	hb.mutex.Unlock()
+
+	b.SetEventBus(hb.EventBus())
+
+	// 广播器关闭后自动移除，已经关闭的广播器会立即回调，不能持有锁
+	b.OnClose(func() { hb.removeClosed(broadcastKey, b) })
+}
+
+// removeClosed 移除已经关闭的广播器，同一个房间号已经换成新的广播器时不移除
+func (hb *HLSBroker) removeClosed(broadcastKey string, b broadcast.Broadcaster) {
 	hb.mutex.Lock()
 	defer hb.mutex.Unlock()
 
-	hb.broadcastMap[broadcastKey] = b
+	if cur, ok := hb.broadcastMap[broadcastKey]; ok && cur == b {
+		delete(hb.broadcastMap, broadcastKey)
+	}
 }
 
 func (hb *HLSBroker) RemoveBroadcaster(broadcastKey string) {
@@ -44,8 +60,23 @@ func (hb *HLSBroker) RemoveBroadcaster(broadcastKey string) {
 
 // FindBroker 查询 Broker
 func (fb *HLSBroker) FindBroadcaster(broadcastKey string) (broadcast.Broadcaster, error) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+
 	if val, ok := fb.broadcastMap[broadcastKey]; ok {
 		return val, nil
 	}
 	return nil, errors.New(fmt.Sprintf("未找到 %s 对应的 Broadcaster", broadcastKey))
 }
+
+// ListBroadcaster 返回当前所有 Broadcaster 的快照
+func (hb *HLSBroker) ListBroadcaster() map[string]broadcast.Broadcaster {
+	hb.mutex.Lock()
+	defer hb.mutex.Unlock()
+
+	list := make(map[string]broadcast.Broadcaster, len(hb.broadcastMap))
+	for key, b := range hb.broadcastMap {
+		list[key] = b
+	}
+	return list
+}
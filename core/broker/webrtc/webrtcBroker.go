@@ -0,0 +1,97 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+	"pull2push/core/broadcast"
+	"sync"
+)
+
+// ====================== WebRTCBroker ======================
+
+// WebRTCBroker 管理所有 WHIP 推流的直播间，推流开始时加入，推流结束时移除
+type WebRTCBroker struct {
+	broadcast.Emitter
+
+	mutex sync.Mutex
+
+	broadcastMap map[string]broadcast.Broadcaster // key为直播房间号
+}
+
+func NewWebRTCBroker() *WebRTCBroker {
+	return &WebRTCBroker{
+		broadcastMap: make(map[string]broadcast.Broadcaster),
+	}
+}
+
+func (wb *WebRTCBroker) AddBroadcaster(broadcastKey string, b broadcast.Broadcaster) {
+	wb.mutex.Lock()
+	wb.broadcastMap[broadcastKey] = b
+	wb.mutex.Unlock()
+
+	wb.attach(broadcastKey, b)
+}
+
+// FindOrAddBroadcaster 直播间存在时返回已有的，不存在时用 create 创建并加入，created 表示是否新建；
+// 查找和加入在同一把锁内完成，同时推流到同一个新直播间时只会创建一个
+func (wb *WebRTCBroker) FindOrAddBroadcaster(broadcastKey string, create func() broadcast.Broadcaster) (b broadcast.Broadcaster, created bool) {
+	wb.mutex.Lock()
+	if cur, ok := wb.broadcastMap[broadcastKey]; ok {
+		wb.mutex.Unlock()
+		return cur, false
+	}
+	b = create()
+	wb.broadcastMap[broadcastKey] = b
+	wb.mutex.Unlock()
+
+	wb.attach(broadcastKey, b)
+	return b, true
+}
+
+// attach 新加入的广播器注入事件总线，并在关闭后自动移除
+func (wb *WebRTCBroker) attach(broadcastKey string, b broadcast.Broadcaster) {
+	b.SetEventBus(wb.EventBus())
+
+	// 广播器关闭后自动移除，已经关闭的广播器会立即回调，不能持有锁
+	b.OnClose(func() { wb.removeClosed(broadcastKey, b) })
+}
+
+// removeClosed 移除已经关闭的广播器，同一个房间号已经换成新的广播器时不移除
+func (wb *WebRTCBroker) removeClosed(broadcastKey string, b broadcast.Broadcaster) {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	if cur, ok := wb.broadcastMap[broadcastKey]; ok && cur == b {
+		delete(wb.broadcastMap, broadcastKey)
+	}
+}
+
+func (wb *WebRTCBroker) RemoveBroadcaster(broadcastKey string) {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	delete(wb.broadcastMap, broadcastKey)
+}
+
+// FindBroadcaster 查询 Broadcaster
+func (wb *WebRTCBroker) FindBroadcaster(broadcastKey string) (broadcast.Broadcaster, error) {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	if val, ok := wb.broadcastMap[broadcastKey]; ok {
+		return val, nil
+	}
+	return nil, errors.New(fmt.Sprintf("未找到 %s 对应的 Broadcaster", broadcastKey))
+}
+
+// ListBroadcaster 返回当前所有 Broadcaster 的快照
+func (wb *WebRTCBroker) ListBroadcaster() map[string]broadcast.Broadcaster {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	list := make(map[string]broadcast.Broadcaster, len(wb.broadcastMap))
+	for key, b := range wb.broadcastMap {
+		list[key] = b
+	}
+	return list
+}
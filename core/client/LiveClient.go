@@ -15,3 +15,10 @@ type LiveClient interface {
 	// GetDataChan 获取当前客户端的写通道
 	GetDataChan() chan []byte
 }
+
+// Kickable 会被服务端主动断开的客户端（如发送队列塞满），广播器移除客户端时据此区分观众是自己离开还是被断开
+type Kickable interface {
+
+	// KickReason 被服务端断开的原因，为空表示客户端自己断开
+	KickReason() string
+}
@@ -3,7 +3,11 @@ package flv
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"log"
 	"pull2push/core/broadcast"
+	"pull2push/core/client"
+	"sync"
+	"sync/atomic"
 )
 
 // ====================== CameraLiveClient ======================
@@ -13,6 +17,11 @@ type CameraLiveClient struct {
 	BroadcasterKey string      // 这个客户端的直播房间的唯一编号
 	ClientId       string      // 这个客户端的id
 	dataCh         chan []byte // 这个客户端的一个只写通道
+	*client.Meter              // 发送统计，由 CameraService 写出数据后计数
+
+	kickSig  chan struct{} // 通道塞满被服务端断开时关闭，CameraService 收到后结束响应
+	kickOnce sync.Once
+	kicked   atomic.Bool
 
 	// http连接相关
 	httpCloseSig        <-chan struct{} // 当这个请求被客户端主动被关闭时触发
@@ -29,13 +38,15 @@ func NewCameraLiveClient(c *gin.Context, broadcasterKey, clientId string, client
 		BroadcasterKey:      broadcasterKey,
 		ClientId:            clientId,
 		dataCh:              make(chan []byte, 1024),
+		kickSig:             make(chan struct{}),
 		httpCloseSig:        c.Done(),
 		httpRequestCloseSig: c.Request.Context().Done(),
+		Meter:               client.NewMeter(clientId, c.ClientIP(), c.Request.UserAgent()),
 		clientCloseSig:      clientCloseSig,
 		broadcasterCloseSig: broadcasterCloseSig,
 	}
 
-	fmt.Println("HLS 客户端连接成功 ClientId = ", clientId)
+	fmt.Println("摄像头 FLV 客户端连接成功 ClientId = ", clientId)
 
 	// 开启状态监听
 	go clc.Listen()
@@ -75,6 +86,39 @@ func (clc *CameraLiveClient) Listen() {
 
 }
 
+// Broadcast 放入通道，通道塞满说明客户端跟不上，通知 CameraService 断开，不阻塞推流
 func (clc *CameraLiveClient) Broadcast(data []byte) {
+	select {
+	case <-clc.kickSig:
+		return
+	default:
+	}
+	select {
+	case clc.dataCh <- data:
+	default:
+		clc.kickOnce.Do(func() {
+			log.Println("客户端太慢，断开连接:", clc.ClientId)
+			clc.Drop()
+			clc.kicked.Store(true)
+			close(clc.kickSig)
+		})
+	}
+}
+
+// Kicked 被服务端断开时返回的通道被关闭
+func (clc *CameraLiveClient) Kicked() <-chan struct{} {
+	return clc.kickSig
+}
+
+// KickReason 实现 client.Kickable，通道塞满被断开时返回原因
+func (clc *CameraLiveClient) KickReason() string {
+	if clc.kicked.Load() {
+		return "send queue full"
+	}
+	return ""
+}
 
+// Stats 实现 client.StatsProvider
+func (clc *CameraLiveClient) Stats() client.Stats {
+	return clc.Snapshot(len(clc.dataCh))
 }
@@ -0,0 +1,118 @@
+package dash
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"pull2push/core/broadcast"
+	dashBroadcast "pull2push/core/broadcast/dash"
+	"pull2push/core/client"
+	"pull2push/core/egress"
+	"strings"
+)
+
+// ====================== DASHLiveClient ======================
+
+// DASHLiveClient 每一个 DASH 播放会话持有一个客户端对象，和 HLS 一样通过轮询 MPD 拉取分片，不走数据通道
+type DASHLiveClient struct {
+	BroadcasterKey string      // 这个客户端的直播房间的唯一编号
+	ClientId       string      // 这个客户端的id
+	DataCh         chan []byte // 这个客户端的一个只写通道
+	*client.Meter              // 发送统计
+
+	// http连接相关
+	httpCloseSig        <-chan struct{} // 当这个请求被客户端主动被关闭时触发
+	httpRequestCloseSig <-chan struct{} // 当这个请求被客户端主动被关闭时触发
+
+	// 父级 broadcaster 相关的内容
+	clientCloseSig      chan<- string                         // broker通过该信道监听客户端离线 【仅发送】
+	broadcasterCloseSig <-chan broadcast.BROADCAST_CLOSE_TYPE // broker被关闭时，同时通知客户端关闭 【仅接收】
+}
+
+func NewDASHLiveClient(c *gin.Context, broadcasterKey, clientId string, clientCloseSig chan<- string, broadcasterCloseSig <-chan broadcast.BROADCAST_CLOSE_TYPE) (*DASHLiveClient, error) {
+
+	dlc := DASHLiveClient{
+		BroadcasterKey:      broadcasterKey,
+		ClientId:            clientId,
+		httpCloseSig:        c.Done(),
+		httpRequestCloseSig: c.Request.Context().Done(),
+		Meter:               client.NewSessionMeter(clientId, c.ClientIP(), c.Request.UserAgent()),
+		clientCloseSig:      clientCloseSig,
+		broadcasterCloseSig: broadcasterCloseSig,
+	}
+
+	fmt.Println("DASH 客户端连接成功 ClientId = ", clientId)
+
+	// 开启状态监听
+	go dlc.Listen()
+
+	return &dlc, nil
+}
+
+func (dlc *DASHLiveClient) Listen() {
+	select {
+	case <-dlc.httpCloseSig:
+	case <-dlc.httpRequestCloseSig:
+	case <-dlc.broadcasterCloseSig:
+	}
+}
+
+func (dlc *DASHLiveClient) Broadcast(data []byte) {
+
+}
+
+// Stats 实现 client.StatsProvider，DASH 没有发送队列
+func (dlc *DASHLiveClient) Stats() client.Stats {
+	return dlc.Snapshot(0)
+}
+
+// GetDataChan 获取当前客户端的写通道
+func (dlc *DASHLiveClient) GetDataChan() chan []byte {
+	return dlc.DataCh
+}
+
+// HandleManifest 返回 MPD，BaseURL 指向带会话编号的分片目录，MPD 请求的 query（如观看鉴权的 token）带到分片地址上，
+// Location 为带 clientId 的 MPD 地址，播放器刷新 MPD 时沿用这个会话
+func (dlc *DASHLiveClient) HandleManifest(w http.ResponseWriter, r *http.Request, packager *dashBroadcast.DASHPackager) {
+	q := r.URL.Query()
+	q.Set("clientId", dlc.ClientId)
+	query := "?" + q.Encode()
+	mpd, err := packager.Manifest(dlc.ClientId+"/", query, "manifest.mpd"+query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dash+xml")
+	w.Header().Set("Cache-Control", "no-store")
+	n, _ := w.Write(mpd)
+	dlc.Add(n)
+}
+
+// HandleSegment 返回初始化分片或媒体分片
+func (dlc *DASHLiveClient) HandleSegment(w http.ResponseWriter, r *http.Request, packager *dashBroadcast.DASHPackager, filename string) {
+	var data []byte
+	switch {
+	case strings.HasSuffix(filename, "-init.mp4"):
+		data = packager.InitSegment(filename)
+	case strings.HasSuffix(filename, ".m4s"):
+		if seg := packager.MediaSegment(filename); seg != nil {
+			data = seg.Data
+		}
+	}
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if strings.HasPrefix(filename, "a-") {
+		w.Header().Set("Content-Type", "audio/mp4")
+	} else {
+		w.Header().Set("Content-Type", "video/mp4")
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	if !egress.FromContext(r.Context()).Wait(len(data), r.Context().Done()) {
+		return
+	}
+	n, _ := w.Write(data)
+	dlc.Add(n)
+}
@@ -7,7 +7,10 @@ import (
 	"log"
 	"net/http"
 	"pull2push/core/broadcast"
-	"runtime/debug"
+	"pull2push/core/client"
+	"pull2push/core/egress"
+	"sync"
+	"sync/atomic"
 )
 
 // ====================== FLVLiveClient ======================
@@ -17,7 +20,11 @@ type FLVLiveClient struct {
 	BrokerKey string        // 这个客户端的直播房间的唯一编号
 	ClientId  string        // 这个客户端的id
 	DataCh    chan []byte   // 这个客户端的一个只写通道
-	CloseSig  chan struct{} // broker被关闭时，同时通知客户端关闭
+	CloseSig  chan struct{} // 客户端断开或者broker被关闭时被关闭
+	closeOnce sync.Once
+	kickSig   chan struct{} // 发送队列塞满时被关闭，Listen 收到后结束
+	kickOnce  sync.Once
+	kicked    atomic.Bool
 
 	// http连接相关
 	httpRequest         *http.Request
@@ -25,6 +32,8 @@ type FLVLiveClient struct {
 	flusher             http.Flusher
 	httpCloseSig        <-chan struct{} // 当这个请求被客户端主动被关闭时触发
 	httpRequestCloseSig <-chan struct{} // 当这个请求被客户端主动被关闭时触发
+	egress              *egress.Viewer  // 出口带宽限制，为 nil 时不限制
+	*client.Meter                       // 发送统计
 
 	// 父级 broadcaster 相关的内容
 	clientCloseSig      chan<- string                         // broker通过该信道监听客户端离线 【仅发送】
@@ -50,11 +59,14 @@ func NewFLVLiveClient(c *gin.Context, brokerKey, clientId string, clientCloseSig
 		ClientId:            clientId,
 		DataCh:              dataCh,
 		CloseSig:            make(chan struct{}),
+		kickSig:             make(chan struct{}),
 		httpRequest:         c.Request,
 		responseWriter:      writer,
 		flusher:             flusher,
 		httpCloseSig:        c.Done(),
 		httpRequestCloseSig: c.Request.Context().Done(),
+		egress:              egress.FromContext(c.Request.Context()),
+		Meter:               client.NewMeter(clientId, c.ClientIP(), c.Request.UserAgent()),
 		clientCloseSig:      clientCloseSig,
 		broadcasterCloseSig: broadcasterCloseSig,
 	}
@@ -67,8 +79,9 @@ func NewFLVLiveClient(c *gin.Context, brokerKey, clientId string, clientCloseSig
 	return &hc, nil
 }
 
-// Listen 客户端监听器
+// Listen 客户端监听器，HTTP 响应只在这个协程里写
 func (flc *FLVLiveClient) Listen() {
+	defer flc.close()
 
 	for {
 		select {
@@ -81,7 +94,12 @@ func (flc *FLVLiveClient) Listen() {
 					return
 				}
 
-				_, err := flc.responseWriter.Write(data)
+				// 超出带宽时在这里等待，请求断开后由下一轮 select 处理
+				if !flc.egress.Wait(len(data), flc.httpRequestCloseSig) {
+					continue
+				}
+				n, err := flc.responseWriter.Write(data)
+				flc.Add(n)
 				if err != nil {
 					// 写出错，关闭连接
 					return
@@ -89,54 +107,84 @@ func (flc *FLVLiveClient) Listen() {
 				flc.flusher.Flush()
 			}
 		case <-flc.httpCloseSig:
-			flc.CloseSig <- struct{}{}
 			// 收到关闭信号，退出循环
 			fmt.Println("flc.httpCloseSig 收到客户端关闭信号，退出循环 ", flc.ClientId)
 
 			// when client closes, remove it
-			flc.clientCloseSig <- flc.ClientId
-
-			close(flc.CloseSig)
-
+			flc.notifyClosed()
 			return
 		case <-flc.httpRequestCloseSig:
-			flc.CloseSig <- struct{}{}
 			// 收到关闭信号，退出循环
 			fmt.Println("<-flc.httpRequestCloseSig 收到客户端关闭信号，退出循环 ", flc.ClientId)
 
 			// when client closes, remove it
-			flc.clientCloseSig <- flc.ClientId
-
-			close(flc.CloseSig)
-
+			flc.notifyClosed()
+			return
+		case <-flc.kickSig:
+			return
+		case <-flc.broadcasterCloseSig:
+			// 直播间被关闭，结束 HTTP 响应
+			fmt.Println("<-flc.broadcasterCloseSig 直播间已关闭，退出循环 ", flc.ClientId)
 			return
 		}
 	}
 }
 
+// notifyClosed 通知 broadcaster 移除当前客户端，broadcaster 已经关闭时没有协程在接收，不能阻塞
+func (flc *FLVLiveClient) notifyClosed() {
+	select {
+	case flc.clientCloseSig <- flc.ClientId:
+	case <-flc.broadcasterCloseSig:
+	}
+}
+
+func (flc *FLVLiveClient) close() {
+	flc.closeOnce.Do(func() {
+		close(flc.CloseSig)
+	})
+}
+
+// Done 客户端断开或者直播间关闭时返回的通道被关闭
+func (flc *FLVLiveClient) Done() <-chan struct{} {
+	return flc.CloseSig
+}
+
 // GetDataChan 获取当前客户端的写通道
 func (flc *FLVLiveClient) GetDataChan() chan []byte {
 	return flc.DataCh
 }
-func (flc *FLVLiveClient) Broadcast(data []byte) {
 
-	defer func() {
-		if err := recover(); err != nil {
-			// 这里写自定义日志处理  打印堆栈
-			log.Printf("panic recovered: %v: %v\n stack trace:\n%s", err, debug.Stack())
-		}
-	}()
-
-	if flc.responseWriter == nil || flc.flusher == nil {
-		log.Println("responseWriter 或 flusher 已经无效，退出")
+// Broadcast 放入发送队列，由 Listen 协程等待出口带宽后写出，不会阻塞广播器的分发；
+// 队列塞满说明客户端跟不上，直接断开，避免给播放器发送残缺的 FLV 流
+func (flc *FLVLiveClient) Broadcast(data []byte) {
+	select {
+	case <-flc.CloseSig:
 		return
+	case <-flc.kickSig:
+		return
+	default:
+	}
+	select {
+	case flc.DataCh <- data:
+	default:
+		flc.kickOnce.Do(func() {
+			log.Println("FLV 客户端太慢，断开连接:", flc.ClientId)
+			flc.Drop()
+			flc.kicked.Store(true)
+			close(flc.kickSig)
+		})
 	}
+}
 
-	_, err := flc.responseWriter.Write(data)
-	if err != nil {
-		// 写出错，关闭连接
-		return
+// KickReason 实现 client.Kickable，发送队列塞满被断开时返回原因
+func (flc *FLVLiveClient) KickReason() string {
+	if flc.kicked.Load() {
+		return "send queue full"
 	}
-	flc.flusher.Flush()
+	return ""
+}
 
+// Stats 实现 client.StatsProvider
+func (flc *FLVLiveClient) Stats() client.Stats {
+	return flc.Snapshot(len(flc.DataCh))
 }
@@ -0,0 +1,161 @@
+package flv
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"pull2push/core/broadcast"
+	"pull2push/core/client"
+	"pull2push/core/egress"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	wsWriteWait    = 10 * time.Second // 单次写超时
+	wsPongWait     = 45 * time.Second // 超过该时间没收到 pong 视为断线
+	wsPingInterval = 15 * time.Second // ping 间隔，必须小于 wsPongWait
+	wsSendQueue    = 1024             // 发送队列长度（按 tag 计），塞满说明客户端太慢
+)
+
+// ====================== WSFLVLiveClient ======================
+
+// WSFLVLiveClient 通过 WebSocket 观看 FLV 直播的客户端，每个 FLV tag 作为一个二进制帧发送
+//
+//	有些代理会缓冲长时间的 chunked 响应，HTTP-FLV 无法使用，flv.js / mpegts.js 都支持 ws:// 方式播放。
+//	Broadcast 只把数据放入发送队列，由 Listen 协程写出，慢客户端不会拖住广播器；队列塞满时直接断开。
+type WSFLVLiveClient struct {
+	BroadcasterKey string        // 这个客户端的直播房间的唯一编号
+	ClientId       string        // 这个客户端的id
+	DataCh         chan []byte   // 发送队列
+	CloseSig       chan struct{} // 连接关闭时被关闭
+
+	conn          *websocket.Conn
+	closeOnce     sync.Once
+	kicked        atomic.Bool    // 发送队列塞满被服务端断开
+	egress        *egress.Viewer // 出口带宽限制，为 nil 时不限制
+	*client.Meter                // 发送统计
+
+	// 父级 broadcaster 相关的内容
+	broadcasterCloseSig <-chan broadcast.BROADCAST_CLOSE_TYPE // broker被关闭时，同时通知客户端关闭 【仅接收】
+}
+
+func NewWSFLVLiveClient(conn *websocket.Conn, broadcasterKey, clientId string, meter *client.Meter, egressViewer *egress.Viewer, broadcasterCloseSig <-chan broadcast.BROADCAST_CLOSE_TYPE) *WSFLVLiveClient {
+	wc := WSFLVLiveClient{
+		BroadcasterKey:      broadcasterKey,
+		ClientId:            clientId,
+		DataCh:              make(chan []byte, wsSendQueue),
+		CloseSig:            make(chan struct{}),
+		conn:                conn,
+		Meter:               meter,
+		egress:              egressViewer,
+		broadcasterCloseSig: broadcasterCloseSig,
+	}
+
+	fmt.Println("WebSocket-FLV 客户端连接成功 ClientId = ", clientId)
+
+	go wc.readLoop()
+	go wc.Listen()
+
+	return &wc
+}
+
+// Listen 发送协程：写出队列中的数据并定时发送 ping
+func (wc *WSFLVLiveClient) Listen() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer func() {
+		ticker.Stop()
+		wc.close()
+	}()
+
+	for {
+		select {
+		case data := <-wc.DataCh:
+			// 超出带宽时在这里等待，数据在发送队列中积压
+			if !wc.egress.Wait(len(data), wc.CloseSig) {
+				return
+			}
+			_ = wc.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := wc.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				log.Println("WebSocket-FLV 写数据失败:", wc.ClientId, err)
+				return
+			}
+			wc.Add(len(data))
+		case <-ticker.C:
+			if err := wc.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case <-wc.broadcasterCloseSig:
+			_ = wc.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "live ended"), time.Now().Add(wsWriteWait))
+			return
+		case <-wc.CloseSig:
+			return
+		}
+	}
+}
+
+// readLoop 读协程：播放端不会发数据，这里只负责处理 pong 和关闭帧
+func (wc *WSFLVLiveClient) readLoop() {
+	defer wc.close()
+
+	wc.conn.SetReadLimit(4096)
+	_ = wc.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	wc.conn.SetPongHandler(func(string) error {
+		return wc.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		if _, _, err := wc.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (wc *WSFLVLiveClient) close() {
+	wc.closeOnce.Do(func() {
+		close(wc.CloseSig)
+		_ = wc.conn.Close()
+		fmt.Println("WebSocket-FLV 客户端断开 ClientId = ", wc.ClientId)
+	})
+}
+
+// Done 连接关闭时返回的通道被关闭
+func (wc *WSFLVLiveClient) Done() <-chan struct{} {
+	return wc.CloseSig
+}
+
+// GetDataChan 获取当前客户端的写通道
+func (wc *WSFLVLiveClient) GetDataChan() chan []byte {
+	return wc.DataCh
+}
+
+// Broadcast 放入发送队列，队列塞满说明客户端跟不上，直接断开，避免给播放器发送残缺的 FLV 流
+func (wc *WSFLVLiveClient) Broadcast(data []byte) {
+	select {
+	case <-wc.CloseSig:
+		return
+	default:
+	}
+	select {
+	case wc.DataCh <- data:
+	default:
+		log.Println("WebSocket-FLV 客户端太慢，断开连接:", wc.ClientId)
+		wc.Drop()
+		wc.kicked.Store(true)
+		wc.close()
+	}
+}
+
+// KickReason 实现 client.Kickable，发送队列塞满被断开时返回原因
+func (wc *WSFLVLiveClient) KickReason() string {
+	if wc.kicked.Load() {
+		return "send queue full"
+	}
+	return ""
+}
+
+// Stats 实现 client.StatsProvider
+func (wc *WSFLVLiveClient) Stats() client.Stats {
+	return wc.Snapshot(len(wc.DataCh))
+}
@@ -3,9 +3,13 @@ package flv
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"math"
 	"net/http"
+	"path"
 	"pull2push/core/broadcast"
 	hlsBroadcast "pull2push/core/broadcast/hls"
+	"pull2push/core/client"
+	"pull2push/core/egress"
 	"strings"
 )
 
@@ -16,6 +20,7 @@ type HLSLiveClient struct {
 	BroadcasterKey string      // 这个客户端的直播房间的唯一编号
 	ClientId       string      // 这个客户端的id
 	DataCh         chan []byte // 这个客户端的一个只写通道
+	*client.Meter              // 发送统计，为 nil 时不统计（如摄像头 HLS 的临时客户端）
 
 	// http连接相关
 	httpCloseSig        <-chan struct{} // 当这个请求被客户端主动被关闭时触发
@@ -33,6 +38,7 @@ func NewHLSLiveClient(c *gin.Context, broadcasterKey, clientId string, clientClo
 		ClientId:            clientId,
 		httpCloseSig:        c.Done(),
 		httpRequestCloseSig: c.Request.Context().Done(),
+		Meter:               client.NewSessionMeter(clientId, c.ClientIP(), c.Request.UserAgent()),
 		clientCloseSig:      clientCloseSig,
 		broadcasterCloseSig: broadcasterCloseSig,
 	}
@@ -76,35 +82,49 @@ func (hlc *HLSLiveClient) Broadcast(data []byte) {
 
 }
 
+// Stats 实现 client.StatsProvider，HLS 没有发送队列
+func (hlc *HLSLiveClient) Stats() client.Stats {
+	return hlc.Snapshot(0)
+}
+
 // GetDataChan 获取当前客户端的写通道
 func (hlc *HLSLiveClient) GetDataChan() chan []byte {
 	return hlc.DataCh
 }
 
-func (hlc *HLSLiveClient) HandleIndex(w http.ResponseWriter, r *http.Request, findBroadcasterTemp *hlsBroadcast.HLSBroadcaster) {
+// HandleIndex 返回当前分片缓存对应的播放列表；直播结束后返回完整的 VOD 播放列表
+func (hlc *HLSLiveClient) HandleIndex(w http.ResponseWriter, r *http.Request, stream *hlsBroadcast.StreamState) {
 	// /live/hls/{broadcasterKey}/{clientID}/index.m3u8
 	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
 	if parts[1] != "live" || parts[len(parts)-1] != "index.m3u8" {
 		http.NotFound(w, r)
 		return
 	}
-	if findBroadcasterTemp.StreamState0 == nil {
+	if stream == nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	segs, seqStart, targetDur, discont := findBroadcasterTemp.StreamState0.Snapshot()
-	pl, err := hlc.buildMediaPlaylist(segs, seqStart, targetDur, discont, r)
+	segs, _, targetDur, discontSeq := stream.Snapshot()
+	_, ended := stream.EndedInfo()
+	pl, err := hlc.buildMediaPlaylist(segs, targetDur, discontSeq, ended, r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-	w.Header().Set("Cache-Control", "no-store")
-	_, _ = w.Write([]byte(pl))
+	if ended {
+		// VOD 播放列表不会再变化，允许短时间缓存
+		w.Header().Set("Cache-Control", "public, max-age=60")
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	n, _ := w.Write([]byte(pl))
+	hlc.Add(n)
 }
 
-func (hlc *HLSLiveClient) HandleSegment(w http.ResponseWriter, r *http.Request, findBroadcasterTemp *hlsBroadcast.HLSBroadcaster) {
+// HandleSegment 返回本地缓存的分片
+func (hlc *HLSLiveClient) HandleSegment(w http.ResponseWriter, r *http.Request, stream *hlsBroadcast.StreamState) {
 	// /api/live/hls/{broadcasterKey}/{clientID}/{seg.ts|m4s}
 
 	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
@@ -114,16 +134,14 @@ func (hlc *HLSLiveClient) HandleSegment(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	if findBroadcasterTemp.StreamState0 == nil {
+	if stream == nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	findBroadcasterTemp.StreamState0.Mu.RLock()
-	defer findBroadcasterTemp.StreamState0.Mu.RUnlock()
-
+	stream.Mu.RLock()
 	var seg *hlsBroadcast.Segment
-	findBroadcasterTemp.StreamState0.Segments.Do(func(v any) {
+	stream.Segments.Do(func(v any) {
 		if v == nil {
 			return
 		}
@@ -132,6 +150,8 @@ func (hlc *HLSLiveClient) HandleSegment(w http.ResponseWriter, r *http.Request,
 			seg = ss
 		}
 	})
+	// 分片写入后不再修改，限速等待时不能占着读锁，否则会阻塞新分片的写入
+	stream.Mu.RUnlock()
 	if seg == nil {
 		http.NotFound(w, r)
 		return
@@ -146,13 +166,17 @@ func (hlc *HLSLiveClient) HandleSegment(w http.ResponseWriter, r *http.Request,
 		w.Header().Set("Content-Type", "application/octet-stream")
 	}
 	w.Header().Set("Cache-Control", "public, max-age=60")
-	_, _ = w.Write(seg.Data)
+	if !egress.FromContext(r.Context()).Wait(len(seg.Data), r.Context().Done()) {
+		return
+	}
+	n, _ := w.Write(seg.Data)
+	hlc.Add(n)
 }
 
 // buildMediaPlaylist HTTP 播放列表生成与分片访问
 // 生成 HLS 标准的分片信息，顺序排列，标明时长和断点。
-// 返回给播放器标准 HLS 播放列表。
-func (hlc *HLSLiveClient) buildMediaPlaylist(segs []*hlsBroadcast.Segment, seqStart uint64, targetDur float64, discont bool, r *http.Request) (string, error) {
+// 返回给播放器标准 HLS 播放列表；直播结束后返回带 EXT-X-ENDLIST 的 VOD 播放列表。
+func (hlc *HLSLiveClient) buildMediaPlaylist(segs []*hlsBroadcast.Segment, targetDur float64, discontSeq uint64, ended bool, r *http.Request) (string, error) {
 
 	// handleIndex 负责根据当前 StreamState 缓存的分片快照，生成标准 HLS 播放列表文本。
 	// 播放列表里指向本地缓存的分片文件名（seq.ts 或 seq.m4s）。
@@ -160,19 +184,42 @@ func (hlc *HLSLiveClient) buildMediaPlaylist(segs []*hlsBroadcast.Segment, seqSt
 
 	if len(segs) == 0 {
 		// 空列表也要有基本头信息，避免播放器报错
+		if ended {
+			return "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-ENDLIST\n", nil
+		}
 		return "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:0\n", nil
 	}
+
+	// EXT-X-TARGETDURATION 不能小于任何一个分片四舍五入后的时长
+	target := int(targetDur + 0.5)
+	for _, s := range segs {
+		if d := int(math.Ceil(s.Dur)); d > target {
+			target = d
+		}
+	}
+	// 直播和 VOD 都以第一个分片的序列号作为 EXT-X-MEDIA-SEQUENCE，重新开播后也能对上
+	seqStart := segs[0].Seq
+
 	var b strings.Builder
 	b.WriteString("#EXTM3U\n")
 	b.WriteString("#EXT-X-VERSION:3\n")
-	b.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(targetDur+0.5)))
+	if ended {
+		b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	}
+	b.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", target))
 	b.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", seqStart))
 	// 可选：I-Frame only、MAP 等根据上游情况补充
-	if discont {
-		b.WriteString("#EXT-X-DISCONTINUITY-SEQUENCE:1\n")
+	if discontSeq > 0 {
+		b.WriteString(fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discontSeq))
 	}
 
-	base := fmt.Sprintf("/api/live/hls/" + hlc.BroadcasterKey + "/" + hlc.ClientId + "/")
+	// 分片地址与播放列表同目录，兼容 /api/live/hls 和 /api/live/camera/hls 等不同路由
+	base := path.Dir(r.URL.Path) + "/"
+	// 播放列表请求的 query（如观看鉴权的 token）原样带到分片地址上
+	query := ""
+	if r.URL.RawQuery != "" {
+		query = "?" + r.URL.RawQuery
+	}
 	for _, s := range segs {
 		if s == nil {
 			continue
@@ -181,7 +228,10 @@ func (hlc *HLSLiveClient) buildMediaPlaylist(segs []*hlsBroadcast.Segment, seqSt
 			b.WriteString("#EXT-X-DISCONTINUITY\n")
 		}
 		b.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n", s.Dur))
-		b.WriteString(base + s.LocalName + "\n")
+		b.WriteString(base + s.LocalName + query + "\n")
+	}
+	if ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
 	}
 	return b.String(), nil
 }
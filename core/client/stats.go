@@ -0,0 +1,97 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// sessionIdleTimeout HLS、DASH 会话多久没有请求后视为已经离开
+const sessionIdleTimeout = 30 * time.Second
+
+// Stats 一个客户端的实时状态
+type Stats struct {
+	ClientId     string    `json:"clientId"`
+	RemoteIP     string    `json:"remoteIP"`
+	UserAgent    string    `json:"userAgent"`
+	ConnectedAt  time.Time `json:"connectedAt"`
+	BytesSent    uint64    `json:"bytesSent"`
+	LastActiveAt time.Time `json:"lastActiveAt"` // 最后一次发送数据的时间
+	Dropped      uint64    `json:"dropped"`      // 发送队列塞满丢弃的数据包数
+	Lag          int       `json:"lag"`          // 发送队列中还没写出的数据包数，HLS、DASH 等没有发送队列的客户端为 0
+}
+
+// StatsProvider 能统计发送情况的客户端，统计接口通过它列出直播间的客户端
+type StatsProvider interface {
+	Stats() Stats
+
+	// Expired HLS、DASH 会话很久没有请求，统计时不再算作当前观众
+	Expired() bool
+}
+
+// Meter 客户端的连接信息和已发送字节数，嵌入到各个客户端中，在写路径上调用 Add 计数；并发安全，为 nil 时不统计
+type Meter struct {
+	clientId    string
+	remoteIP    string
+	userAgent   string
+	connectedAt time.Time
+	idleTimeout time.Duration // 为 0 表示长连接，连接断开时由广播器移除
+	bytesSent   atomic.Uint64
+	lastActive  atomic.Int64 // Unix 纳秒
+	dropped     atomic.Uint64
+}
+
+// NewMeter 长连接客户端连接时创建
+func NewMeter(clientId, remoteIP, userAgent string) *Meter {
+	m := &Meter{clientId: clientId, remoteIP: remoteIP, userAgent: userAgent, connectedAt: time.Now()}
+	m.lastActive.Store(m.connectedAt.UnixNano())
+	return m
+}
+
+// NewSessionMeter HLS、DASH 这类由短请求组成的会话创建，这类客户端没有断开事件，超过 sessionIdleTimeout 没有请求后 Expired 返回 true
+func NewSessionMeter(clientId, remoteIP, userAgent string) *Meter {
+	m := NewMeter(clientId, remoteIP, userAgent)
+	m.idleTimeout = sessionIdleTimeout
+	return m
+}
+
+// Add 写出 n 字节后调用
+func (m *Meter) Add(n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.bytesSent.Add(uint64(n))
+	m.lastActive.Store(time.Now().UnixNano())
+}
+
+// Drop 发送队列塞满丢弃一个数据包时调用
+func (m *Meter) Drop() {
+	if m == nil {
+		return
+	}
+	m.dropped.Add(1)
+}
+
+// Expired 会话是否已经很久没有请求，长连接始终返回 false
+func (m *Meter) Expired() bool {
+	if m == nil || m.idleTimeout <= 0 {
+		return false
+	}
+	return time.Since(time.Unix(0, m.lastActive.Load())) > m.idleTimeout
+}
+
+// Snapshot 返回当前状态，lag 为客户端发送队列中积压的数据包数
+func (m *Meter) Snapshot(lag int) Stats {
+	if m == nil {
+		return Stats{Lag: lag}
+	}
+	return Stats{
+		ClientId:     m.clientId,
+		RemoteIP:     m.remoteIP,
+		UserAgent:    m.userAgent,
+		ConnectedAt:  m.connectedAt,
+		BytesSent:    m.bytesSent.Load(),
+		LastActiveAt: time.Unix(0, m.lastActive.Load()),
+		Dropped:      m.dropped.Load(),
+		Lag:          lag,
+	}
+}
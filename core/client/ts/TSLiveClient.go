@@ -0,0 +1,171 @@
+package ts
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"pull2push/core/broadcast"
+	"pull2push/core/client"
+	"pull2push/core/egress"
+	mediaTS "pull2push/core/media/ts"
+	"sync"
+	"sync/atomic"
+)
+
+// tsSendQueue 发送队列长度（按 tag 计），塞满说明客户端太慢
+const tsSendQueue = 4096
+
+// ====================== TSLiveClient ======================
+
+// TSLiveClient 以连续 MPEG-TS 观看直播的客户端，给 IPTV 机顶盒等只支持 TS 的播放端使用
+//
+//	和 FLV 客户端一样加入广播器收 FLV 数据，由 Listen 协程重新封装成 TS 写回 HTTP 响应。
+//	Broadcast 只把数据放入队列，慢客户端不会拖住广播器；队列塞满时直接断开。
+type TSLiveClient struct {
+	BroadcasterKey string        // 这个客户端的直播房间的唯一编号
+	ClientId       string        // 这个客户端的id
+	DataCh         chan []byte   // 发送队列，内容为 FLV 数据
+	CloseSig       chan struct{} // 连接关闭时被关闭
+
+	closeOnce     sync.Once
+	kickSig       chan struct{} // 发送队列塞满时被关闭，Read 收到后返回 EOF
+	kickOnce      sync.Once
+	kicked        atomic.Bool    // 发送队列塞满被服务端断开
+	pending       []byte         // Read 还没读完的数据
+	egress        *egress.Viewer // 出口带宽限制，为 nil 时不限制
+	*client.Meter                // 发送统计
+
+	// http连接相关
+	responseWriter      gin.ResponseWriter
+	httpRequestCloseSig <-chan struct{} // 当这个请求被客户端主动被关闭时触发
+
+	// 父级 broadcaster 相关的内容
+	broadcasterCloseSig <-chan broadcast.BROADCAST_CLOSE_TYPE // broker被关闭时，同时通知客户端关闭 【仅接收】
+}
+
+func NewTSLiveClient(c *gin.Context, broadcasterKey, clientId string, broadcasterCloseSig <-chan broadcast.BROADCAST_CLOSE_TYPE) *TSLiveClient {
+	tc := TSLiveClient{
+		BroadcasterKey:      broadcasterKey,
+		ClientId:            clientId,
+		DataCh:              make(chan []byte, tsSendQueue),
+		CloseSig:            make(chan struct{}),
+		kickSig:             make(chan struct{}),
+		responseWriter:      c.Writer,
+		httpRequestCloseSig: c.Request.Context().Done(),
+		egress:              egress.FromContext(c.Request.Context()),
+		Meter:               client.NewMeter(clientId, c.ClientIP(), c.Request.UserAgent()),
+		broadcasterCloseSig: broadcasterCloseSig,
+	}
+
+	fmt.Println("TS 客户端连接成功 ClientId = ", clientId)
+
+	go tc.Listen()
+
+	return &tc
+}
+
+// Listen 转封装协程：从队列读出 FLV，封装成 TS 写给客户端，连接断开或直播结束时退出；HTTP 响应只在这个协程里写
+func (tc *TSLiveClient) Listen() {
+	defer tc.close()
+
+	err := mediaTS.CopyFLVAsTS(tsFlushWriter{tc.responseWriter, tc.Meter, tc.egress, tc.httpRequestCloseSig}, tc)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Println("TS 客户端输出失败:", tc.ClientId, err)
+	}
+}
+
+// Read 实现 io.Reader，按顺序读出广播器发来的 FLV 数据；连接关闭或直播结束时返回 EOF
+func (tc *TSLiveClient) Read(p []byte) (int, error) {
+	for len(tc.pending) == 0 {
+		select {
+		case data := <-tc.DataCh:
+			tc.pending = data
+		case <-tc.httpRequestCloseSig:
+			return 0, io.EOF
+		case <-tc.broadcasterCloseSig:
+			return 0, io.EOF
+		case <-tc.kickSig:
+			return 0, io.EOF
+		case <-tc.CloseSig:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, tc.pending)
+	tc.pending = tc.pending[n:]
+	return n, nil
+}
+
+func (tc *TSLiveClient) close() {
+	tc.closeOnce.Do(func() {
+		close(tc.CloseSig)
+		fmt.Println("TS 客户端断开 ClientId = ", tc.ClientId)
+	})
+}
+
+// Done 连接关闭时返回的通道被关闭
+func (tc *TSLiveClient) Done() <-chan struct{} {
+	return tc.CloseSig
+}
+
+// GetDataChan 获取当前客户端的写通道
+func (tc *TSLiveClient) GetDataChan() chan []byte {
+	return tc.DataCh
+}
+
+// Broadcast 放入发送队列，队列塞满说明客户端跟不上，直接断开，避免给机顶盒输出残缺的 TS
+func (tc *TSLiveClient) Broadcast(data []byte) {
+	select {
+	case <-tc.CloseSig:
+		return
+	case <-tc.kickSig:
+		return
+	default:
+	}
+	select {
+	case tc.DataCh <- data:
+	default:
+		tc.kickOnce.Do(func() {
+			log.Println("TS 客户端太慢，断开连接:", tc.ClientId)
+			tc.Drop()
+			tc.kicked.Store(true)
+			close(tc.kickSig)
+		})
+	}
+}
+
+// tsFlushWriter 每写一个帧刷新一次，机顶盒不用等缓冲区写满；超出出口带宽时先等待再写
+type tsFlushWriter struct {
+	w      gin.ResponseWriter
+	meter  *client.Meter
+	egress *egress.Viewer
+	done   <-chan struct{}
+}
+
+func (fw tsFlushWriter) Write(p []byte) (int, error) {
+	if !fw.egress.Wait(len(p), fw.done) {
+		// 连接已经断开
+		return 0, io.EOF
+	}
+	n, err := fw.w.Write(p)
+	fw.meter.Add(n)
+	if err != nil {
+		return n, err
+	}
+	fw.w.Flush()
+	return n, nil
+}
+
+// KickReason 实现 client.Kickable，发送队列塞满被断开时返回原因
+func (tc *TSLiveClient) KickReason() string {
+	if tc.kicked.Load() {
+		return "send queue full"
+	}
+	return ""
+}
+
+// Stats 实现 client.StatsProvider，积压为还没转封装的 FLV 数据块数
+func (tc *TSLiveClient) Stats() client.Stats {
+	return tc.Snapshot(len(tc.DataCh))
+}
@@ -0,0 +1,182 @@
+package webrtc
+
+import (
+	"fmt"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+	"pull2push/core/broadcast"
+	webrtcBroadcast "pull2push/core/broadcast/webrtc"
+	"pull2push/core/client"
+	"pull2push/core/egress"
+	"sync"
+	"time"
+)
+
+// ====================== WHEPLiveClient ======================
+
+// WHEPLiveClient 通过 WHEP 观看直播的客户端，每个观众一个 PeerConnection
+//
+//	媒体数据由广播器共用的本地轨道直接发送，不走数据通道；观众发来的 PLI/FIR 转给推流端请求关键帧。
+//	共用轨道无法逐个观众限速，出口带宽只按 ICE 连接实际发送的字节数计入，新观众按实际速率判断能否加入。
+type WHEPLiveClient struct {
+	BroadcasterKey string        // 这个客户端的直播房间的唯一编号
+	ClientId       string        // 这个客户端的id，也是 WHEP 会话编号
+	DataCh         chan []byte   // 这个客户端的一个只写通道
+	CloseSig       chan struct{} // 连接关闭时被关闭
+
+	pc            *webrtc.PeerConnection
+	closeOnce     sync.Once
+	egress        *egress.Viewer // 出口带宽，为 nil 时不计入
+	*client.Meter                // 连接信息；发送字节数取自 ICE 连接的计数，断开时记入
+
+	// 父级 broadcaster 相关的内容
+	clientCloseSig      chan<- string                         // broker通过该信道监听客户端离线 【仅发送】
+	broadcasterCloseSig <-chan broadcast.BROADCAST_CLOSE_TYPE // broker被关闭时，同时通知客户端关闭 【仅接收】
+}
+
+// NewWHEPLiveClient 根据观众的 offer 创建客户端，返回 answer
+func NewWHEPLiveClient(broadcasterKey, clientId, offer string, tracks []webrtc.TrackLocal, requestKeyFrame func(), meter *client.Meter, egressViewer *egress.Viewer, clientCloseSig chan<- string, broadcasterCloseSig <-chan broadcast.BROADCAST_CLOSE_TYPE) (*WHEPLiveClient, string, error) {
+	pc, err := webrtcBroadcast.NewPeerConnection()
+	if err != nil {
+		return nil, "", err
+	}
+
+	wc := WHEPLiveClient{
+		BroadcasterKey:      broadcasterKey,
+		ClientId:            clientId,
+		CloseSig:            make(chan struct{}),
+		pc:                  pc,
+		Meter:               meter,
+		egress:              egressViewer,
+		clientCloseSig:      clientCloseSig,
+		broadcasterCloseSig: broadcasterCloseSig,
+	}
+
+	for _, track := range tracks {
+		sender, err := pc.AddTrack(track)
+		if err != nil {
+			_ = pc.Close()
+			return nil, "", err
+		}
+		go wc.readRTCP(sender, requestKeyFrame)
+	}
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			go wc.Close()
+		}
+	})
+
+	answer, err := webrtcBroadcast.Negotiate(pc, offer)
+	if err != nil {
+		_ = pc.Close()
+		return nil, "", err
+	}
+
+	fmt.Println("WHEP 客户端连接成功 ClientId = ", clientId)
+
+	// 开启状态监听
+	go wc.Listen()
+	go wc.countEgress()
+
+	return &wc, answer, nil
+}
+
+// readRTCP 读取观众的 RTCP（NACK 由拦截器处理），收到 PLI/FIR 时向推流端请求关键帧
+func (wc *WHEPLiveClient) readRTCP(sender *webrtc.RTPSender, requestKeyFrame func()) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, p := range packets {
+			switch p.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				if requestKeyFrame != nil {
+					requestKeyFrame()
+				}
+			}
+		}
+	}
+}
+
+// countEgress 每秒把 ICE 连接新发送的字节数计入出口带宽
+func (wc *WHEPLiveClient) countEgress() {
+	if wc.egress == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var counted uint64
+	for {
+		select {
+		case <-ticker.C:
+			if sent := wc.transportBytesSent(); sent > counted {
+				wc.egress.Count(int(sent - counted))
+				counted = sent
+			}
+		case <-wc.CloseSig:
+			return
+		}
+	}
+}
+
+// Listen 等待连接关闭或直播结束，然后通知广播器移除自己
+func (wc *WHEPLiveClient) Listen() {
+	select {
+	case <-wc.CloseSig:
+	case <-wc.broadcasterCloseSig:
+		wc.Close()
+		return
+	}
+
+	select {
+	case wc.clientCloseSig <- wc.ClientId:
+	case <-wc.broadcasterCloseSig:
+	}
+}
+
+// Close 关闭连接，WHEP 的 DELETE 请求也走这里
+func (wc *WHEPLiveClient) Close() {
+	wc.closeOnce.Do(func() {
+		close(wc.CloseSig)
+		wc.Add(int(wc.transportBytesSent()))
+		_ = wc.pc.Close()
+		fmt.Println("WHEP 客户端断开 ClientId = ", wc.ClientId)
+	})
+}
+
+// Done 连接关闭时返回的通道被关闭
+func (wc *WHEPLiveClient) Done() <-chan struct{} {
+	return wc.CloseSig
+}
+
+func (wc *WHEPLiveClient) Broadcast(data []byte) {
+
+}
+
+// GetDataChan 获取当前客户端的写通道
+func (wc *WHEPLiveClient) GetDataChan() chan []byte {
+	return wc.DataCh
+}
+
+// Stats 实现 client.StatsProvider，媒体由共用的本地轨道发送，没有发送队列
+func (wc *WHEPLiveClient) Stats() client.Stats {
+	stats := wc.Snapshot(0)
+	select {
+	case <-wc.CloseSig:
+		// 断开时已经记入 Meter
+	default:
+		stats.BytesSent += wc.transportBytesSent()
+	}
+	return stats
+}
+
+// transportBytesSent ICE 连接累计发送的字节数，包括 RTP、RTCP 和 DTLS
+func (wc *WHEPLiveClient) transportBytesSent() uint64 {
+	if stats, ok := wc.pc.GetStats()["iceTransport"].(webrtc.TransportStats); ok {
+		return stats.BytesSent
+	}
+	return 0
+}
@@ -0,0 +1,230 @@
+package webrtc
+
+import (
+	"errors"
+	"io"
+	"pull2push/core/broadcast"
+	flvBroadcast "pull2push/core/broadcast/flv"
+	webrtcBroadcast "pull2push/core/broadcast/webrtc"
+	"pull2push/core/client"
+	"pull2push/core/media/av"
+	"pull2push/core/media/testsrc"
+	"pull2push/event"
+	"pull2push/event/payload"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+)
+
+// negotiate 创建 offer，等 ICE 候选收集完，交给 answerer 后设置 answer（WHIP/WHEP 不使用 trickle ICE）
+func negotiate(t *testing.T, pc *webrtc.PeerConnection, answerer func(offer string) (string, error)) {
+	t.Helper()
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gatherComplete
+
+	answer, err := answerer(pc.LocalDescription().SDP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// publishTestsrc 把 testsrc 的 H.264 按实时速度打成 RTP 写入推流端的轨道，直到 src 被关闭
+func publishTestsrc(t *testing.T, track *webrtc.TrackLocalStaticRTP, src io.Reader) {
+	packetizer := rtp.NewPacketizer(1200, 0, 0, &codecs.H264Payloader{}, rtp.NewRandomSequencer(), 90000)
+	parser := flvBroadcast.NewFLVParser(false)
+	if _, err := parser.ParseHeader(src); err != nil {
+		t.Error(err)
+		return
+	}
+	var spsPPS [][]byte
+	for {
+		tag, err := parser.ParseNextTag(src)
+		if err != nil {
+			return
+		}
+		pkt, ok := av.DemuxFLVTag(tag.TagType, tag.Timestamp, tag.RawData)
+		if !ok || pkt.Type != av.PacketVideo {
+			continue
+		}
+		if pkt.IsSequenceHeader {
+			cfg, err := av.ParseAVCConfig(pkt.Data)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			spsPPS = append(append(spsPPS, cfg.SPS...), cfg.PPS...)
+			continue
+		}
+		nalus, err := av.SplitAVCC(pkt.Data, 4)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if pkt.IsKeyFrame {
+			nalus = append(append([][]byte{}, spsPPS...), nalus...)
+		}
+		for _, p := range packetizer.Packetize(av.JoinAnnexB(nalus), 90000/25) {
+			if err := track.WriteRTP(p); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				t.Error(err)
+				return
+			}
+			// 按包间隔发送，测试流的关键帧有上百个包，一次性发出会超过本机 UDP 接收缓冲区
+			time.Sleep(200 * time.Microsecond)
+		}
+	}
+}
+
+// TestWHIPToWHEPLoopback 本机的推流端通过 WHIP 推 H.264，观众通过 WHEP 收到同一路 RTP，FLV 桥接输出关键帧
+func TestWHIPToWHEPLoopback(t *testing.T) {
+	bus := event.NewEventBus()
+	events := bus.SubscribeMultiple([]event.EventType{event.StreamPublished, event.StreamUnpublished})
+
+	// WHIP 推流端
+	publisher, err := webrtcBroadcast.NewPeerConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	videoTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publisher.AddTrack(videoTrack); err != nil {
+		t.Fatal(err)
+	}
+
+	var wb *webrtcBroadcast.WebRTCBroadcaster
+	negotiate(t, publisher, func(offer string) (string, error) {
+		b, answer, err := webrtcBroadcast.NewWebRTCBroadcaster("whip-room", "session-1", offer)
+		if err == nil {
+			b.SetEventBus(bus)
+		}
+		wb = b
+		return answer, err
+	})
+	defer wb.Close(broadcast.BrokerClosed)
+
+	src, err := testsrc.OpenFLV("testsrc://?audio=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	go publishTestsrc(t, videoTrack, src)
+
+	// FLV 桥接：读到视频序列头和关键帧
+	bridged := make(chan error, 1)
+	go func() {
+		flvReader := wb.FLVReader()
+		defer flvReader.Close()
+		parser := flvBroadcast.NewFLVParser(false)
+		if _, err := parser.ParseHeader(flvReader); err != nil {
+			bridged <- err
+			return
+		}
+		sawConfig := false
+		for {
+			tag, err := parser.ParseNextTag(flvReader)
+			if err != nil {
+				bridged <- err
+				return
+			}
+			pkt, ok := av.DemuxFLVTag(tag.TagType, tag.Timestamp, tag.RawData)
+			if !ok || pkt.Type != av.PacketVideo {
+				continue
+			}
+			if pkt.IsSequenceHeader {
+				sawConfig = true
+			} else if pkt.IsKeyFrame && sawConfig {
+				bridged <- nil
+				// 继续读，桥接不会因为没人读而卡住转发
+				_, _ = io.Copy(io.Discard, flvReader)
+				return
+			}
+		}
+	}()
+
+	// WHEP 观众
+	viewer, err := webrtcBroadcast.NewPeerConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer viewer.Close()
+	if _, err := viewer.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := viewer.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan struct{})
+	viewer.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if track.Kind() != webrtc.RTPCodecTypeVideo {
+			return
+		}
+		if _, _, err := track.ReadRTP(); err == nil {
+			close(received)
+		}
+	})
+
+	var whep *WHEPLiveClient
+	negotiate(t, viewer, func(offer string) (string, error) {
+		c, answer, err := NewWHEPLiveClient("whip-room", "viewer-1", offer, wb.Tracks(), wb.RequestKeyFrame, client.NewMeter("viewer-1", "127.0.0.1", "test"), nil, wb.ClientCloseSig, wb.BroadcasterCloseSig)
+		whep = c
+		return answer, err
+	})
+	wb.AddLiveClient("viewer-1", whep)
+
+	select {
+	case <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("WHEP viewer received no video RTP")
+	}
+	select {
+	case err := <-bridged:
+		if err != nil {
+			t.Fatal("FLV bridge:", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("FLV bridge produced no key frame")
+	}
+	if stats := wb.Stats(); stats.BytesIn == 0 {
+		t.Fatalf("stats = %+v, want BytesIn > 0", stats)
+	}
+
+	// 推流结束：观众被断开，发布和取消发布成对出现
+	wb.Close(broadcast.BrokerEnd)
+	select {
+	case <-whep.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("WHEP viewer not closed after publisher ended")
+	}
+
+	var got []event.EventType
+	timeout := time.After(5 * time.Second)
+	for len(got) < 2 {
+		select {
+		case e := <-events:
+			if p := e.Payload.(payload.StreamPayload); p.BroadcasterKey != "whip-room" || p.Protocol != payload.ProtocolWebRTC {
+				t.Fatalf("payload = %+v", p)
+			}
+			got = append(got, e.Type)
+		case <-timeout:
+			t.Fatalf("events = %v, want StreamPublished, StreamUnpublished", got)
+		}
+	}
+	if got[0] != event.StreamPublished || got[1] != event.StreamUnpublished {
+		t.Fatalf("events = %v, want StreamPublished, StreamUnpublished", got)
+	}
+}
@@ -0,0 +1,392 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sync"
+	"time"
+)
+
+/*
+出口带宽限制
+	令牌桶分三层：每个观众、每个直播间、本节点，观众写数据前从三层桶中同时预扣，等待其中最长的时间后再写，
+	等待发生在观众自己的发送协程里（广播器只把数据放入观众的发送队列），慢下来的观众在自己的队列中积压，
+	队列塞满时被断开，不会拖慢广播器的分发和推流/拉流。
+	WHEP 的媒体由共用的本地轨道发送，无法逐个观众等待，只按 ICE 连接实际发送的字节数计入（Viewer.Count）。
+	优先级：每个直播间属于一个优先级，优先级的 Threshold 表示它最多能用到本节点带宽的多少，
+	如 low = 0.7 时低优先级直播间合计不超过 70%，剩下的留给高优先级；
+	新观众加入时本节点的实际出口速率已经达到该优先级的 Threshold，或者直播间已用满自己的带宽，拒绝加入。
+*/
+
+// 优先级
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// 默认的优先级阈值
+var defaultThresholds = map[string]float64{
+	PriorityHigh:   1.0,
+	PriorityNormal: 0.9,
+	PriorityLow:    0.7,
+}
+
+var (
+	ErrNodeBudget   = errors.New("node egress bandwidth exhausted")
+	ErrStreamBudget = errors.New("stream egress bandwidth exhausted")
+)
+
+// Options 出口带宽配置，速率单位为 字节/秒，0 表示不限制
+type Options struct {
+	NodeRate    int64
+	ViewerRate  int64
+	StreamRate  int64              // 每个直播间的默认上限
+	StreamRates map[string]int64   // 直播间 → 上限，覆盖 StreamRate
+	Priorities  map[string]string  // 直播间（支持 path.Match 通配符）→ 优先级，匹配不到的为 normal
+	Thresholds  map[string]float64 // 优先级 → 阈值，覆盖默认值 high 1.0 / normal 0.9 / low 0.7
+	SessionTTL  time.Duration      // HLS 会话多久没有请求后不再算作已加入的观众，为 0 使用默认值 30s
+}
+
+// Stats 出口带宽的当前状态，速率单位为 字节/秒
+type Stats struct {
+	NodeRate  int64                  `json:"nodeRate"`  // 0 表示不限制
+	NodeUsage float64                `json:"nodeUsage"` // 最近一秒的实际出口速率
+	Streams   map[string]StreamStats `json:"streams"`
+	Refused   map[string]uint64      `json:"refused"` // 拒绝原因 → 累计次数
+}
+
+// StreamStats 一个直播间的出口带宽
+type StreamStats struct {
+	Priority string  `json:"priority"`
+	Rate     int64   `json:"rate"` // 0 表示不限制
+	Usage    float64 `json:"usage"`
+	Viewers  int     `json:"viewers"` // 正在写数据的观众，HLS 为进行中的请求
+}
+
+// Limiter 出口带宽限制，并发安全；为 nil 时不限制
+type Limiter struct {
+	opts Options
+
+	node      *bucket
+	nodeMeter meter
+	classes   map[string]*bucket // 阈值小于 1 的优先级共用的桶
+
+	mu       sync.Mutex
+	streams  map[string]*streamBudget
+	sessions map[string]time.Time // 直播间/客户端编号 → 最后一次请求时间
+	refused  map[string]uint64
+}
+
+type streamBudget struct {
+	priority string
+	rate     int64
+	bucket   *bucket
+	meter    meter
+	viewers  int
+}
+
+// NewLimiter 创建出口带宽限制
+func NewLimiter(opts Options) *Limiter {
+	if opts.SessionTTL <= 0 {
+		opts.SessionTTL = 30 * time.Second
+	}
+	l := &Limiter{
+		opts:     opts,
+		node:     newBucket(opts.NodeRate),
+		classes:  make(map[string]*bucket),
+		streams:  make(map[string]*streamBudget),
+		sessions: make(map[string]time.Time),
+		refused:  make(map[string]uint64),
+	}
+	if opts.NodeRate > 0 {
+		for _, priority := range []string{PriorityHigh, PriorityNormal, PriorityLow} {
+			if t := l.threshold(priority); t < 1 {
+				l.classes[priority] = newBucket(int64(float64(opts.NodeRate) * t))
+			}
+		}
+	}
+	return l
+}
+
+// Admit 新观众加入，带宽不足时返回 ErrNodeBudget 或 ErrStreamBudget；加入后观众离开时调用 Viewer.Release
+func (l *Limiter) Admit(broadcasterKey string) (*Viewer, error) {
+	if l == nil {
+		return nil, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sb := l.streamLocked(broadcasterKey)
+	if err := l.checkLocked(sb); err != nil {
+		return nil, err
+	}
+	sb.viewers++
+	return l.newViewer(broadcasterKey, sb), nil
+}
+
+// AdmitSession HLS 这类短请求的观众：会话已加入时不再检查带宽，返回的 Viewer 在请求结束时调用 Release
+func (l *Limiter) AdmitSession(broadcasterKey, sessionId string) (*Viewer, error) {
+	if l == nil {
+		return nil, nil
+	}
+	sessionKey := broadcasterKey + "/" + sessionId
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sb := l.streamLocked(broadcasterKey)
+	if lastSeen, ok := l.sessions[sessionKey]; !ok || now.Sub(lastSeen) > l.opts.SessionTTL {
+		if err := l.checkLocked(sb); err != nil {
+			return nil, err
+		}
+	}
+	l.sessions[sessionKey] = now
+	l.pruneSessionsLocked(now)
+	sb.viewers++
+	return l.newViewer(broadcasterKey, sb), nil
+}
+
+// Stats 返回本节点和各直播间的出口带宽
+func (l *Limiter) Stats() Stats {
+	if l == nil {
+		return Stats{Streams: map[string]StreamStats{}, Refused: map[string]uint64{}}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := Stats{
+		NodeRate:  l.opts.NodeRate,
+		NodeUsage: l.nodeMeter.rate(),
+		Streams:   make(map[string]StreamStats, len(l.streams)),
+		Refused:   make(map[string]uint64, len(l.refused)),
+	}
+	for key, sb := range l.streams {
+		stats.Streams[key] = StreamStats{Priority: sb.priority, Rate: sb.rate, Usage: sb.meter.rate(), Viewers: sb.viewers}
+	}
+	for reason, n := range l.refused {
+		stats.Refused[reason] = n
+	}
+	return stats
+}
+
+// checkLocked 新观众加入前检查本节点和直播间的实际出口速率
+func (l *Limiter) checkLocked(sb *streamBudget) error {
+	var err error
+	switch {
+	case l.opts.NodeRate > 0 && l.nodeMeter.rate() >= float64(l.opts.NodeRate)*l.threshold(sb.priority):
+		err = ErrNodeBudget
+	case sb.rate > 0 && sb.meter.rate() >= float64(sb.rate):
+		err = ErrStreamBudget
+	}
+	if err != nil {
+		l.refused[err.Error()]++
+	}
+	return err
+}
+
+// streamLocked 返回直播间的带宽状态，不存在时按配置创建
+func (l *Limiter) streamLocked(broadcasterKey string) *streamBudget {
+	if sb, ok := l.streams[broadcasterKey]; ok {
+		return sb
+	}
+
+	// 释放没有观众、也已经没有流量的直播间，避免直播间很多时 map 只增不减
+	for key, sb := range l.streams {
+		if sb.viewers <= 0 && sb.meter.rate() == 0 {
+			delete(l.streams, key)
+		}
+	}
+
+	rate := l.opts.StreamRate
+	if r, ok := l.opts.StreamRates[broadcasterKey]; ok {
+		rate = r
+	}
+	sb := &streamBudget{priority: l.priority(broadcasterKey), rate: rate, bucket: newBucket(rate)}
+	l.streams[broadcasterKey] = sb
+	return sb
+}
+
+func (l *Limiter) newViewer(broadcasterKey string, sb *streamBudget) *Viewer {
+	v := &Viewer{limiter: l, broadcasterKey: broadcasterKey, stream: sb}
+	v.buckets = []*bucket{newBucket(l.opts.ViewerRate), sb.bucket, l.classes[sb.priority], l.node}
+	return v
+}
+
+func (l *Limiter) release(broadcasterKey string, sb *streamBudget) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sb.viewers--
+}
+
+func (l *Limiter) pruneSessionsLocked(now time.Time) {
+	if len(l.sessions) < 1024 {
+		return
+	}
+	for key, lastSeen := range l.sessions {
+		if now.Sub(lastSeen) > l.opts.SessionTTL {
+			delete(l.sessions, key)
+		}
+	}
+}
+
+func (l *Limiter) priority(broadcasterKey string) string {
+	for pattern, priority := range l.opts.Priorities {
+		if ok, _ := path.Match(pattern, broadcasterKey); ok {
+			return priority
+		}
+	}
+	return PriorityNormal
+}
+
+func (l *Limiter) threshold(priority string) float64 {
+	if t, ok := l.opts.Thresholds[priority]; ok && t > 0 {
+		return t
+	}
+	if t, ok := defaultThresholds[priority]; ok {
+		return t
+	}
+	return defaultThresholds[PriorityNormal]
+}
+
+// Viewer 一个观众的出口带宽，为 nil 时不限制
+type Viewer struct {
+	limiter        *Limiter
+	broadcasterKey string
+	stream         *streamBudget
+	buckets        []*bucket
+	releaseOnce    sync.Once
+}
+
+// Wait 写 n 字节前调用，按三层令牌桶等待，done 被关闭时提前返回 false
+func (v *Viewer) Wait(n int, done <-chan struct{}) bool {
+	if v == nil || n <= 0 {
+		return true
+	}
+	wait := v.reserve(n)
+	if wait <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Count 记入已经发出的 n 字节，不等待；给无法逐个观众限速的输出（如 WHEP 共用的本地轨道）使用，
+// 这些流量照样占用直播间和本节点的带宽，后来的观众按实际速率判断能否加入
+func (v *Viewer) Count(n int) {
+	if v == nil || n <= 0 {
+		return
+	}
+	v.reserve(n)
+}
+
+// reserve 从三层令牌桶中预扣 n 字节并计入速率，返回需要等待的时间
+func (v *Viewer) reserve(n int) time.Duration {
+	now := time.Now()
+	var wait time.Duration
+	for _, b := range v.buckets {
+		if d := b.reserve(n, now); d > wait {
+			wait = d
+		}
+	}
+	v.stream.meter.add(n, now)
+	v.limiter.nodeMeter.add(n, now)
+	return wait
+}
+
+// Release 观众离开，重复调用无效果
+func (v *Viewer) Release() {
+	if v == nil {
+		return
+	}
+	v.releaseOnce.Do(func() {
+		v.limiter.release(v.broadcasterKey, v.stream)
+	})
+}
+
+type contextKey struct{}
+
+// NewContext 把观众的出口带宽放到请求的 context 中，LiveClient 写数据时通过 FromContext 取出
+func NewContext(ctx context.Context, v *Viewer) context.Context {
+	return context.WithValue(ctx, contextKey{}, v)
+}
+
+// FromContext 取出请求的出口带宽，没有时返回 nil（不限制）
+func FromContext(ctx context.Context) *Viewer {
+	v, _ := ctx.Value(contextKey{}).(*Viewer)
+	return v
+}
+
+// bucket 令牌桶，容量为一秒的速率；预扣后令牌可以为负，返回补齐所需的时间
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newBucket rate <= 0 时返回 nil，表示不限制
+func newBucket(rate int64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+func (b *bucket) reserve(n int, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// meter 统计最近一个完整的一秒窗口内的速率
+type meter struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	bytes       int64
+	last        float64
+}
+
+func (m *meter) add(n int, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if elapsed := now.Sub(m.windowStart); elapsed >= time.Second {
+		m.last = float64(m.bytes) / elapsed.Seconds()
+		if elapsed >= 2*time.Second {
+			// 中间空闲了一段时间，上一个窗口的速率已经没有意义
+			m.last = 0
+		}
+		m.windowStart = now
+		m.bytes = 0
+	}
+	m.bytes += int64(n)
+}
+
+func (m *meter) rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Since(m.windowStart) >= 2*time.Second {
+		return 0
+	}
+	return m.last
+}
@@ -0,0 +1,95 @@
+package av
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AACSampleRates AudioSpecificConfig 中 samplingFrequencyIndex 对应的采样率
+var AACSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// AACConfig 解析后的 AudioSpecificConfig
+type AACConfig struct {
+	ObjectType      uint8 // 2 = AAC-LC
+	SampleRateIndex uint8
+	SampleRate      int
+	Channels        int
+}
+
+// ParseAACConfig 解析 AudioSpecificConfig
+func ParseAACConfig(asc []byte) (*AACConfig, error) {
+	if len(asc) < 2 {
+		return nil, errors.New("AudioSpecificConfig 长度不足")
+	}
+	cfg := &AACConfig{
+		ObjectType:      asc[0] >> 3,
+		SampleRateIndex: (asc[0]&0x07)<<1 | asc[1]>>7,
+		Channels:        int(asc[1]>>3) & 0x0F,
+	}
+	if int(cfg.SampleRateIndex) >= len(AACSampleRates) {
+		return nil, fmt.Errorf("不支持的 AAC 采样率索引 %d", cfg.SampleRateIndex)
+	}
+	cfg.SampleRate = AACSampleRates[cfg.SampleRateIndex]
+	return cfg, nil
+}
+
+// Bytes 重新编码为 2 字节的 AudioSpecificConfig
+func (c *AACConfig) Bytes() []byte {
+	return []byte{
+		c.ObjectType<<3 | c.SampleRateIndex>>1,
+		(c.SampleRateIndex&0x01)<<7 | uint8(c.Channels&0x0F)<<3,
+	}
+}
+
+// ADTSHeader 为一个原始 AAC 帧生成 7 字节 ADTS 头（无 CRC）
+func (c *AACConfig) ADTSHeader(payloadLen int) []byte {
+	frameLen := payloadLen + 7
+	profile := c.ObjectType - 1
+	return []byte{
+		0xFF,
+		0xF1, // MPEG-4, layer 0, protection_absent = 1
+		profile<<6 | c.SampleRateIndex<<2 | uint8(c.Channels>>2)&0x01,
+		uint8(c.Channels&0x03)<<6 | uint8(frameLen>>11)&0x03,
+		uint8(frameLen >> 3),
+		uint8(frameLen&0x07)<<5 | 0x1F,
+		0xFC,
+	}
+}
+
+// ADTSFrame 一个从 ADTS 流中拆出的 AAC 帧
+type ADTSFrame struct {
+	Config *AACConfig
+	Data   []byte // 不带 ADTS 头的原始帧
+}
+
+// SplitADTS 把一段 ADTS 数据拆分成若干原始 AAC 帧
+func SplitADTS(data []byte) ([]ADTSFrame, error) {
+	var frames []ADTSFrame
+	for len(data) > 0 {
+		if len(data) < 7 || data[0] != 0xFF || data[1]&0xF0 != 0xF0 {
+			return frames, errors.New("无效的 ADTS 头")
+		}
+		protectionAbsent := data[1] & 0x01
+		headerLen := 7
+		if protectionAbsent == 0 {
+			headerLen = 9
+		}
+		frameLen := int(data[3]&0x03)<<11 | int(data[4])<<3 | int(data[5])>>5
+		if frameLen < headerLen || frameLen > len(data) {
+			return frames, errors.New("ADTS 帧长度错误")
+		}
+		idx := (data[2] >> 2) & 0x0F
+		if int(idx) >= len(AACSampleRates) {
+			return frames, fmt.Errorf("不支持的 AAC 采样率索引 %d", idx)
+		}
+		cfg := &AACConfig{
+			ObjectType:      (data[2] >> 6) + 1,
+			SampleRateIndex: idx,
+			SampleRate:      AACSampleRates[idx],
+			Channels:        int(data[2]&0x01)<<2 | int(data[3]>>6),
+		}
+		frames = append(frames, ADTSFrame{Config: cfg, Data: data[headerLen:frameLen]})
+		data = data[frameLen:]
+	}
+	return frames, nil
+}
@@ -0,0 +1,67 @@
+package av
+
+import "time"
+
+/*
+av 包定义各种封装格式之间转换时使用的统一音视频帧模型。
+
+	FLV tag、MPEG-TS PES、RTP 包等都先转换成 Packet，再由对应的 muxer 封装成目标格式，
+	这样 HLS 切片、DASH、TS 输出等只需要面向 Packet 编程。
+*/
+
+// PacketType 帧类型
+type PacketType uint8
+
+const (
+	PacketVideo PacketType = 1 // 视频帧
+	PacketAudio PacketType = 2 // 音频帧
+)
+
+// Codec 编码格式
+type Codec uint8
+
+const (
+	CodecUnknown Codec = iota
+	CodecH264
+	CodecH265
+	CodecAAC
+	CodecOpus
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecH264:
+		return "H.264"
+	case CodecH265:
+		return "H.265"
+	case CodecAAC:
+		return "AAC"
+	case CodecOpus:
+		return "Opus"
+	default:
+		return "Unknown"
+	}
+}
+
+// Packet 一个音频帧或视频帧
+type Packet struct {
+	Type  PacketType
+	Codec Codec
+
+	// DTS 解码时间戳，CTS 为显示时间相对解码时间的偏移（PTS = DTS + CTS）
+	DTS time.Duration
+	CTS time.Duration
+
+	IsKeyFrame       bool // 是否关键帧（音频帧恒为 true）
+	IsSequenceHeader bool // 是否序列头：视频为 AVCDecoderConfigurationRecord，音频为 AudioSpecificConfig
+
+	// Data 帧数据
+	// 	H.264/H.265：AVCC 格式（4 字节长度前缀的 NALU 序列），序列头时为解码配置记录
+	// 	AAC：不带 ADTS 头的原始帧，序列头时为 AudioSpecificConfig
+	Data []byte
+}
+
+// PTS 显示时间戳
+func (p *Packet) PTS() time.Duration {
+	return p.DTS + p.CTS
+}
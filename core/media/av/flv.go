@@ -0,0 +1,138 @@
+package av
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// FLV tag 类型及编码 id，与 core/broadcast/flv 中的常量保持一致
+const (
+	FLVTagAudio  = 8
+	FLVTagVideo  = 9
+	FLVTagScript = 18
+
+	flvCodecH264 = 7
+	flvCodecH265 = 12
+	flvFormatAAC = 10
+)
+
+// FLVHeader 生成 FLV 文件头（9 字节）+ PreviousTagSize0（4 字节）
+func FLVHeader(hasVideo, hasAudio bool) []byte {
+	var flags byte
+	if hasAudio {
+		flags |= 0x04
+	}
+	if hasVideo {
+		flags |= 0x01
+	}
+	return []byte{'F', 'L', 'V', 0x01, flags, 0, 0, 0, 9, 0, 0, 0, 0}
+}
+
+// FLVTagBytes 把一个 tag 的 data 部分封装成完整的 tag 字节（tag 头 + data + PreviousTagSize）
+func FLVTagBytes(tagType uint8, timestamp uint32, data []byte) []byte {
+	size := len(data)
+	buf := make([]byte, 11+size+4)
+	buf[0] = tagType
+	buf[1] = byte(size >> 16)
+	buf[2] = byte(size >> 8)
+	buf[3] = byte(size)
+	buf[4] = byte(timestamp >> 16)
+	buf[5] = byte(timestamp >> 8)
+	buf[6] = byte(timestamp)
+	buf[7] = byte(timestamp >> 24)
+	copy(buf[11:], data)
+	binary.BigEndian.PutUint32(buf[11+size:], uint32(11+size))
+	return buf
+}
+
+// DemuxFLVTag 把 FLV tag 的 data 部分转换成 Packet
+// 返回 ok=false 表示不是音视频帧（如 script tag）或编码不支持
+func DemuxFLVTag(tagType uint8, timestamp uint32, data []byte) (pkt *Packet, ok bool) {
+	dts := time.Duration(timestamp) * time.Millisecond
+	switch tagType {
+	case FLVTagVideo:
+		if len(data) < 5 {
+			return nil, false
+		}
+		frameType := data[0] >> 4
+		var codec Codec
+		switch data[0] & 0x0F {
+		case flvCodecH264:
+			codec = CodecH264
+		case flvCodecH265:
+			codec = CodecH265
+		default:
+			return nil, false
+		}
+		packetType := data[1]
+		if packetType > 1 {
+			// 2 = end of sequence
+			return nil, false
+		}
+		// CompositionTime 为 24 位有符号整数
+		cts := int32(uint32(data[2])<<16|uint32(data[3])<<8|uint32(data[4])) << 8 >> 8
+		return &Packet{
+			Type:             PacketVideo,
+			Codec:            codec,
+			DTS:              dts,
+			CTS:              time.Duration(cts) * time.Millisecond,
+			IsKeyFrame:       frameType == 1,
+			IsSequenceHeader: packetType == 0,
+			Data:             data[5:],
+		}, true
+	case FLVTagAudio:
+		if len(data) < 2 || data[0]>>4 != flvFormatAAC {
+			return nil, false
+		}
+		return &Packet{
+			Type:             PacketAudio,
+			Codec:            CodecAAC,
+			DTS:              dts,
+			IsKeyFrame:       true,
+			IsSequenceHeader: data[1] == 0,
+			Data:             data[2:],
+		}, true
+	}
+	return nil, false
+}
+
+// MuxFLVTag 把 Packet 转换成 FLV tag 的 data 部分
+// 返回 ok=false 表示该编码无法放入 FLV
+func MuxFLVTag(pkt *Packet) (tagType uint8, timestamp uint32, data []byte, ok bool) {
+	timestamp = uint32(pkt.DTS / time.Millisecond)
+	switch pkt.Codec {
+	case CodecH264, CodecH265:
+		codecID := byte(flvCodecH264)
+		if pkt.Codec == CodecH265 {
+			codecID = flvCodecH265
+		}
+		frameType := byte(2)
+		if pkt.IsKeyFrame || pkt.IsSequenceHeader {
+			frameType = 1
+		}
+		packetType := byte(1)
+		if pkt.IsSequenceHeader {
+			packetType = 0
+		}
+		cts := int32(pkt.CTS / time.Millisecond)
+		data = make([]byte, 5+len(pkt.Data))
+		data[0] = frameType<<4 | codecID
+		data[1] = packetType
+		data[2] = byte(cts >> 16)
+		data[3] = byte(cts >> 8)
+		data[4] = byte(cts)
+		copy(data[5:], pkt.Data)
+		return FLVTagVideo, timestamp, data, true
+	case CodecAAC:
+		packetType := byte(1)
+		if pkt.IsSequenceHeader {
+			packetType = 0
+		}
+		data = make([]byte, 2+len(pkt.Data))
+		data[0] = 0xAF // AAC, 44kHz, 16bit, stereo（AAC 的真实参数以 AudioSpecificConfig 为准）
+		data[1] = packetType
+		copy(data[2:], pkt.Data)
+		return FLVTagAudio, timestamp, data, true
+	}
+	return 0, 0, nil, false
+}
@@ -0,0 +1,446 @@
+package av
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// H.264 NALU 类型
+const (
+	H264NALSlice = 1
+	H264NALIDR   = 5
+	H264NALSEI   = 6
+	H264NALSPS   = 7
+	H264NALPPS   = 8
+	H264NALAUD   = 9
+)
+
+// AnnexBStartCode Annex B 起始码
+var AnnexBStartCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+// AVCConfig 解析后的 AVCDecoderConfigurationRecord
+type AVCConfig struct {
+	Profile       uint8
+	Compatibility uint8
+	Level         uint8
+	LengthSize    int // NALU 长度前缀字节数，通常为 4
+	SPS           [][]byte
+	PPS           [][]byte
+
+	Width  int // 从 SPS 解析出的宽
+	Height int // 从 SPS 解析出的高
+}
+
+// ParseAVCConfig 解析 AVCDecoderConfigurationRecord
+func ParseAVCConfig(record []byte) (*AVCConfig, error) {
+	if len(record) < 7 {
+		return nil, errors.New("AVCDecoderConfigurationRecord 长度不足")
+	}
+	cfg := &AVCConfig{
+		Profile:       record[1],
+		Compatibility: record[2],
+		Level:         record[3],
+		LengthSize:    int(record[4]&0x03) + 1,
+	}
+	pos := 5
+	numSPS := int(record[pos] & 0x1F)
+	pos++
+	for i := 0; i < numSPS; i++ {
+		if pos+2 > len(record) {
+			return nil, errors.New("SPS 长度越界")
+		}
+		n := int(binary.BigEndian.Uint16(record[pos:]))
+		pos += 2
+		if pos+n > len(record) {
+			return nil, errors.New("SPS 数据越界")
+		}
+		cfg.SPS = append(cfg.SPS, record[pos:pos+n])
+		pos += n
+	}
+	if pos >= len(record) {
+		return nil, errors.New("缺少 PPS")
+	}
+	numPPS := int(record[pos])
+	pos++
+	for i := 0; i < numPPS; i++ {
+		if pos+2 > len(record) {
+			return nil, errors.New("PPS 长度越界")
+		}
+		n := int(binary.BigEndian.Uint16(record[pos:]))
+		pos += 2
+		if pos+n > len(record) {
+			return nil, errors.New("PPS 数据越界")
+		}
+		cfg.PPS = append(cfg.PPS, record[pos:pos+n])
+		pos += n
+	}
+	if len(cfg.SPS) > 0 {
+		if w, h, err := ParseH264SPSSize(cfg.SPS[0]); err == nil {
+			cfg.Width, cfg.Height = w, h
+		}
+	}
+	return cfg, nil
+}
+
+// BuildAVCConfig 由 SPS/PPS 构造 AVCDecoderConfigurationRecord（长度前缀固定 4 字节）
+func BuildAVCConfig(sps, pps []byte) ([]byte, error) {
+	if len(sps) < 4 || len(pps) == 0 {
+		return nil, errors.New("SPS/PPS 无效")
+	}
+	var b bytes.Buffer
+	b.WriteByte(1)      // configurationVersion
+	b.WriteByte(sps[1]) // AVCProfileIndication
+	b.WriteByte(sps[2]) // profile_compatibility
+	b.WriteByte(sps[3]) // AVCLevelIndication
+	b.WriteByte(0xFF)   // lengthSizeMinusOne = 3
+	b.WriteByte(0xE1)   // numOfSequenceParameterSets = 1
+	_ = binary.Write(&b, binary.BigEndian, uint16(len(sps)))
+	b.Write(sps)
+	b.WriteByte(1) // numOfPictureParameterSets
+	_ = binary.Write(&b, binary.BigEndian, uint16(len(pps)))
+	b.Write(pps)
+	return b.Bytes(), nil
+}
+
+// SplitAVCC 按长度前缀拆分 AVCC 格式的 NALU
+func SplitAVCC(data []byte, lengthSize int) ([][]byte, error) {
+	if lengthSize < 1 || lengthSize > 4 {
+		return nil, fmt.Errorf("无效的 NALU 长度前缀 %d", lengthSize)
+	}
+	var nalus [][]byte
+	for len(data) > 0 {
+		if len(data) < lengthSize {
+			return nalus, errors.New("NALU 长度前缀不完整")
+		}
+		n := 0
+		for i := 0; i < lengthSize; i++ {
+			n = n<<8 | int(data[i])
+		}
+		data = data[lengthSize:]
+		if n > len(data) {
+			return nalus, errors.New("NALU 数据越界")
+		}
+		nalus = append(nalus, data[:n])
+		data = data[n:]
+	}
+	return nalus, nil
+}
+
+// SplitAnnexB 按起始码拆分 Annex B 格式的 NALU
+func SplitAnnexB(data []byte) [][]byte {
+	var nalus [][]byte
+	start := -1
+	i := 0
+	for i+2 < len(data) {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			if start >= 0 {
+				end := i
+				// 四字节起始码的前导 0 不属于上一个 NALU
+				if end > start && data[end-1] == 0 {
+					end--
+				}
+				if end > start {
+					nalus = append(nalus, data[start:end])
+				}
+			}
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+	if start >= 0 && start < len(data) {
+		nalus = append(nalus, data[start:])
+	} else if start < 0 && len(data) > 0 {
+		// 没有起始码，当作单个 NALU
+		nalus = append(nalus, data)
+	}
+	return nalus
+}
+
+// JoinAVCC 把若干 NALU 用 4 字节长度前缀拼接成 AVCC 格式
+func JoinAVCC(nalus [][]byte) []byte {
+	size := 0
+	for _, n := range nalus {
+		size += 4 + len(n)
+	}
+	out := make([]byte, 0, size)
+	for _, n := range nalus {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(n)))
+		out = append(out, n...)
+	}
+	return out
+}
+
+// JoinAnnexB 把若干 NALU 用起始码拼接成 Annex B 格式
+func JoinAnnexB(nalus [][]byte) []byte {
+	size := 0
+	for _, n := range nalus {
+		size += 4 + len(n)
+	}
+	out := make([]byte, 0, size)
+	for _, n := range nalus {
+		out = append(out, AnnexBStartCode...)
+		out = append(out, n...)
+	}
+	return out
+}
+
+// H264NALType 返回 NALU 类型
+func H264NALType(nalu []byte) uint8 {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return nalu[0] & 0x1F
+}
+
+// removeEmulationPrevention 去掉 NALU 中的防竞争字节 0x03
+func removeEmulationPrevention(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// bitReader 指数哥伦布编码读取器
+type bitReader struct {
+	data []byte
+	pos  int // bit 位置
+}
+
+func (r *bitReader) readBit() (uint, error) {
+	if r.pos >= len(r.data)*8 {
+		return 0, errors.New("SPS 数据不足")
+	}
+	bit := (r.data[r.pos/8] >> (7 - uint(r.pos%8))) & 0x01
+	r.pos++
+	return uint(bit), nil
+}
+
+func (r *bitReader) readBits(n int) (uint, error) {
+	var v uint
+	for i := 0; i < n; i++ {
+		b, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | b
+	}
+	return v, nil
+}
+
+func (r *bitReader) readUE() (uint, error) {
+	zeros := 0
+	for {
+		b, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			break
+		}
+		zeros++
+		if zeros > 31 {
+			return 0, errors.New("无效的指数哥伦布编码")
+		}
+	}
+	v, err := r.readBits(zeros)
+	if err != nil {
+		return 0, err
+	}
+	return (1 << uint(zeros)) - 1 + v, nil
+}
+
+func (r *bitReader) readSE() (int, error) {
+	v, err := r.readUE()
+	if err != nil {
+		return 0, err
+	}
+	if v%2 == 0 {
+		return -int(v / 2), nil
+	}
+	return int((v + 1) / 2), nil
+}
+
+func (r *bitReader) skipScalingList(size int) error {
+	last, next := 8, 8
+	for i := 0; i < size; i++ {
+		if next != 0 {
+			delta, err := r.readSE()
+			if err != nil {
+				return err
+			}
+			next = (last + delta + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+	return nil
+}
+
+// ParseH264SPSSize 从 SPS 中解析出视频宽高
+func ParseH264SPSSize(sps []byte) (width, height int, err error) {
+	if len(sps) < 4 {
+		return 0, 0, errors.New("SPS 长度不足")
+	}
+	r := &bitReader{data: removeEmulationPrevention(sps[1:])}
+	profileIdc, err := r.readBits(8)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err = r.readBits(16); err != nil { // constraint flags + level_idc
+		return 0, 0, err
+	}
+	if _, err = r.readUE(); err != nil { // seq_parameter_set_id
+		return 0, 0, err
+	}
+
+	chromaFormatIdc := uint(1)
+	switch profileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		if chromaFormatIdc, err = r.readUE(); err != nil {
+			return 0, 0, err
+		}
+		if chromaFormatIdc == 3 {
+			if _, err = r.readBit(); err != nil { // separate_colour_plane_flag
+				return 0, 0, err
+			}
+		}
+		if _, err = r.readUE(); err != nil { // bit_depth_luma_minus8
+			return 0, 0, err
+		}
+		if _, err = r.readUE(); err != nil { // bit_depth_chroma_minus8
+			return 0, 0, err
+		}
+		if _, err = r.readBit(); err != nil { // qpprime_y_zero_transform_bypass_flag
+			return 0, 0, err
+		}
+		scalingMatrixPresent, err := r.readBit()
+		if err != nil {
+			return 0, 0, err
+		}
+		if scalingMatrixPresent == 1 {
+			count := 8
+			if chromaFormatIdc == 3 {
+				count = 12
+			}
+			for i := 0; i < count; i++ {
+				present, err := r.readBit()
+				if err != nil {
+					return 0, 0, err
+				}
+				if present == 1 {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					if err = r.skipScalingList(size); err != nil {
+						return 0, 0, err
+					}
+				}
+			}
+		}
+	}
+
+	if _, err = r.readUE(); err != nil { // log2_max_frame_num_minus4
+		return 0, 0, err
+	}
+	picOrderCntType, err := r.readUE()
+	if err != nil {
+		return 0, 0, err
+	}
+	switch picOrderCntType {
+	case 0:
+		if _, err = r.readUE(); err != nil {
+			return 0, 0, err
+		}
+	case 1:
+		if _, err = r.readBit(); err != nil {
+			return 0, 0, err
+		}
+		if _, err = r.readSE(); err != nil {
+			return 0, 0, err
+		}
+		if _, err = r.readSE(); err != nil {
+			return 0, 0, err
+		}
+		n, err := r.readUE()
+		if err != nil {
+			return 0, 0, err
+		}
+		for i := uint(0); i < n; i++ {
+			if _, err = r.readSE(); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if _, err = r.readUE(); err != nil { // max_num_ref_frames
+		return 0, 0, err
+	}
+	if _, err = r.readBit(); err != nil { // gaps_in_frame_num_value_allowed_flag
+		return 0, 0, err
+	}
+	widthMbs, err := r.readUE()
+	if err != nil {
+		return 0, 0, err
+	}
+	heightMapUnits, err := r.readUE()
+	if err != nil {
+		return 0, 0, err
+	}
+	frameMbsOnly, err := r.readBit()
+	if err != nil {
+		return 0, 0, err
+	}
+	if frameMbsOnly == 0 {
+		if _, err = r.readBit(); err != nil { // mb_adaptive_frame_field_flag
+			return 0, 0, err
+		}
+	}
+	if _, err = r.readBit(); err != nil { // direct_8x8_inference_flag
+		return 0, 0, err
+	}
+	var cropLeft, cropRight, cropTop, cropBottom uint
+	cropping, err := r.readBit()
+	if err != nil {
+		return 0, 0, err
+	}
+	if cropping == 1 {
+		if cropLeft, err = r.readUE(); err != nil {
+			return 0, 0, err
+		}
+		if cropRight, err = r.readUE(); err != nil {
+			return 0, 0, err
+		}
+		if cropTop, err = r.readUE(); err != nil {
+			return 0, 0, err
+		}
+		if cropBottom, err = r.readUE(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	cropUnitX, cropUnitY := uint(1), 2-frameMbsOnly
+	if chromaFormatIdc == 1 {
+		cropUnitX, cropUnitY = 2, 2*(2-frameMbsOnly)
+	} else if chromaFormatIdc == 2 {
+		cropUnitX, cropUnitY = 2, 2-frameMbsOnly
+	}
+	width = int((widthMbs+1)*16 - (cropLeft+cropRight)*cropUnitX)
+	height = int((2-frameMbsOnly)*(heightMapUnits+1)*16 - (cropTop+cropBottom)*cropUnitY)
+	return width, height, nil
+}
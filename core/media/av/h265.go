@@ -0,0 +1,204 @@
+package av
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// H.265 NALU 类型
+const (
+	H265NALVPS = 32
+	H265NALSPS = 33
+	H265NALPPS = 34
+	H265NALAUD = 35
+)
+
+// HEVCConfig 解析后的 HEVCDecoderConfigurationRecord（只保留封装需要的字段）
+type HEVCConfig struct {
+	LengthSize int
+	VPS        [][]byte
+	SPS        [][]byte
+	PPS        [][]byte
+
+	Width  int // 从 SPS 解析出的宽
+	Height int // 从 SPS 解析出的高
+}
+
+// H265NALType 返回 NALU 类型
+func H265NALType(nalu []byte) uint8 {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return (nalu[0] >> 1) & 0x3F
+}
+
+// ParseHEVCConfig 解析 HEVCDecoderConfigurationRecord
+func ParseHEVCConfig(record []byte) (*HEVCConfig, error) {
+	if len(record) < 23 {
+		return nil, errors.New("HEVCDecoderConfigurationRecord 长度不足")
+	}
+	cfg := &HEVCConfig{LengthSize: int(record[21]&0x03) + 1}
+	numArrays := int(record[22])
+	pos := 23
+	for i := 0; i < numArrays; i++ {
+		if pos+3 > len(record) {
+			return nil, errors.New("HEVC 参数集数组越界")
+		}
+		nalType := record[pos] & 0x3F
+		numNalus := int(record[pos+1])<<8 | int(record[pos+2])
+		pos += 3
+		for j := 0; j < numNalus; j++ {
+			if pos+2 > len(record) {
+				return nil, errors.New("HEVC 参数集长度越界")
+			}
+			n := int(record[pos])<<8 | int(record[pos+1])
+			pos += 2
+			if pos+n > len(record) {
+				return nil, errors.New("HEVC 参数集数据越界")
+			}
+			nalu := record[pos : pos+n]
+			pos += n
+			switch nalType {
+			case H265NALVPS:
+				cfg.VPS = append(cfg.VPS, nalu)
+			case H265NALSPS:
+				cfg.SPS = append(cfg.SPS, nalu)
+			case H265NALPPS:
+				cfg.PPS = append(cfg.PPS, nalu)
+			}
+		}
+	}
+	if len(cfg.SPS) > 0 {
+		info := parseH265SPSInfo(cfg.SPS[0])
+		cfg.Width, cfg.Height = info.width, info.height
+	}
+	return cfg, nil
+}
+
+// BuildHEVCConfig 由 VPS/SPS/PPS 构造 HEVCDecoderConfigurationRecord（长度前缀固定 4 字节）
+//
+//	profile/tier/level、色度格式和位深从 SPS 中解析，解析失败时按 Main 8bit 4:2:0 填写。
+func BuildHEVCConfig(vps, sps, pps []byte) ([]byte, error) {
+	if len(vps) < 2 || len(sps) < 2 || len(pps) < 2 {
+		return nil, errors.New("VPS/SPS/PPS 无效")
+	}
+	info := parseH265SPSInfo(sps)
+
+	var b bytes.Buffer
+	b.WriteByte(1) // configurationVersion
+	b.Write(info.ptl[:])
+	b.Write([]byte{0xF0, 0x00})                        // min_spatial_segmentation_idc = 0
+	b.WriteByte(0xFC)                                  // parallelismType = 0
+	b.WriteByte(0xFC | info.chromaFormat&0x03)         // chromaFormat
+	b.WriteByte(0xF8 | info.bitDepthLumaMinus8&0x07)   // bitDepthLumaMinus8
+	b.WriteByte(0xF8 | info.bitDepthChromaMinus8&0x07) // bitDepthChromaMinus8
+	b.Write([]byte{0x00, 0x00})                        // avgFrameRate
+	// constantFrameRate = 0, numTemporalLayers, temporalIdNested, lengthSizeMinusOne = 3
+	b.WriteByte((info.maxSubLayers&0x07)<<3 | info.temporalIdNested<<2 | 0x03)
+	b.WriteByte(3) // numOfArrays
+	for _, nalu := range [][]byte{vps, sps, pps} {
+		b.WriteByte(0x80 | H265NALType(nalu)) // array_completeness = 1
+		_ = binary.Write(&b, binary.BigEndian, uint16(1))
+		_ = binary.Write(&b, binary.BigEndian, uint16(len(nalu)))
+		b.Write(nalu)
+	}
+	return b.Bytes(), nil
+}
+
+// h265SPSInfo 构造 HEVCDecoderConfigurationRecord 需要的 SPS 字段
+type h265SPSInfo struct {
+	ptl                  [12]byte // general_profile_tier_level，原样拷贝
+	maxSubLayers         uint8
+	temporalIdNested     uint8
+	chromaFormat         uint8
+	bitDepthLumaMinus8   uint8
+	bitDepthChromaMinus8 uint8
+	width, height        int // 裁剪后的宽高，解析失败时为 0
+}
+
+func parseH265SPSInfo(sps []byte) h265SPSInfo {
+	info := h265SPSInfo{maxSubLayers: 1, chromaFormat: 1}
+	info.ptl[0] = 0x01 // Main profile
+	info.ptl[1] = 0x60 // profile_compatibility_flag[1]、[2]
+	info.ptl[11] = 93  // level 3.1
+
+	data := removeEmulationPrevention(sps[2:]) // 跳过 2 字节 NALU 头
+	if len(data) < 13 {
+		return info
+	}
+	maxSubLayersMinus1 := (data[0] >> 1) & 0x07
+	info.maxSubLayers = maxSubLayersMinus1 + 1
+	info.temporalIdNested = data[0] & 0x01
+	copy(info.ptl[:], data[1:13])
+
+	r := &bitReader{data: data, pos: 13 * 8}
+	profilePresent := make([]uint, maxSubLayersMinus1)
+	levelPresent := make([]uint, maxSubLayersMinus1)
+	for i := range profilePresent {
+		profilePresent[i], _ = r.readBit()
+		levelPresent[i], _ = r.readBit()
+	}
+	if maxSubLayersMinus1 > 0 {
+		r.pos += 2 * (8 - int(maxSubLayersMinus1)) // reserved_zero_2bits
+	}
+	for i := range profilePresent {
+		if profilePresent[i] == 1 {
+			r.pos += 88
+		}
+		if levelPresent[i] == 1 {
+			r.pos += 8
+		}
+	}
+
+	if _, err := r.readUE(); err != nil { // sps_seq_parameter_set_id
+		return info
+	}
+	chromaFormat, err := r.readUE()
+	if err != nil {
+		return info
+	}
+	if chromaFormat == 3 {
+		r.pos++ // separate_colour_plane_flag
+	}
+	width, err := r.readUE() // pic_width_in_luma_samples
+	if err != nil {
+		return info
+	}
+	height, err := r.readUE() // pic_height_in_luma_samples
+	if err != nil {
+		return info
+	}
+	var crop [4]uint // conf_win_left/right/top/bottom_offset，单位为色度采样
+	if conformance, err := r.readBit(); err != nil {
+		return info
+	} else if conformance == 1 {
+		for i := range crop {
+			if crop[i], err = r.readUE(); err != nil {
+				return info
+			}
+		}
+	}
+	subWidth, subHeight := uint(1), uint(1)
+	if chromaFormat == 1 || chromaFormat == 2 {
+		subWidth = 2
+	}
+	if chromaFormat == 1 {
+		subHeight = 2
+	}
+	if cropW, cropH := subWidth*(crop[0]+crop[1]), subHeight*(crop[2]+crop[3]); cropW < width && cropH < height {
+		info.width, info.height = int(width-cropW), int(height-cropH)
+	}
+	luma, err := r.readUE()
+	if err != nil {
+		return info
+	}
+	chroma, err := r.readUE()
+	if err != nil {
+		return info
+	}
+	info.chromaFormat = uint8(chromaFormat)
+	info.bitDepthLumaMinus8 = uint8(luma)
+	info.bitDepthChromaMinus8 = uint8(chroma)
+	return info
+}
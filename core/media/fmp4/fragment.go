@@ -0,0 +1,61 @@
+package fmp4
+
+import "encoding/binary"
+
+// Sample 媒体分片中的一个采样（一帧）
+type Sample struct {
+	Duration uint32 // 时长，单位为轨道时间基
+	CTS      int32  // PTS - DTS，单位为轨道时间基
+	KeyFrame bool
+	Data     []byte // 视频为 AVCC 格式，音频为原始 AAC 帧
+}
+
+const (
+	sampleFlagsKey    = 0x02000000 // sample_depends_on = 2（不依赖其他帧）
+	sampleFlagsNonKey = 0x01010000 // sample_depends_on = 1, sample_is_non_sync_sample = 1
+)
+
+// Fragment 生成一个媒体分片 styp + moof + mdat
+//
+//	sequence 为分片序号，baseTime 为第一个采样的解码时间（轨道时间基）
+func Fragment(t *Track, sequence uint32, baseTime uint64, samples []Sample) []byte {
+	styp := box("styp", []byte("msdh"), u32(0), []byte("msdhmsixcmfs"))
+
+	mfhd := fullBox("mfhd", 0, 0, u32(sequence))
+	tfhd := fullBox("tfhd", 0, 0x020000, u32(t.ID)) // default-base-is-moof
+	tfdt := fullBox("tfdt", 1, 0, u64(baseTime))
+
+	// trun：data_offset + 每个采样的 duration/size/flags/composition_time_offset
+	const trunFlags = 0x000001 | 0x000100 | 0x000200 | 0x000400 | 0x000800
+	entries := make([]byte, 0, 16*len(samples))
+	mdatSize := 8
+	for _, s := range samples {
+		flags := uint32(sampleFlagsNonKey)
+		if s.KeyFrame {
+			flags = sampleFlagsKey
+		}
+		entries = binary.BigEndian.AppendUint32(entries, s.Duration)
+		entries = binary.BigEndian.AppendUint32(entries, uint32(len(s.Data)))
+		entries = binary.BigEndian.AppendUint32(entries, flags)
+		entries = binary.BigEndian.AppendUint32(entries, uint32(s.CTS))
+		mdatSize += len(s.Data)
+	}
+	// data_offset 先占位，moof 长度确定后回填
+	trun := fullBox("trun", 1, trunFlags, u32(uint32(len(samples))), u32(0), entries)
+	traf := box("traf", tfhd, tfdt, trun)
+	moof := box("moof", mfhd, traf)
+
+	// data_offset 相对 moof 起始位置，指向 mdat 的数据部分
+	trunOffset := len(moof) - len(trun)
+	binary.BigEndian.PutUint32(moof[trunOffset+16:], uint32(len(moof)+8))
+
+	out := make([]byte, 0, len(styp)+len(moof)+mdatSize)
+	out = append(out, styp...)
+	out = append(out, moof...)
+	out = binary.BigEndian.AppendUint32(out, uint32(mdatSize))
+	out = append(out, "mdat"...)
+	for _, s := range samples {
+		out = append(out, s.Data...)
+	}
+	return out
+}
@@ -0,0 +1,178 @@
+package fmp4
+
+import (
+	"errors"
+	"fmt"
+	"pull2push/core/media/av"
+)
+
+/*
+fMP4（CMAF）封装
+	每个轨道单独输出一个初始化分片（ftyp + moov）和若干媒体分片（moof + mdat），
+	也就是 CMAF 要求的“一个分片只包含一个轨道”，DASH 的视频和音频各自是一个 AdaptationSet。
+*/
+
+// Track 一个轨道的描述
+type Track struct {
+	ID        uint32
+	Codec     av.Codec
+	Timescale uint32
+
+	// 视频
+	AVCRecord []byte // AVCDecoderConfigurationRecord
+	Width     int
+	Height    int
+
+	// 音频
+	AAC *av.AACConfig
+}
+
+// NewVideoTrack 由 AVCDecoderConfigurationRecord 创建 H.264 视频轨道，时间基 90kHz
+func NewVideoTrack(id uint32, record []byte) (*Track, error) {
+	cfg, err := av.ParseAVCConfig(record)
+	if err != nil {
+		return nil, err
+	}
+	return &Track{
+		ID:        id,
+		Codec:     av.CodecH264,
+		Timescale: 90000,
+		AVCRecord: record,
+		Width:     cfg.Width,
+		Height:    cfg.Height,
+	}, nil
+}
+
+// NewAudioTrack 由 AudioSpecificConfig 创建 AAC 音频轨道，时间基为采样率
+func NewAudioTrack(id uint32, asc []byte) (*Track, error) {
+	cfg, err := av.ParseAACConfig(asc)
+	if err != nil {
+		return nil, err
+	}
+	return &Track{
+		ID:        id,
+		Codec:     av.CodecAAC,
+		Timescale: uint32(cfg.SampleRate),
+		AAC:       cfg,
+	}, nil
+}
+
+// IsVideo 是否视频轨道
+func (t *Track) IsVideo() bool {
+	return t.Codec == av.CodecH264
+}
+
+// CodecString RFC 6381 编码字符串，用于 MPD 的 codecs 属性
+func (t *Track) CodecString() string {
+	switch t.Codec {
+	case av.CodecH264:
+		if len(t.AVCRecord) >= 4 {
+			return fmt.Sprintf("avc1.%02x%02x%02x", t.AVCRecord[1], t.AVCRecord[2], t.AVCRecord[3])
+		}
+		return "avc1.640028"
+	case av.CodecAAC:
+		return fmt.Sprintf("mp4a.40.%d", t.AAC.ObjectType)
+	}
+	return ""
+}
+
+// InitSegment 生成初始化分片 ftyp + moov
+func (t *Track) InitSegment() ([]byte, error) {
+	stsd, err := t.sampleEntry()
+	if err != nil {
+		return nil, err
+	}
+	ftyp := box("ftyp", []byte("iso6"), u32(0), []byte("iso6cmfcdashmp41"))
+
+	mvhd := fullBox("mvhd", 0, 0,
+		u32(0), u32(0), // creation / modification time
+		u32(1000), u32(0), // timescale, duration
+		u32(0x00010000), u16(0x0100), zeros(10), // rate, volume, reserved
+		unityMatrix, zeros(24),
+		u32(t.ID+1), // next_track_ID
+	)
+
+	var width, height uint32
+	volume := uint16(0)
+	if t.IsVideo() {
+		width, height = uint32(t.Width)<<16, uint32(t.Height)<<16
+	} else {
+		volume = 0x0100
+	}
+	tkhd := fullBox("tkhd", 0, 0x000003, // enabled | in_movie
+		u32(0), u32(0), u32(t.ID), u32(0), u32(0), // times, track_ID, reserved, duration
+		zeros(8), u16(0), u16(0), u16(volume), u16(0), // reserved, layer, alternate_group, volume, reserved
+		unityMatrix, u32(width), u32(height),
+	)
+
+	mdhd := fullBox("mdhd", 0, 0, u32(0), u32(0), u32(t.Timescale), u32(0), u16(0x55C4), u16(0)) // language = und
+	handler, name, mediaHeader := "vide", "VideoHandler", fullBox("vmhd", 0, 1, zeros(8))
+	if !t.IsVideo() {
+		handler, name, mediaHeader = "soun", "SoundHandler", fullBox("smhd", 0, 0, zeros(4))
+	}
+	hdlr := fullBox("hdlr", 0, 0, u32(0), []byte(handler), zeros(12), []byte(name), []byte{0})
+	dinf := box("dinf", fullBox("dref", 0, 0, u32(1), fullBox("url ", 0, 1)))
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, u32(1), stsd),
+		fullBox("stts", 0, 0, u32(0)),
+		fullBox("stsc", 0, 0, u32(0)),
+		fullBox("stsz", 0, 0, u32(0), u32(0)),
+		fullBox("stco", 0, 0, u32(0)),
+	)
+	trak := box("trak", tkhd, box("mdia", mdhd, hdlr, box("minf", mediaHeader, dinf, stbl)))
+
+	trex := fullBox("trex", 0, 0, u32(t.ID), u32(1), u32(0), u32(0), u32(0))
+	moov := box("moov", mvhd, trak, box("mvex", trex))
+
+	return append(ftyp, moov...), nil
+}
+
+func (t *Track) sampleEntry() ([]byte, error) {
+	switch t.Codec {
+	case av.CodecH264:
+		compressor := make([]byte, 32)
+		return box("avc1",
+			zeros(6), u16(1), // reserved, data_reference_index
+			zeros(16), // pre_defined / reserved
+			u16(uint16(t.Width)), u16(uint16(t.Height)),
+			u32(0x00480000), u32(0x00480000), // 72 dpi
+			u32(0), u16(1), compressor, // reserved, frame_count, compressorname
+			u16(0x0018), u16(0xFFFF), // depth, pre_defined = -1
+			box("avcC", t.AVCRecord),
+		), nil
+	case av.CodecAAC:
+		return box("mp4a",
+			zeros(6), u16(1),
+			zeros(8),
+			u16(uint16(t.AAC.Channels)), u16(16), // channelcount, samplesize
+			zeros(4),
+			u32(uint32(t.AAC.SampleRate)<<16),
+			t.esds(),
+		), nil
+	}
+	return nil, errors.New(fmt.Sprintf("fmp4 不支持的编码 %s", t.Codec))
+}
+
+// esds MPEG-4 ES 描述符，携带 AudioSpecificConfig
+func (t *Track) esds() []byte {
+	asc := t.AAC.Bytes()
+	descriptor := func(tag byte, body ...[]byte) []byte {
+		n := 0
+		for _, b := range body {
+			n += len(b)
+		}
+		out := []byte{tag, byte(n)}
+		for _, b := range body {
+			out = append(out, b...)
+		}
+		return out
+	}
+	decSpecific := descriptor(0x05, asc)
+	decConfig := descriptor(0x04,
+		[]byte{0x40, 0x15},       // objectTypeIndication = MPEG-4 Audio, streamType = audio
+		zeros(3), u32(0), u32(0), // bufferSizeDB, maxBitrate, avgBitrate
+		decSpecific,
+	)
+	es := descriptor(0x03, u16(uint16(t.ID)), []byte{0}, decConfig, descriptor(0x06, []byte{0x02}))
+	return fullBox("esds", 0, 0, es)
+}
@@ -0,0 +1,237 @@
+package fmp4
+
+import (
+	"pull2push/core/media/av"
+	"time"
+)
+
+const (
+	VideoTrackID = 1
+	AudioTrackID = 2
+
+	aacFrameSamples = 1024
+	// 音频时间轴与 DTS 偏差超过该值时重新对齐（上游断流重连、时间戳跳变）
+	audioResyncThreshold = 500 * time.Millisecond
+)
+
+// TrackFragment 一个轨道的一个媒体分片
+type TrackFragment struct {
+	Track    *Track
+	Number   uint32 // 分片序号，每个轨道独立从 1 开始递增
+	BaseTime uint64 // 起始解码时间，单位为轨道时间基
+	Duration uint64 // 分片时长，单位为轨道时间基
+	Data     []byte
+}
+
+// FragmentHandler 切出一个媒体分片时回调
+type FragmentHandler func(frag *TrackFragment)
+
+// TrackHandler 轨道的编码配置变化时回调（初始化分片需要更新）
+type TrackHandler func(track *Track)
+
+// Segmenter 把连续的音视频帧切成 CMAF 分片
+//
+//	有视频时只在视频关键帧处切分，音频分片跟随视频的切分时刻；纯音频流按时长切分。
+//	非并发安全，同一路流由一个协程顺序写入。
+type Segmenter struct {
+	target     time.Duration
+	onFragment FragmentHandler
+	onTrack    TrackHandler
+
+	video *trackState
+	audio *trackState
+
+	started  bool
+	startDTS time.Duration
+}
+
+type trackState struct {
+	track   *Track
+	number  uint32
+	pending []*av.Packet
+
+	// 音频按每帧 1024 个采样累加时间，避免毫秒时间戳取整造成的抖动
+	nextTime uint64
+	hasTime  bool
+}
+
+// NewSegmenter 创建 CMAF 切片器，target 为目标分片时长
+func NewSegmenter(target time.Duration, onTrack TrackHandler, onFragment FragmentHandler) *Segmenter {
+	if target <= 0 {
+		target = 2 * time.Second
+	}
+	return &Segmenter{target: target, onTrack: onTrack, onFragment: onFragment}
+}
+
+// WritePacket 写入一个音视频帧
+func (s *Segmenter) WritePacket(pkt *av.Packet) error {
+	if pkt.IsSequenceHeader {
+		return s.updateTrack(pkt)
+	}
+
+	var st *trackState
+	switch {
+	case pkt.Type == av.PacketVideo && pkt.Codec == av.CodecH264:
+		st = s.video
+	case pkt.Type == av.PacketAudio && pkt.Codec == av.CodecAAC:
+		st = s.audio
+	}
+	if st == nil {
+		// 没有序列头或不支持的编码（DASH 输出只支持 H.264/AAC）
+		return nil
+	}
+
+	boundary := false
+	if s.video != nil {
+		boundary = pkt.Type == av.PacketVideo && pkt.IsKeyFrame
+	} else {
+		boundary = pkt.Type == av.PacketAudio
+	}
+
+	if !s.started {
+		if !boundary {
+			return nil
+		}
+		s.started = true
+		s.startDTS = pkt.DTS
+	} else if boundary && pkt.DTS-s.startDTS >= s.target {
+		s.cut(pkt.DTS)
+		s.startDTS = pkt.DTS
+	}
+
+	st.pending = append(st.pending, pkt)
+	return nil
+}
+
+// Flush 推流结束时输出最后一个不完整的分片
+func (s *Segmenter) Flush() {
+	if !s.started {
+		return
+	}
+	end := s.startDTS
+	if s.video != nil && len(s.video.pending) > 0 {
+		last := s.video.pending[len(s.video.pending)-1]
+		end = last.DTS + 40*time.Millisecond
+	}
+	s.cut(end)
+	s.started = false
+}
+
+func (s *Segmenter) updateTrack(pkt *av.Packet) error {
+	var track *Track
+	var err error
+	var st **trackState
+	switch pkt.Codec {
+	case av.CodecH264:
+		if s.video != nil && string(s.video.track.AVCRecord) == string(pkt.Data) {
+			return nil
+		}
+		track, err = NewVideoTrack(VideoTrackID, pkt.Data)
+		st = &s.video
+	case av.CodecAAC:
+		if s.audio != nil {
+			if cfg, e := av.ParseAACConfig(pkt.Data); e == nil && *cfg == *s.audio.track.AAC {
+				return nil
+			}
+		}
+		track, err = NewAudioTrack(AudioTrackID, pkt.Data)
+		st = &s.audio
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if *st == nil {
+		*st = &trackState{}
+	}
+	(*st).track = track
+	if s.onTrack != nil {
+		s.onTrack(track)
+	}
+	return nil
+}
+
+// cut 以 end 为结束时间输出各轨道当前积攒的帧
+func (s *Segmenter) cut(end time.Duration) {
+	if s.video != nil {
+		s.flushVideo(end)
+	}
+	if s.audio != nil {
+		s.flushAudio()
+	}
+}
+
+func (s *Segmenter) flushVideo(end time.Duration) {
+	st := s.video
+	if len(st.pending) == 0 {
+		return
+	}
+	ts := st.track.Timescale
+	samples := make([]Sample, len(st.pending))
+	for i, pkt := range st.pending {
+		next := end
+		if i+1 < len(st.pending) {
+			next = st.pending[i+1].DTS
+		}
+		dur := ticks(next, ts) - ticks(pkt.DTS, ts)
+		if next <= pkt.DTS {
+			dur = 0
+		}
+		samples[i] = Sample{
+			Duration: uint32(dur),
+			CTS:      int32(int64(ticks(pkt.PTS(), ts)) - int64(ticks(pkt.DTS, ts))),
+			KeyFrame: pkt.IsKeyFrame,
+			Data:     pkt.Data,
+		}
+	}
+	base := ticks(st.pending[0].DTS, ts)
+	s.emit(st, base, samples)
+}
+
+func (s *Segmenter) flushAudio() {
+	st := s.audio
+	if len(st.pending) == 0 {
+		return
+	}
+	ts := st.track.Timescale
+	first := ticks(st.pending[0].DTS, ts)
+	drift := time.Duration(int64(st.nextTime)-int64(first)) * time.Second / time.Duration(ts)
+	if !st.hasTime || drift > audioResyncThreshold || drift < -audioResyncThreshold {
+		st.nextTime = first
+		st.hasTime = true
+	}
+	samples := make([]Sample, len(st.pending))
+	for i, pkt := range st.pending {
+		samples[i] = Sample{Duration: aacFrameSamples, KeyFrame: true, Data: pkt.Data}
+	}
+	base := st.nextTime
+	st.nextTime += uint64(len(samples)) * aacFrameSamples
+	s.emit(st, base, samples)
+}
+
+func (s *Segmenter) emit(st *trackState, base uint64, samples []Sample) {
+	st.pending = nil
+	st.number++
+	var total uint64
+	for _, smp := range samples {
+		total += uint64(smp.Duration)
+	}
+	if s.onFragment != nil {
+		s.onFragment(&TrackFragment{
+			Track:    st.track,
+			Number:   st.number,
+			BaseTime: base,
+			Duration: total,
+			Data:     Fragment(st.track, st.number, base, samples),
+		})
+	}
+}
+
+// ticks 把时间转换为指定时间基下的刻度
+func ticks(d time.Duration, timescale uint32) uint64 {
+	if d < 0 {
+		return 0
+	}
+	return uint64(d/time.Microsecond) * uint64(timescale) / 1000000
+}
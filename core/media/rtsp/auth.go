@@ -0,0 +1,120 @@
+package rtsp
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// authenticator 根据 401 响应中的 WWW-Authenticate 生成 Authorization 头，优先使用 Digest
+type authenticator struct {
+	username string
+	password string
+
+	scheme string // "", Basic, Digest
+	realm  string
+	nonce  string
+	opaque string
+	qop    string // 只支持 auth
+	nc     int
+	cnonce string
+}
+
+// challenge 处理服务端的认证质询
+func (a *authenticator) challenge(values []string) error {
+	for _, v := range values {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(v), " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		p := parseAuthParams(params)
+		if alg := p["algorithm"]; alg != "" && !strings.EqualFold(alg, "MD5") {
+			continue
+		}
+		a.scheme = "Digest"
+		a.realm = p["realm"]
+		a.nonce = p["nonce"]
+		a.opaque = p["opaque"]
+		a.qop = ""
+		for _, q := range strings.Split(p["qop"], ",") {
+			if strings.TrimSpace(q) == "auth" {
+				a.qop = "auth"
+			}
+		}
+		a.nc = 0
+		return nil
+	}
+	for _, v := range values {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(v)), "basic") {
+			a.scheme = "Basic"
+			return nil
+		}
+	}
+	return errors.New("不支持的认证方式: " + strings.Join(values, "; "))
+}
+
+// authorization 生成当前请求的 Authorization 头，还没有收到质询时返回空
+func (a *authenticator) authorization(method, uri string) string {
+	switch a.scheme {
+	case "Basic":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(a.username+":"+a.password))
+	case "Digest":
+		ha1 := md5Hex(a.username + ":" + a.realm + ":" + a.password)
+		ha2 := md5Hex(method + ":" + uri)
+		header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, a.username, a.realm, a.nonce, uri)
+		if a.qop == "auth" {
+			if a.cnonce == "" {
+				b := make([]byte, 8)
+				_, _ = rand.Read(b)
+				a.cnonce = hex.EncodeToString(b)
+			}
+			a.nc++
+			nc := fmt.Sprintf("%08x", a.nc)
+			response := md5Hex(ha1 + ":" + a.nonce + ":" + nc + ":" + a.cnonce + ":auth:" + ha2)
+			header += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%s", response="%s"`, nc, a.cnonce, response)
+		} else {
+			header += fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+a.nonce+":"+ha2))
+		}
+		if a.opaque != "" {
+			header += fmt.Sprintf(`, opaque="%s"`, a.opaque)
+		}
+		return header
+	}
+	return ""
+}
+
+// parseAuthParams 解析 key="value", key=value 形式的参数，引号内可以有逗号
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, s = rest[1:], ""
+			} else {
+				value, s = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+		params[key] = value
+	}
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,306 @@
+package rtsp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+rtsp 包实现拉流需要的最小 RTSP 客户端：
+
+	OPTIONS → DESCRIBE → SETUP（RTP over TCP interleaved）→ PLAY → 读取 RTP，
+	支持 Basic / Digest 认证，PLAY 之后定时发送 GET_PARAMETER（服务端不支持时用 OPTIONS）保活。
+	只使用 TCP 传输，不需要额外的 UDP 端口，也能穿过大多数 NAT 和防火墙。
+*/
+
+const (
+	defaultPort           = "554"
+	dialTimeout           = 5 * time.Second
+	requestTimeout        = 10 * time.Second
+	defaultSessionTimeout = 60 * time.Second
+	userAgent             = "pull2push-rtsp/1.0"
+)
+
+// Response RTSP 响应
+type Response struct {
+	StatusCode int
+	Status     string
+	Header     textproto.MIMEHeader
+	Body       []byte
+}
+
+// Client 一个 RTSP 连接
+type Client struct {
+	URL *url.URL // 去掉用户名密码之后的地址
+
+	conn    net.Conn
+	br      *bufio.Reader
+	writeMu sync.Mutex
+
+	cseq           int
+	session        string
+	SessionTimeout time.Duration // 服务端在 Session 头中声明的超时时间
+	auth           *authenticator
+	publicMethods  string // OPTIONS 返回的 Public 头
+	playing        bool
+}
+
+// Dial 连接 RTSP 服务器，地址中的用户名密码用于认证
+func Dial(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "rtsp" {
+		return nil, errors.New(fmt.Sprintf("不支持的协议 %s", u.Scheme))
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	conn, err := net.DialTimeout("tcp", host, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:           conn,
+		br:             bufio.NewReaderSize(conn, 64*1024),
+		SessionTimeout: defaultSessionTimeout,
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		c.auth = &authenticator{username: u.User.Username(), password: password}
+	}
+	u.User = nil
+	c.URL = u
+	return c, nil
+}
+
+// Close 关闭连接，服务端会在 TCP 断开时释放会话
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Options 发送 OPTIONS，记录服务端支持的方法
+func (c *Client) Options() error {
+	resp, err := c.Do("OPTIONS", c.URL.String(), nil)
+	if err != nil {
+		return err
+	}
+	c.publicMethods = resp.Header.Get("Public")
+	return nil
+}
+
+// Describe 获取并解析 SDP，媒体的 control 地址按 Content-Base 解析成绝对地址
+func (c *Client) Describe() (*SessionDescription, error) {
+	resp, err := c.Do("DESCRIBE", c.URL.String(), map[string]string{"Accept": "application/sdp"})
+	if err != nil {
+		return nil, err
+	}
+	base := resp.Header.Get("Content-Base")
+	if base == "" {
+		base = resp.Header.Get("Content-Location")
+	}
+	if base == "" {
+		base = c.URL.String()
+	}
+	return ParseSDP(resp.Body, base)
+}
+
+// Setup 以 TCP interleaved 方式建立一路媒体，RTP 使用 channel，RTCP 使用 channel+1
+func (c *Client) Setup(media *Media, channel int) error {
+	transport := fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", channel, channel+1)
+	resp, err := c.Do("SETUP", media.Control, map[string]string{"Transport": transport})
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(resp.Header.Get("Transport"), "TCP") {
+		return errors.New("服务端不支持 RTP over TCP")
+	}
+	media.Channel = channel
+	return nil
+}
+
+// Play 开始播放，返回 RTP-Info 中各路媒体的起始 rtptime（key 为 control 地址）
+func (c *Client) Play() (map[string]uint32, error) {
+	resp, err := c.Do("PLAY", c.URL.String(), map[string]string{"Range": "npt=0.000-"})
+	if err != nil {
+		return nil, err
+	}
+	c.playing = true
+	return parseRTPInfo(resp.Header.Get("RTP-Info")), nil
+}
+
+// KeepAlive 发送保活请求，PLAY 之后响应和 RTP 交错返回，由 ReadFrame 丢弃，这里不等待响应
+func (c *Client) KeepAlive() error {
+	method := "OPTIONS"
+	if strings.Contains(c.publicMethods, "GET_PARAMETER") {
+		method = "GET_PARAMETER"
+	}
+	return c.writeRequest(method, c.URL.String(), nil)
+}
+
+// ReadFrame 读取一个 interleaved 数据帧，跳过其中夹杂的 RTSP 响应（保活请求的回复）
+func (c *Client) ReadFrame() (channel int, payload []byte, err error) {
+	for {
+		b, err := c.br.Peek(1)
+		if err != nil {
+			return 0, nil, err
+		}
+		switch b[0] {
+		case '$':
+			var header [4]byte
+			if _, err := io.ReadFull(c.br, header[:]); err != nil {
+				return 0, nil, err
+			}
+			payload := make([]byte, int(header[2])<<8|int(header[3]))
+			if _, err := io.ReadFull(c.br, payload); err != nil {
+				return 0, nil, err
+			}
+			return int(header[1]), payload, nil
+		case 'R':
+			if _, err := c.readResponse(); err != nil {
+				return 0, nil, err
+			}
+		default:
+			// 数据错位，逐字节找下一个 '$'
+			if _, err := c.br.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+}
+
+// Do 发送请求并等待响应，401 时按 WWW-Authenticate 计算认证信息后重试一次
+func (c *Client) Do(method, uri string, header map[string]string) (*Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.writeRequest(method, uri, header); err != nil {
+			return nil, err
+		}
+		_ = c.conn.SetReadDeadline(time.Now().Add(requestTimeout))
+		resp, err := c.readResponse()
+		_ = c.conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == 401 && attempt == 0 && c.auth != nil {
+			if err := c.auth.challenge(resp.Header.Values("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != 200 {
+			return nil, errors.New(fmt.Sprintf("%s %s 失败: %d %s", method, uri, resp.StatusCode, resp.Status))
+		}
+
+		if session := resp.Header.Get("Session"); session != "" {
+			parts := strings.Split(session, ";")
+			c.session = strings.TrimSpace(parts[0])
+			for _, p := range parts[1:] {
+				if v, ok := strings.CutPrefix(strings.TrimSpace(p), "timeout="); ok {
+					if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+						c.SessionTimeout = time.Duration(seconds) * time.Second
+					}
+				}
+			}
+		}
+		return resp, nil
+	}
+}
+
+func (c *Client) writeRequest(method, uri string, header map[string]string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.cseq++
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s RTSP/1.0\r\n", method, uri)
+	fmt.Fprintf(&b, "CSeq: %d\r\n", c.cseq)
+	fmt.Fprintf(&b, "User-Agent: %s\r\n", userAgent)
+	if c.session != "" {
+		fmt.Fprintf(&b, "Session: %s\r\n", c.session)
+	}
+	if c.auth != nil {
+		if authorization := c.auth.authorization(method, uri); authorization != "" {
+			fmt.Fprintf(&b, "Authorization: %s\r\n", authorization)
+		}
+	}
+	for k, v := range header {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+	b.WriteString("\r\n")
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(requestTimeout))
+	_, err := io.WriteString(c.conn, b.String())
+	_ = c.conn.SetWriteDeadline(time.Time{})
+	return err
+}
+
+func (c *Client) readResponse() (*Response, error) {
+	tp := textproto.NewReader(c.br)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	// RTSP/1.0 200 OK
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "RTSP/") {
+		return nil, errors.New("无效的 RTSP 响应: " + line)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, errors.New("无效的 RTSP 状态码: " + line)
+	}
+	resp := &Response{StatusCode: code}
+	if len(parts) == 3 {
+		resp.Status = parts[2]
+	}
+	if resp.Header, err = tp.ReadMIMEHeader(); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if n, _ := strconv.Atoi(resp.Header.Get("Content-Length")); n > 0 {
+		resp.Body = make([]byte, n)
+		if _, err := io.ReadFull(c.br, resp.Body); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// parseRTPInfo 解析 RTP-Info: url=rtsp://.../trackID=0;seq=1;rtptime=1234,url=...
+func parseRTPInfo(value string) map[string]uint32 {
+	info := make(map[string]uint32)
+	for _, item := range strings.Split(value, ",") {
+		var uri string
+		var rtptime uint32
+		hasTime := false
+		for _, field := range strings.Split(item, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(field), "=")
+			switch k {
+			case "url":
+				uri = v
+			case "rtptime":
+				if t, err := strconv.ParseUint(v, 10, 32); err == nil {
+					rtptime = uint32(t)
+					hasTime = true
+				}
+			}
+		}
+		if uri != "" && hasTime {
+			info[uri] = rtptime
+		}
+	}
+	return info
+}
@@ -0,0 +1,350 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"pull2push/core/media/av"
+	"pull2push/core/media/testsrc"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+const (
+	testUser  = "admin"
+	testPass  = "secret"
+	testRealm = "pull2push"
+	testNonce = "0123456789abcdef"
+)
+
+// flvTag 测试里用到的 FLV tag
+type flvTag struct {
+	tagType   uint8
+	timestamp uint32
+	data      []byte
+}
+
+// readFLVTag 读取下一个 tag（连同后面的 PreviousTagSize）
+func readFLVTag(r io.Reader) (flvTag, error) {
+	var header [11]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return flvTag{}, err
+	}
+	size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+	timestamp := uint32(header[7])<<24 | uint32(header[4])<<16 | uint32(header[5])<<8 | uint32(header[6])
+	data := make([]byte, size+4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return flvTag{}, err
+	}
+	return flvTag{tagType: header[0], timestamp: timestamp, data: data[:size]}, nil
+}
+
+// limitWriter 写满 n 字节后返回错误，让 testsrc.Generate 退出
+type limitWriter struct {
+	buf bytes.Buffer
+	n   int
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.buf.Len() >= w.n {
+		return 0, io.ErrShortWrite
+	}
+	return w.buf.Write(p)
+}
+
+// testsrcFrames 生成两个 GOP 左右的 testsrc 视频，返回 SPS、PPS 和 AnnexB 格式的帧
+func testsrcFrames(t *testing.T) (sps, pps []byte, frames []*av.Packet) {
+	t.Helper()
+	w := &limitWriter{n: 300 * 1024}
+	_ = testsrc.Generate(w, testsrc.Options{FPS: 25, GOP: 25}, false)
+
+	r := bytes.NewReader(w.buf.Bytes()[13:]) // FLV 头和第一个 PreviousTagSize
+	for {
+		tag, err := readFLVTag(r)
+		if err != nil {
+			break
+		}
+		pkt, ok := av.DemuxFLVTag(tag.tagType, tag.timestamp, tag.data)
+		if !ok || pkt.Type != av.PacketVideo {
+			continue
+		}
+		if pkt.IsSequenceHeader {
+			cfg, err := av.ParseAVCConfig(pkt.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sps, pps = cfg.SPS[0], cfg.PPS[0]
+			continue
+		}
+		nalus, err := av.SplitAVCC(pkt.Data, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkt.Data = av.JoinAnnexB(nalus)
+		frames = append(frames, pkt)
+	}
+	if sps == nil || len(frames) < 30 {
+		t.Fatalf("testsrc produced %d frames", len(frames))
+	}
+	return sps, pps, frames
+}
+
+// testServer 只支持一个连接的 RTSP 服务器：Digest 认证，一路 H.264，RTP over TCP interleaved
+type testServer struct {
+	ln       net.Listener
+	sps, pps []byte
+	frames   []*av.Packet
+	methods  chan string   // 通过认证的请求方法
+	closed   chan struct{} // 客户端断开连接后关闭
+	err      chan error
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sps, pps, frames := testsrcFrames(t)
+	s := &testServer{ln: ln, sps: sps, pps: pps, frames: frames, methods: make(chan string, 16), closed: make(chan struct{}), err: make(chan error, 1)}
+	go func() {
+		s.err <- s.serve()
+	}()
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	return s
+}
+
+func (s *testServer) url() string {
+	return fmt.Sprintf("rtsp://%s:%s@%s/live/stream", testUser, testPass, s.ln.Addr())
+}
+
+func (s *testServer) serve() error {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer close(s.closed)
+
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return nil
+		}
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return err
+		}
+		method, uri, _ := strings.Cut(line, " ")
+		uri, _, _ = strings.Cut(uri, " ")
+		cseq := header.Get("CSeq")
+
+		if !checkDigest(header.Get("Authorization"), method) {
+			fmt.Fprintf(conn, "RTSP/1.0 401 Unauthorized\r\nCSeq: %s\r\nWWW-Authenticate: Basic realm=\"%s\"\r\nWWW-Authenticate: Digest realm=\"%s\", nonce=\"%s\", qop=\"auth\"\r\n\r\n", cseq, testRealm, testRealm, testNonce)
+			continue
+		}
+		s.methods <- method
+
+		switch method {
+		case "OPTIONS":
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nPublic: OPTIONS, DESCRIBE, SETUP, PLAY, GET_PARAMETER, TEARDOWN\r\n\r\n", cseq)
+		case "DESCRIBE":
+			sdp := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=test\r\nt=0 0\r\na=control:*\r\n" +
+				"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n" +
+				"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=" + base64.StdEncoding.EncodeToString(s.sps) + "," + base64.StdEncoding.EncodeToString(s.pps) + "\r\n" +
+				"a=control:trackID=0\r\n" +
+				"m=application 0 RTP/AVP 107\r\na=rtpmap:107 vnd.onvif.metadata/90000\r\na=control:trackID=1\r\n"
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nContent-Base: %s/\r\nContent-Type: application/sdp\r\nContent-Length: %d\r\n\r\n%s", cseq, uri, len(sdp), sdp)
+		case "SETUP":
+			if !strings.HasSuffix(uri, "/trackID=0") {
+				return errors.New("unexpected SETUP " + uri)
+			}
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nTransport: %s\r\nSession: 12345678;timeout=60\r\n\r\n", cseq, header.Get("Transport"))
+		case "PLAY":
+			if header.Get("Session") != "12345678" {
+				return errors.New("PLAY without session")
+			}
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nSession: 12345678\r\nRTP-Info: url=%s/trackID=0;seq=1;rtptime=1000\r\n\r\n", cseq, uri)
+			go s.stream(conn)
+		default:
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\n\r\n", cseq)
+		}
+	}
+}
+
+// stream 把帧打成 RTP，以 interleaved 通道 0 发出，中间插一个 RTCP 通道的包
+func (s *testServer) stream(conn net.Conn) {
+	packetizer := rtp.NewPacketizer(1400, 96, 0x1234, &codecs.H264Payloader{}, rtp.NewFixedSequencer(1), 90000)
+	for i, f := range s.frames {
+		if i == 1 {
+			_, _ = conn.Write([]byte{'$', 1, 0, 4, 0x80, 0xC8, 0, 0})
+		}
+		for _, p := range packetizer.Packetize(f.Data, 90000/25) {
+			// 时间戳从 PLAY 响应中的 rtptime 开始
+			p.Timestamp = 1000 + uint32(f.DTS*90000/time.Second)
+			b, _ := p.Marshal()
+			frame := append([]byte{'$', 0, 0, 0}, b...)
+			binary.BigEndian.PutUint16(frame[2:], uint16(len(b)))
+			if _, err := conn.Write(frame); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// checkDigest 按 RFC 2617 校验 qop=auth 的 Digest 认证
+func checkDigest(authorization, method string) bool {
+	scheme, params, _ := strings.Cut(authorization, " ")
+	if scheme != "Digest" {
+		return false
+	}
+	p := parseAuthParams(params)
+	if p["username"] != testUser || p["realm"] != testRealm || p["nonce"] != testNonce || p["qop"] != "auth" {
+		return false
+	}
+	ha1 := md5Hex(testUser + ":" + testRealm + ":" + testPass)
+	ha2 := md5Hex(method + ":" + p["uri"])
+	return p["response"] == md5Hex(ha1+":"+testNonce+":"+p["nc"]+":"+p["cnonce"]+":auth:"+ha2)
+}
+
+// TestOpenFLV 经过 Digest 认证拉取进程内 RTSP 服务器的 H.264，输出 FLV 头、序列头和关键帧，关闭后断开连接
+func TestOpenFLV(t *testing.T) {
+	s := newTestServer(t)
+
+	r, err := OpenFLV(s.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var header [13]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	if string(header[:3]) != "FLV" || header[4] != 0x01 {
+		t.Fatalf("FLV header = %x, want video only", header[:9])
+	}
+
+	var sawConfig bool
+	var keyFrames int
+	var lastTimestamp uint32
+	for keyFrames < 2 {
+		tag, err := readFLVTag(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkt, ok := av.DemuxFLVTag(tag.tagType, tag.timestamp, tag.data)
+		if !ok || pkt.Type != av.PacketVideo {
+			t.Fatalf("unexpected tag type %d", tag.tagType)
+		}
+		switch {
+		case pkt.IsSequenceHeader:
+			cfg, err := av.ParseAVCConfig(pkt.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(cfg.SPS[0], s.sps) || !bytes.Equal(cfg.PPS[0], s.pps) {
+				t.Fatal("sequence header does not match sprop-parameter-sets")
+			}
+			sawConfig = true
+		case !sawConfig:
+			t.Fatal("frame before sequence header")
+		default:
+			if keyFrames == 0 && !pkt.IsKeyFrame {
+				t.Fatal("first frame is not a key frame")
+			}
+			if pkt.IsKeyFrame {
+				keyFrames++
+			}
+			if tag.timestamp < lastTimestamp {
+				t.Fatalf("timestamp went back: %d < %d", tag.timestamp, lastTimestamp)
+			}
+			lastTimestamp = tag.timestamp
+		}
+	}
+	// 第二个关键帧在 1 秒处，时间戳从 RTP-Info 的 rtptime 开始算
+	if lastTimestamp != 1000 {
+		t.Fatalf("second key frame at %dms, want 1000ms", lastTimestamp)
+	}
+
+	var methods []string
+	for len(s.methods) > 0 {
+		methods = append(methods, <-s.methods)
+	}
+	if got := strings.Join(methods, " "); got != "OPTIONS DESCRIBE SETUP PLAY" {
+		t.Fatalf("methods = %s", got)
+	}
+
+	_ = r.Close()
+	select {
+	case <-s.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RTSP connection not closed after Close")
+	}
+	if err := <-s.err; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOpenFLVWrongPassword 认证失败时 OpenFLV 返回错误
+func TestOpenFLVWrongPassword(t *testing.T) {
+	s := newTestServer(t)
+	if _, err := OpenFLV(strings.Replace(s.url(), testPass, "wrong", 1)); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("err = %v, want 401", err)
+	}
+}
+
+func TestParseSDPControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		sdp     string
+		base    string
+		control string
+	}{
+		{"relative", "m=video 0 RTP/AVP 96\r\na=control:trackID=1\r\n", "rtsp://cam/live/", "rtsp://cam/live/trackID=1"},
+		{"relative without slash", "m=video 0 RTP/AVP 96\r\na=control:trackID=1\r\n", "rtsp://cam/live", "rtsp://cam/live/trackID=1"},
+		{"absolute", "m=video 0 RTP/AVP 96\r\na=control:rtsp://other/track\r\n", "rtsp://cam/live", "rtsp://other/track"},
+		{"aggregate", "m=video 0 RTP/AVP 96\r\na=control:*\r\n", "rtsp://cam/live", "rtsp://cam/live"},
+		{"session control", "a=control:rtsp://cam/session\r\nm=video 0 RTP/AVP 96\r\na=control:video\r\n", "rtsp://cam/live", "rtsp://cam/session/video"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sd, err := ParseSDP([]byte(tt.sdp), tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sd.Medias[0].Control; got != tt.control {
+				t.Fatalf("control = %s, want %s", got, tt.control)
+			}
+			if sd.Medias[0].ClockRate != 90000 {
+				t.Fatalf("clock rate = %d, want 90000 for video", sd.Medias[0].ClockRate)
+			}
+		})
+	}
+}
+
+func TestParseRTPInfo(t *testing.T) {
+	info := parseRTPInfo("url=rtsp://cam/live/trackID=0;seq=1;rtptime=1234, url=rtsp://cam/live/trackID=1;seq=7,url=rtsp://cam/live/trackID=2;rtptime=4294967295")
+	want := map[string]uint32{"rtsp://cam/live/trackID=0": 1234, "rtsp://cam/live/trackID=2": 4294967295}
+	if len(info) != len(want) {
+		t.Fatalf("info = %v, want %v", info, want)
+	}
+	for k, v := range want {
+		if info[k] != v {
+			t.Fatalf("info = %v, want %v", info, want)
+		}
+	}
+}
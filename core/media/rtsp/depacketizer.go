@@ -0,0 +1,431 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/pion/rtp"
+	"pull2push/core/media/av"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// depacketizer 把一路 RTP 还原成 av.Packet
+type depacketizer interface {
+
+	// decode 处理一个 RTP 包，返回这次组好的帧（可能没有）
+	decode(pkt *rtp.Packet) []*av.Packet
+
+	// setBaseTime 设置 PLAY 响应 RTP-Info 中的起始 rtptime，各路媒体以它为 0 点对齐
+	setBaseTime(rtptime uint32)
+}
+
+// newDepacketizer 根据 SDP 创建对应编码的解包器，不支持的编码返回错误
+func newDepacketizer(m *Media) (depacketizer, error) {
+	switch m.Codec {
+	case "H264":
+		d := &videoDepacketizer{codec: av.CodecH264, clock: rtpClock{rate: m.ClockRate}}
+		for _, s := range strings.Split(m.Fmtp["sprop-parameter-sets"], ",") {
+			if nalu, err := base64.StdEncoding.DecodeString(s); err == nil && len(nalu) > 0 {
+				d.setParam(nalu)
+			}
+		}
+		return d, nil
+	case "H265":
+		d := &videoDepacketizer{codec: av.CodecH265, clock: rtpClock{rate: m.ClockRate}}
+		for _, key := range []string{"sprop-vps", "sprop-sps", "sprop-pps"} {
+			if nalu, err := base64.StdEncoding.DecodeString(m.Fmtp[key]); err == nil && len(nalu) > 0 {
+				d.setParam(nalu)
+			}
+		}
+		return d, nil
+	case "MPEG4-GENERIC":
+		return newAACDepacketizer(m)
+	}
+	return nil, errors.New(fmt.Sprintf("不支持的编码 %s", m.Codec))
+}
+
+// ====================== rtpClock ======================
+
+// rtpClock 把 32 位 RTP 时间戳换算成从 0 开始的时间，处理回绕
+type rtpClock struct {
+	rate    uint32
+	base    uint32
+	hasBase bool
+	started bool
+	last    uint32
+	ext     int64 // 相对 base 的扩展时间戳
+}
+
+func (c *rtpClock) setBase(ts uint32) {
+	if !c.started {
+		c.base = ts
+		c.hasBase = true
+	}
+}
+
+func (c *rtpClock) time(ts uint32) time.Duration {
+	if !c.started {
+		if !c.hasBase {
+			c.base = ts
+		}
+		c.last = c.base
+		c.started = true
+	}
+	c.ext += int64(int32(ts - c.last))
+	c.last = ts
+	if c.ext <= 0 || c.rate == 0 {
+		return 0
+	}
+	rate := int64(c.rate)
+	return time.Duration(c.ext/rate)*time.Second + time.Duration(c.ext%rate)*time.Second/time.Duration(rate)
+}
+
+// ====================== videoDepacketizer ======================
+
+// videoDepacketizer H.264（RFC 6184）/ H.265（RFC 7798）解包
+//
+//	按时间戳和 marker 位组成一帧，参数集变化时输出新的序列头，收到第一个关键帧之前的帧直接丢弃。
+//	RTP 时间戳是显示时间，摄像头一般不开 B 帧，这里把它当作 DTS，CTS 为 0。
+type videoDepacketizer struct {
+	codec av.Codec
+	clock rtpClock
+
+	nalus       [][]byte
+	fragment    []byte
+	fragmenting bool
+	frameTs     uint32
+	hasFrame    bool
+	lastSeq     uint16
+	hasSeq      bool
+
+	params        [3][]byte // H.264 为 [_, SPS, PPS]，H.265 为 [VPS, SPS, PPS]
+	configChanged bool
+	started       bool
+}
+
+func (d *videoDepacketizer) setBaseTime(rtptime uint32) {
+	d.clock.setBase(rtptime)
+}
+
+func (d *videoDepacketizer) decode(pkt *rtp.Packet) []*av.Packet {
+	// 丢包时丢掉正在拼接的分片
+	if d.hasSeq && pkt.SequenceNumber != d.lastSeq+1 {
+		d.fragmenting = false
+	}
+	d.lastSeq, d.hasSeq = pkt.SequenceNumber, true
+
+	var out []*av.Packet
+	if d.hasFrame && pkt.Timestamp != d.frameTs {
+		// 上一帧没有 marker 位
+		out = d.flush()
+	}
+	d.frameTs, d.hasFrame = pkt.Timestamp, true
+
+	if d.codec == av.CodecH265 {
+		d.unpackH265(pkt.Payload)
+	} else {
+		d.unpackH264(pkt.Payload)
+	}
+
+	if pkt.Marker {
+		out = append(out, d.flush()...)
+	}
+	return out
+}
+
+func (d *videoDepacketizer) unpackH264(payload []byte) {
+	if len(payload) < 1 {
+		return
+	}
+	switch naluType := payload[0] & 0x1F; {
+	case naluType >= 1 && naluType <= 23:
+		d.nalus = append(d.nalus, payload)
+	case naluType == 24: // STAP-A
+		d.unpackAggregation(payload[1:])
+	case naluType == 28: // FU-A
+		if len(payload) < 3 {
+			return
+		}
+		d.unpackFragment(payload[1]&0x80 != 0, payload[1]&0x40 != 0, []byte{payload[0]&0xE0 | payload[1]&0x1F}, payload[2:])
+	}
+}
+
+func (d *videoDepacketizer) unpackH265(payload []byte) {
+	if len(payload) < 3 {
+		return
+	}
+	switch naluType := (payload[0] >> 1) & 0x3F; naluType {
+	case 48: // AP
+		d.unpackAggregation(payload[2:])
+	case 49: // FU
+		fuHeader := payload[2]
+		header := []byte{payload[0]&0x81 | (fuHeader&0x3F)<<1, payload[1]}
+		d.unpackFragment(fuHeader&0x80 != 0, fuHeader&0x40 != 0, header, payload[3:])
+	case 50: // PACI
+	default:
+		d.nalus = append(d.nalus, payload)
+	}
+}
+
+// unpackAggregation 拆分聚合包：每个 NALU 前有 2 字节长度
+func (d *videoDepacketizer) unpackAggregation(data []byte) {
+	for len(data) >= 2 {
+		n := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if n == 0 || n > len(data) {
+			return
+		}
+		d.nalus = append(d.nalus, data[:n])
+		data = data[n:]
+	}
+}
+
+// unpackFragment 拼接分片，header 为还原后的 NALU 头
+func (d *videoDepacketizer) unpackFragment(start, end bool, header, data []byte) {
+	if start {
+		d.fragment = append(append(d.fragment[:0:0], header...), data...)
+		d.fragmenting = true
+	} else if d.fragmenting {
+		d.fragment = append(d.fragment, data...)
+	}
+	if end && d.fragmenting {
+		d.nalus = append(d.nalus, d.fragment)
+		d.fragment = nil
+		d.fragmenting = false
+	}
+}
+
+// setParam 记录参数集，返回是否是参数集
+func (d *videoDepacketizer) setParam(nalu []byte) bool {
+	idx := -1
+	if d.codec == av.CodecH265 {
+		switch av.H265NALType(nalu) {
+		case av.H265NALVPS:
+			idx = 0
+		case av.H265NALSPS:
+			idx = 1
+		case av.H265NALPPS:
+			idx = 2
+		}
+	} else {
+		switch av.H264NALType(nalu) {
+		case av.H264NALSPS:
+			idx = 1
+		case av.H264NALPPS:
+			idx = 2
+		}
+	}
+	if idx < 0 {
+		return false
+	}
+	if string(d.params[idx]) != string(nalu) {
+		d.params[idx] = append([]byte(nil), nalu...)
+		d.configChanged = true
+	}
+	return true
+}
+
+// isKeyFrame H.264 的 IDR，H.265 的 IRAP（BLA/IDR/CRA）
+func (d *videoDepacketizer) isKeyFrame(nalu []byte) bool {
+	if d.codec == av.CodecH265 {
+		t := av.H265NALType(nalu)
+		return t >= 16 && t <= 21
+	}
+	return av.H264NALType(nalu) == av.H264NALIDR
+}
+
+func (d *videoDepacketizer) isAUD(nalu []byte) bool {
+	if d.codec == av.CodecH265 {
+		return av.H265NALType(nalu) == av.H265NALAUD
+	}
+	return av.H264NALType(nalu) == av.H264NALAUD
+}
+
+// flush 把攒下的 NALU 组成一帧
+func (d *videoDepacketizer) flush() []*av.Packet {
+	nalus := d.nalus
+	d.nalus = nil
+	d.hasFrame = false
+	if len(nalus) == 0 {
+		return nil
+	}
+
+	var frame [][]byte
+	keyFrame := false
+	for _, nalu := range nalus {
+		if len(nalu) == 0 || d.setParam(nalu) || d.isAUD(nalu) {
+			continue
+		}
+		if d.isKeyFrame(nalu) {
+			keyFrame = true
+		}
+		frame = append(frame, nalu)
+	}
+
+	dts := d.clock.time(d.frameTs)
+	var out []*av.Packet
+	if d.configChanged {
+		if record, err := d.buildConfig(); err == nil {
+			out = append(out, &av.Packet{
+				Type:             av.PacketVideo,
+				Codec:            d.codec,
+				DTS:              dts,
+				IsKeyFrame:       true,
+				IsSequenceHeader: true,
+				Data:             record,
+			})
+			d.configChanged = false
+		}
+	}
+	// 还没有序列头，或者还没等到第一个关键帧
+	if len(frame) == 0 || (!d.started && (!keyFrame || d.configChanged)) {
+		return out
+	}
+	d.started = true
+	return append(out, &av.Packet{
+		Type:       av.PacketVideo,
+		Codec:      d.codec,
+		DTS:        dts,
+		IsKeyFrame: keyFrame,
+		Data:       av.JoinAVCC(frame),
+	})
+}
+
+func (d *videoDepacketizer) buildConfig() ([]byte, error) {
+	if d.codec == av.CodecH265 {
+		return av.BuildHEVCConfig(d.params[0], d.params[1], d.params[2])
+	}
+	return av.BuildAVCConfig(d.params[1], d.params[2])
+}
+
+// ====================== aacDepacketizer ======================
+
+// aacDepacketizer AAC（RFC 3640 mpeg4-generic，AAC-hbr 模式）解包
+type aacDepacketizer struct {
+	clock            rtpClock
+	config           []byte // AudioSpecificConfig
+	sizeLength       int
+	indexLength      int
+	indexDeltaLength int
+	configSent       bool
+
+	fragment []byte // 一个 AU 被拆成多个 RTP 包时拼接
+	fragSize int
+}
+
+func newAACDepacketizer(m *Media) (*aacDepacketizer, error) {
+	config, err := hex.DecodeString(m.Fmtp["config"])
+	if err != nil || len(config) < 2 {
+		return nil, errors.New("AAC 缺少 config 参数")
+	}
+	if _, err := av.ParseAACConfig(config); err != nil {
+		return nil, err
+	}
+	d := &aacDepacketizer{
+		clock:            rtpClock{rate: m.ClockRate},
+		config:           config,
+		sizeLength:       fmtpInt(m.Fmtp, "sizelength", 13),
+		indexLength:      fmtpInt(m.Fmtp, "indexlength", 3),
+		indexDeltaLength: fmtpInt(m.Fmtp, "indexdeltalength", 3),
+	}
+	if d.clock.rate == 0 {
+		cfg, _ := av.ParseAACConfig(config)
+		d.clock.rate = uint32(cfg.SampleRate)
+	}
+	return d, nil
+}
+
+func (d *aacDepacketizer) setBaseTime(rtptime uint32) {
+	d.clock.setBase(rtptime)
+}
+
+func (d *aacDepacketizer) decode(pkt *rtp.Packet) []*av.Packet {
+	var out []*av.Packet
+	if !d.configSent {
+		out = append(out, &av.Packet{
+			Type:             av.PacketAudio,
+			Codec:            av.CodecAAC,
+			IsKeyFrame:       true,
+			IsSequenceHeader: true,
+			Data:             d.config,
+		})
+		d.configSent = true
+	}
+
+	payload := pkt.Payload
+	if len(payload) < 2 {
+		return out
+	}
+	headerBits := int(binary.BigEndian.Uint16(payload))
+	headersLen := (headerBits + 7) / 8
+	if 2+headersLen > len(payload) {
+		return out
+	}
+	headers := &bitReader{data: payload[2 : 2+headersLen]}
+	data := payload[2+headersLen:]
+
+	var sizes []int
+	for i := 0; headers.pos+d.sizeLength <= headerBits; i++ {
+		size := headers.read(d.sizeLength)
+		if i == 0 {
+			headers.read(d.indexLength)
+		} else {
+			headers.read(d.indexDeltaLength)
+		}
+		sizes = append(sizes, size)
+	}
+
+	// 一个 AU 拆成多个包：只有一个 AU 头，数据比声明的长度短
+	if len(sizes) == 1 && (sizes[0] > len(data) || d.fragment != nil) {
+		if d.fragment == nil {
+			d.fragSize = sizes[0]
+		}
+		d.fragment = append(d.fragment, data...)
+		if !pkt.Marker || len(d.fragment) < d.fragSize {
+			return out
+		}
+		data, sizes = d.fragment[:d.fragSize], []int{d.fragSize}
+		d.fragment = nil
+	}
+
+	for i, size := range sizes {
+		if size > len(data) {
+			break
+		}
+		out = append(out, &av.Packet{
+			Type:       av.PacketAudio,
+			Codec:      av.CodecAAC,
+			DTS:        d.clock.time(pkt.Timestamp + uint32(i*1024)),
+			IsKeyFrame: true,
+			Data:       data[:size],
+		})
+		data = data[size:]
+	}
+	return out
+}
+
+func fmtpInt(fmtp map[string]string, key string, def int) int {
+	if v, err := strconv.Atoi(fmtp[key]); err == nil {
+		return v
+	}
+	return def
+}
+
+// bitReader 读取 AU 头
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) read(n int) int {
+	v := 0
+	for i := 0; i < n && r.pos < len(r.data)*8; i++ {
+		v = v<<1 | int(r.data[r.pos/8]>>(7-uint(r.pos%8))&0x01)
+		r.pos++
+	}
+	return v
+}
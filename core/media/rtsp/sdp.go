@@ -0,0 +1,118 @@
+package rtsp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// SessionDescription DESCRIBE 返回的 SDP，只保留拉流需要的字段
+type SessionDescription struct {
+	Medias []*Media
+}
+
+// Media SDP 中的一路媒体
+type Media struct {
+	Type        string            // video / audio
+	PayloadType uint8             // 第一个 payload type
+	Codec       string            // 大写编码名，如 H264、H265、MPEG4-GENERIC
+	ClockRate   uint32            // RTP 时钟频率
+	Channels    int               // 音频声道数
+	Fmtp        map[string]string // a=fmtp 参数，key 为小写
+	Control     string            // SETUP 使用的绝对地址
+	Channel     int               // SETUP 后的 interleaved RTP 通道号
+}
+
+// ParseSDP 解析 SDP，base 用于把相对的 control 地址解析成绝对地址
+func ParseSDP(body []byte, base string) (*SessionDescription, error) {
+	sd := &SessionDescription{}
+	sessionControl := ""
+	var media *Media
+
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) < 2 || line[1] != '=' {
+			continue
+		}
+		key, value := line[0], line[2:]
+		switch key {
+		case 'm':
+			// m=video 0 RTP/AVP 96
+			fields := strings.Fields(value)
+			if len(fields) < 4 {
+				media = nil
+				continue
+			}
+			pt, _ := strconv.Atoi(fields[3])
+			media = &Media{Type: fields[0], PayloadType: uint8(pt), Fmtp: make(map[string]string)}
+			sd.Medias = append(sd.Medias, media)
+		case 'a':
+			name, attr, _ := strings.Cut(value, ":")
+			if media == nil {
+				if name == "control" {
+					sessionControl = attr
+				}
+				continue
+			}
+			switch name {
+			case "control":
+				media.Control = attr
+			case "rtpmap":
+				// a=rtpmap:96 H264/90000
+				pt, enc, _ := strings.Cut(attr, " ")
+				if p, _ := strconv.Atoi(pt); uint8(p) != media.PayloadType {
+					continue
+				}
+				parts := strings.Split(enc, "/")
+				media.Codec = strings.ToUpper(parts[0])
+				if len(parts) > 1 {
+					rate, _ := strconv.Atoi(parts[1])
+					media.ClockRate = uint32(rate)
+				}
+				if len(parts) > 2 {
+					media.Channels, _ = strconv.Atoi(parts[2])
+				}
+			case "fmtp":
+				// a=fmtp:96 packetization-mode=1;sprop-parameter-sets=...
+				pt, params, _ := strings.Cut(attr, " ")
+				if p, _ := strconv.Atoi(pt); uint8(p) != media.PayloadType {
+					continue
+				}
+				for _, kv := range strings.Split(params, ";") {
+					k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+					if k != "" {
+						media.Fmtp[strings.ToLower(k)] = v
+					}
+				}
+			}
+		}
+	}
+	if len(sd.Medias) == 0 {
+		return nil, errors.New("SDP 中没有媒体")
+	}
+
+	if sessionControl != "" && sessionControl != "*" {
+		base = resolveControl(base, sessionControl)
+	}
+	for _, m := range sd.Medias {
+		m.Control = resolveControl(base, m.Control)
+		if m.ClockRate == 0 && m.Type == "video" {
+			m.ClockRate = 90000
+		}
+	}
+	return sd, nil
+}
+
+// resolveControl control 为绝对地址时直接使用，为 * 或空时使用 base，否则拼在 base 后面
+func resolveControl(base, control string) string {
+	switch {
+	case control == "" || control == "*":
+		return base
+	case strings.HasPrefix(control, "rtsp://"):
+		return control
+	case strings.HasSuffix(base, "/"):
+		return base + control
+	default:
+		return base + "/" + control
+	}
+}
@@ -0,0 +1,151 @@
+package rtsp
+
+import (
+	"errors"
+	"github.com/pion/rtp"
+	"io"
+	"log"
+	"pull2push/core/media/av"
+	"time"
+)
+
+// OpenFLV 拉取 rtsp:// 地址，把 RTP 转成 FLV 字节流返回
+//
+//	选取 SDP 中第一路 H.264/H.265 视频和第一路 AAC 音频，其余媒体不 SETUP。
+//	返回的 ReadCloser 关闭时断开 RTSP 连接；RTSP 连接断开时读到 EOF 或错误。
+func OpenFLV(rawURL string) (io.ReadCloser, error) {
+	c, err := Dial(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+
+	tracks, err := c.start()
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	go func() {
+		err := c.pumpFLV(tracks, pw)
+		_ = c.Close()
+		_ = pw.CloseWithError(err)
+	}()
+	return &flvReadCloser{PipeReader: pr, client: c}, nil
+}
+
+// flvReadCloser 关闭读端时同时断开 RTSP 连接，让 pumpFLV 退出
+type flvReadCloser struct {
+	*io.PipeReader
+	client *Client
+}
+
+func (r *flvReadCloser) Close() error {
+	_ = r.client.Close()
+	return r.PipeReader.Close()
+}
+
+// track 一路已经 SETUP 的媒体
+type track struct {
+	media        *Media
+	depacketizer depacketizer
+}
+
+// start OPTIONS → DESCRIBE → SETUP → PLAY，返回 key 为 RTP 通道号的媒体
+func (c *Client) start() (map[int]*track, error) {
+	if err := c.Options(); err != nil {
+		return nil, err
+	}
+	sd, err := c.Describe()
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := make(map[int]*track)
+	var hasVideo, hasAudio bool
+	for _, m := range sd.Medias {
+		if (m.Type == "video" && hasVideo) || (m.Type == "audio" && hasAudio) {
+			continue
+		}
+		d, err := newDepacketizer(m)
+		if err != nil {
+			log.Println("RTSP 跳过媒体:", m.Type, err)
+			continue
+		}
+		channel := len(tracks) * 2
+		if err := c.Setup(m, channel); err != nil {
+			return nil, err
+		}
+		tracks[channel] = &track{media: m, depacketizer: d}
+		hasVideo = hasVideo || m.Type == "video"
+		hasAudio = hasAudio || m.Type == "audio"
+	}
+	if len(tracks) == 0 {
+		return nil, errors.New("RTSP 没有可用的 H.264/H.265/AAC 媒体")
+	}
+
+	rtpInfo, err := c.Play()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tracks {
+		if rtptime, ok := rtpInfo[t.media.Control]; ok {
+			t.depacketizer.setBaseTime(rtptime)
+		}
+	}
+	return tracks, nil
+}
+
+// pumpFLV 读取 RTP 并写出 FLV，同时定时保活
+func (c *Client) pumpFLV(tracks map[int]*track, w io.Writer) error {
+	hasVideo, hasAudio := false, false
+	for _, t := range tracks {
+		hasVideo = hasVideo || t.media.Type == "video"
+		hasAudio = hasAudio || t.media.Type == "audio"
+	}
+	if _, err := w.Write(av.FLVHeader(hasVideo, hasAudio)); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(c.SessionTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.KeepAlive(); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		channel, payload, err := c.ReadFrame()
+		if err != nil {
+			return err
+		}
+		t, ok := tracks[channel]
+		if !ok {
+			// RTCP 或未 SETUP 的通道
+			continue
+		}
+		pkt := &rtp.Packet{}
+		if err := pkt.Unmarshal(payload); err != nil {
+			continue
+		}
+		for _, p := range t.depacketizer.decode(pkt) {
+			tagType, timestamp, data, ok := av.MuxFLVTag(p)
+			if !ok {
+				continue
+			}
+			if _, err := w.Write(av.FLVTagBytes(tagType, timestamp, data)); err != nil {
+				return err
+			}
+		}
+	}
+}
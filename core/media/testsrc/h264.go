@@ -0,0 +1,203 @@
+package testsrc
+
+/*
+预先生成的 H.264 码流
+	不依赖编码器，直接按 H.264 语法写出 Constrained Baseline 码流：
+		关键帧：IDR 帧，所有宏块都是 I_PCM（直接存放像素），画面是 8 条彩条；
+		其他帧：P 帧，所有宏块都是 P_Skip，完全复制上一帧，每帧只有几个字节。
+	码流在启动时生成一次，之后每一帧直接复用。
+*/
+
+const (
+	videoWidth  = 320
+	videoHeight = 240
+	mbWidth     = videoWidth / 16
+	mbHeight    = videoHeight / 16
+
+	log2MaxFrameNum = 4 // frame_num 占 4 位
+)
+
+// colorBars 8 条彩条（白、黄、青、绿、品红、红、蓝、黑）的 YCbCr（BT.601，75% 幅度）
+var colorBars = [8][3]byte{
+	{180, 128, 128},
+	{162, 44, 142},
+	{131, 156, 44},
+	{112, 72, 58},
+	{84, 184, 198},
+	{65, 100, 212},
+	{35, 212, 114},
+	{16, 128, 128},
+}
+
+// bitWriter 按位写出 RBSP
+type bitWriter struct {
+	buf  []byte
+	bits int // buf 最后一个字节已经写了多少位，0 表示需要新字节
+}
+
+func (w *bitWriter) writeBit(b uint) {
+	if w.bits == 0 {
+		w.buf = append(w.buf, 0)
+	}
+	if b != 0 {
+		w.buf[len(w.buf)-1] |= 0x80 >> w.bits
+	}
+	w.bits = (w.bits + 1) % 8
+}
+
+func (w *bitWriter) writeBits(v uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.writeBit((v >> i) & 1)
+	}
+}
+
+// writeUE 无符号指数哥伦布编码
+func (w *bitWriter) writeUE(v uint) {
+	v++
+	n := 0
+	for t := v; t > 1; t >>= 1 {
+		n++
+	}
+	w.writeBits(0, n)
+	w.writeBits(v, n+1)
+}
+
+// writeSE 有符号指数哥伦布编码
+func (w *bitWriter) writeSE(v int) {
+	if v > 0 {
+		w.writeUE(uint(2*v - 1))
+	} else {
+		w.writeUE(uint(-2 * v))
+	}
+}
+
+// alignZero 用 0 补齐到字节边界
+func (w *bitWriter) alignZero() {
+	for w.bits != 0 {
+		w.writeBit(0)
+	}
+}
+
+// trailing rbsp_trailing_bits
+func (w *bitWriter) trailing() {
+	w.writeBit(1)
+	w.alignZero()
+}
+
+// nalu 加上 NAL 头并插入防竞争字节
+func nalu(refIdc, nalType byte, rbsp []byte) []byte {
+	out := []byte{refIdc<<5 | nalType}
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros == 2 && b <= 3 {
+			out = append(out, 0x03)
+			zeros = 0
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+// buildSPS profile 66（Constrained Baseline）、level 3.0、POC type 2（无 B 帧）
+func buildSPS() []byte {
+	w := &bitWriter{}
+	w.writeBits(66, 8)   // profile_idc
+	w.writeBits(0xC0, 8) // constraint_set0_flag、constraint_set1_flag
+	w.writeBits(30, 8)   // level_idc
+	w.writeUE(0)         // seq_parameter_set_id
+	w.writeUE(log2MaxFrameNum - 4)
+	w.writeUE(2) // pic_order_cnt_type
+	w.writeUE(1) // max_num_ref_frames
+	w.writeBit(0)
+	w.writeUE(mbWidth - 1)
+	w.writeUE(mbHeight - 1)
+	w.writeBit(1) // frame_mbs_only_flag
+	w.writeBit(1) // direct_8x8_inference_flag
+	w.writeBit(0) // frame_cropping_flag
+	w.writeBit(0) // vui_parameters_present_flag
+	w.trailing()
+	return nalu(3, 7, w.buf)
+}
+
+// buildPPS CAVLC，不开加权预测，不传去块滤波参数
+func buildPPS() []byte {
+	w := &bitWriter{}
+	w.writeUE(0)  // pic_parameter_set_id
+	w.writeUE(0)  // seq_parameter_set_id
+	w.writeBit(0) // entropy_coding_mode_flag
+	w.writeBit(0) // bottom_field_pic_order_in_frame_present_flag
+	w.writeUE(0)  // num_slice_groups_minus1
+	w.writeUE(0)  // num_ref_idx_l0_default_active_minus1
+	w.writeUE(0)  // num_ref_idx_l1_default_active_minus1
+	w.writeBit(0) // weighted_pred_flag
+	w.writeBits(0, 2)
+	w.writeSE(0)  // pic_init_qp_minus26
+	w.writeSE(0)  // pic_init_qs_minus26
+	w.writeSE(0)  // chroma_qp_index_offset
+	w.writeBit(0) // deblocking_filter_control_present_flag
+	w.writeBit(0) // constrained_intra_pred_flag
+	w.writeBit(0) // redundant_pic_cnt_present_flag
+	w.trailing()
+	return nalu(3, 8, w.buf)
+}
+
+// buildIDR 整帧 I_PCM 彩条，idrPicId 相邻两个 IDR 必须不同
+func buildIDR(idrPicId uint) []byte {
+	w := &bitWriter{}
+	w.writeUE(0) // first_mb_in_slice
+	w.writeUE(7) // slice_type：I，整帧相同
+	w.writeUE(0) // pic_parameter_set_id
+	w.writeBits(0, log2MaxFrameNum)
+	w.writeUE(idrPicId)
+	w.writeBit(0) // no_output_of_prior_pics_flag
+	w.writeBit(0) // long_term_reference_flag
+	w.writeSE(0)  // slice_qp_delta
+
+	for mbY := 0; mbY < mbHeight; mbY++ {
+		for mbX := 0; mbX < mbWidth; mbX++ {
+			w.writeUE(25) // mb_type：I_PCM
+			w.alignZero()
+			// 亮度 16x16，然后 Cb、Cr 各 8x8
+			for y := 0; y < 16; y++ {
+				for x := 0; x < 16; x++ {
+					w.writeBits(uint(barAt(mbX*16 + x)[0]), 8)
+				}
+			}
+			for c := 1; c <= 2; c++ {
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						w.writeBits(uint(barAt(mbX*16 + x*2)[c]), 8)
+					}
+				}
+			}
+		}
+	}
+	w.trailing()
+	return nalu(3, 5, w.buf)
+}
+
+// buildPSkip 整帧 P_Skip，作为参考帧按 frameNum 递增
+func buildPSkip(frameNum uint) []byte {
+	w := &bitWriter{}
+	w.writeUE(0) // first_mb_in_slice
+	w.writeUE(5) // slice_type：P，整帧相同
+	w.writeUE(0) // pic_parameter_set_id
+	w.writeBits(frameNum%(1<<log2MaxFrameNum), log2MaxFrameNum)
+	w.writeBit(0)                 // num_ref_idx_active_override_flag
+	w.writeBit(0)                 // ref_pic_list_modification_flag_l0
+	w.writeBit(0)                 // adaptive_ref_pic_marking_mode_flag
+	w.writeSE(0)                  // slice_qp_delta
+	w.writeUE(mbWidth * mbHeight) // mb_skip_run
+	w.trailing()
+	return nalu(2, 1, w.buf)
+}
+
+// barAt 横坐标 x 处的彩条颜色
+func barAt(x int) [3]byte {
+	return colorBars[x*len(colorBars)/videoWidth]
+}
@@ -0,0 +1,194 @@
+package testsrc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/url"
+	"pull2push/core/media/av"
+	"strconv"
+	"time"
+)
+
+/*
+内置测试流
+	不依赖 ffmpeg 和上游，纯 Go 生成一路 FLV 直播：320x240 彩条 H.264 + 44.1kHz 双声道静音 AAC + onMetaData。
+	用来测试播放器、监控，以及在没有网络的环境下跑各种输出协议。上游地址：
+		testsrc://                      默认 25 帧，每秒一个关键帧，带音频
+		testsrc://?fps=30&gop=60&audio=0
+*/
+
+const (
+	audioSampleRate = 44100
+	audioFrameSize  = 1024 // 每个 AAC 帧的采样数
+)
+
+var (
+	// audioSpecificConfig AAC-LC、44100Hz、双声道
+	audioSpecificConfig = []byte{0x12, 0x10}
+
+	// silentAACFrame AAC-LC 双声道的静音帧
+	silentAACFrame = []byte{0x21, 0x00, 0x49, 0x90, 0x02, 0x19, 0x00, 0x23, 0x80}
+)
+
+// Options 测试流参数
+type Options struct {
+	FPS   int  // 帧率，1~60
+	GOP   int  // 关键帧间隔（帧），默认等于帧率
+	Audio bool // 是否带静音音轨
+}
+
+// ParseOptions 解析 testsrc:// 地址上的参数
+func ParseOptions(rawURL string) (Options, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Options{}, err
+	}
+	q := u.Query()
+	opt := Options{FPS: 25, Audio: q.Get("audio") != "0"}
+	if v := q.Get("fps"); v != "" {
+		if opt.FPS, err = strconv.Atoi(v); err != nil || opt.FPS < 1 || opt.FPS > 60 {
+			return Options{}, errors.New("testsrc fps 必须是 1~60：" + v)
+		}
+	}
+	opt.GOP = opt.FPS
+	if v := q.Get("gop"); v != "" {
+		if opt.GOP, err = strconv.Atoi(v); err != nil || opt.GOP < 1 {
+			return Options{}, errors.New("testsrc gop 必须大于 0：" + v)
+		}
+	}
+	return opt, nil
+}
+
+// OpenFLV 打开 testsrc:// 上游，返回按实时速度输出的 FLV 字节流，关闭返回的 ReadCloser 后停止生成
+func OpenFLV(rawURL string) (io.ReadCloser, error) {
+	opt, err := ParseOptions(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(Generate(pw, opt, true))
+	}()
+	return pr, nil
+}
+
+// Generate 向 w 写出测试流，直到写入失败；realtime 为 false 时不等待，尽快写出（用于测试）
+func Generate(w io.Writer, opt Options, realtime bool) error {
+	sps, pps := buildSPS(), buildPPS()
+	avcConfig, err := av.BuildAVCConfig(sps, pps)
+	if err != nil {
+		return err
+	}
+
+	head := av.FLVHeader(true, opt.Audio)
+	head = append(head, av.FLVTagBytes(av.FLVTagScript, 0, onMetaData(opt))...)
+	head = append(head, muxTag(&av.Packet{Type: av.PacketVideo, Codec: av.CodecH264, IsSequenceHeader: true, Data: avcConfig})...)
+	if opt.Audio {
+		head = append(head, muxTag(&av.Packet{Type: av.PacketAudio, Codec: av.CodecAAC, IsSequenceHeader: true, Data: audioSpecificConfig})...)
+	}
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+
+	// 两个 IDR 交替使用 idr_pic_id，P 帧按 frame_num 取模复用
+	idr := [2][]byte{av.JoinAVCC([][]byte{buildIDR(0)}), av.JoinAVCC([][]byte{buildIDR(1)})}
+	pFrames := make([][]byte, 1<<log2MaxFrameNum)
+	for i := range pFrames {
+		pFrames[i] = av.JoinAVCC([][]byte{buildPSkip(uint(i))})
+	}
+
+	start := time.Now()
+	videoFrame, audioFrame := 0, 0
+	for {
+		videoDTS := time.Duration(videoFrame) * time.Second / time.Duration(opt.FPS)
+		audioDTS := time.Duration(audioFrame) * audioFrameSize * time.Second / audioSampleRate
+
+		// 音视频按时间戳交织输出
+		var pkt *av.Packet
+		if opt.Audio && audioDTS < videoDTS {
+			pkt = &av.Packet{Type: av.PacketAudio, Codec: av.CodecAAC, DTS: audioDTS, IsKeyFrame: true, Data: silentAACFrame}
+			audioFrame++
+		} else {
+			n := videoFrame % opt.GOP
+			pkt = &av.Packet{Type: av.PacketVideo, Codec: av.CodecH264, DTS: videoDTS, IsKeyFrame: n == 0}
+			if n == 0 {
+				pkt.Data = idr[(videoFrame/opt.GOP)%2]
+			} else {
+				pkt.Data = pFrames[n%len(pFrames)]
+			}
+			videoFrame++
+		}
+
+		if realtime {
+			if wait := time.Until(start.Add(pkt.DTS)); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		if _, err := w.Write(muxTag(pkt)); err != nil {
+			return err
+		}
+	}
+}
+
+// muxTag 把帧封装成完整的 FLV tag
+func muxTag(pkt *av.Packet) []byte {
+	tagType, timestamp, data, _ := av.MuxFLVTag(pkt)
+	return av.FLVTagBytes(tagType, timestamp, data)
+}
+
+// amfProperty onMetaData 中的一个属性，value 为 float64、bool 或 string
+type amfProperty struct {
+	key   string
+	value any
+}
+
+// onMetaData 生成 script tag 的内容（AMF0）
+func onMetaData(opt Options) []byte {
+	props := []amfProperty{
+		{"width", float64(videoWidth)},
+		{"height", float64(videoHeight)},
+		{"framerate", float64(opt.FPS)},
+		{"videocodecid", float64(7)},
+	}
+	if opt.Audio {
+		props = append(props,
+			amfProperty{"audiocodecid", float64(10)},
+			amfProperty{"audiosamplerate", float64(audioSampleRate)},
+			amfProperty{"stereo", true},
+		)
+	}
+	props = append(props, amfProperty{"encoder", "pull2push testsrc"})
+
+	buf := amfString(nil, "onMetaData")
+	buf = append(buf, 0x08) // ECMA array
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(props)))
+	for _, p := range props {
+		buf = amfKey(buf, p.key)
+		switch v := p.value.(type) {
+		case float64:
+			buf = append(buf, 0x00)
+			buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(v))
+		case bool:
+			b := byte(0)
+			if v {
+				b = 1
+			}
+			buf = append(buf, 0x01, b)
+		case string:
+			buf = amfString(buf, v)
+		}
+	}
+	return append(buf, 0x00, 0x00, 0x09) // object end
+}
+
+func amfKey(buf []byte, key string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(key)))
+	return append(buf, key...)
+}
+
+func amfString(buf []byte, s string) []byte {
+	buf = append(buf, 0x02)
+	return amfKey(buf, s)
+}
@@ -0,0 +1,211 @@
+package testsrc
+
+import (
+	"bytes"
+	"io"
+	"pull2push/core/media/av"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    Options
+		wantErr bool
+	}{
+		{"testsrc://", Options{FPS: 25, GOP: 25, Audio: true}, false},
+		{"testsrc://?fps=30&gop=60&audio=0", Options{FPS: 30, GOP: 60, Audio: false}, false},
+		{"testsrc://?fps=10", Options{FPS: 10, GOP: 10, Audio: true}, false},
+		{"testsrc://?fps=0", Options{}, true},
+		{"testsrc://?fps=61", Options{}, true},
+		{"testsrc://?fps=abc", Options{}, true},
+		{"testsrc://?gop=0", Options{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseOptions(tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseOptions(%q) err = %v, wantErr %v", tt.url, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseOptions(%q) = %+v, want %+v", tt.url, got, tt.want)
+		}
+	}
+}
+
+// limitWriter 写满 n 字节后返回错误，让 Generate 退出
+type limitWriter struct {
+	buf bytes.Buffer
+	n   int
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.buf.Len() >= w.n {
+		return 0, io.ErrShortWrite
+	}
+	return w.buf.Write(p)
+}
+
+// readTag 读取下一个 FLV tag（连同后面的 PreviousTagSize），校验 PreviousTagSize
+func readTag(t *testing.T, r io.Reader) (tagType uint8, timestamp uint32, data []byte, ok bool) {
+	t.Helper()
+	var header [11]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, nil, false
+	}
+	size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+	timestamp = uint32(header[7])<<24 | uint32(header[4])<<16 | uint32(header[5])<<8 | uint32(header[6])
+	data = make([]byte, size+4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, 0, nil, false
+	}
+	if prev := int(data[size])<<24 | int(data[size+1])<<16 | int(data[size+2])<<8 | int(data[size+3]); prev != size+11 {
+		t.Fatalf("PreviousTagSize = %d, want %d", prev, size+11)
+	}
+	return header[0], timestamp, data[:size], true
+}
+
+// TestGenerate 生成的 FLV：onMetaData 和序列头在最前，关键帧间隔等于 GOP，音视频时间戳正确并按时间交织
+func TestGenerate(t *testing.T) {
+	opt := Options{FPS: 30, GOP: 15, Audio: true}
+	w := &limitWriter{n: 2 * 1024 * 1024}
+	if err := Generate(w, opt, false); err != io.ErrShortWrite {
+		t.Fatal(err)
+	}
+
+	data := w.buf.Bytes()
+	if string(data[:3]) != "FLV" || data[4] != 0x05 {
+		t.Fatalf("FLV header = %x, want audio and video", data[:9])
+	}
+	r := bytes.NewReader(data[13:])
+
+	tagType, _, _, ok := readTag(t, r)
+	if !ok || tagType != av.FLVTagScript {
+		t.Fatalf("first tag type = %d, want onMetaData", tagType)
+	}
+
+	var videoFrames, audioFrames int
+	var sawVideoConfig, sawAudioConfig bool
+	var lastTimestamp uint32
+	for {
+		tagType, timestamp, tagData, ok := readTag(t, r)
+		if !ok {
+			break
+		}
+		pkt, ok := av.DemuxFLVTag(tagType, timestamp, tagData)
+		if !ok {
+			t.Fatalf("unexpected tag type %d", tagType)
+		}
+		if timestamp < lastTimestamp {
+			t.Fatalf("timestamp went back: %d < %d", timestamp, lastTimestamp)
+		}
+		lastTimestamp = timestamp
+
+		switch {
+		case pkt.IsSequenceHeader && pkt.Type == av.PacketVideo:
+			cfg, err := av.ParseAVCConfig(pkt.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			width, height, err := av.ParseH264SPSSize(cfg.SPS[0])
+			if err != nil || width != 320 || height != 240 {
+				t.Fatalf("SPS size = %dx%d, %v, want 320x240", width, height, err)
+			}
+			sawVideoConfig = true
+		case pkt.IsSequenceHeader:
+			if _, err := av.ParseAACConfig(pkt.Data); err != nil {
+				t.Fatal(err)
+			}
+			sawAudioConfig = true
+		case !sawVideoConfig || !sawAudioConfig:
+			t.Fatal("frame before sequence headers")
+		case pkt.Type == av.PacketVideo:
+			if want := uint32(videoFrames * 1000 / opt.FPS); timestamp != want {
+				t.Fatalf("video frame %d at %dms, want %dms", videoFrames, timestamp, want)
+			}
+			if pkt.IsKeyFrame != (videoFrames%opt.GOP == 0) {
+				t.Fatalf("video frame %d key frame = %v, GOP %d", videoFrames, pkt.IsKeyFrame, opt.GOP)
+			}
+			nalus, err := av.SplitAVCC(pkt.Data, 4)
+			if err != nil || len(nalus) != 1 {
+				t.Fatalf("video frame %d: %d NALUs, %v", videoFrames, len(nalus), err)
+			}
+			if isIDR := av.H264NALType(nalus[0]) == av.H264NALIDR; isIDR != pkt.IsKeyFrame {
+				t.Fatalf("video frame %d: NAL type %d, key frame %v", videoFrames, av.H264NALType(nalus[0]), pkt.IsKeyFrame)
+			}
+			videoFrames++
+		default:
+			if want := uint32(audioFrames * audioFrameSize * 1000 / audioSampleRate); timestamp != want {
+				t.Fatalf("audio frame %d at %dms, want %dms", audioFrames, timestamp, want)
+			}
+			audioFrames++
+		}
+	}
+
+	if videoFrames < 2*opt.GOP {
+		t.Fatalf("only %d video frames", videoFrames)
+	}
+	// 音视频交织输出，两者的时长相差不到一帧
+	videoDur := time.Duration(videoFrames) * time.Second / time.Duration(opt.FPS)
+	audioDur := time.Duration(audioFrames) * audioFrameSize * time.Second / audioSampleRate
+	if diff := videoDur - audioDur; diff < -time.Second/time.Duration(opt.FPS) || diff > 2*audioFrameSize*time.Second/audioSampleRate {
+		t.Fatalf("video %v, audio %v: not interleaved", videoDur, audioDur)
+	}
+}
+
+// TestGenerateNoAudio audio=0 时只有视频
+func TestGenerateNoAudio(t *testing.T) {
+	w := &limitWriter{n: 512 * 1024}
+	_ = Generate(w, Options{FPS: 25, GOP: 25}, false)
+
+	data := w.buf.Bytes()
+	if data[4] != 0x01 {
+		t.Fatalf("FLV flags = %x, want video only", data[4])
+	}
+	r := bytes.NewReader(data[13:])
+	for {
+		tagType, _, _, ok := readTag(t, r)
+		if !ok {
+			break
+		}
+		if tagType == av.FLVTagAudio {
+			t.Fatal("audio tag with audio=0")
+		}
+	}
+}
+
+// TestOpenFLVRealtime 按实时速度输出，关闭后停止生成
+func TestOpenFLVRealtime(t *testing.T) {
+	src, err := OpenFLV("testsrc://?fps=10&audio=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	var header [13]byte
+	if _, err := io.ReadFull(src, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for {
+		tagType, timestamp, _, ok := readTag(t, src)
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		if tagType == av.FLVTagVideo && timestamp >= 500 {
+			break
+		}
+	}
+	// 第一帧在 0ms 立即输出，500ms 的帧要等到开始后 500ms
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 5*time.Second {
+		t.Fatalf("frame at 500ms read after %v, want realtime", elapsed)
+	}
+
+	if err := src.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Read(make([]byte, 1)); err != io.ErrClosedPipe {
+		t.Fatalf("read after Close: %v, want io.ErrClosedPipe", err)
+	}
+}
@@ -0,0 +1,283 @@
+package ts
+
+import (
+	"bytes"
+	"errors"
+	"pull2push/core/media/av"
+	"time"
+)
+
+/*
+MPEG-TS 解封装器
+	解析 PAT/PMT，按 PID 组装 PES，把 H.264（Annex B）和 AAC（ADTS）还原成 av.Packet。
+	视频首次出现或 SPS/PPS 变化时先输出一个序列头（AVCDecoderConfigurationRecord），
+	音频配置变化时输出 AudioSpecificConfig 序列头，下游可以直接按 FLV 的习惯处理。
+*/
+
+// PacketHandler 解出一个音视频帧时回调
+type PacketHandler func(pkt *av.Packet)
+
+// Demuxer TS 解封装器，非并发安全
+type Demuxer struct {
+	onPacket PacketHandler
+
+	buf     []byte // 不足一个 TS 包的剩余数据
+	pmtPID  int
+	streams map[uint16]*pesStream
+
+	avcRecord []byte
+	aac       *av.AACConfig
+}
+
+type pesStream struct {
+	streamType byte
+	buf        bytes.Buffer
+	started    bool
+}
+
+// NewDemuxer 创建 TS 解封装器
+func NewDemuxer(onPacket PacketHandler) *Demuxer {
+	return &Demuxer{onPacket: onPacket, pmtPID: -1, streams: make(map[uint16]*pesStream)}
+}
+
+// Write 写入任意长度的 TS 数据，内部按 188 字节对齐
+func (d *Demuxer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(d.buf) > 0 {
+		p = append(d.buf, p...)
+		d.buf = nil
+	}
+	for len(p) >= PacketSize {
+		if p[0] != 0x47 {
+			// 丢失同步，找下一个同步字节
+			i := bytes.IndexByte(p[1:], 0x47)
+			if i < 0 {
+				p = nil
+				break
+			}
+			p = p[i+1:]
+			continue
+		}
+		d.parsePacket(p[:PacketSize])
+		p = p[PacketSize:]
+	}
+	if len(p) > 0 {
+		d.buf = append([]byte(nil), p...)
+	}
+	return n, nil
+}
+
+// Streams PMT 中是否有视频（H.264）、音频（AAC），PMT 解析出来之前都为 false
+func (d *Demuxer) Streams() (hasVideo, hasAudio bool) {
+	for _, s := range d.streams {
+		switch s.streamType {
+		case StreamTypeH264:
+			hasVideo = true
+		case StreamTypeAAC:
+			hasAudio = true
+		}
+	}
+	return hasVideo, hasAudio
+}
+
+// Flush 输出各 PID 尚未结束的 PES（一个分片结束或流结束时调用）
+func (d *Demuxer) Flush() {
+	for _, s := range d.streams {
+		d.flushPES(s)
+	}
+}
+
+func (d *Demuxer) parsePacket(pkt []byte) {
+	pusi := pkt[1]&0x40 != 0
+	pid := uint16(pkt[1]&0x1F)<<8 | uint16(pkt[2])
+	afc := (pkt[3] >> 4) & 0x03
+
+	payload := pkt[4:]
+	if afc == 0x02 || afc == 0x03 {
+		afLen := int(pkt[4])
+		if 5+afLen > PacketSize {
+			return
+		}
+		payload = pkt[5+afLen:]
+	}
+	if afc == 0x02 || len(payload) == 0 {
+		return
+	}
+
+	switch {
+	case pid == PIDPAT:
+		d.parsePAT(payload, pusi)
+	case int(pid) == d.pmtPID:
+		d.parsePMT(payload, pusi)
+	default:
+		s, ok := d.streams[pid]
+		if !ok {
+			return
+		}
+		if pusi {
+			d.flushPES(s)
+			s.started = true
+		}
+		if s.started {
+			s.buf.Write(payload)
+		}
+	}
+}
+
+// psiSection 去掉 pointer_field，返回完整的 PSI 段
+func psiSection(payload []byte, pusi bool) ([]byte, error) {
+	if !pusi {
+		return nil, errors.New("不支持跨包的 PSI 段")
+	}
+	pointer := int(payload[0])
+	if 1+pointer+3 > len(payload) {
+		return nil, errors.New("PSI 段越界")
+	}
+	section := payload[1+pointer:]
+	length := int(section[1]&0x0F)<<8 | int(section[2])
+	if 3+length > len(section) {
+		return nil, errors.New("PSI 段越界")
+	}
+	return section[:3+length], nil
+}
+
+func (d *Demuxer) parsePAT(payload []byte, pusi bool) {
+	section, err := psiSection(payload, pusi)
+	if err != nil || len(section) < 12 {
+		return
+	}
+	// 跳过 8 字节头，最后 4 字节为 CRC
+	for pos := 8; pos+4 <= len(section)-4; pos += 4 {
+		program := uint16(section[pos])<<8 | uint16(section[pos+1])
+		pid := int(section[pos+2]&0x1F)<<8 | int(section[pos+3])
+		if program != 0 {
+			d.pmtPID = pid
+			return
+		}
+	}
+}
+
+func (d *Demuxer) parsePMT(payload []byte, pusi bool) {
+	section, err := psiSection(payload, pusi)
+	if err != nil || len(section) < 16 {
+		return
+	}
+	infoLen := int(section[10]&0x0F)<<8 | int(section[11])
+	pos := 12 + infoLen
+	end := len(section) - 4
+	for pos+5 <= end {
+		streamType := section[pos]
+		pid := uint16(section[pos+1]&0x1F)<<8 | uint16(section[pos+2])
+		esInfoLen := int(section[pos+3]&0x0F)<<8 | int(section[pos+4])
+		pos += 5 + esInfoLen
+		if streamType != StreamTypeH264 && streamType != StreamTypeAAC {
+			continue
+		}
+		if _, ok := d.streams[pid]; !ok {
+			d.streams[pid] = &pesStream{streamType: streamType}
+		}
+	}
+}
+
+// parseTimestamp 解析 PES 头中 5 字节的 33 位时间戳
+func parseTimestamp(b []byte) time.Duration {
+	ts := uint64(b[0]>>1&0x07)<<30 | uint64(b[1])<<22 | uint64(b[2]>>1)<<15 | uint64(b[3])<<7 | uint64(b[4]>>1)
+	return time.Duration(ts) * time.Millisecond / 90
+}
+
+func (d *Demuxer) flushPES(s *pesStream) {
+	if !s.started || s.buf.Len() == 0 {
+		return
+	}
+	pes := s.buf.Bytes()
+	defer s.buf.Reset()
+	s.started = false
+
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return
+	}
+	flags := pes[7] >> 6
+	headerLen := int(pes[8])
+	if 9+headerLen > len(pes) {
+		return
+	}
+	var pts, dts time.Duration
+	if flags&0x02 != 0 && headerLen >= 5 {
+		pts = parseTimestamp(pes[9:])
+		dts = pts
+	}
+	if flags == 0x03 && headerLen >= 10 {
+		dts = parseTimestamp(pes[14:])
+	}
+	payload := pes[9+headerLen:]
+
+	switch s.streamType {
+	case StreamTypeH264:
+		d.demuxH264(payload, pts, dts)
+	case StreamTypeAAC:
+		d.demuxAAC(payload, pts)
+	}
+}
+
+func (d *Demuxer) demuxH264(payload []byte, pts, dts time.Duration) {
+	var sps, pps []byte
+	var nalus [][]byte
+	key := false
+	for _, n := range av.SplitAnnexB(payload) {
+		switch av.H264NALType(n) {
+		case av.H264NALSPS:
+			sps = n
+		case av.H264NALPPS:
+			pps = n
+		case av.H264NALAUD:
+		case av.H264NALIDR:
+			key = true
+			nalus = append(nalus, n)
+		default:
+			nalus = append(nalus, n)
+		}
+	}
+	if sps != nil && pps != nil {
+		if record, err := av.BuildAVCConfig(sps, pps); err == nil && !bytes.Equal(record, d.avcRecord) {
+			d.avcRecord = record
+			d.emit(&av.Packet{Type: av.PacketVideo, Codec: av.CodecH264, DTS: dts, IsKeyFrame: true, IsSequenceHeader: true, Data: record})
+		}
+	}
+	if d.avcRecord == nil || len(nalus) == 0 {
+		// 还没拿到参数集的帧无法解码，直接丢弃
+		return
+	}
+	d.emit(&av.Packet{
+		Type:       av.PacketVideo,
+		Codec:      av.CodecH264,
+		DTS:        dts,
+		CTS:        pts - dts,
+		IsKeyFrame: key,
+		Data:       av.JoinAVCC(nalus),
+	})
+}
+
+func (d *Demuxer) demuxAAC(payload []byte, pts time.Duration) {
+	frames, _ := av.SplitADTS(payload)
+	for i, f := range frames {
+		if d.aac == nil || *d.aac != *f.Config {
+			d.aac = f.Config
+			d.emit(&av.Packet{Type: av.PacketAudio, Codec: av.CodecAAC, DTS: pts, IsKeyFrame: true, IsSequenceHeader: true, Data: f.Config.Bytes()})
+		}
+		// 一个 PES 里可能有多个 ADTS 帧，每帧 1024 个采样
+		offset := time.Duration(i) * 1024 * time.Second / time.Duration(f.Config.SampleRate)
+		d.emit(&av.Packet{
+			Type:       av.PacketAudio,
+			Codec:      av.CodecAAC,
+			DTS:        pts + offset,
+			IsKeyFrame: true,
+			Data:       append([]byte(nil), f.Data...), // PES 缓冲区会被复用
+		})
+	}
+}
+
+func (d *Demuxer) emit(pkt *av.Packet) {
+	if d.onPacket != nil {
+		d.onPacket(pkt)
+	}
+}
@@ -0,0 +1,143 @@
+package ts
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"pull2push/core/media/av"
+	"time"
+)
+
+// NewFLVReader 把 MPEG-TS 字节流转换成 FLV 字节流
+//
+//	SRT、HTTP 推上来的 TS 经过它之后，就可以交给 CameraBroadcaster 按 FLV tag 解析、缓存和分发。
+//	FLV 头在 PMT 解析出来之后按实际的音视频写出，时间戳以第一个帧的 DTS 为 0 点。
+//	src 读完或出错时返回的 Reader 读到 EOF 或同样的错误；关闭返回的 Reader 后转换协程在下一次写入时退出。
+func NewFLVReader(src io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(copyTSAsFLV(pw, src))
+	}()
+	return pr
+}
+
+// copyTSAsFLV 读取 src 中的 TS，解出的帧逐个写成 FLV tag
+func copyTSAsFLV(w io.Writer, src io.Reader) error {
+	var (
+		writeErr   error
+		headerSent bool
+		base       time.Duration
+		demuxer    *Demuxer
+	)
+	demuxer = NewDemuxer(func(pkt *av.Packet) {
+		if writeErr != nil {
+			return
+		}
+		if !headerSent {
+			hasVideo, hasAudio := demuxer.Streams()
+			if _, writeErr = w.Write(av.FLVHeader(hasVideo, hasAudio)); writeErr != nil {
+				return
+			}
+			headerSent = true
+			base = pkt.DTS
+		}
+
+		p := *pkt
+		p.DTS -= base
+		if p.DTS < 0 {
+			p.DTS = 0
+		}
+		tagType, timestamp, data, ok := av.MuxFLVTag(&p)
+		if !ok {
+			return
+		}
+		_, writeErr = w.Write(av.FLVTagBytes(tagType, timestamp, data))
+	})
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			_, _ = demuxer.Write(buf[:n])
+			if writeErr != nil {
+				return writeErr
+			}
+		}
+		if err != nil {
+			demuxer.Flush()
+			if writeErr != nil {
+				return writeErr
+			}
+			return err
+		}
+	}
+}
+
+// CopyFLVAsTS 把 FLV 字节流转换成连续的 MPEG-TS 写入 w，直到 src 读完或 w 写入失败
+//
+//	用于给 IPTV 机顶盒等只认 TS 的播放端输出直播。src 可以不带 FLV 头（中途加入的观众只收到 tag）。
+//	从第一个关键帧（纯音频时为第一个音频帧）开始输出，每个关键帧前重发 PAT/PMT，播放端中途接入也能同步。
+//	每个帧封装完成后一次写入 w，w 需要时可以在 Write 里 Flush。
+func CopyFLVAsTS(w io.Writer, src io.Reader) error {
+	r := bufio.NewReaderSize(src, 64*1024)
+	if head, err := r.Peek(3); err != nil {
+		return err
+	} else if string(head) == "FLV" {
+		// FLV 头 9 字节 + PreviousTagSize0
+		if _, err := r.Discard(9 + 4); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	muxer := NewMuxer(&buf)
+	started := false
+	var tablesAt time.Duration
+	tagHeader := make([]byte, 11)
+	for {
+		if _, err := io.ReadFull(r, tagHeader); err != nil {
+			return err
+		}
+		tagType := tagHeader[0] & 0x1F
+		dataSize := uint32(tagHeader[1])<<16 | uint32(tagHeader[2])<<8 | uint32(tagHeader[3])
+		timestamp := uint32(tagHeader[7])<<24 | uint32(tagHeader[4])<<16 | uint32(tagHeader[5])<<8 | uint32(tagHeader[6])
+		data := make([]byte, dataSize+4) // 连同 PreviousTagSize 一起读出
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+
+		pkt, ok := av.DemuxFLVTag(tagType, timestamp, data[:dataSize])
+		if !ok {
+			continue
+		}
+		if pkt.IsSequenceHeader {
+			_ = muxer.WritePacket(pkt)
+			continue
+		}
+
+		boundary := false
+		if muxer.HasVideo() {
+			boundary = pkt.Type == av.PacketVideo && pkt.IsKeyFrame
+		} else if muxer.HasAudio() {
+			// 纯音频没有关键帧，每秒重发一次 PAT/PMT
+			boundary = pkt.Type == av.PacketAudio && (!started || pkt.DTS-tablesAt >= time.Second)
+		}
+		if !started && !boundary {
+			continue
+		}
+		buf.Reset()
+		if boundary {
+			_ = muxer.WriteTables()
+			tablesAt = pkt.DTS
+			started = true
+		}
+		// 缺少对应的序列头时丢弃这一帧，已经写出的 PAT/PMT 照常输出
+		_ = muxer.WritePacket(pkt)
+		if buf.Len() == 0 {
+			continue
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package ts
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"pull2push/core/media/av"
+	"time"
+)
+
+/*
+MPEG-TS 封装器
+	把 av.Packet 封装成 188 字节的 TS 包：PAT/PMT + 视频 PES（H.264/H.265 Annex B）+ 音频 PES（AAC ADTS）。
+	视频 PID 同时承载 PCR。关键帧前插入 AUD 和参数集，保证每个分片都能独立解码。
+*/
+
+const (
+	PacketSize = 188
+
+	PIDPAT   = 0x0000
+	PIDPMT   = 0x1000
+	PIDVideo = 0x0100
+	PIDAudio = 0x0101
+
+	StreamTypeH264 = 0x1B
+	StreamTypeH265 = 0x24
+	StreamTypeAAC  = 0x0F
+
+	streamIDVideo = 0xE0
+	streamIDAudio = 0xC0
+)
+
+var (
+	h264AUD = []byte{0x09, 0xF0}
+	h265AUD = []byte{0x46, 0x01, 0x50}
+)
+
+// Muxer TS 封装器，非并发安全
+type Muxer struct {
+	w  io.Writer
+	cc map[uint16]uint8 // 每个 PID 的 continuity_counter
+
+	videoCodec av.Codec
+	avc        *av.AVCConfig
+	hevc       *av.HEVCConfig
+	aac        *av.AACConfig
+}
+
+// NewMuxer 创建 TS 封装器
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{w: w, cc: make(map[uint16]uint8)}
+}
+
+// SetWriter 切换输出（切片时每个分片一个缓冲区）
+func (m *Muxer) SetWriter(w io.Writer) {
+	m.w = w
+}
+
+// HasVideo 是否已收到视频序列头
+func (m *Muxer) HasVideo() bool {
+	return m.avc != nil || m.hevc != nil
+}
+
+// HasAudio 是否已收到音频序列头
+func (m *Muxer) HasAudio() bool {
+	return m.aac != nil
+}
+
+// WriteTables 写出 PAT 和 PMT
+func (m *Muxer) WriteTables() error {
+	pat := []byte{
+		0x00,       // table_id
+		0xB0, 0x0D, // section_syntax_indicator + section_length = 13
+		0x00, 0x01, // transport_stream_id
+		0xC1,       // version 0, current_next 1
+		0x00, 0x00, // section_number, last_section_number
+		0x00, 0x01, // program_number
+		0xE0 | PIDPMT>>8, PIDPMT & 0xFF,
+	}
+	if err := m.writeSection(PIDPAT, pat); err != nil {
+		return err
+	}
+
+	type stream struct {
+		typ byte
+		pid uint16
+	}
+	var streams []stream
+	if m.avc != nil {
+		streams = append(streams, stream{StreamTypeH264, PIDVideo})
+	} else if m.hevc != nil {
+		streams = append(streams, stream{StreamTypeH265, PIDVideo})
+	}
+	if m.aac != nil {
+		streams = append(streams, stream{StreamTypeAAC, PIDAudio})
+	}
+	pcrPID := uint16(PIDVideo)
+	if !m.HasVideo() {
+		pcrPID = PIDAudio
+	}
+	sectionLen := 9 + 5*len(streams) + 4
+	pmt := []byte{
+		0x02,
+		0xB0 | byte(sectionLen>>8), byte(sectionLen),
+		0x00, 0x01, // program_number
+		0xC1,
+		0x00, 0x00,
+		0xE0 | byte(pcrPID>>8), byte(pcrPID),
+		0xF0, 0x00, // program_info_length = 0
+	}
+	for _, s := range streams {
+		pmt = append(pmt, s.typ, 0xE0|byte(s.pid>>8), byte(s.pid), 0xF0, 0x00)
+	}
+	return m.writeSection(PIDPMT, pmt)
+}
+
+// writeSection 把一个 PSI 段（不含 CRC）封装成一个 TS 包
+func (m *Muxer) writeSection(pid uint16, section []byte) error {
+	section = binary.BigEndian.AppendUint32(section, crc32MPEG2(section))
+	pkt := make([]byte, PacketSize)
+	pkt[0] = 0x47
+	pkt[1] = 0x40 | byte(pid>>8)
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10 | m.nextCC(pid)
+	pkt[4] = 0x00 // pointer_field
+	n := copy(pkt[5:], section)
+	for i := 5 + n; i < PacketSize; i++ {
+		pkt[i] = 0xFF
+	}
+	_, err := m.w.Write(pkt)
+	return err
+}
+
+func (m *Muxer) nextCC(pid uint16) byte {
+	c := m.cc[pid]
+	m.cc[pid] = (c + 1) & 0x0F
+	return c
+}
+
+// WritePacket 封装一个音视频帧；序列头只更新编码配置，不产生输出
+func (m *Muxer) WritePacket(pkt *av.Packet) error {
+	if pkt.IsSequenceHeader {
+		return m.updateConfig(pkt)
+	}
+	switch pkt.Type {
+	case av.PacketVideo:
+		payload, err := m.videoPayload(pkt)
+		if err != nil {
+			return err
+		}
+		return m.writePES(PIDVideo, streamIDVideo, pkt.PTS(), pkt.DTS, true, payload, pkt.IsKeyFrame, true)
+	case av.PacketAudio:
+		if m.aac == nil {
+			return errors.New("缺少 AAC 序列头")
+		}
+		payload := append(m.aac.ADTSHeader(len(pkt.Data)), pkt.Data...)
+		return m.writePES(PIDAudio, streamIDAudio, pkt.DTS, pkt.DTS, false, payload, true, !m.HasVideo())
+	}
+	return nil
+}
+
+func (m *Muxer) updateConfig(pkt *av.Packet) error {
+	var err error
+	switch pkt.Codec {
+	case av.CodecH264:
+		m.videoCodec = av.CodecH264
+		m.avc, err = av.ParseAVCConfig(pkt.Data)
+	case av.CodecH265:
+		m.videoCodec = av.CodecH265
+		m.hevc, err = av.ParseHEVCConfig(pkt.Data)
+	case av.CodecAAC:
+		m.aac, err = av.ParseAACConfig(pkt.Data)
+	}
+	return err
+}
+
+// videoPayload AVCC -> Annex B，插入 AUD，关键帧前补齐参数集
+func (m *Muxer) videoPayload(pkt *av.Packet) ([]byte, error) {
+	var lengthSize int
+	var aud []byte
+	var params [][]byte
+	isParam := func(nalu []byte) bool { return false }
+	isAUD := func(nalu []byte) bool { return false }
+
+	switch {
+	case pkt.Codec == av.CodecH264 && m.avc != nil:
+		lengthSize = m.avc.LengthSize
+		aud = h264AUD
+		params = append(append(params, m.avc.SPS...), m.avc.PPS...)
+		isParam = func(n []byte) bool { t := av.H264NALType(n); return t == av.H264NALSPS || t == av.H264NALPPS }
+		isAUD = func(n []byte) bool { return av.H264NALType(n) == av.H264NALAUD }
+	case pkt.Codec == av.CodecH265 && m.hevc != nil:
+		lengthSize = m.hevc.LengthSize
+		aud = h265AUD
+		params = append(append(append(params, m.hevc.VPS...), m.hevc.SPS...), m.hevc.PPS...)
+		isParam = func(n []byte) bool {
+			t := av.H265NALType(n)
+			return t == av.H265NALVPS || t == av.H265NALSPS || t == av.H265NALPPS
+		}
+		isAUD = func(n []byte) bool { return av.H265NALType(n) == av.H265NALAUD }
+	default:
+		return nil, errors.New("缺少视频序列头")
+	}
+
+	nalus, err := av.SplitAVCC(pkt.Data, lengthSize)
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]byte, 0, len(nalus)+len(params)+1)
+	out = append(out, aud)
+	if pkt.IsKeyFrame {
+		hasParam := false
+		for _, n := range nalus {
+			if isParam(n) {
+				hasParam = true
+				break
+			}
+		}
+		if !hasParam {
+			out = append(out, params...)
+		}
+	}
+	for _, n := range nalus {
+		if !isAUD(n) {
+			out = append(out, n)
+		}
+	}
+	return av.JoinAnnexB(out), nil
+}
+
+// toTS90k 转换为 90kHz 时钟，截断为 33 位
+func toTS90k(d time.Duration) uint64 {
+	return uint64(d.Milliseconds()*90) & 0x1FFFFFFFF
+}
+
+func appendTimestamp(b []byte, prefix byte, ts uint64) []byte {
+	return append(b,
+		prefix<<4|byte(ts>>29)&0x0E|0x01,
+		byte(ts>>22),
+		byte(ts>>14)&0xFE|0x01,
+		byte(ts>>7),
+		byte(ts<<1)|0x01,
+	)
+}
+
+// writePES 把一个 PES 包拆分成若干 TS 包写出
+func (m *Muxer) writePES(pid uint16, streamID byte, pts, dts time.Duration, withDTS bool, payload []byte, key, withPCR bool) error {
+	pts90, dts90 := toTS90k(pts), toTS90k(dts)
+	if pts90 == dts90 {
+		withDTS = false
+	}
+
+	header := []byte{0x00, 0x00, 0x01, streamID, 0x00, 0x00, 0x80}
+	if withDTS {
+		header = append(header, 0xC0, 10)
+		header = appendTimestamp(header, 0x03, pts90)
+		header = appendTimestamp(header, 0x01, dts90)
+	} else {
+		header = append(header, 0x80, 5)
+		header = appendTimestamp(header, 0x02, pts90)
+	}
+	pesLen := len(header) - 6 + len(payload)
+	if pesLen <= 0xFFFF {
+		binary.BigEndian.PutUint16(header[4:], uint16(pesLen))
+	}
+	pes := append(header, payload...)
+
+	first := true
+	for len(pes) > 0 {
+		pkt := make([]byte, PacketSize)
+		pkt[0] = 0x47
+		pkt[1] = byte(pid >> 8)
+		if first {
+			pkt[1] |= 0x40 // payload_unit_start_indicator
+		}
+		pkt[2] = byte(pid)
+
+		// 自适应字段：首包携带随机访问标记和 PCR，末包用于填充
+		var af []byte
+		if first && (withPCR || key) {
+			flags := byte(0)
+			if key {
+				flags |= 0x40
+			}
+			af = append(af, flags)
+			if withPCR {
+				af[0] |= 0x10
+				pcr := dts90
+				af = append(af, byte(pcr>>25), byte(pcr>>17), byte(pcr>>9), byte(pcr>>1), byte(pcr<<7)|0x7E, 0x00)
+			}
+		}
+		space := PacketSize - 4
+		if af != nil {
+			space -= 1 + len(af)
+		}
+		if len(pes) < space {
+			stuffing := space - len(pes)
+			if af == nil {
+				// 自适应字段长度字节本身占 1 字节
+				if stuffing > 1 {
+					af = append(af, 0x00)
+					for i := 0; i < stuffing-2; i++ {
+						af = append(af, 0xFF)
+					}
+				} else {
+					af = []byte{}
+				}
+			} else {
+				for i := 0; i < stuffing; i++ {
+					af = append(af, 0xFF)
+				}
+			}
+		}
+
+		pos := 4
+		if af != nil {
+			pkt[3] = 0x30 | m.nextCC(pid)
+			pkt[4] = byte(len(af))
+			copy(pkt[5:], af)
+			pos = 5 + len(af)
+		} else {
+			pkt[3] = 0x10 | m.nextCC(pid)
+		}
+		n := copy(pkt[pos:], pes)
+		pes = pes[n:]
+		if _, err := m.w.Write(pkt); err != nil {
+			return err
+		}
+		first = false
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package ts
+
+import (
+	"bytes"
+	"pull2push/core/media/av"
+	"time"
+)
+
+// SegmentHandler 切出一个完整分片时回调，data 为完整的 TS 分片，dur 为分片时长
+type SegmentHandler func(data []byte, dur time.Duration)
+
+// Segmenter 把连续的音视频帧切成 HLS 用的 TS 分片
+//
+//	有视频时只在关键帧处切分，保证每个分片以关键帧开头；纯音频流按时长切分。
+//	非并发安全，同一路流由一个协程顺序写入。
+type Segmenter struct {
+	target    time.Duration
+	onSegment SegmentHandler
+
+	muxer    *Muxer
+	buf      *bytes.Buffer
+	started  bool
+	startDTS time.Duration
+	lastDTS  time.Duration
+}
+
+// NewSegmenter 创建切片器，target 为目标分片时长
+func NewSegmenter(target time.Duration, onSegment SegmentHandler) *Segmenter {
+	if target <= 0 {
+		target = 4 * time.Second
+	}
+	return &Segmenter{
+		target:    target,
+		onSegment: onSegment,
+		muxer:     NewMuxer(nil),
+	}
+}
+
+// WritePacket 写入一个音视频帧
+func (s *Segmenter) WritePacket(pkt *av.Packet) error {
+	if pkt.IsSequenceHeader {
+		return s.muxer.WritePacket(pkt)
+	}
+
+	// 可以作为分片起点的帧：有视频时为视频关键帧，纯音频时为任意音频帧
+	boundary := false
+	if s.muxer.HasVideo() {
+		boundary = pkt.Type == av.PacketVideo && pkt.IsKeyFrame
+	} else {
+		boundary = pkt.Type == av.PacketAudio && s.muxer.HasAudio()
+	}
+
+	if !s.started {
+		if !boundary {
+			// 等待第一个关键帧
+			return nil
+		}
+		s.startSegment(pkt.DTS)
+	} else if boundary && pkt.DTS-s.startDTS >= s.target {
+		s.flush(pkt.DTS - s.startDTS)
+		s.startSegment(pkt.DTS)
+	}
+
+	if err := s.muxer.WritePacket(pkt); err != nil {
+		return err
+	}
+	s.lastDTS = pkt.DTS
+	return nil
+}
+
+// Flush 推流结束时输出最后一个不完整的分片
+func (s *Segmenter) Flush() {
+	if !s.started {
+		return
+	}
+	dur := s.lastDTS - s.startDTS
+	if dur <= 0 {
+		dur = 40 * time.Millisecond
+	}
+	s.flush(dur)
+	s.started = false
+}
+
+func (s *Segmenter) startSegment(dts time.Duration) {
+	s.buf = &bytes.Buffer{}
+	s.muxer.SetWriter(s.buf)
+	_ = s.muxer.WriteTables()
+	s.startDTS = dts
+	s.started = true
+}
+
+func (s *Segmenter) flush(dur time.Duration) {
+	if s.buf == nil || s.buf.Len() == 0 {
+		return
+	}
+	if s.onSegment != nil {
+		s.onSegment(s.buf.Bytes(), dur)
+	}
+	s.buf = nil
+}
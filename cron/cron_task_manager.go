@@ -4,6 +4,7 @@ import (
 	"context"
 	"pull2push/event"
 	"pull2push/logger"
+	"pull2push/metrics"
 	"pull2push/resource"
 	"sync"
 	"time"
@@ -107,10 +108,14 @@ func (tm *CronTaskManager) subscribeToEvents() {
 	go func() {
 		for {
 			select {
-			case <-shutdownCh:
+			case e, ok := <-shutdownCh:
+				if !ok {
+					return
+				}
 				logger.Info("TaskManager received system shutdown event")
 				// 停止服务
 				tm.Stop()
+				e.Ack()
 			case <-tm.stopCh:
 				return
 			}
@@ -189,11 +194,15 @@ func (tm *CronTaskManager) executeTask(ctx context.Context, task CronTask) {
 
 	logger.Info("Executing task", "task", taskName)
 
-	// 执行任务
+	// 执行任务，耗时记录到 /metrics
+	start := time.Now()
 	err := task.Execute(taskCtx)
+	result := "ok"
 	if err != nil {
+		result = "error"
 		logger.Error("CronTask execution failed", "task", taskName, "error", err)
 	} else {
 		logger.Info("CronTask executed successfully", "task", taskName)
 	}
+	metrics.CronTaskSeconds.Observe(time.Since(start).Seconds(), taskName, result)
 }
@@ -0,0 +1,75 @@
+package cron
+
+import (
+	"context"
+	"pull2push/core/broadcast"
+	"pull2push/core/broker"
+	"pull2push/logger"
+	"time"
+)
+
+const (
+	defaultVODRetention      = 10 * time.Minute // 默认回放保留期
+	defaultVODExpireInterval = 30 * time.Second // 默认检查间隔
+)
+
+// VODExpireTask 直播结束后的回放保留期过期时释放缓存的分片：
+// 预先注册的直播间（如 test-camera、拉流转推）只清空回放分片，之后还能继续推流、重新拉流；推流时自动创建的直播间关闭并从 Broker 中移除
+type VODExpireTask struct {
+	brokers   []broker.Broker
+	retention time.Duration
+}
+
+// NewVODExpireTask 创建回放过期清理任务，retention 小于等于 0 时使用默认保留期
+func NewVODExpireTask(retention time.Duration, brokers ...broker.Broker) *VODExpireTask {
+	if retention <= 0 {
+		retention = defaultVODRetention
+	}
+	return &VODExpireTask{
+		brokers:   brokers,
+		retention: retention,
+	}
+}
+
+// Name 返回任务名称
+func (t *VODExpireTask) Name() string {
+	return "vod_expire_task"
+}
+
+// Execute 执行任务
+func (t *VODExpireTask) Execute(ctx context.Context) error {
+	now := time.Now()
+	for _, b := range t.brokers {
+		for key, bc := range b.ListBroadcaster() {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			replayable, ok := bc.(broadcast.Replayable)
+			if !ok {
+				continue
+			}
+			endedAt, ended := replayable.EndedAt()
+			if !ended || now.Sub(endedAt) < t.retention {
+				continue
+			}
+			if replayable.Configured() {
+				replayable.ResetReplay()
+				logger.Info("VOD retention expired, replay segments dropped", "broadcasterKey", key, "endedAt", endedAt)
+				continue
+			}
+			bc.Close(broadcast.BrokerClosed)
+			logger.Info("VOD retention expired, broadcaster closed", "broadcasterKey", key, "endedAt", endedAt)
+		}
+	}
+	return nil
+}
+
+// GetInterval 返回任务执行间隔
+func (t *VODExpireTask) GetInterval() time.Duration {
+	return defaultVODExpireInterval
+}
+
+// Enable 是否启用
+func (t *VODExpireTask) Enable() bool {
+	return len(t.brokers) > 0
+}
@@ -1,7 +1,10 @@
 package event
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type EventType string
@@ -10,70 +13,343 @@ type EventType string
 const (
 	SystemSetUp    EventType = "SystemSetUp"
 	SystemShutdown EventType = "SystemShutdown"
+
+	// 直播间生命周期事件，Payload 为 event/payload 中对应的结构体，订阅方不需要依赖 core/
+	StreamPublished   EventType = "StreamPublished"   // 开始推流，拉流转推时为连上上游，重连、切换上游不重复发布，Payload 为 payload.StreamPayload
+	StreamUnpublished EventType = "StreamUnpublished" // 推流结束，拉流转推时为停止拉流或重连不上，和 StreamPublished 成对出现，Payload 为 payload.StreamPayload
+	UpstreamConnected EventType = "UpstreamConnected" // 连上上游（推流时为推流端开始推流）并收到数据，Payload 为 payload.UpstreamPayload
+	UpstreamLost      EventType = "UpstreamLost"      // 上游断开，和 UpstreamConnected 成对出现，Payload 为 payload.UpstreamPayload
+	UpstreamFailover  EventType = "UpstreamFailover"  // 直播间切换了上游，Payload 为 payload.FailoverPayload
+	ViewerJoined      EventType = "ViewerJoined"      // 观众加入，Payload 为 payload.ViewerPayload
+	ViewerLeft        EventType = "ViewerLeft"        // 观众主动离开，Payload 为 payload.ViewerPayload
+	ViewerKicked      EventType = "ViewerKicked"      // 服务端断开观众（客户端太慢、直播间关闭等），Payload 为 payload.ViewerPayload
+	StreamEnded       EventType = "StreamEnded"       // 直播自然结束：拉流转推遇到 EXT-X-ENDLIST，直播间进入 BrokerEnd，和上游故障引起的 StreamUnpublished 区分开，Payload 为 payload.StreamPayload
+	RecordDone        EventType = "RecordDone"        // 回放定稿：推流结束、拉流转推遇到 EXT-X-ENDLIST 后分片冻结为回放，Payload 为 payload.RecordPayload
 )
 
 // Event 事件结构体定义
 type Event struct {
 	Type    EventType
 	Payload any
+	At      time.Time // 事件发生时间，Publish 时为空则自动填充
+
+	ack *ack // PublishAndWait 发布的事件，订阅方处理完后通过 Ack 通知发布方
+}
+
+// Ack 通知 PublishAndWait 的发布方本订阅方已处理完事件，Publish 发布的事件调用无效果，重复调用无效果
+func (e Event) Ack() {
+	if e.ack != nil {
+		e.ack.once.Do(e.ack.wg.Done)
+	}
+}
+
+type ack struct {
+	once sync.Once
+	wg   *sync.WaitGroup
+}
+
+// DropPolicy 订阅方队列满时的处理方式
+type DropPolicy int
+
+const (
+	// DropNewest 丢弃正在发布的事件，默认策略，发布方不会被阻塞
+	DropNewest DropPolicy = iota
+
+	// DropOldest 丢弃队列中最旧的事件，适合只关心最新状态的订阅方
+	DropOldest
+
+	// Block 等待订阅方取走事件后再投递，发布方会被慢订阅方阻塞，取消订阅后不再等待
+	//
+	//	直播间和观众事件是在 Broker、广播器持锁时发布的，订阅这些事件不要用 Block，只能通过 SubscribeWith 显式选择
+	Block
+)
+
+func (p DropPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Block:
+		return "block"
+	}
+	return "unknown"
+}
+
+// defaultQueueSize Subscribe、SubscribeMultiple 的队列长度
+const defaultQueueSize = 64
+
+// Filter 订阅过滤器，返回 false 的事件不投递给该订阅方
+type Filter func(e Event) bool
+
+// PayloadFilter 按 Payload 类型过滤，只投递 Payload 为 T 且 match 返回 true 的事件，match 为 nil 时只按类型过滤
+//
+//	如只订阅某个直播间的观众事件：event.PayloadFilter(func(p payload.ViewerPayload) bool { return p.BroadcasterKey == key })
+func PayloadFilter[T any](match func(T) bool) Filter {
+	return func(e Event) bool {
+		p, ok := e.Payload.(T)
+		return ok && (match == nil || match(p))
+	}
+}
+
+// SubscribeOptions 订阅选项
+type SubscribeOptions struct {
+	Types     []EventType
+	Filter    Filter     // 为空时投递所有 Types 中的事件
+	QueueSize int        // 订阅方队列长度，<= 0 时使用默认值 64
+	Policy    DropPolicy // 队列满时的处理方式，默认 DropNewest
+}
+
+// SubscriberStats 订阅方的队列状态
+type SubscriberStats struct {
+	Types    []EventType `json:"types"`
+	Policy   string      `json:"policy"`
+	Queued   int         `json:"queued"`   // 队列中未取走的事件数
+	Capacity int         `json:"capacity"` // 队列长度
+	Dropped  uint64      `json:"dropped"`  // 队列满时丢弃的事件数
+}
+
+// subscriber 一个订阅方，ch 只在取消订阅时关闭
+//
+//	发布方持有 mu 的读锁投递事件，取消订阅时先关闭 done 让阻塞的发布方退出，再持有写锁关闭 ch，不会向已关闭的 ch 发送事件。
+type subscriber struct {
+	ch      chan Event
+	types   []EventType
+	filter  Filter
+	policy  DropPolicy
+	dropped atomic.Uint64
+	total   *atomic.Uint64 // 总线的丢弃计数
+
+	mu        sync.RWMutex
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+
+	acksMutex sync.Mutex
+	acks      []*ack // 投递过的 PublishAndWait 事件，取消订阅时视为已处理
+}
+
+// deliver 按丢弃策略投递事件，wait 为 true 时不论策略都等待投递（PublishAndWait），cancel 被关闭时不再等待，返回是否投递成功
+func (s *subscriber) deliver(e Event, wait bool, cancel <-chan struct{}) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return false
+	}
+
+	if wait || s.policy == Block {
+		select {
+		case s.ch <- e:
+			if e.ack != nil {
+				s.acksMutex.Lock()
+				s.acks = append(s.acks, e.ack)
+				s.acksMutex.Unlock()
+			}
+			return true
+		case <-s.done:
+			return false
+		case <-cancel:
+			return false
+		}
+	}
+
+	for {
+		select {
+		case s.ch <- e:
+			return true
+		default:
+		}
+		if s.policy == DropNewest {
+			s.drop()
+			return false
+		}
+		// DropOldest：取出最旧的事件后重试，取出时订阅方可能刚好取走了，这时不计数
+		select {
+		case old := <-s.ch:
+			s.drop()
+			old.Ack()
+		default:
+		}
+	}
+}
+
+func (s *subscriber) drop() {
+	s.dropped.Add(1)
+	s.total.Add(1)
+}
+
+func (s *subscriber) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mu.Unlock()
+
+		s.acksMutex.Lock()
+		for _, a := range s.acks {
+			a.once.Do(a.wg.Done)
+		}
+		s.acks = nil
+		s.acksMutex.Unlock()
+	})
 }
 
+// EventBus 事件总线，每个订阅方有自己的有界队列，慢订阅方按自己的丢弃策略处理，不会拖丢其他订阅方的事件
 type EventBus struct {
-	subscribers map[EventType][]chan Event
+	subscribers map[EventType][]*subscriber
+	byCh        map[chan Event]*subscriber
 	mu          sync.RWMutex
+	dropped     atomic.Uint64
 }
 
 func NewEventBus() *EventBus {
 	return &EventBus{
-		subscribers: make(map[EventType][]chan Event),
+		subscribers: make(map[EventType][]*subscriber),
+		byCh:        make(map[chan Event]*subscriber),
 	}
 }
 
+// Subscribe 订阅一种事件，队列满时丢弃新事件，不会阻塞发布方
 func (b *EventBus) Subscribe(eventType EventType) chan Event {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
-	ch := make(chan Event, 1)
-	b.subscribers[eventType] = append(b.subscribers[eventType], ch)
-	return ch
+	return b.SubscribeWith(SubscribeOptions{Types: []EventType{eventType}})
 }
 
+// SubscribeMultiple 订阅多种事件，队列满时丢弃新事件，不会阻塞发布方
 func (b *EventBus) SubscribeMultiple(eventTypes []EventType) chan Event {
+	return b.SubscribeWith(SubscribeOptions{Types: eventTypes})
+}
+
+// SubscribeWith 按选项订阅，返回的通道通过 Unsubscribe 取消订阅
+func (b *EventBus) SubscribeWith(opts SubscribeOptions) chan Event {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	sub := &subscriber{
+		ch:     make(chan Event, opts.QueueSize),
+		types:  opts.Types,
+		filter: opts.Filter,
+		policy: opts.Policy,
+		done:   make(chan struct{}),
+		total:  &b.dropped,
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
-
-	ch := make(chan Event, 10)
-	for _, eventType := range eventTypes {
-		b.subscribers[eventType] = append(b.subscribers[eventType], ch)
+	for _, eventType := range opts.Types {
+		b.subscribers[eventType] = append(b.subscribers[eventType], sub)
 	}
-	return ch
+	b.byCh[sub.ch] = sub
+	return sub.ch
 }
 
+// Unsubscribe 取消订阅并关闭通道，队列中剩余的事件仍然可以读出；重复调用、传入未订阅的通道无效果
 func (b *EventBus) Unsubscribe(ch chan Event) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
-
-	for eventType, channels := range b.subscribers {
-		for i, subCh := range channels {
-			if subCh == ch {
-				b.subscribers[eventType] = append(channels[:i], channels[i+1:]...)
-				break
+	sub, ok := b.byCh[ch]
+	if ok {
+		delete(b.byCh, ch)
+		for _, eventType := range sub.types {
+			channels := b.subscribers[eventType]
+			for i, s := range channels {
+				if s == sub {
+					b.subscribers[eventType] = append(channels[:i:i], channels[i+1:]...)
+					break
+				}
 			}
 		}
 	}
-	close(ch)
+	b.mu.Unlock()
+
+	// 在总线锁外关闭，阻塞中的发布方不会卡住其他订阅、发布
+	if ok {
+		sub.close()
+	}
 }
 
+// Publish 发布事件，按各订阅方的丢弃策略投递
 func (b *EventBus) Publish(event Event) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	for _, sub := range b.match(event) {
+		sub.deliver(event, false, nil)
+	}
+}
+
+// PublishAndWait 发布事件并等待所有订阅方处理完（调用 Event.Ack 或者取消订阅），ctx 结束时返回 ctx.Err()
+//
+//	不论订阅方的丢弃策略都会等待投递，用于 SystemShutdown 这类需要保证顺序的事件，订阅方处理完后必须调用 Ack。
+//	ctx 结束时不再等待投递和 Ack，投递协程随 ctx 退出，超时返回不会留下阻塞的协程。
+func (b *EventBus) PublishAndWait(ctx context.Context, event Event) error {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	// 各订阅方并行投递，一个订阅方不读取时不影响其他订阅方
+	var wg sync.WaitGroup
+	var acks []*ack
+	for _, sub := range b.match(event) {
+		e := event
+		e.ack = &ack{wg: &wg}
+		acks = append(acks, e.ack)
+		wg.Add(1)
+		go func() {
+			if !sub.deliver(e, true, ctx.Done()) {
+				e.Ack()
+			}
+		}()
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		return nil
+	case <-ctx.Done():
+		// 还没投递的协程随 ctx 退出，已经投递但没有 Ack 的视为已处理，之后订阅方再 Ack 无效果
+		for _, a := range acks {
+			a.once.Do(a.wg.Done)
+		}
+		<-waited
+		return ctx.Err()
+	}
+}
+
+// Dropped 所有订阅方（包括已取消订阅的）丢弃的事件数
+func (b *EventBus) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Stats 返回所有订阅方的队列状态
+func (b *EventBus) Stats() []SubscriberStats {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
+	stats := make([]SubscriberStats, 0, len(b.byCh))
+	for _, sub := range b.byCh {
+		stats = append(stats, SubscriberStats{
+			Types:    sub.types,
+			Policy:   sub.policy.String(),
+			Queued:   len(sub.ch),
+			Capacity: cap(sub.ch),
+			Dropped:  sub.dropped.Load(),
+		})
+	}
+	return stats
+}
 
-	if subs, ok := b.subscribers[event.Type]; ok {
-		for _, ch := range subs {
-			select {
-			case ch <- event:
-			default:
-			}
+// match 找出订阅了该事件、并且通过过滤器的订阅方
+func (b *EventBus) match(event Event) []*subscriber {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	subs := make([]*subscriber, 0, len(b.subscribers[event.Type]))
+	for _, sub := range b.subscribers[event.Type] {
+		if sub.filter == nil || sub.filter(event) {
+			subs = append(subs, sub)
 		}
 	}
+	return subs
 }
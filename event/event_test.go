@@ -0,0 +1,196 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// checkGoroutines 等待 goroutine 数回到 before 以内，超时后打印所有 goroutine 的堆栈
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+			t.Fatalf("goroutines = %d, want <= %d\n%s", runtime.NumGoroutine(), before, buf[:n])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// drain 读出队列中已有的事件的 Payload
+func drain(ch chan Event) []any {
+	var got []any
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return got
+			}
+			got = append(got, e.Payload)
+		default:
+			return got
+		}
+	}
+}
+
+func equal(got []any, want ...any) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestDropPolicy 队列满时 DropNewest 丢弃新事件、DropOldest 丢弃旧事件，发布方都不会被阻塞
+func TestDropPolicy(t *testing.T) {
+	tests := []struct {
+		policy DropPolicy
+		want   []any
+	}{
+		{DropNewest, []any{1, 2}},
+		{DropOldest, []any{3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			bus := NewEventBus()
+			ch := bus.SubscribeWith(SubscribeOptions{Types: []EventType{ViewerJoined}, QueueSize: 2, Policy: tt.policy})
+			for i := 1; i <= 4; i++ {
+				bus.Publish(Event{Type: ViewerJoined, Payload: i})
+			}
+			if got := drain(ch); !equal(got, tt.want...) {
+				t.Fatalf("received %v, want %v", got, tt.want)
+			}
+			if bus.Dropped() != 2 {
+				t.Fatalf("Dropped = %d, want 2", bus.Dropped())
+			}
+			stats := bus.Stats()
+			if len(stats) != 1 || stats[0].Dropped != 2 || stats[0].Policy != tt.policy.String() || stats[0].Capacity != 2 {
+				t.Fatalf("Stats = %+v", stats)
+			}
+		})
+	}
+}
+
+// TestBlock 队列满时 Block 订阅方让发布方等到事件被取走，不丢事件
+func TestBlock(t *testing.T) {
+	bus := NewEventBus()
+	ch := bus.SubscribeWith(SubscribeOptions{Types: []EventType{ViewerJoined}, QueueSize: 1, Policy: Block})
+	bus.Publish(Event{Type: ViewerJoined, Payload: 1})
+
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(Event{Type: ViewerJoined, Payload: 2})
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("Publish returned while Block queue is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if e := <-ch; e.Payload != 1 {
+		t.Fatalf("first event = %v, want 1", e.Payload)
+	}
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish not returned after event taken")
+	}
+	if e := <-ch; e.Payload != 2 || bus.Dropped() != 0 {
+		t.Fatalf("second event = %v, dropped %d", e.Payload, bus.Dropped())
+	}
+}
+
+// TestUnsubscribeDuringPublish 发布方阻塞在 Block 订阅方上时取消订阅，发布方返回，通道关闭前的事件仍然可以读出
+func TestUnsubscribeDuringPublish(t *testing.T) {
+	bus := NewEventBus()
+	ch := bus.SubscribeWith(SubscribeOptions{Types: []EventType{ViewerJoined}, QueueSize: 1, Policy: Block})
+	other := bus.Subscribe(ViewerJoined)
+	bus.Publish(Event{Type: ViewerJoined, Payload: 1})
+
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(Event{Type: ViewerJoined, Payload: 2})
+		close(published)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	bus.Unsubscribe(ch)
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish still blocked after Unsubscribe")
+	}
+	if got := drain(ch); !equal(got, 1) {
+		t.Fatalf("unsubscribed channel = %v, want [1] then closed", got)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("channel not closed after Unsubscribe")
+	}
+	// 其他订阅方照常收到
+	if got := drain(other); !equal(got, 1, 2) {
+		t.Fatalf("other subscriber = %v, want [1 2]", got)
+	}
+	// 重复取消订阅无效果
+	bus.Unsubscribe(ch)
+}
+
+// TestPublishAndWait 所有订阅方 Ack 或取消订阅后返回
+func TestPublishAndWait(t *testing.T) {
+	bus := NewEventBus()
+	acked := bus.SubscribeWith(SubscribeOptions{Types: []EventType{SystemShutdown}, QueueSize: 1})
+	leaving := bus.Subscribe(SystemShutdown)
+	go func() {
+		e := <-acked
+		time.Sleep(50 * time.Millisecond)
+		e.Ack()
+	}()
+	go func() {
+		<-leaving
+		bus.Unsubscribe(leaving)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := bus.PublishAndWait(ctx, Event{Type: SystemShutdown}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("returned after %v, before Ack", elapsed)
+	}
+}
+
+// TestPublishAndWaitTimeout 订阅方不读取或者不 Ack 时按 ctx 超时返回，投递协程全部退出
+func TestPublishAndWaitTimeout(t *testing.T) {
+	bus := NewEventBus()
+	// 队列已满并且不读取
+	stuck := bus.SubscribeWith(SubscribeOptions{Types: []EventType{SystemShutdown}, QueueSize: 1})
+	bus.Publish(Event{Type: SystemShutdown})
+	// 读取了但不 Ack
+	noAck := bus.Subscribe(SystemShutdown)
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := bus.PublishAndWait(ctx, Event{Type: SystemShutdown})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	checkGoroutines(t, before)
+
+	// 超时后订阅方再 Ack、取消订阅不会 panic
+	e := <-noAck
+	e.Ack()
+	bus.Unsubscribe(stuck)
+	bus.Unsubscribe(noAck)
+}
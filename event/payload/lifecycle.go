@@ -0,0 +1,56 @@
+package payload
+
+// 直播间生命周期事件的 Payload，只包含基础类型，webhook、录制等订阅方可以直接序列化
+
+// 直播类型，对应各个 Broker；DASH、TS 是 FLV/HLS 直播间的观看方式，只用于观看相关的回调
+const (
+	ProtocolFLV    = "flv"
+	ProtocolHLS    = "hls"
+	ProtocolCamera = "camera"
+	ProtocolWebRTC = "webrtc"
+	ProtocolDASH   = "dash"
+	ProtocolTS     = "ts"
+)
+
+// StreamPayload 直播间发布/取消发布
+type StreamPayload struct {
+	BroadcasterKey string `json:"broadcasterKey"`
+	Protocol       string `json:"protocol"`
+	URL            string `json:"url,omitempty"`    // 推流端地址，拉流转推时为上游地址
+	Reason         string `json:"reason,omitempty"` // 取消发布的原因，如 publisher closed、stopped、ended，或上游的错误
+}
+
+// UpstreamPayload 上游连上/断开
+type UpstreamPayload struct {
+	BroadcasterKey string `json:"broadcasterKey"`
+	Protocol       string `json:"protocol"`
+	URL            string `json:"url,omitempty"`    // 上游地址，推流时为推流端地址
+	Reason         string `json:"reason,omitempty"` // 断开原因
+}
+
+// FailoverPayload 一次上游切换
+type FailoverPayload struct {
+	BroadcasterKey string `json:"broadcasterKey"`
+	Protocol       string `json:"protocol"`
+	From           string `json:"from"`
+	To             string `json:"to"`
+	Reason         string `json:"reason"`
+	FailBack       bool   `json:"failBack"` // 是否为主上游恢复后的切回
+}
+
+// RecordPayload 回放定稿，回放分片保存在内存中，保留期内可以通过直播间的 HLS 地址观看
+type RecordPayload struct {
+	BroadcasterKey string  `json:"broadcasterKey"`
+	Protocol       string  `json:"protocol"`
+	Segments       int     `json:"segments"` // 回放的分片数
+	Duration       float64 `json:"duration"` // 回放的总时长，秒
+}
+
+// ViewerPayload 观众加入/离开/被断开
+type ViewerPayload struct {
+	BroadcasterKey string `json:"broadcasterKey"`
+	Protocol       string `json:"protocol"`
+	ClientId       string `json:"clientId"`
+	Reason         string `json:"reason,omitempty"` // 被断开的原因
+	Viewers        int    `json:"viewers"`          // 事件发生后直播间的观众数
+}
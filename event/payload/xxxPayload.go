@@ -1,6 +0,0 @@
-package payload
-
-type XxxPayload struct {
-	Foo string `json:"foo"`
-	Bar string `json:"bar"`
-}
@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+Prometheus 指标
+	按 Prometheus 文本格式（text/plain; version=0.0.4）输出 /metrics，不引入 client_golang：
+	直播间的状态、字节数、观众数等在抓取时从各广播器的 Stats 现算，写成 gauge/counter；
+	需要在拉流、定时任务路径上记录分布的（HLS 分片下载耗时、定时任务耗时）用这里的 HistogramVec，抓取时一起输出。
+*/
+
+// ContentType /metrics 响应的 Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// 指标类型
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+var (
+	// SegmentDownloadSeconds HLS 分片下载耗时（包括重试），标签为 protocol、stream
+	SegmentDownloadSeconds = NewHistogramVec("pull2push_hls_segment_download_seconds", "HLS segment download latency including retries.",
+		[]string{"protocol", "stream"}, []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16})
+
+	// CronTaskSeconds 定时任务执行耗时，标签为 task、result（ok/error）
+	CronTaskSeconds = NewHistogramVec("pull2push_cron_task_duration_seconds", "Cron task execution duration.",
+		[]string{"task", "result"}, []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30})
+
+	histograms = []*HistogramVec{SegmentDownloadSeconds, CronTaskSeconds}
+)
+
+// WriteHistograms 输出所有 HistogramVec
+func WriteHistograms(w *Writer) {
+	for _, h := range histograms {
+		h.Write(w)
+	}
+}
+
+// Writer 按 Prometheus 文本格式拼接指标，同一个指标的样本要写在它的 Header 之后、下一个 Header 之前
+type Writer struct {
+	b strings.Builder
+}
+
+// NewWriter 创建 Writer
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+// Header 写出指标的 HELP 和 TYPE
+func (w *Writer) Header(name, typ, help string) {
+	w.b.WriteString("# HELP " + name + " " + strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help) + "\n")
+	w.b.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample 写出一个样本，labels 为 名称、值 交替排列
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.b.WriteString(name)
+	if len(labels) > 0 {
+		w.b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.b.WriteByte(',')
+			}
+			w.b.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		w.b.WriteByte('}')
+	}
+	w.b.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// Bytes 返回拼接好的响应内容
+func (w *Writer) Bytes() []byte {
+	return []byte(w.b.String())
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// HistogramVec 按标签值分组的直方图，并发安全
+type HistogramVec struct {
+	name, help string
+	labelNames []string
+	buckets    []float64 // 升序的桶上界，不含 +Inf
+
+	mu     sync.Mutex
+	series map[string]*histogram // 标签值拼接 → 直方图
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64 // 每个桶（不累计）的样本数，最后一个为 +Inf
+	sum         float64
+	count       uint64
+}
+
+// NewHistogramVec 创建直方图，buckets 为升序的桶上界
+func NewHistogramVec(name, help string, labelNames []string, buckets []float64) *HistogramVec {
+	return &HistogramVec{name: name, help: help, labelNames: labelNames, buckets: buckets, series: make(map[string]*histogram)}
+}
+
+// Observe 记录一个样本，labelValues 与创建时的 labelNames 一一对应
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labelValues: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, value)]++
+	s.sum += value
+	s.count++
+}
+
+// Delete 删除一组标签值的样本，直播间关闭后调用，避免已经不存在的直播间一直留在 /metrics 中
+func (h *HistogramVec) Delete(labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.series, strings.Join(labelValues, "\xff"))
+}
+
+// Write 输出所有分组的 _bucket、_sum、_count，没有样本时只输出 HELP、TYPE
+func (h *HistogramVec) Write(w *Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w.Header(h.name, TypeHistogram, h.help)
+	for _, key := range keys {
+		s := h.series[key]
+		labels := make([]string, 0, 2*len(h.labelNames)+2)
+		for i, name := range h.labelNames {
+			labels = append(labels, name, s.labelValues[i])
+		}
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			w.Sample(h.name+"_bucket", float64(cumulative), append(labels, "le", formatFloat(le))...)
+		}
+		w.Sample(h.name+"_sum", s.sum, labels...)
+		w.Sample(h.name+"_count", float64(s.count), labels...)
+	}
+}
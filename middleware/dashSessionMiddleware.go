@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionIdKey gin.Context 中的会话编号，路由中没有 clientId 参数时（如 DASH）由 DASHSessionMiddleware 写入
+const SessionIdKey = "sessionId"
+
+// DASHSessionMiddleware 从 DASH 地址中取出会话编号，供后面的观看限制、出口带宽限制按会话计数
+//
+//	manifest.mpd 的会话编号在 ?clientId= 中，没有时生成新会话并重定向到带 clientId 的地址，
+//	播放器之后刷新 MPD 都带着同一个编号（MPD 里的 Location 也是这个地址），不会每次刷新都占一个新会话；
+//	分片地址为 {clientId}/{segment}，会话编号是路径的第一段。
+func DASHSessionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filepath := strings.TrimPrefix(c.Param("filepath"), "/")
+		var id string
+		if filepath == "manifest.mpd" {
+			if id = c.Query("clientId"); id == "" {
+				q := c.Request.URL.Query()
+				q.Set("clientId", NewSessionId())
+				c.Redirect(http.StatusFound, "manifest.mpd?"+q.Encode())
+				c.Abort()
+				return
+			}
+		} else {
+			id, _, _ = strings.Cut(filepath, "/")
+		}
+		c.Set(SessionIdKey, id)
+		c.Next()
+	}
+}
+
+// sessionId HLS 会话编号取路由中的 clientId，DASH 取 DASHSessionMiddleware 写入的
+func sessionId(c *gin.Context) string {
+	if id := c.GetString(SessionIdKey); id != "" {
+		return id
+	}
+	return c.Param("clientId")
+}
+
+// NewSessionId 生成 DASH、WebRTC 的会话编号
+func NewSessionId() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"pull2push/core/egress"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EgressMiddleware 长连接观看（FLV、TS、摄像头拉流、WHEP）的出口带宽限制：带宽不足时拒绝或重定向新观众，加入后把限速放到请求的 context 中；
+// 请求结束时释放，WHEP 这类会话比请求长的由处理函数 Hold 到会话结束
+func EgressMiddleware(l *egress.Limiter, redirectURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		viewer, err := l.Admit(c.Param("broadcasterKey"))
+		if err != nil {
+			refuseEgress(c, err, redirectURL)
+			return
+		}
+		c.Request = c.Request.WithContext(egress.NewContext(c.Request.Context(), viewer))
+		releaseAfter(c, viewer.Release)
+	}
+}
+
+// EgressSessionMiddleware HLS、DASH 观看的出口带宽限制，按会话编号区分会话，已加入的会话不会被拒绝
+func EgressSessionMiddleware(l *egress.Limiter, redirectURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		viewer, err := l.AdmitSession(c.Param("broadcasterKey"), sessionId(c))
+		if err != nil {
+			refuseEgress(c, err, redirectURL)
+			return
+		}
+		defer viewer.Release()
+		c.Request = c.Request.WithContext(egress.NewContext(c.Request.Context(), viewer))
+		c.Next()
+	}
+}
+
+// refuseEgress 配置了 redirectURL 时重定向到其他节点，否则返回 503
+func refuseEgress(c *gin.Context, err error, redirectURL string) {
+	if redirectURL != "" {
+		c.Redirect(http.StatusFound, strings.TrimSuffix(redirectURL, "/")+c.Request.URL.RequestURI())
+		c.Abort()
+		return
+	}
+	c.Header("Retry-After", strconv.Itoa(limitRetryAfter))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	c.Abort()
+}
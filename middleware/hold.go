@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// holdKey gin.Context 中本次请求占用的名额
+const holdKey = "middleware.hold"
+
+// hold 观看限制、出口带宽等中间件在本次请求中占用的名额，默认请求结束时释放
+type hold struct {
+	mu       sync.Mutex
+	held     bool
+	releases []func()
+}
+
+// Hold 观看会话比请求活得久时（如 WHEP，请求只负责交换 SDP）由处理函数调用，
+// 请求结束时不再释放名额，改为由调用方在会话结束时调用返回的函数释放
+func Hold(c *gin.Context) func() {
+	h := holdOf(c)
+	h.mu.Lock()
+	h.held = true
+	h.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(h.release)
+	}
+}
+
+// releaseAfter 登记名额的释放函数，执行后续处理，没有被 Hold 时在请求结束时释放
+func releaseAfter(c *gin.Context, release func()) {
+	h := holdOf(c)
+	h.mu.Lock()
+	h.releases = append(h.releases, release)
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		held := h.held
+		h.mu.Unlock()
+		if !held {
+			h.release()
+		}
+	}()
+	c.Next()
+}
+
+func (h *hold) release() {
+	h.mu.Lock()
+	releases := h.releases
+	h.releases = nil
+	h.mu.Unlock()
+	for _, release := range releases {
+		release()
+	}
+}
+
+func holdOf(c *gin.Context) *hold {
+	if v, ok := c.Get(holdKey); ok {
+		return v.(*hold)
+	}
+	h := &hold{}
+	c.Set(holdKey, h)
+	return h
+}
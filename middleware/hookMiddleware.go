@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"pull2push/webhook"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HookPublish 推流前调用 on_publish 回调，回调拒绝时返回 403
+func HookPublish(hooks *webhook.Client, protocol string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := hooks.OnPublish(c.Request.Context(), hookRequest(c, protocol)); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "推流被拒绝：" + err.Error()})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// HookPlay 观看前调用 on_play 回调，回调拒绝时返回 403
+func HookPlay(hooks *webhook.Client, protocol string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := hooks.OnPlay(c.Request.Context(), hookRequest(c, protocol)); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "观看被拒绝：" + err.Error()})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// hookRequest 从路由参数中取出直播间和客户端编号
+func hookRequest(c *gin.Context, protocol string) webhook.Request {
+	return webhook.Request{
+		BroadcasterKey: c.Param("broadcasterKey"),
+		Protocol:       protocol,
+		ClientId:       c.Param("clientId"),
+		IP:             c.ClientIP(),
+		URL:            c.Request.URL.Path,
+		Param:          c.Request.URL.RawQuery,
+		Time:           time.Now().Unix(),
+	}
+}
@@ -1,71 +0,0 @@
-package middleware
-
-import (
-	"github.com/golang-jwt/jwt/v5"
-	"net/http"
-	"strings"
-	"time"
-
-	"github.com/gin-gonic/gin"
-)
-
-var jwtSecret = []byte("your-secret-key") // 建议放到配置文件
-
-// Claims 定义 JWT 的 payload
-type Claims struct {
-	UserID   int64  `json:"user_id"`
-	Username string `json:"username"`
-	jwt.RegisteredClaims
-}
-
-// GenerateToken 生成 JWT
-func GenerateToken(userID int64, username string) (string, error) {
-	claims := Claims{
-		UserID:   userID,
-		Username: username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 1天有效
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "go-server",
-		},
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
-}
-
-// JWTAuthMiddleware 验证 JWT
-func JWTAuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "请求头中缺少 Authorization"})
-			c.Abort()
-			return
-		}
-
-		parts := strings.SplitN(authHeader, " ", 2)
-		if !(len(parts) == 2 && strings.ToLower(parts[0]) == "bearer") {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization 格式错误"})
-			c.Abort()
-			return
-		}
-
-		tokenStr := parts[1]
-		token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的 Token"})
-			c.Abort()
-			return
-		}
-
-		if claims, ok := token.Claims.(*Claims); ok {
-			c.Set("userID", claims.UserID)
-			c.Set("username", claims.Username)
-		}
-
-		c.Next()
-	}
-}
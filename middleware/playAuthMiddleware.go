@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"path"
+	"pull2push/config"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+观看鉴权
+	播放器一般不能设置请求头，token 放在 query 中：?token=xxx，支持两种格式：
+		签名 token：<过期时间 Unix 秒>-<hex(HMAC-SHA256(secret, 直播间 + "\n" + 过期时间 + "\n" + 观众 IP))>，
+			签名时 IP 为空表示不绑定 IP，业务后台用 SignPlayToken 生成；
+		JWT：HS256，claims 中 stream 为直播间（"*" 表示所有直播间），必须带 exp，可选 ip 绑定观众 IP。
+	HLS、DASH 的分片地址会带上播放列表请求的 query，分片请求同样校验 token，token 过期后无法继续拉取分片。
+*/
+
+var (
+	ErrPlayTokenMissing = errors.New("缺少 token")
+	ErrPlayTokenInvalid = errors.New("无效的 token")
+	ErrPlayTokenExpired = errors.New("token 已过期")
+)
+
+// PlayClaims 观看 JWT 的 payload
+type PlayClaims struct {
+	Stream string `json:"stream"`
+	IP     string `json:"ip,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// PlayAuth 观看鉴权
+type PlayAuth struct {
+	config config.PlayAuthConfig
+}
+
+// NewPlayAuth 创建观看鉴权
+func NewPlayAuth(cfg config.PlayAuthConfig) *PlayAuth {
+	return &PlayAuth{config: cfg}
+}
+
+// Required 直播间是否需要鉴权
+func (a *PlayAuth) Required(broadcasterKey string) bool {
+	if a == nil {
+		return false
+	}
+	for _, pattern := range a.config.Streams {
+		if ok, _ := path.Match(pattern, broadcasterKey); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify 校验观看 token，不需要鉴权的直播间直接通过
+func (a *PlayAuth) Verify(broadcasterKey, token, ip string) error {
+	if !a.Required(broadcasterKey) {
+		return nil
+	}
+	if token == "" {
+		return ErrPlayTokenMissing
+	}
+	// JWT 由三段 base64 组成，签名 token 中没有 "."
+	if strings.Count(token, ".") == 2 {
+		return a.verifyJWT(broadcasterKey, token, ip)
+	}
+	return a.verifySigned(broadcasterKey, token, ip)
+}
+
+func (a *PlayAuth) verifySigned(broadcasterKey, token, ip string) error {
+	if a.config.Secret == "" {
+		return ErrPlayTokenInvalid
+	}
+	expireStr, sig, ok := strings.Cut(token, "-")
+	if !ok {
+		return ErrPlayTokenInvalid
+	}
+	expire, err := strconv.ParseInt(expireStr, 10, 64)
+	if err != nil {
+		return ErrPlayTokenInvalid
+	}
+
+	// 先按绑定观众 IP 校验，再按不绑定 IP 校验
+	if !hmac.Equal([]byte(sig), []byte(signPlay(a.config.Secret, broadcasterKey, expireStr, ip))) &&
+		!hmac.Equal([]byte(sig), []byte(signPlay(a.config.Secret, broadcasterKey, expireStr, ""))) {
+		return ErrPlayTokenInvalid
+	}
+	if time.Now().Unix() > expire {
+		return ErrPlayTokenExpired
+	}
+	return nil
+}
+
+func (a *PlayAuth) verifyJWT(broadcasterKey, token, ip string) error {
+	if a.config.JWTSecret == "" {
+		return ErrPlayTokenInvalid
+	}
+	claims := &PlayClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(a.config.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return ErrPlayTokenExpired
+	}
+	if err != nil {
+		return ErrPlayTokenInvalid
+	}
+	if claims.Stream != broadcasterKey && claims.Stream != "*" {
+		return ErrPlayTokenInvalid
+	}
+	if claims.IP != "" && claims.IP != ip {
+		return ErrPlayTokenInvalid
+	}
+	return nil
+}
+
+// SignPlayToken 生成签名 token，ip 为空时不绑定观众 IP
+func SignPlayToken(secret, broadcasterKey string, expire time.Time, ip string) string {
+	expireStr := strconv.FormatInt(expire.Unix(), 10)
+	return expireStr + "-" + signPlay(secret, broadcasterKey, expireStr, ip)
+}
+
+func signPlay(secret, broadcasterKey, expire, ip string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(broadcasterKey + "\n" + expire + "\n" + ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PlayAuthMiddleware 观看鉴权，token 缺失时返回 401，无效或过期时返回 403
+func PlayAuthMiddleware(auth *PlayAuth) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := auth.Verify(c.Param("broadcasterKey"), c.Query("token"), c.ClientIP())
+		if errors.Is(err, ErrPlayTokenMissing) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"pull2push/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrStreamKeyNotConfigured = errors.New("直播间未配置推流密钥")
+	ErrStreamKeyMissing       = errors.New("缺少推流密钥")
+	ErrStreamKeyInvalid       = errors.New("推流密钥错误")
+)
+
+// CheckStreamKey 校验推流密钥，HTTP 之外的推流（如 SRT 的 streamid）也用它；通过时返回 nil
+func CheckStreamKey(cfg config.PublishConfig, broadcasterKey, key string) error {
+	streamKey, ok := cfg.StreamKeys[broadcasterKey]
+	if !ok {
+		if cfg.RequireKey {
+			return ErrStreamKeyNotConfigured
+		}
+		return nil
+	}
+	if key == "" {
+		return ErrStreamKeyMissing
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(streamKey)) != 1 {
+		return ErrStreamKeyInvalid
+	}
+	return nil
+}
+
+// PublishAuthMiddleware 校验推流密钥：配置了密钥的直播间必须带 ?key= 或请求头 X-Stream-Key，缺失时返回 401，错误时返回 403
+func PublishAuthMiddleware(cfg config.PublishConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Query("key")
+		if key == "" {
+			key = c.GetHeader("X-Stream-Key")
+		}
+		if err := CheckStreamKey(cfg, c.Param("broadcasterKey"), key); err != nil {
+			status := http.StatusForbidden
+			if err == ErrStreamKeyMissing {
+				status = http.StatusUnauthorized
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
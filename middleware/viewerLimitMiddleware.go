@@ -0,0 +1,242 @@
+package middleware
+
+import (
+	"net/http"
+	"pull2push/config"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+观看限制
+	FLV、TS、摄像头拉流是长连接，请求开始时占用名额，请求结束时释放；WHEP 占用到 WebRTC 连接断开；
+	HLS、DASH 由很多短请求组成，按 直播间 + 会话编号 记为一个会话，SessionTTL 内没有请求后释放；
+	DASH 的会话编号在第一次请求 MPD 时分配（DASHSessionMiddleware），之后刷新 MPD、下载分片都沿用，不会每次刷新占一个新名额。
+	广播器里的 HLS、DASH 客户端同样没有断开事件，由广播器定时移除过期的会话，按需拉流的观众数才能回到 0。
+	直播间按 broadcasterKey 计数，同一个直播间以 FLV、TS、HLS、DASH、WebRTC 等不同方式观看的观众合并计算。
+	本节点和直播间超限返回 503，单个 IP 超限返回 429，响应中带原因和 Retry-After。
+*/
+
+const (
+	defaultSessionTTL = 30 * time.Second
+	limitRetryAfter   = 10 // 超限时建议客户端多少秒后重试
+)
+
+// 超限原因
+const (
+	LimitReasonNode   = "too many viewers on this node"
+	LimitReasonStream = "too many viewers on this stream"
+	LimitReasonIP     = "too many connections from this IP"
+)
+
+// LimitError 超限错误，Status 为返回给客户端的 HTTP 状态码
+type LimitError struct {
+	Status int
+	Reason string
+}
+
+func (e *LimitError) Error() string {
+	return e.Reason
+}
+
+// ViewerLimitStats 观看限制的当前状态
+type ViewerLimitStats struct {
+	Viewers    int               `json:"viewers"`    // 本节点当前观众数（连接 + HLS 会话）
+	MaxViewers int               `json:"maxViewers"` // 0 表示不限制
+	Streams    map[string]int    `json:"streams"`    // 直播间 → 当前观众数
+	IPs        int               `json:"ips"`        // 当前有连接的 IP 数
+	Rejected   map[string]uint64 `json:"rejected"`   // 超限原因 → 累计拒绝次数
+}
+
+// viewerSession 一个 HLS 会话
+type viewerSession struct {
+	broadcasterKey, ip string
+	lastSeen           time.Time
+}
+
+// ViewerLimiter 按本节点、直播间、IP 三个维度限制观众数，并发安全；为 nil 时不限制
+type ViewerLimiter struct {
+	config config.LimitsConfig
+
+	mu        sync.Mutex
+	total     int
+	perStream map[string]int // 直播间 → 观众数
+	perIP     map[string]int
+	sessions  map[string]*viewerSession // 直播间/客户端编号 → HLS 会话
+	pruned    time.Time
+	rejected  map[string]uint64
+}
+
+// NewViewerLimiter 创建观看限制
+func NewViewerLimiter(cfg config.LimitsConfig) *ViewerLimiter {
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = defaultSessionTTL
+	}
+	return &ViewerLimiter{
+		config:    cfg,
+		perStream: make(map[string]int),
+		perIP:     make(map[string]int),
+		sessions:  make(map[string]*viewerSession),
+		rejected:  make(map[string]uint64),
+	}
+}
+
+// Acquire 长连接占用一个名额，连接结束后调用 release 释放
+func (l *ViewerLimiter) Acquire(broadcasterKey, ip string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked(time.Now())
+	if err := l.admitLocked(broadcasterKey, ip); err != nil {
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			l.leaveLocked(broadcasterKey, ip)
+			l.mu.Unlock()
+		})
+	}, nil
+}
+
+// Touch HLS 请求：会话已存在时刷新，不存在时占用一个名额
+func (l *ViewerLimiter) Touch(broadcasterKey, sessionId, ip string) error {
+	if l == nil {
+		return nil
+	}
+	sessionKey := broadcasterKey + "/" + sessionId
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked(now)
+	if session, ok := l.sessions[sessionKey]; ok {
+		session.lastSeen = now
+		return nil
+	}
+	if err := l.admitLocked(broadcasterKey, ip); err != nil {
+		return err
+	}
+	l.sessions[sessionKey] = &viewerSession{broadcasterKey: broadcasterKey, ip: ip, lastSeen: now}
+	return nil
+}
+
+// Stats 返回当前观众数和累计拒绝次数
+func (l *ViewerLimiter) Stats() ViewerLimitStats {
+	if l == nil {
+		return ViewerLimitStats{Streams: map[string]int{}, Rejected: map[string]uint64{}}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked(time.Now())
+
+	stats := ViewerLimitStats{
+		Viewers:    l.total,
+		MaxViewers: l.config.MaxViewers,
+		Streams:    make(map[string]int, len(l.perStream)),
+		IPs:        len(l.perIP),
+		Rejected:   make(map[string]uint64, len(l.rejected)),
+	}
+	for stream, n := range l.perStream {
+		stats.Streams[stream] = n
+	}
+	for reason, n := range l.rejected {
+		stats.Rejected[reason] = n
+	}
+	return stats
+}
+
+// admitLocked 检查三个维度的上限，通过后计数
+func (l *ViewerLimiter) admitLocked(broadcasterKey, ip string) error {
+	var err *LimitError
+	switch {
+	case l.config.MaxViewers > 0 && l.total >= l.config.MaxViewers:
+		err = &LimitError{Status: http.StatusServiceUnavailable, Reason: LimitReasonNode}
+	case l.streamLimit(broadcasterKey) > 0 && l.perStream[broadcasterKey] >= l.streamLimit(broadcasterKey):
+		err = &LimitError{Status: http.StatusServiceUnavailable, Reason: LimitReasonStream}
+	case l.config.MaxConnsPerIP > 0 && l.perIP[ip] >= l.config.MaxConnsPerIP:
+		err = &LimitError{Status: http.StatusTooManyRequests, Reason: LimitReasonIP}
+	}
+	if err != nil {
+		l.rejected[err.Reason]++
+		return err
+	}
+
+	l.total++
+	l.perStream[broadcasterKey]++
+	l.perIP[ip]++
+	return nil
+}
+
+func (l *ViewerLimiter) leaveLocked(broadcasterKey, ip string) {
+	l.total--
+	if l.perStream[broadcasterKey]--; l.perStream[broadcasterKey] <= 0 {
+		delete(l.perStream, broadcasterKey)
+	}
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// streamLimit 直播间的观众上限，Streams 中单独配置的优先
+func (l *ViewerLimiter) streamLimit(broadcasterKey string) int {
+	if n, ok := l.config.Streams[broadcasterKey]; ok {
+		return n
+	}
+	return l.config.MaxViewersPerStream
+}
+
+// pruneLocked 释放过期的 HLS 会话，最多每秒清理一次
+func (l *ViewerLimiter) pruneLocked(now time.Time) {
+	if now.Sub(l.pruned) < time.Second {
+		return
+	}
+	l.pruned = now
+	for key, session := range l.sessions {
+		if now.Sub(session.lastSeen) > l.config.SessionTTL {
+			delete(l.sessions, key)
+			l.leaveLocked(session.broadcasterKey, session.ip)
+		}
+	}
+}
+
+// ViewerLimitMiddleware 长连接观看（FLV、TS、摄像头拉流、WHEP）的观看限制，请求结束后释放名额，WHEP 由处理函数 Hold 到会话结束
+func ViewerLimitMiddleware(l *ViewerLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		release, err := l.Acquire(c.Param("broadcasterKey"), c.ClientIP())
+		if err != nil {
+			abortLimited(c, err)
+			return
+		}
+		releaseAfter(c, release)
+	}
+}
+
+// SessionLimitMiddleware HLS、DASH 观看的观看限制，按会话编号区分会话
+func SessionLimitMiddleware(l *ViewerLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := l.Touch(c.Param("broadcasterKey"), sessionId(c), c.ClientIP()); err != nil {
+			abortLimited(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+func abortLimited(c *gin.Context, err error) {
+	status := http.StatusServiceUnavailable
+	if limitErr, ok := err.(*LimitError); ok {
+		status = limitErr.Status
+	}
+	c.Header("Retry-After", strconv.Itoa(limitRetryAfter))
+	c.JSON(status, gin.H{"error": err.Error()})
+	c.Abort()
+}
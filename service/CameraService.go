@@ -1,34 +1,183 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"io"
 	"net/http"
+	"pull2push/config"
 	"pull2push/core/broadcast"
 	cameraBroadcast "pull2push/core/broadcast/camera"
 	cameraBroker "pull2push/core/broker/camera"
 	cameraClient "pull2push/core/client/camera"
+	hlsClient "pull2push/core/client/hls"
+	"pull2push/core/egress"
+	"pull2push/core/media/ts"
+	"strings"
+	"time"
 )
 
+// kickPublisherTimeout 顶掉旧推流端时等待它退出的时长
+const kickPublisherTimeout = 5 * time.Second
+
 // CameraService 摄像头直播推流 Service 层
 type CameraService struct {
 	CameraBrokerPool *cameraBroker.CameraBroker
+	Publish          config.PublishConfig // 推流密钥在中间件中校验，这里处理自动创建直播间和重复推流
+}
+
+// publishRoom 返回推流的直播间：不存在时按配置自动创建；正在推流时按配置拒绝或顶掉旧的推流端
+func (cs *CameraService) publishRoom(broadcasterKey string) (*cameraBroadcast.CameraBroadcaster, error) {
+	return publishCameraRoom(cs.CameraBrokerPool, cs.Publish, broadcasterKey)
+}
+
+// ErrRoomNotFound 推流到不存在的直播间，并且没有开启自动创建
+var ErrRoomNotFound = errors.New("直播不存在")
+
+// publishCameraRoom 所有推流方式（HTTP、WebSocket、TS、WHIP、SRT）共用的推流前检查，返回推流的摄像头直播间
+func publishCameraRoom(pool *cameraBroker.CameraBroker, cfg config.PublishConfig, broadcasterKey string) (*cameraBroadcast.CameraBroadcaster, error) {
+	findBroadcaster, err := pool.FindBroadcaster(broadcasterKey)
+	if err != nil {
+		if !cfg.AutoCreate {
+			return nil, ErrRoomNotFound
+		}
+		findBroadcaster = findOrCreateCameraRoom(pool, broadcasterKey)
+	}
+	cameraRoom, ok := findBroadcaster.(*cameraBroadcast.CameraBroadcaster)
+	if !ok {
+		return nil, errors.New("直播间不是摄像头直播间 " + broadcasterKey)
+	}
+
+	if cameraRoom.Publishing() {
+		if cfg.OnDuplicate != config.DuplicateKick {
+			return nil, cameraBroadcast.ErrPublishing
+		}
+		if !cameraRoom.KickPublisher(kickPublisherTimeout) {
+			fmt.Println("旧的推流端未能断开:", broadcasterKey)
+			return nil, cameraBroadcast.ErrPublishing
+		}
+	}
+	return cameraRoom, nil
 }
 
 // ExecutePush ==================== HTTP ====================
 // ExecutePush 处理摄像头推上来的流数据
-func (cs *CameraService) ExecutePush(c *gin.Context, broadcasterKey string) {
+func (cs *CameraService) ExecutePush(c *gin.Context, broadcasterKey string) error {
 
-	findBroadcaster, err := cs.CameraBrokerPool.FindBroadcaster(broadcasterKey)
+	cameraRoom, err := cs.publishRoom(broadcasterKey)
 	if err != nil {
-		fmt.Printf("未找到对应的广播器 %s \n", broadcasterKey)
-		return
+		return err
 	}
 
 	// 开始不断接收推流
-	findBroadcaster.PullLoop(broadcast.BroadcasterOptional{GinContext: c})
+	// 推流结束后保留广播器供 HLS 回放，回放保留期过后由 cron.VODExpireTask 移除
+	return cameraRoom.Publish(broadcast.BroadcasterOptional{GinContext: c})
+}
+
+// ExecutePushWS 处理 WebSocket 推流：每条二进制消息是 FLV 流的一段，按顺序拼接后交给广播器解析
+//
+//	浏览器 MediaRecorder、移动端 SDK 无法维持一个很长的 POST 请求体，改用 WebSocket 推流。
+//	目前只支持 FLV，第一条消息不是 FLV 头时直接关闭连接。
+func (cs *CameraService) ExecutePushWS(c *gin.Context, broadcasterKey string) error {
+
+	// 升级前检查，拒绝时还能返回 HTTP 状态码
+	cameraRoom, err := cs.publishRoom(broadcasterKey)
+	if err != nil {
+		return err
+	}
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		fmt.Println("WebSocket 升级失败:", err)
+		return nil
+	}
+	defer conn.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(readWSIngest(conn, pw))
+	}()
+
+	// 阻塞直到推流结束：socket 关闭后管道返回 EOF，广播器冻结分片进入回放
+	if err := cameraRoom.Publish(broadcast.BroadcasterOptional{GinContext: c, Reader: pr}); err != nil {
+		fmt.Println("WebSocket 推流失败:", err)
+	}
+	_ = pr.Close()
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	return nil
+}
+
+// ExecutePushTS 处理硬件编码器以 chunked POST 推上来的 MPEG-TS：解出 PES 转成 FLV tag 后交给广播器
+//
+//	后续的 GOP 缓存、HTTP-FLV 分发、HLS 切片和回放与 FLV 推流完全一样。
+func (cs *CameraService) ExecutePushTS(c *gin.Context, broadcasterKey string) error {
+
+	cameraRoom, err := cs.publishRoom(broadcasterKey)
+	if err != nil {
+		return err
+	}
 
-	cs.CameraBrokerPool.RemoveBroadcaster(broadcasterKey)
+	flvReader := ts.NewFLVReader(c.Request.Body)
+	defer flvReader.Close()
+
+	// 阻塞直到推流结束：请求体读完后返回 EOF，广播器冻结分片进入回放
+	return cameraRoom.Publish(broadcast.BroadcasterOptional{GinContext: c, Reader: flvReader})
+}
+
+// readWSIngest 把推流端发来的二进制消息写入管道，同时用 ping/pong 检测推流端是否断线
+func readWSIngest(conn *websocket.Conn, w io.Writer) error {
+	const pongWait = 30 * time.Second
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(pongWait / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second)); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	first := true
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return io.EOF
+			}
+			return err
+		}
+		// 推流端发数据也算存活
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+		if msgType != websocket.BinaryMessage || len(data) == 0 {
+			continue
+		}
+		if first {
+			if len(data) < 3 || string(data[:3]) != "FLV" {
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "only FLV is supported"), time.Now().Add(time.Second))
+				return errors.New("WebSocket 推流只支持 FLV")
+			}
+			first = false
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
 }
 
 // ExecutePull 处理每一个链接上来的客户端的推流
@@ -55,19 +204,61 @@ func (cs *CameraService) ExecutePull(c *gin.Context, broadcasterKey, clientId st
 		return
 	}
 
+	defer findBroadcasterTemp.RemoveLiveClient(clientId)
+
+	// 出口带宽限制，由中间件放到请求的 context 中
+	egressViewer := egress.FromContext(c.Request.Context())
+
 	for {
 		select {
 		case pkt, ok := <-client.GetDataChan():
 			if !ok {
 				return
 			}
-			_, err := c.Writer.Write(pkt)
+			if !egressViewer.Wait(len(pkt), c.Request.Context().Done()) {
+				return
+			}
+			n, err := c.Writer.Write(pkt)
+			client.Add(n)
 			if err != nil {
 				return
 			}
 			flusher.Flush()
+		case <-findBroadcasterTemp.BroadcasterCloseSig:
+			// 直播间被关闭，结束响应
+			return
+		case <-client.Kicked():
+			// 客户端太慢被断开，由 defer 移出直播间
+			return
 		case <-c.Request.Context().Done():
 			return
 		}
 	}
 }
+
+// ExecuteHLS 以 HLS 方式返回推流切出的播放列表和分片
+func (cs *CameraService) ExecuteHLS(c *gin.Context, broadcasterKey, clientId string) error {
+
+	findBroadcaster, err := cs.CameraBrokerPool.FindBroadcaster(broadcasterKey)
+	if err != nil {
+		return errors.New("直播不存在！！！" + err.Error())
+	}
+	findBroadcasterTemp, _ := findBroadcaster.(*cameraBroadcast.CameraBroadcaster)
+
+	// HLS 是短连接，只用客户端对象生成播放列表，不加入广播器的实时分发
+	hlsLiveClient := &hlsClient.HLSLiveClient{BroadcasterKey: broadcasterKey, ClientId: clientId}
+	if strings.HasSuffix(c.Param("filepath"), "/index.m3u8") {
+		hlsLiveClient.HandleIndex(c.Writer, c.Request, findBroadcasterTemp.StreamState)
+		return nil
+	}
+	hlsLiveClient.HandleSegment(c.Writer, c.Request, findBroadcasterTemp.StreamState)
+	return nil
+}
+
+// findOrCreateCameraRoom 返回摄像头直播间，不存在时创建，创建的直播间回放过期后会被移除
+func findOrCreateCameraRoom(pool *cameraBroker.CameraBroker, broadcasterKey string) broadcast.Broadcaster {
+	b, _ := pool.FindOrAddBroadcaster(broadcasterKey, func() broadcast.Broadcaster {
+		return cameraBroadcast.NewAutoCameraBroadcaster(broadcasterKey)
+	})
+	return b
+}
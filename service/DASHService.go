@@ -0,0 +1,153 @@
+package service
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"pull2push/core/broadcast"
+	dashBroadcast "pull2push/core/broadcast/dash"
+	flvBroadcast "pull2push/core/broadcast/flv"
+	hlsBroadcast "pull2push/core/broadcast/hls"
+	flvBroker "pull2push/core/broker/flv"
+	hlsBroker "pull2push/core/broker/hls"
+	dashClient "pull2push/core/client/dash"
+	"pull2push/middleware"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dashPackagerIdleTimeout 超过该时间没有任何 DASH 请求，停止打包释放资源
+	dashPackagerIdleTimeout = time.Minute
+	// dashReadyTimeout 新建打包器时等待第一个分片切出的最长时间
+	dashReadyTimeout = 10 * time.Second
+)
+
+// DASHService 把 FLV/HLS 拉流转推的直播重新封装成 MPEG-DASH 输出
+type DASHService struct {
+	FLVBrokerPool *flvBroker.FLVBroker
+	HLSBrokerPool *hlsBroker.HLSBroker
+
+	packagerMutex sync.Mutex
+	packagers     map[string]*dashBroadcast.DASHPackager // map[broadcasterKey]DASHPackager，按需创建
+}
+
+// dashSource 一个可以输出 DASH 的广播器
+type dashSource struct {
+	broadcaster         broadcast.Broadcaster
+	packetSource        broadcast.PacketSource
+	clientCloseSig      chan<- string
+	broadcasterCloseSig <-chan broadcast.BROADCAST_CLOSE_TYPE
+}
+
+// ---------- HTTP 服务 ----------
+
+// LiveDASH 处理 DASH 请求
+//
+//	/api/live/dash/{broadcasterKey}/manifest.mpd           获取 MPD，可以通过 ?clientId= 指定会话编号
+//	/api/live/dash/{broadcasterKey}/{clientId}/{segment}    获取初始化分片和媒体分片
+func (ds *DASHService) LiveDASH(c *gin.Context, broadcasterKey string) error {
+	source, err := ds.findSource(broadcasterKey)
+	if err != nil {
+		return err
+	}
+	// 按需拉流：每次请求都记录一次访问，没有在拉流时开始拉流
+	if touchable, ok := source.broadcaster.(broadcast.Touchable); ok {
+		touchable.Touch()
+	}
+	packager := ds.findPackager(broadcasterKey, source.packetSource)
+	packager.Touch()
+
+	filepath := strings.TrimPrefix(c.Param("filepath"), "/")
+	if filepath == "manifest.mpd" {
+		// DASHSessionMiddleware 已经保证 MPD 请求带着 clientId
+		clientId := c.Query("clientId")
+		if clientId == "" {
+			clientId = middleware.NewSessionId()
+		}
+		// 带着 clientId 刷新 MPD 时沿用已有的会话，发送统计才能连续
+		var dashLiveClient *dashClient.DASHLiveClient
+		if liveClient, err := source.broadcaster.FindLiveClient(clientId); err == nil {
+			dashLiveClient, _ = liveClient.(*dashClient.DASHLiveClient)
+		}
+		if dashLiveClient == nil {
+			dashLiveClient, err = dashClient.NewDASHLiveClient(c, broadcasterKey, clientId, source.clientCloseSig, source.broadcasterCloseSig)
+			if err != nil {
+				return errors.New("客户端创建失败！！！" + err.Error())
+			}
+		}
+		source.broadcaster.AddLiveClient(clientId, dashLiveClient)
+
+		// 新建的打包器要等第一个关键帧间隔才能切出分片
+		deadline := time.Now().Add(dashReadyTimeout)
+		for !packager.Ready() && time.Now().Before(deadline) {
+			select {
+			case <-c.Request.Context().Done():
+				return nil
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+
+		dashLiveClient.HandleManifest(c.Writer, c.Request, packager)
+		return nil
+	}
+
+	parts := strings.SplitN(filepath, "/", 2)
+	if len(parts) != 2 {
+		return errors.New("无效的 DASH 地址 " + filepath)
+	}
+	liveClient, err := source.broadcaster.FindLiveClient(parts[0])
+	if err != nil {
+		return errors.New("为查询到对应的客户端！！！" + err.Error())
+	}
+	dashLiveClient, ok := liveClient.(*dashClient.DASHLiveClient)
+	if !ok {
+		return errors.New("客户端不是 DASH 会话 " + parts[0])
+	}
+	dashLiveClient.HandleSegment(c.Writer, c.Request, packager, parts[1])
+	return nil
+}
+
+// findSource 在 FLV、HLS 拉流转推中查找直播
+func (ds *DASHService) findSource(broadcasterKey string) (*dashSource, error) {
+	if ds.FLVBrokerPool != nil {
+		if b, err := ds.FLVBrokerPool.FindBroadcaster(broadcasterKey); err == nil {
+			if fb, ok := b.(*flvBroadcast.FLVBroadcaster); ok {
+				return &dashSource{broadcaster: fb, packetSource: fb, clientCloseSig: fb.ClientCloseSig, broadcasterCloseSig: fb.BroadcasterCloseSig}, nil
+			}
+		}
+	}
+	if ds.HLSBrokerPool != nil {
+		if b, err := ds.HLSBrokerPool.FindBroadcaster(broadcasterKey); err == nil {
+			if hb, ok := b.(*hlsBroadcast.HLSBroadcaster); ok {
+				return &dashSource{broadcaster: hb, packetSource: hb, clientCloseSig: hb.ClientCloseSig, broadcasterCloseSig: hb.CloseSignal()}, nil
+			}
+		}
+	}
+	return nil, errors.New("直播不存在！！！" + broadcasterKey)
+}
+
+// findPackager 返回直播对应的打包器，不存在时创建；顺便回收长时间无人观看的打包器
+//
+//	请求的直播也一样：打包器空闲太久（分片早已过时），或者直播间被关闭后重新创建（订阅的还是旧的广播器）时重新创建。
+func (ds *DASHService) findPackager(broadcasterKey string, source broadcast.PacketSource) *dashBroadcast.DASHPackager {
+	ds.packagerMutex.Lock()
+	defer ds.packagerMutex.Unlock()
+
+	if ds.packagers == nil {
+		ds.packagers = make(map[string]*dashBroadcast.DASHPackager)
+	}
+	for key, p := range ds.packagers {
+		if time.Since(p.IdleSince()) > dashPackagerIdleTimeout || (key == broadcasterKey && p.Source() != source) {
+			p.Close()
+			delete(ds.packagers, key)
+		}
+	}
+
+	p, ok := ds.packagers[broadcasterKey]
+	if !ok {
+		p = dashBroadcast.NewDASHPackager(broadcasterKey, source)
+		ds.packagers[broadcasterKey] = p
+	}
+	return p
+}
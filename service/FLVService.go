@@ -1,12 +1,17 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 	"io"
+	"net/http"
 	flvBroadcast "pull2push/core/broadcast/flv"
 	flvBroker "pull2push/core/broker/flv"
+	"pull2push/core/client"
 	flvClient "pull2push/core/client/flv"
+	"pull2push/core/egress"
 )
 
 // FLVService FLV拉流转推 Service 层
@@ -14,6 +19,13 @@ type FLVService struct {
 	FLVBrokerPool *flvBroker.FLVBroker
 }
 
+// wsUpgrader WebSocket 升级器，跨域规则和 HTTP 接口保持一致（全部放开）
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 64 * 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
 // ---------- HTTP 服务 ----------
 
 // LiveFlv 处理 flv 的拉流转推
@@ -41,9 +53,36 @@ func (fs *FLVService) LiveFlv(c *gin.Context, broadcasterKey, clientId string) {
 
 		findBroadcasterTemp.AddLiveClient(clientId, liveFLVClient)
 
-		// 这里写数据推送逻辑，或者直接阻塞直到连接关闭
-		<-c.Request.Context().Done()
+		// 数据由客户端的 Listen 协程写出，这里阻塞到它结束（连接断开、被断开或直播间关闭），之后不会再写响应
+		<-liveFLVClient.Done()
+		// 连接断开后移除客户端，按需拉流的直播间没有客户端后才能空闲断开
+		findBroadcasterTemp.RemoveLiveClient(clientId)
 		return false
 	})
 
 }
+
+// LiveFlvWS 以 WebSocket 方式输出 FLV，每个 tag 一个二进制帧，连接断开前一直阻塞
+func (fs *FLVService) LiveFlvWS(c *gin.Context, broadcasterKey, clientId string) error {
+
+	findBroadcaster, err := fs.FLVBrokerPool.FindBroadcaster(broadcasterKey)
+	if err != nil {
+		return errors.New("直播不存在！！！" + err.Error())
+	}
+	findBroadcasterTemp, _ := findBroadcaster.(*flvBroadcast.FLVBroadcaster)
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade 失败时已经写回了错误响应
+		fmt.Println("WebSocket 升级失败:", err)
+		return nil
+	}
+
+	wsClient := flvClient.NewWSFLVLiveClient(conn, broadcasterKey, clientId, client.NewMeter(clientId, c.ClientIP(), c.Request.UserAgent()), egress.FromContext(c.Request.Context()), findBroadcasterTemp.BroadcasterCloseSig)
+	// 加入时先收到 FLV 头 + 序列头 + 最近一个 GOP，之后是实时的 tag
+	findBroadcasterTemp.AddLiveClient(clientId, wsClient)
+
+	<-wsClient.Done()
+	findBroadcasterTemp.RemoveLiveClient(clientId)
+	return nil
+}
@@ -30,19 +30,28 @@ func (hs HLSService) LiveHLS(c *gin.Context, broadcasterKey, clientId string) er
 	}
 
 	findBroadcasterTemp, _ := findBroadcaster.(*hlsBroadcast.HLSBroadcaster)
+	// 按需拉流：HLS 客户端没有断开事件，每次请求都记录一次访问
+	findBroadcasterTemp.Touch()
 
 	filepath := c.Param("filepath")
 	//  "xxx/index.m3u8" 结尾的就是第一次请求，这时通过 HandleIndex 接口第一次返回本地缓存的数据片给前端使用
 	if strings.HasSuffix(filepath, "/index.m3u8") {
 
-		hlsLiveClient, err := hlsClient.NewHLSLiveClient(c, broadcasterKey, clientId, findBroadcasterTemp.ClientCloseSig, findBroadcasterTemp.BroadcasterCloseSig)
-		if err != nil {
-			return errors.New("客户端创建失败！！！" + err.Error())
+		// 播放器会反复请求播放列表，同一个会话沿用已有的客户端，发送统计才能连续
+		var hlsLiveClient *hlsClient.HLSLiveClient
+		if liveClient, err := findBroadcasterTemp.FindLiveClient(clientId); err == nil {
+			hlsLiveClient, _ = liveClient.(*hlsClient.HLSLiveClient)
+		}
+		if hlsLiveClient == nil {
+			hlsLiveClient, err = hlsClient.NewHLSLiveClient(c, broadcasterKey, clientId, findBroadcasterTemp.ClientCloseSig, findBroadcasterTemp.CloseSignal())
+			if err != nil {
+				return errors.New("客户端创建失败！！！" + err.Error())
+			}
 		}
 		findBroadcasterTemp.AddLiveClient(clientId, hlsLiveClient)
 
 		// 第一次链接，返回最新的直播数据分片
-		hlsLiveClient.HandleIndex(c.Writer, c.Request, findBroadcasterTemp)
+		hlsLiveClient.HandleIndex(c.Writer, c.Request, findBroadcasterTemp.StreamState0)
 
 		return nil
 	}
@@ -54,7 +63,7 @@ func (hs HLSService) LiveHLS(c *gin.Context, broadcasterKey, clientId string) er
 	}
 	hlsLiveClient, _ := liveClient.(*hlsClient.HLSLiveClient)
 	// 返回本地缓存的数据分片
-	hlsLiveClient.HandleSegment(c.Writer, c.Request, findBroadcasterTemp)
+	hlsLiveClient.HandleSegment(c.Writer, c.Request, findBroadcasterTemp.StreamState0)
 	// /live/hls/test-hls/c91b431e-ba21-47c9-8649-a05ce2490838/index.m3u8
 	return nil
 
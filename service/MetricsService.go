@@ -0,0 +1,137 @@
+package service
+
+import (
+	"pull2push/core/broadcast"
+	"pull2push/core/broker"
+	"pull2push/core/egress"
+	"pull2push/event"
+	"pull2push/metrics"
+	"pull2push/middleware"
+	"sort"
+)
+
+// MetricsService Prometheus 指标 Service 层
+type MetricsService struct {
+	Brokers       []broker.Broker // 所有直播类型的 Broker
+	ViewerLimiter *middleware.ViewerLimiter
+	EgressLimiter *egress.Limiter
+	EventBus      *event.EventBus
+}
+
+// streamMetric 直播间维度的一个指标，标签为 protocol、stream
+type streamMetric struct {
+	name, typ, help string
+	value           func(s *broadcast.StreamStats) float64
+}
+
+var streamMetrics = []streamMetric{
+	{"pull2push_upstream_up", metrics.TypeGauge, "Whether the upstream is currently delivering data (1) or not (0).", func(s *broadcast.StreamStats) float64 {
+		return boolFloat(s.UpstreamState == broadcast.UpstreamStreaming)
+	}},
+	{"pull2push_upstream_reconnects_total", metrics.TypeCounter, "Times the upstream started delivering data again after a disconnect.", func(s *broadcast.StreamStats) float64 {
+		return float64(s.Reconnects)
+	}},
+	{"pull2push_upstream_uptime_seconds", metrics.TypeGauge, "Seconds since the upstream started delivering data this time.", func(s *broadcast.StreamStats) float64 {
+		return float64(s.Uptime)
+	}},
+	{"pull2push_stream_bytes_in_total", metrics.TypeCounter, "Bytes received from the upstream.", func(s *broadcast.StreamStats) float64 {
+		return float64(s.BytesIn)
+	}},
+	{"pull2push_stream_bytes_out_total", metrics.TypeCounter, "Bytes sent to viewers, including viewers that already left.", func(s *broadcast.StreamStats) float64 {
+		return float64(s.BytesOut)
+	}},
+	{"pull2push_stream_bitrate_kbps", metrics.TypeGauge, "Current upstream bitrate in kbps.", func(s *broadcast.StreamStats) float64 {
+		return s.Bitrate
+	}},
+	{"pull2push_stream_fps", metrics.TypeGauge, "Current upstream video frame rate.", func(s *broadcast.StreamStats) float64 {
+		return s.FPS
+	}},
+	{"pull2push_stream_viewers", metrics.TypeGauge, "Current viewers.", func(s *broadcast.StreamStats) float64 {
+		return float64(s.Viewers)
+	}},
+	{"pull2push_stream_dropped_packets_total", metrics.TypeCounter, "Packets dropped because a viewer could not keep up.", func(s *broadcast.StreamStats) float64 {
+		return float64(s.Dropped)
+	}},
+}
+
+// upstreamStates pull2push_upstream_state 输出的所有状态，当前状态为 1，其余为 0
+var upstreamStates = []string{broadcast.UpstreamIdle, broadcast.UpstreamConnecting, broadcast.UpstreamStreaming, broadcast.UpstreamSlate, broadcast.UpstreamEnded}
+
+// ---------- HTTP 服务 ----------
+
+// Metrics 按 Prometheus 文本格式输出所有指标
+func (ms *MetricsService) Metrics() []byte {
+	w := metrics.NewWriter()
+	ms.writeStreams(w)
+	ms.writeNode(w)
+	metrics.WriteHistograms(w)
+	return w.Bytes()
+}
+
+// writeStreams 直播间维度的指标，抓取时从各广播器的 Stats 现算
+func (ms *MetricsService) writeStreams(w *metrics.Writer) {
+	list := (&StatsService{Brokers: ms.Brokers}).Streams(false)
+
+	w.Header("pull2push_upstream_state", metrics.TypeGauge, "Upstream connection state, 1 for the current state.")
+	for i := range list {
+		for _, state := range upstreamStates {
+			w.Sample("pull2push_upstream_state", boolFloat(list[i].UpstreamState == state), "protocol", list[i].Protocol, "stream", list[i].BroadcasterKey, "state", state)
+		}
+	}
+	for _, m := range streamMetrics {
+		w.Header(m.name, m.typ, m.help)
+		for i := range list {
+			w.Sample(m.name, m.value(&list[i]), "protocol", list[i].Protocol, "stream", list[i].BroadcasterKey)
+		}
+	}
+}
+
+// writeNode 本节点维度的指标：观看限制、出口带宽、事件总线
+func (ms *MetricsService) writeNode(w *metrics.Writer) {
+	viewerStats := ms.ViewerLimiter.Stats()
+	w.Header("pull2push_node_viewers", metrics.TypeGauge, "Viewers admitted by the viewer limiter on this node.")
+	w.Sample("pull2push_node_viewers", float64(viewerStats.Viewers))
+	w.Header("pull2push_viewer_rejected_total", metrics.TypeCounter, "Viewers rejected by the viewer limiter.")
+	for _, reason := range sortedKeys(viewerStats.Rejected) {
+		w.Sample("pull2push_viewer_rejected_total", float64(viewerStats.Rejected[reason]), "reason", reason)
+	}
+
+	egressStats := ms.EgressLimiter.Stats()
+	w.Header("pull2push_egress_bytes_per_second", metrics.TypeGauge, "Egress rate of this node over the last second.")
+	w.Sample("pull2push_egress_bytes_per_second", egressStats.NodeUsage)
+	w.Header("pull2push_egress_refused_total", metrics.TypeCounter, "Viewers refused because egress bandwidth was exhausted.")
+	for _, reason := range sortedKeys(egressStats.Refused) {
+		w.Sample("pull2push_egress_refused_total", float64(egressStats.Refused[reason]), "reason", reason)
+	}
+
+	if ms.EventBus == nil {
+		return
+	}
+	w.Header("pull2push_eventbus_dropped_total", metrics.TypeCounter, "Events dropped because a subscriber queue was full.")
+	w.Sample("pull2push_eventbus_dropped_total", float64(ms.EventBus.Dropped()))
+	queued := 0
+	subscribers := ms.EventBus.Stats()
+	for _, sub := range subscribers {
+		queued += sub.Queued
+	}
+	w.Header("pull2push_eventbus_subscribers", metrics.TypeGauge, "Current EventBus subscribers.")
+	w.Sample("pull2push_eventbus_subscribers", float64(len(subscribers)))
+	w.Header("pull2push_eventbus_queued", metrics.TypeGauge, "Events waiting in subscriber queues.")
+	w.Sample("pull2push_eventbus_queued", float64(queued))
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
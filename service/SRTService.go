@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/datarhei/gosrt"
+	"log"
+	"net"
+	"net/url"
+	"pull2push/config"
+	"pull2push/core/broadcast"
+	cameraBroadcast "pull2push/core/broadcast/camera"
+	cameraBroker "pull2push/core/broker/camera"
+	"pull2push/core/media/ts"
+	"pull2push/event/payload"
+	"pull2push/middleware"
+	"pull2push/webhook"
+	"strings"
+	"time"
+)
+
+// SRTService SRT 推流 Service 层
+//
+//	listener 模式：编码器主动连接，stream id 决定进入哪个直播间；
+//	caller 模式：服务端主动连接处于 listener 模式的编码器。
+//	两种模式收到的都是 MPEG-TS，转换成 FLV 后进入同名的摄像头直播间，和 HTTP/WebSocket 推流在同一个 Broker 中。
+type SRTService struct {
+	CameraBrokerPool *cameraBroker.CameraBroker
+	Publish          config.PublishConfig // 和 HTTP 推流一样校验推流密钥（streamid 中的 key）、自动创建直播间、处理重复推流
+	Passphrase       string               // 为空时不加密
+	Latency          time.Duration        // 为 0 时使用 gosrt 的默认值
+	Hooks            *webhook.Client      // listener 模式接收推流前调用 on_publish，为 nil 时不回调
+}
+
+// Config 生成一个连接的 SRT 配置
+func (ss *SRTService) Config(streamId, passphrase string) srt.Config {
+	config := srt.DefaultConfig()
+	config.StreamId = streamId
+	config.Passphrase = passphrase
+	if ss.Latency > 0 {
+		config.Latency = ss.Latency
+		config.ReceiverLatency = ss.Latency
+		config.PeerLatency = ss.Latency
+	}
+	return config
+}
+
+// Serve listener 模式：在 ln 上接收推流连接，直到 ln 被关闭
+func (ss *SRTService) Serve(ln srt.Listener) error {
+	for {
+		req, err := ln.Accept2()
+		if err != nil {
+			return err
+		}
+		go ss.handleRequest(req)
+	}
+}
+
+// handleRequest 校验 stream id、口令和推流密钥，通过后开始接收推流
+func (ss *SRTService) handleRequest(req srt.ConnRequest) {
+	broadcasterKey, mode, streamKey := ParseSRTStreamId(req.StreamId())
+	if broadcasterKey == "" {
+		req.Reject(srt.REJX_BAD_REQUEST)
+		return
+	}
+	if mode != "publish" {
+		// 只接收推流，观看请使用 HTTP-FLV/HLS
+		req.Reject(srt.REJX_BAD_MODE)
+		return
+	}
+
+	if ss.Passphrase != "" {
+		if !req.IsEncrypted() {
+			req.Reject(srt.REJ_UNSECURE)
+			return
+		}
+		if err := req.SetPassphrase(ss.Passphrase); err != nil {
+			req.Reject(srt.REJ_BADSECRET)
+			return
+		}
+	} else if req.IsEncrypted() {
+		req.Reject(srt.REJ_UNSECURE)
+		return
+	}
+
+	if err := middleware.CheckStreamKey(ss.Publish, broadcasterKey, streamKey); err != nil {
+		log.Println("SRT 推流被拒绝:", broadcasterKey, err)
+		if err == middleware.ErrStreamKeyMissing {
+			req.Reject(srt.REJX_UNAUTHORIZED)
+		} else {
+			req.Reject(srt.REJX_FORBIDDEN)
+		}
+		return
+	}
+
+	ip, _, _ := net.SplitHostPort(req.RemoteAddr().String())
+	hookReq := webhook.Request{BroadcasterKey: broadcasterKey, Protocol: payload.ProtocolCamera, IP: ip, Param: req.StreamId()}
+	if err := ss.Hooks.OnPublish(context.Background(), hookReq); err != nil {
+		log.Println("SRT 推流被拒绝:", broadcasterKey, err)
+		req.Reject(srt.REJX_FORBIDDEN)
+		return
+	}
+
+	cameraRoom, err := publishCameraRoom(ss.CameraBrokerPool, ss.Publish, broadcasterKey)
+	if err != nil {
+		log.Println("SRT 推流被拒绝:", broadcasterKey, err)
+		switch err {
+		case ErrRoomNotFound:
+			req.Reject(srt.REJX_NOTFOUND)
+		case cameraBroadcast.ErrPublishing:
+			req.Reject(srt.REJX_CONFLICT)
+		default:
+			req.Reject(srt.REJX_FORBIDDEN)
+		}
+		return
+	}
+
+	conn, err := req.Accept()
+	if err != nil {
+		log.Println("SRT 接受连接失败:", err)
+		return
+	}
+	log.Println("SRT 开始推流:", broadcasterKey, conn.RemoteAddr())
+	ss.ingest(conn, cameraRoom)
+}
+
+// Call caller 模式：连接编码器并接收推流，断开后按退避时间重连，直到 ctx 被取消
+//
+//	直播间来自服务端自己的配置，不校验推流密钥，不存在时总是创建；直播间正在推流时按 Publish.OnDuplicate 处理。
+func (ss *SRTService) Call(ctx context.Context, address, streamId, passphrase, broadcasterKey string) {
+	if passphrase == "" {
+		passphrase = ss.Passphrase
+	}
+	publish := ss.Publish
+	publish.AutoCreate = true
+
+	backoff := time.Second
+	for ctx.Err() == nil {
+		var conn srt.Conn
+		cameraRoom, err := publishCameraRoom(ss.CameraBrokerPool, publish, broadcasterKey)
+		if err == nil {
+			conn, err = srt.Dial("srt", address, ss.Config(streamId, passphrase))
+		}
+		if err != nil {
+			log.Println("SRT 连接编码器失败:", broadcasterKey, address, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > 30*time.Second {
+				backoff = 30 * time.Second
+			}
+			continue
+		}
+		backoff = time.Second
+
+		log.Println("SRT 开始拉流:", broadcasterKey, address)
+		stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+		ss.ingest(conn, cameraRoom)
+		stop()
+	}
+}
+
+// ingest 把 SRT 连接中的 MPEG-TS 转成 FLV 交给摄像头直播间，连接断开后直播间进入回放
+func (ss *SRTService) ingest(conn srt.Conn, cameraRoom *cameraBroadcast.CameraBroadcaster) {
+	defer conn.Close()
+
+	flvReader := ts.NewFLVReader(conn)
+	defer flvReader.Close()
+
+	cameraRoom.PullLoop(broadcast.BroadcasterOptional{Reader: flvReader})
+	log.Println("SRT 推流结束:", cameraRoom.BroadcasterKey)
+}
+
+// ParseSRTStreamId 从 stream id 中解析直播间、模式和推流密钥，mode 为 publish 或 request
+//
+//	支持 SRT Access Control 格式 "#!::r=live/test-srt,m=publish,key=s3cret"（取 r 的最后一段作为直播间），
+//	以及简写 "publish:test-srt?key=s3cret"、"test-srt"（默认为 publish）。
+func ParseSRTStreamId(streamId string) (broadcasterKey, mode, streamKey string) {
+	mode = "publish"
+	if rest, ok := strings.CutPrefix(streamId, "#!::"); ok {
+		for _, kv := range strings.Split(rest, ",") {
+			k, v, _ := strings.Cut(kv, "=")
+			switch k {
+			case "r":
+				broadcasterKey = v[strings.LastIndex(v, "/")+1:]
+			case "m":
+				mode = v
+			case "key":
+				streamKey = v
+			}
+		}
+		return broadcasterKey, mode, streamKey
+	}
+	streamId, query, _ := strings.Cut(streamId, "?")
+	if values, err := url.ParseQuery(query); err == nil {
+		streamKey = values.Get("key")
+	}
+	if m, key, ok := strings.Cut(streamId, ":"); ok {
+		return key, m, streamKey
+	}
+	return streamId, mode, streamKey
+}
+
+// ListenSRT 按配置开启 listener，返回的 Listener 由调用方关闭
+func (ss *SRTService) ListenSRT(address string) (srt.Listener, error) {
+	ln, err := srt.Listen("srt", address, ss.Config("", ""))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("SRT 监听 %s 失败: %s", address, err.Error()))
+	}
+	return ln, nil
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"pull2push/config"
+	cameraBroadcast "pull2push/core/broadcast/camera"
+	cameraBroker "pull2push/core/broker/camera"
+	"pull2push/core/media/testsrc"
+	"pull2push/core/media/ts"
+	"pull2push/event"
+	"pull2push/event/payload"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/datarhei/gosrt"
+)
+
+// listenSRT 在本机随机端口开启 SRT listener，测试结束时关闭
+func listenSRT(t *testing.T, ss *SRTService) string {
+	t.Helper()
+	ln, err := ss.ListenSRT("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = ss.Serve(ln)
+	}()
+	t.Cleanup(ln.Close)
+	return ln.Addr().String()
+}
+
+// rejection gosrt 拒绝连接时 Dial 返回的错误
+func rejection(reason srt.RejectionReason) string {
+	return "connection rejected: REJECT (" + strconv.FormatUint(uint64(reason), 32) + ")"
+}
+
+// TestSRTPublishLoopback 本机 SRT 推流端把 testsrc 转成 TS 推上来，摄像头直播间解析出视频，断开后结束推流
+func TestSRTPublishLoopback(t *testing.T) {
+	pool := cameraBroker.NewCameraBroker()
+	bus := event.NewEventBus()
+	pool.SetEventBus(bus)
+	events := bus.SubscribeMultiple([]event.EventType{event.StreamPublished, event.StreamUnpublished})
+
+	ss := &SRTService{
+		CameraBrokerPool: pool,
+		Publish:          config.PublishConfig{StreamKeys: map[string]string{"srt-room": "s3cret"}, AutoCreate: true},
+	}
+	addr := listenSRT(t, ss)
+
+	conn, err := srt.Dial("srt", addr, ss.Config("publish:srt-room?key=s3cret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	src, err := testsrc.OpenFLV("testsrc://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	sent := make(chan error, 1)
+	go func() {
+		sent <- ts.CopyFLVAsTS(conn, src)
+	}()
+
+	select {
+	case e := <-events:
+		if e.Type != event.StreamPublished {
+			t.Fatalf("event = %s, want StreamPublished", e.Type)
+		}
+		if p := e.Payload.(payload.StreamPayload); p.BroadcasterKey != "srt-room" || p.Protocol != payload.ProtocolCamera {
+			t.Fatalf("payload = %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no StreamPublished after SRT publish")
+	}
+
+	b, err := pool.FindBroadcaster("srt-room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	room := b.(*cameraBroadcast.CameraBroadcaster)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		stats := room.Stats()
+		if stats.Video.Codec != "" && stats.Video.Width == 320 && stats.Video.Height == 240 && stats.Audio.Codec != "" && stats.BytesIn > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stats = %+v, want 320x240 video with audio", stats)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// 同一个直播间已经在推流，默认拒绝新的推流端
+	if dup, err := srt.Dial("srt", addr, ss.Config("publish:srt-room?key=s3cret", "")); err == nil {
+		dup.Close()
+		t.Fatal("duplicate publisher accepted")
+	} else if err.Error() != rejection(srt.REJX_CONFLICT) {
+		t.Fatalf("duplicate publisher: %v, want %s", err, rejection(srt.REJX_CONFLICT))
+	}
+
+	// 推流端断开：推流结束，直播间保留供回放
+	_ = src.Close()
+	if err := <-sent; err != nil && err != io.ErrClosedPipe {
+		t.Fatal(err)
+	}
+	conn.Close()
+	select {
+	case e := <-events:
+		if e.Type != event.StreamUnpublished {
+			t.Fatalf("event = %s, want StreamUnpublished", e.Type)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no StreamUnpublished after SRT publisher closed")
+	}
+	if _, ended := room.EndedAt(); !ended || room.Publishing() {
+		t.Fatal("room still publishing after SRT publisher closed")
+	}
+}
+
+// TestSRTReject 推流前的各项检查不通过时按 SRT 拒绝码拒绝连接
+func TestSRTReject(t *testing.T) {
+	pool := cameraBroker.NewCameraBroker()
+	ss := &SRTService{
+		CameraBrokerPool: pool,
+		Publish:          config.PublishConfig{StreamKeys: map[string]string{"srt-room": "s3cret"}},
+		Passphrase:       "passphrase-123",
+	}
+	addr := listenSRT(t, ss)
+	findOrCreateCameraRoom(pool, "srt-room")
+
+	tests := []struct {
+		streamId   string
+		passphrase string
+		reason     srt.RejectionReason
+	}{
+		{"", "passphrase-123", srt.REJX_BAD_REQUEST},
+		{"request:srt-room", "passphrase-123", srt.REJX_BAD_MODE},
+		{"publish:srt-room?key=s3cret", "", srt.REJ_UNSECURE},
+		{"publish:srt-room", "passphrase-123", srt.REJX_UNAUTHORIZED},
+		{"publish:srt-room?key=wrong", "passphrase-123", srt.REJX_FORBIDDEN},
+		{"publish:missing-room", "passphrase-123", srt.REJX_NOTFOUND},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q", tt.streamId), func(t *testing.T) {
+			conn, err := srt.Dial("srt", addr, ss.Config(tt.streamId, tt.passphrase))
+			if err == nil {
+				conn.Close()
+				t.Fatal("connection accepted")
+			}
+			if err.Error() != rejection(tt.reason) {
+				t.Fatalf("err = %v, want %s", err, rejection(tt.reason))
+			}
+		})
+	}
+}
+
+func TestParseSRTStreamId(t *testing.T) {
+	tests := []struct {
+		streamId                        string
+		broadcasterKey, mode, streamKey string
+	}{
+		{"test-srt", "test-srt", "publish", ""},
+		{"publish:test-srt?key=s3cret", "test-srt", "publish", "s3cret"},
+		{"request:test-srt", "test-srt", "request", ""},
+		{"#!::r=live/test-srt,m=publish,key=s3cret", "test-srt", "publish", "s3cret"},
+		{"#!::r=test-srt,m=request", "test-srt", "request", ""},
+		{"#!::m=publish", "", "publish", ""},
+	}
+	for _, tt := range tests {
+		broadcasterKey, mode, streamKey := ParseSRTStreamId(tt.streamId)
+		if broadcasterKey != tt.broadcasterKey || mode != tt.mode || streamKey != tt.streamKey {
+			t.Errorf("ParseSRTStreamId(%q) = %q, %q, %q, want %q, %q, %q", tt.streamId, broadcasterKey, mode, streamKey, tt.broadcasterKey, tt.mode, tt.streamKey)
+		}
+	}
+}
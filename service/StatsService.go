@@ -0,0 +1,61 @@
+package service
+
+import (
+	"errors"
+	"pull2push/core/broadcast"
+	"pull2push/core/broker"
+	"sort"
+)
+
+// StatsService 直播间实时统计 Service 层
+type StatsService struct {
+	Brokers []broker.Broker // 所有直播类型的 Broker
+}
+
+// ---------- HTTP 服务 ----------
+
+// Streams 返回所有直播间的实时统计，按直播间、协议排序；withClients 为 false 时不返回客户端列表
+func (ss *StatsService) Streams(withClients bool) []broadcast.StreamStats {
+	list := make([]broadcast.StreamStats, 0)
+	for _, b := range ss.Brokers {
+		for _, bc := range b.ListBroadcaster() {
+			sp, ok := bc.(broadcast.StatsProvider)
+			if !ok {
+				continue
+			}
+			stats := sp.Stats()
+			if !withClients {
+				stats.Clients = nil
+			}
+			list = append(list, stats)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].BroadcasterKey != list[j].BroadcasterKey {
+			return list[i].BroadcasterKey < list[j].BroadcasterKey
+		}
+		return list[i].Protocol < list[j].Protocol
+	})
+	return list
+}
+
+// Stream 返回一个直播间的实时统计
+//
+//	同一个直播间可能同时存在于多个 Broker 中（如 WHIP 推流会桥接到同名的摄像头直播间），每个协议一条。
+func (ss *StatsService) Stream(broadcasterKey string) ([]broadcast.StreamStats, error) {
+	list := make([]broadcast.StreamStats, 0, 1)
+	for _, b := range ss.Brokers {
+		bc, err := b.FindBroadcaster(broadcasterKey)
+		if err != nil {
+			continue
+		}
+		if sp, ok := bc.(broadcast.StatsProvider); ok {
+			list = append(list, sp.Stats())
+		}
+	}
+	if len(list) == 0 {
+		return nil, errors.New("直播不存在！！！" + broadcasterKey)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Protocol < list[j].Protocol })
+	return list, nil
+}
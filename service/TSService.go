@@ -0,0 +1,53 @@
+package service
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"pull2push/core/broadcast"
+	cameraBroadcast "pull2push/core/broadcast/camera"
+	flvBroadcast "pull2push/core/broadcast/flv"
+	cameraBroker "pull2push/core/broker/camera"
+	flvBroker "pull2push/core/broker/flv"
+	tsClient "pull2push/core/client/ts"
+)
+
+// TSService 连续 MPEG-TS 观看 Service 层，拉流转推的 FLV 直播和摄像头推流都可以用 TS 观看
+type TSService struct {
+	FLVBrokerPool    *flvBroker.FLVBroker
+	CameraBrokerPool *cameraBroker.CameraBroker
+}
+
+// LiveTS 以 video/mp2t 持续输出直播，连接断开或直播结束前一直阻塞
+func (ts *TSService) LiveTS(c *gin.Context, broadcasterKey, clientId string) error {
+	broadcaster, broadcasterCloseSig, err := ts.findBroadcaster(broadcasterKey)
+	if err != nil {
+		return err
+	}
+
+	c.Header("Content-Type", "video/mp2t")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	tsLiveClient := tsClient.NewTSLiveClient(c, broadcasterKey, clientId, broadcasterCloseSig)
+	// 加入时先收到 FLV 头 + 序列头 + 最近一个 GOP，TS 从这个 GOP 的关键帧开始输出
+	broadcaster.AddLiveClient(clientId, tsLiveClient)
+
+	<-tsLiveClient.Done()
+	broadcaster.RemoveLiveClient(clientId)
+	return nil
+}
+
+// findBroadcaster 先查拉流转推的 FLV 直播，再查摄像头推流
+func (ts *TSService) findBroadcaster(broadcasterKey string) (broadcast.Broadcaster, <-chan broadcast.BROADCAST_CLOSE_TYPE, error) {
+	if b, err := ts.FLVBrokerPool.FindBroadcaster(broadcasterKey); err == nil {
+		return b, b.(*flvBroadcast.FLVBroadcaster).BroadcasterCloseSig, nil
+	}
+	b, err := ts.CameraBrokerPool.FindBroadcaster(broadcasterKey)
+	if err != nil {
+		return nil, nil, errors.New("直播不存在！！！" + err.Error())
+	}
+	return b, b.(*cameraBroadcast.CameraBroadcaster).BroadcasterCloseSig, nil
+}
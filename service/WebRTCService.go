@@ -0,0 +1,160 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"pull2push/config"
+	"pull2push/core/broadcast"
+	cameraBroadcast "pull2push/core/broadcast/camera"
+	webrtcBroadcast "pull2push/core/broadcast/webrtc"
+	cameraBroker "pull2push/core/broker/camera"
+	webrtcBroker "pull2push/core/broker/webrtc"
+	"pull2push/core/client"
+	webrtcClient "pull2push/core/client/webrtc"
+	"pull2push/core/egress"
+	"pull2push/middleware"
+)
+
+// maxSDPSize SDP 请求体的最大长度
+const maxSDPSize = 64 * 1024
+
+// WebRTCService WHIP 推流、WHEP 观看 Service 层
+type WebRTCService struct {
+	WebRTCBrokerPool *webrtcBroker.WebRTCBroker
+	CameraBrokerPool *cameraBroker.CameraBroker // WHIP 推流桥接成 FLV 后交给同名的摄像头直播间
+	Publish          config.PublishConfig       // 推流密钥在中间件中校验，这里和摄像头推流一样处理自动创建直播间和重复推流
+}
+
+// ---------- HTTP 服务 ----------
+
+// WHIP 处理 WHIP 推流：请求体为 SDP offer，返回 201 + SDP answer，Location 为结束推流用的会话地址
+//
+//	同名的摄像头直播间按 Publish 配置自动创建，WebRTC 推流可以同时用 /api/live/camera 下的 HTTP-FLV、HLS 观看。
+//	正在推流时（WHIP 或其他方式）按 Publish.OnDuplicate 拒绝或顶掉旧的推流端。
+func (ws *WebRTCService) WHIP(c *gin.Context, broadcasterKey string) error {
+	offer, err := readSDP(c)
+	if err != nil {
+		return err
+	}
+	if old, err := ws.findWebRTCBroadcaster(broadcasterKey); err == nil {
+		if ws.Publish.OnDuplicate != config.DuplicateKick {
+			return cameraBroadcast.ErrPublishing
+		}
+		// 旧的 WHIP 推流结束后 FLV 桥接随之结束，摄像头直播间的推流端由 publishCameraRoom 等待退出
+		old.Close(broadcast.BrokerEnd)
+	}
+	cameraRoom, err := publishCameraRoom(ws.CameraBrokerPool, ws.Publish, broadcasterKey)
+	if err != nil {
+		return err
+	}
+
+	sessionId := middleware.NewSessionId()
+	wb, answer, err := webrtcBroadcast.NewWebRTCBroadcaster(broadcasterKey, sessionId, offer)
+	if err != nil {
+		return err
+	}
+	// 同时有两个 WHIP 推流到同一个直播间时只保留先加入的
+	if _, created := ws.WebRTCBrokerPool.FindOrAddBroadcaster(broadcasterKey, func() broadcast.Broadcaster { return wb }); !created {
+		wb.Close(broadcast.BrokerEnd)
+		return cameraBroadcast.ErrPublishing
+	}
+	// 推流端拿到 answer 之后才能建立连接，这里记录的状态不会覆盖 OnConnectionStateChange 中的
+	wb.SetUpstream(c.ClientIP(), broadcast.UpstreamConnecting)
+
+	// FLV 桥接：推流结束时管道返回 EOF，摄像头直播间进入回放
+	go func() {
+		flvReader := wb.FLVReader()
+		cameraRoom.PullLoop(broadcast.BroadcasterOptional{Reader: flvReader})
+		_ = flvReader.Close()
+	}()
+
+	// 推流结束时 Close 会把广播器从 Broker 中移除
+	go wb.PullLoop(broadcast.BroadcasterOptional{})
+
+	c.Header("Location", fmt.Sprintf("/api/live/whip/%s/%s", broadcasterKey, sessionId))
+	c.Data(http.StatusCreated, "application/sdp", []byte(answer))
+	return nil
+}
+
+// WHIPDelete 推流端结束推流
+func (ws *WebRTCService) WHIPDelete(c *gin.Context, broadcasterKey, sessionId string) error {
+	wb, err := ws.findWebRTCBroadcaster(broadcasterKey)
+	if err != nil {
+		return err
+	}
+	if wb.SessionId != sessionId {
+		return errors.New("WHIP 会话不存在！！！" + sessionId)
+	}
+	wb.Close(broadcast.BrokerEnd)
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// WHEP 处理 WHEP 观看：请求体为 SDP offer，返回 201 + SDP answer，Location 为结束观看用的会话地址
+func (ws *WebRTCService) WHEP(c *gin.Context, broadcasterKey string) error {
+	offer, err := readSDP(c)
+	if err != nil {
+		return err
+	}
+	wb, err := ws.findWebRTCBroadcaster(broadcasterKey)
+	if err != nil {
+		return err
+	}
+
+	clientId := middleware.NewSessionId()
+	whepLiveClient, answer, err := webrtcClient.NewWHEPLiveClient(broadcasterKey, clientId, offer, wb.Tracks(), wb.RequestKeyFrame, client.NewMeter(clientId, c.ClientIP(), c.Request.UserAgent()), egress.FromContext(c.Request.Context()), wb.ClientCloseSig, wb.BroadcasterCloseSig)
+	if err != nil {
+		return errors.New("WHEP 协商失败！！！" + err.Error())
+	}
+	wb.AddLiveClient(clientId, whepLiveClient)
+
+	// 请求只负责交换 SDP，观看名额和出口带宽保留到连接断开
+	release := middleware.Hold(c)
+	go func() {
+		<-whepLiveClient.Done()
+		release()
+	}()
+
+	c.Header("Location", fmt.Sprintf("/api/live/whep/%s/%s", broadcasterKey, clientId))
+	c.Data(http.StatusCreated, "application/sdp", []byte(answer))
+	return nil
+}
+
+// WHEPDelete 观众结束观看
+func (ws *WebRTCService) WHEPDelete(c *gin.Context, broadcasterKey, clientId string) error {
+	wb, err := ws.findWebRTCBroadcaster(broadcasterKey)
+	if err != nil {
+		return err
+	}
+	liveClient, err := wb.FindLiveClient(clientId)
+	if err != nil {
+		return errors.New("WHEP 会话不存在！！！" + err.Error())
+	}
+	liveClient.(*webrtcClient.WHEPLiveClient).Close()
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// findWebRTCBroadcaster 查找正在 WHIP 推流的直播间
+func (ws *WebRTCService) findWebRTCBroadcaster(broadcasterKey string) (*webrtcBroadcast.WebRTCBroadcaster, error) {
+	findBroadcaster, err := ws.WebRTCBrokerPool.FindBroadcaster(broadcasterKey)
+	if err != nil {
+		return nil, errors.New("直播不存在！！！" + err.Error())
+	}
+	return findBroadcaster.(*webrtcBroadcast.WebRTCBroadcaster), nil
+}
+
+// readSDP 读取请求体中的 SDP offer
+func readSDP(c *gin.Context) (string, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSDPSize))
+	if err != nil {
+		return "", errors.New("读取 SDP 失败！！！" + err.Error())
+	}
+	if len(body) == 0 {
+		return "", errors.New("请求体必须是 SDP offer")
+	}
+	return string(body), nil
+}
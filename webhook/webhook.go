@@ -0,0 +1,311 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"pull2push/config"
+	"pull2push/event"
+	"pull2push/event/payload"
+	"pull2push/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+HTTP 回调（参考 SRS 的 http_hooks）
+	on_publish、on_play 在推流/观看前同步调用，决定是否允许：
+		回调返回非 2xx，或者返回的 JSON 中 code 不为 0（也可以直接返回数字）时拒绝；
+		超时、连不上也按拒绝处理，业务后台挂了时不会放过未授权的请求。
+	on_unpublish、on_stop、on_record_done 由事件总线上的事件触发，交给固定数量的工作协程异步调用，失败后按退避时间重试；
+	工作协程都在重试时事件在订阅队列中排队，队列满后丢弃，业务后台挂了时不会堆积协程。
+	配置了 secret 时请求头带 X-Hook-Signature: sha256=<hex(HMAC-SHA256(secret, 请求体))>，业务后台据此校验请求来自本服务。
+*/
+
+// 回调类型，也是请求体中的 action
+const (
+	ActionPublish    = "on_publish"
+	ActionUnpublish  = "on_unpublish"
+	ActionPlay       = "on_play"
+	ActionStop       = "on_stop"
+	ActionRecordDone = "on_record_done"
+)
+
+const (
+	SignatureHeader = "X-Hook-Signature" // 请求体签名
+
+	defaultTimeout      = 3 * time.Second
+	defaultRetries      = 3
+	defaultPlayCacheTTL = time.Minute
+	retryBackoff        = time.Second // 第一次重试的等待时间，之后每次翻倍
+	asyncWorkers        = 4           // 异步回调的工作协程数
+)
+
+// ErrDenied 回调拒绝了推流/观看
+var ErrDenied = errors.New("webhook denied")
+
+// Request 回调的请求体
+type Request struct {
+	Action         string  `json:"action"`
+	BroadcasterKey string  `json:"broadcasterKey"`
+	Protocol       string  `json:"protocol"`           // 直播类型：flv/hls/camera/webrtc，DASH、TS 观看时为 dash、ts
+	ClientId       string  `json:"clientId,omitempty"` // 观看的客户端编号
+	IP             string  `json:"ip,omitempty"`       // 推流端/观众的 IP
+	URL            string  `json:"url,omitempty"`      // 请求路径
+	Param          string  `json:"param,omitempty"`    // 请求的 query，token 等鉴权参数一般放在这里
+	Reason         string  `json:"reason,omitempty"`   // on_unpublish、on_stop 的原因
+	Segments       int     `json:"segments,omitempty"` // on_record_done 回放的分片数
+	Duration       float64 `json:"duration,omitempty"` // on_record_done 回放的总时长，秒
+	Time           int64   `json:"time"`               // 回调时间，Unix 秒
+}
+
+// Client 回调客户端，并发安全；为 nil 或者没有配置回调地址时所有回调都直接放行
+type Client struct {
+	config     config.WebhookConfig
+	httpClient *http.Client
+
+	// on_play 允许过的客户端，key 为 直播类型/直播间/客户端，value 为过期时间
+	playMutex   sync.Mutex
+	playAllowed map[string]time.Time
+	playPruned  time.Time
+}
+
+// NewClient 创建回调客户端
+func NewClient(cfg config.WebhookConfig) *Client {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = defaultRetries
+	}
+	if cfg.PlayCacheTTL <= 0 {
+		cfg.PlayCacheTTL = defaultPlayCacheTTL
+	}
+	return &Client{
+		config:      cfg,
+		httpClient:  &http.Client{Timeout: cfg.Timeout},
+		playAllowed: make(map[string]time.Time),
+	}
+}
+
+// OnPublish 推流前调用，返回 ErrDenied 等错误时拒绝推流
+func (c *Client) OnPublish(ctx context.Context, req Request) error {
+	if c == nil || c.config.OnPublish == "" {
+		return nil
+	}
+	req.Action = ActionPublish
+	return c.call(ctx, c.config.OnPublish, req)
+}
+
+// OnPlay 观看前调用，返回 ErrDenied 等错误时拒绝观看
+//
+//	同一个客户端允许后 PlayCacheTTL 内不再回调，HLS、DASH 的分片请求不会每次都打到业务后台；客户端编号为空时按 IP 区分。
+func (c *Client) OnPlay(ctx context.Context, req Request) error {
+	if c == nil || c.config.OnPlay == "" {
+		return nil
+	}
+	viewer := req.ClientId
+	if viewer == "" {
+		viewer = req.IP
+	}
+	cacheKey := req.Protocol + "/" + req.BroadcasterKey + "/" + viewer
+	if c.playCached(cacheKey) {
+		return nil
+	}
+
+	req.Action = ActionPlay
+	if err := c.call(ctx, c.config.OnPlay, req); err != nil {
+		return err
+	}
+	c.cachePlay(cacheKey)
+	return nil
+}
+
+// OnUnpublish 推流结束后调用，失败时重试，由 Listen 的工作协程调用
+func (c *Client) OnUnpublish(req Request) {
+	if c == nil || c.config.OnUnpublish == "" {
+		return
+	}
+	req.Action = ActionUnpublish
+	c.callAsync(c.config.OnUnpublish, req)
+}
+
+// OnStop 观众离开后调用，失败时重试，由 Listen 的工作协程调用
+func (c *Client) OnStop(req Request) {
+	if c == nil || c.config.OnStop == "" {
+		return
+	}
+	req.Action = ActionStop
+	c.callAsync(c.config.OnStop, req)
+}
+
+// OnRecordDone 回放定稿后调用，失败时重试，由 Listen 的工作协程调用
+//
+//	本服务不写录制文件，回放分片保存在内存中，回调只带分片数和时长，保留期内通过直播间的 HLS 地址观看。
+func (c *Client) OnRecordDone(req Request) {
+	if c == nil || c.config.OnRecordDone == "" {
+		return
+	}
+	req.Action = ActionRecordDone
+	c.callAsync(c.config.OnRecordDone, req)
+}
+
+// Events 触发异步回调的事件，订阅后交给 Listen 处理
+func (c *Client) Events() []event.EventType {
+	return []event.EventType{event.StreamUnpublished, event.ViewerLeft, event.ViewerKicked, event.RecordDone}
+}
+
+// Listen 启动 asyncWorkers 个工作协程把事件转换成回调，ch 被关闭（取消订阅）且回调都结束后返回
+//
+//	推流结束、拉流转推停止（StreamUnpublished）触发 on_unpublish；观众离开、被断开触发 on_stop；回放定稿（RecordDone）触发 on_record_done。
+func (c *Client) Listen(ch <-chan event.Event) {
+	var wg sync.WaitGroup
+	for i := 0; i < asyncWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range ch {
+				c.handle(e)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *Client) handle(e event.Event) {
+	switch p := e.Payload.(type) {
+	case payload.StreamPayload:
+		c.OnUnpublish(Request{BroadcasterKey: p.BroadcasterKey, Protocol: p.Protocol, IP: hostOf(p.URL), Reason: p.Reason, Time: e.At.Unix()})
+	case payload.ViewerPayload:
+		c.OnStop(Request{BroadcasterKey: p.BroadcasterKey, Protocol: p.Protocol, ClientId: p.ClientId, Reason: p.Reason, Time: e.At.Unix()})
+	case payload.RecordPayload:
+		c.OnRecordDone(Request{BroadcasterKey: p.BroadcasterKey, Protocol: p.Protocol, Segments: p.Segments, Duration: p.Duration, Time: e.At.Unix()})
+	}
+}
+
+// callAsync 异步回调，失败后按 1s、2s、4s…… 重试 Retries 次
+func (c *Client) callAsync(url string, req Request) {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.call(context.Background(), url, req)
+		if err == nil {
+			return
+		}
+		if attempt >= c.config.Retries {
+			logger.Error("webhook failed", "action", req.Action, "broadcasterKey", req.BroadcasterKey, "attempts", attempt+1, "error", err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// call 发送一次回调，回调拒绝时返回 ErrDenied
+func (c *Client) call(ctx context.Context, url string, req Request) error {
+	if req.Time == 0 {
+		req.Time = time.Now().Unix()
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.config.Secret != "" {
+		httpReq.Header.Set(SignatureHeader, Sign(c.config.Secret, body))
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s returned %s", ErrDenied, req.Action, resp.Status)
+	}
+	if code, ok := responseCode(respBody); ok && code != 0 {
+		return fmt.Errorf("%w: %s returned code %d", ErrDenied, req.Action, code)
+	}
+	return nil
+}
+
+// Sign 计算请求体签名，格式为 sha256=<十六进制>
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// responseCode 解析回调返回的 code：{"code":0} 或者直接返回数字；为空或不是这两种格式时 ok 为 false
+func responseCode(body []byte) (code int, ok bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(string(body)); err == nil {
+		return n, true
+	}
+	var resp struct {
+		Code *int `json:"code"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Code == nil {
+		return 0, false
+	}
+	return *resp.Code, true
+}
+
+func (c *Client) playCached(key string) bool {
+	c.playMutex.Lock()
+	defer c.playMutex.Unlock()
+	expireAt, ok := c.playAllowed[key]
+	if !ok || time.Now().After(expireAt) {
+		return false
+	}
+	// 持续观看的客户端一直有效
+	c.playAllowed[key] = time.Now().Add(c.config.PlayCacheTTL)
+	return true
+}
+
+func (c *Client) cachePlay(key string) {
+	c.playMutex.Lock()
+	defer c.playMutex.Unlock()
+	now := time.Now()
+	c.playAllowed[key] = now.Add(c.config.PlayCacheTTL)
+
+	// 定期清理过期的客户端
+	if now.Sub(c.playPruned) > c.config.PlayCacheTTL {
+		for k, expireAt := range c.playAllowed {
+			if now.After(expireAt) {
+				delete(c.playAllowed, k)
+			}
+		}
+		c.playPruned = now
+	}
+}
+
+// hostOf 从 host:port 或拉流转推的上游地址中取出 host，都不是时原样返回
+func hostOf(addr string) string {
+	if u, err := url.Parse(addr); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	if i := strings.LastIndex(addr, ":"); i > 0 && !strings.Contains(addr, "/") {
+		return strings.Trim(addr[:i], "[]")
+	}
+	return addr
+}
@@ -0,0 +1,327 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pull2push/config"
+	"pull2push/event"
+	"pull2push/event/payload"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hookServer 记录收到的回调，respond 决定返回内容
+type hookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []Request
+	headers  []http.Header
+	bodies   [][]byte
+}
+
+func newHookServer(t *testing.T, respond func(w http.ResponseWriter, n int)) *hookServer {
+	t.Helper()
+	hs := &hookServer{}
+	hs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req Request
+		_ = json.Unmarshal(body, &req)
+		hs.mu.Lock()
+		hs.requests = append(hs.requests, req)
+		hs.headers = append(hs.headers, r.Header.Clone())
+		hs.bodies = append(hs.bodies, body)
+		n := len(hs.requests)
+		hs.mu.Unlock()
+		respond(w, n)
+	}))
+	t.Cleanup(hs.Close)
+	return hs
+}
+
+func (hs *hookServer) count() int {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return len(hs.requests)
+}
+
+func (hs *hookServer) request(i int) (Request, http.Header, []byte) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return hs.requests[i], hs.headers[i], hs.bodies[i]
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOnPublishAllowDeny(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+	}{
+		{"2xx 空响应", http.StatusOK, "", false},
+		{"code 0", http.StatusOK, `{"code":0}`, false},
+		{"数字 0", http.StatusOK, "0", false},
+		{"code 非 0", http.StatusOK, `{"code":403,"msg":"denied"}`, true},
+		{"数字非 0", http.StatusOK, "1", true},
+		{"非 2xx", http.StatusForbidden, "", true},
+		{"不认识的响应按允许处理", http.StatusOK, "ok", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			hs := newHookServer(t, func(w http.ResponseWriter, _ int) {
+				w.WriteHeader(tc.status)
+				_, _ = io.WriteString(w, tc.body)
+			})
+			c := NewClient(config.WebhookConfig{OnPublish: hs.URL})
+			err := c.OnPublish(context.Background(), Request{BroadcasterKey: "room", Protocol: payload.ProtocolCamera})
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("err = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrDenied) {
+				t.Fatalf("err = %v, want ErrDenied", err)
+			}
+			req, _, _ := hs.request(0)
+			if req.Action != ActionPublish || req.BroadcasterKey != "room" || req.Time == 0 {
+				t.Fatalf("request = %+v", req)
+			}
+		})
+	}
+}
+
+func TestOnPublishUnreachableDenies(t *testing.T) {
+	hs := newHookServer(t, func(w http.ResponseWriter, _ int) {})
+	url := hs.URL
+	hs.Close()
+
+	c := NewClient(config.WebhookConfig{OnPublish: url})
+	if err := c.OnPublish(context.Background(), Request{BroadcasterKey: "room"}); err == nil {
+		t.Fatal("unreachable hook allowed publish")
+	}
+}
+
+func TestOnPublishTimeoutDenies(t *testing.T) {
+	release := make(chan struct{})
+	hs := newHookServer(t, func(w http.ResponseWriter, _ int) { <-release })
+	defer close(release)
+
+	c := NewClient(config.WebhookConfig{OnPublish: hs.URL, Timeout: 100 * time.Millisecond})
+	start := time.Now()
+	if err := c.OnPublish(context.Background(), Request{BroadcasterKey: "room"}); err == nil {
+		t.Fatal("timed out hook allowed publish")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("timeout not applied")
+	}
+}
+
+func TestNilClientAllows(t *testing.T) {
+	var c *Client
+	if err := c.OnPublish(context.Background(), Request{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.OnPlay(context.Background(), Request{}); err != nil {
+		t.Fatal(err)
+	}
+	c.OnUnpublish(Request{})
+	c.OnStop(Request{})
+
+	// 没有配置地址的回调也直接放行
+	if err := NewClient(config.WebhookConfig{}).OnPublish(context.Background(), Request{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	hs := newHookServer(t, func(w http.ResponseWriter, _ int) {})
+	c := NewClient(config.WebhookConfig{OnPublish: hs.URL, Secret: "s3cret"})
+	if err := c.OnPublish(context.Background(), Request{BroadcasterKey: "room"}); err != nil {
+		t.Fatal(err)
+	}
+	_, header, body := hs.request(0)
+	if got, want := header.Get(SignatureHeader), Sign("s3cret", body); got != want {
+		t.Fatalf("signature = %q, want %q", got, want)
+	}
+
+	// 没有配置 secret 时不签名
+	hs2 := newHookServer(t, func(w http.ResponseWriter, _ int) {})
+	if err := NewClient(config.WebhookConfig{OnPublish: hs2.URL}).OnPublish(context.Background(), Request{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, header, _ := hs2.request(0); header.Get(SignatureHeader) != "" {
+		t.Fatal("unexpected signature without secret")
+	}
+}
+
+func TestOnPlayCache(t *testing.T) {
+	hs := newHookServer(t, func(w http.ResponseWriter, _ int) {})
+	c := NewClient(config.WebhookConfig{OnPlay: hs.URL, PlayCacheTTL: time.Minute})
+
+	req := Request{BroadcasterKey: "room", Protocol: payload.ProtocolHLS, ClientId: "c1"}
+	for i := 0; i < 3; i++ {
+		if err := c.OnPlay(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := hs.count(); n != 1 {
+		t.Fatalf("hook called %d times, want 1", n)
+	}
+
+	// 其他客户端、其他观看方式单独回调
+	req.ClientId = "c2"
+	_ = c.OnPlay(context.Background(), req)
+	req.Protocol = payload.ProtocolDASH
+	_ = c.OnPlay(context.Background(), req)
+	if n := hs.count(); n != 3 {
+		t.Fatalf("hook called %d times, want 3", n)
+	}
+}
+
+func TestOnPlayDeniedNotCached(t *testing.T) {
+	hs := newHookServer(t, func(w http.ResponseWriter, _ int) { w.WriteHeader(http.StatusForbidden) })
+	c := NewClient(config.WebhookConfig{OnPlay: hs.URL})
+
+	req := Request{BroadcasterKey: "room", Protocol: payload.ProtocolFLV, ClientId: "c1"}
+	for i := 0; i < 2; i++ {
+		if err := c.OnPlay(context.Background(), req); !errors.Is(err, ErrDenied) {
+			t.Fatalf("err = %v, want ErrDenied", err)
+		}
+	}
+	if n := hs.count(); n != 2 {
+		t.Fatalf("hook called %d times, want 2", n)
+	}
+}
+
+func TestAsyncRetry(t *testing.T) {
+	// 第一次失败，重试后成功
+	hs := newHookServer(t, func(w http.ResponseWriter, n int) {
+		if n == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	c := NewClient(config.WebhookConfig{OnStop: hs.URL, Retries: 2})
+	c.OnStop(Request{BroadcasterKey: "room", ClientId: "c1"})
+	if n := hs.count(); n != 2 {
+		t.Fatalf("hook called %d times, want 2", n)
+	}
+
+	// Retries 小于 0 时不重试
+	hs2 := newHookServer(t, func(w http.ResponseWriter, _ int) { w.WriteHeader(http.StatusInternalServerError) })
+	NewClient(config.WebhookConfig{OnStop: hs2.URL, Retries: -1}).OnStop(Request{BroadcasterKey: "room"})
+	if n := hs2.count(); n != 1 {
+		t.Fatalf("hook called %d times, want 1", n)
+	}
+}
+
+func TestListen(t *testing.T) {
+	hs := newHookServer(t, func(w http.ResponseWriter, _ int) {})
+	c := NewClient(config.WebhookConfig{OnUnpublish: hs.URL, OnStop: hs.URL, OnRecordDone: hs.URL})
+
+	bus := event.NewEventBus()
+	ch := bus.SubscribeMultiple(c.Events())
+	done := make(chan struct{})
+	go func() {
+		c.Listen(ch)
+		close(done)
+	}()
+
+	bus.Publish(event.Event{Type: event.StreamUnpublished, Payload: payload.StreamPayload{BroadcasterKey: "relay", Protocol: payload.ProtocolFLV, URL: "http://10.0.0.8:8080/live/a.flv", Reason: "stopped"}})
+	bus.Publish(event.Event{Type: event.ViewerKicked, Payload: payload.ViewerPayload{BroadcasterKey: "relay", Protocol: payload.ProtocolFLV, ClientId: "c1", Reason: "send queue full"}})
+	bus.Publish(event.Event{Type: event.RecordDone, Payload: payload.RecordPayload{BroadcasterKey: "cam", Protocol: payload.ProtocolCamera, Segments: 5, Duration: 10.5}})
+	// 不关心的事件不回调
+	bus.Publish(event.Event{Type: event.ViewerJoined, Payload: payload.ViewerPayload{BroadcasterKey: "relay", ClientId: "c2"}})
+	waitFor(t, func() bool { return hs.count() == 3 })
+
+	bus.Unsubscribe(ch)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Listen did not return after unsubscribe")
+	}
+
+	got := map[string]Request{}
+	for i := 0; i < 3; i++ {
+		req, _, _ := hs.request(i)
+		got[req.Action] = req
+	}
+	if req := got[ActionUnpublish]; req.BroadcasterKey != "relay" || req.Protocol != payload.ProtocolFLV || req.IP != "10.0.0.8" || req.Reason != "stopped" {
+		t.Fatalf("on_unpublish = %+v", req)
+	}
+	if req := got[ActionStop]; req.ClientId != "c1" || req.Reason != "send queue full" {
+		t.Fatalf("on_stop = %+v", req)
+	}
+	if req := got[ActionRecordDone]; req.BroadcasterKey != "cam" || req.Protocol != payload.ProtocolCamera || req.Segments != 5 || req.Duration != 10.5 {
+		t.Fatalf("on_record_done = %+v", req)
+	}
+}
+
+func TestListenBoundedWorkers(t *testing.T) {
+	var running, peak atomic.Int32
+	release := make(chan struct{})
+	hs := newHookServer(t, func(w http.ResponseWriter, _ int) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		running.Add(-1)
+	})
+	c := NewClient(config.WebhookConfig{OnStop: hs.URL, Timeout: 10 * time.Second})
+
+	ch := make(chan event.Event, 64)
+	done := make(chan struct{})
+	go func() {
+		c.Listen(ch)
+		close(done)
+	}()
+	for i := 0; i < 3*asyncWorkers; i++ {
+		ch <- event.Event{Type: event.ViewerLeft, Payload: payload.ViewerPayload{BroadcasterKey: "room", ClientId: "c"}}
+	}
+	waitFor(t, func() bool { return running.Load() == asyncWorkers })
+	time.Sleep(100 * time.Millisecond)
+	if p := peak.Load(); p != asyncWorkers {
+		t.Fatalf("peak concurrent hooks = %d, want %d", p, asyncWorkers)
+	}
+
+	close(release)
+	close(ch)
+	<-done
+	if n := hs.count(); n != 3*asyncWorkers {
+		t.Fatalf("hook called %d times, want %d", n, 3*asyncWorkers)
+	}
+}
+
+func TestHostOf(t *testing.T) {
+	cases := map[string]string{
+		"10.0.0.8:51234":              "10.0.0.8",
+		"[::1]:51234":                 "::1",
+		"http://10.0.0.8:8080/a.flv":  "10.0.0.8",
+		"rtsp://cam.local/stream1":    "cam.local",
+		"":                            "",
+		"testsrc://?fps=30":           "testsrc://?fps=30",
+		"file:///var/media/slate.flv": "file:///var/media/slate.flv",
+	}
+	for addr, want := range cases {
+		if got := hostOf(addr); got != want {
+			t.Errorf("hostOf(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}